@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gedex/simdoc/pkg/datastore/database"
+	"github.com/gedex/simdoc/pkg/importer"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/watermark"
+)
+
+// runImport runs the import command, which imports documents from a directory
+// or ZIP archive and prints the report in JSON format.
+//
+//	simdoc [flags] import -source=/srv/share [-manifest=manifest.csv] [-journal=import.journal] [-owner=admin01] [-org=acme]
+//
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	source := fs.String("source", "", "Directory or ZIP archive to import")
+	manifest := fs.String("manifest", "", "Manifest in CSV or JSON mapping files to documents. Default to one document per file")
+	journal := fs.String("journal", "", "Journal of imported files, used to resume an interrupted import")
+	owner := fs.String("owner", "", "Login or email of documents owner, unless specified in the manifest")
+	org := fs.String("org", "", "Slug of the organization to import into. Owners and participants must belong to it")
+	fs.Parse(args)
+
+	if *source == "" {
+		fmt.Fprintf(os.Stderr, "usage: simdoc [flags] import -source=path [-manifest=path] [-journal=path] [-owner=login] [-org=slug]\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	ds := database.NewDatastore(database.MustConnect(*dsn))
+	countStorageUsage(ds)
+	if *org != "" {
+		o, err := ds.GetOrganizationBySlug(*org)
+		if err != nil {
+			fatalf("import: unknown organization %s\n", *org)
+		}
+		ds = ds.ForOrganization(o.ID)
+	}
+
+	var usr *model.User
+	if *owner != "" {
+		u, err := ds.GetUserByLogin(*owner)
+		if err != nil {
+			fatalf("import: unknown owner %s\n", *owner)
+		}
+		usr = u
+	}
+
+	src, err := importer.OpenSource(*source)
+	if err != nil {
+		fatalf("import: %s\n", err)
+	}
+	defer src.Close()
+
+	var entries []*importer.Entry
+	if *manifest != "" {
+		entries, err = importer.LoadManifest(*manifest)
+	} else {
+		entries, err = importer.EntriesFromSource(src)
+	}
+	if err != nil {
+		fatalf("import: %s\n", err)
+	}
+
+	j, err := importer.OpenJournal(*journal)
+	if err != nil {
+		fatalf("import: %s\n", err)
+	}
+	defer j.Close()
+
+	im := importer.New(ds, pipeline, *fsRoot, *filesPrefix, usr, j)
+	if wm := watermark.New(*watermarkText, *watermarkImage); wm != nil {
+		im.SetWatermark(wm)
+	}
+	rep := im.Run(src, entries)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+
+	if rep.Failed > 0 {
+		j.Close()
+		src.Close()
+		os.Exit(1)
+	}
+}
+
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+	os.Exit(1)
+}
@@ -0,0 +1,53 @@
+// Package auth defines external authentication providers, such as LDAP, that
+// verify login and password against a directory instead of the users table.
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrorBadCredentials = errors.New("Bad credentials")
+	ErrorUnknownUser    = errors.New("Unknown user")
+)
+
+// Identity represents a user authenticated by a provider.
+type Identity struct {
+	Subject string // Stable ID of the user at the provider, empty if none
+	Login   string
+	Email   string
+	Name    string
+	Role    string // Role mapped from the directory, empty for default role
+}
+
+// Provider authenticates users against an external directory.
+type Provider interface {
+	// Name returns the name of the provider, recorded as the source of users
+	// provisioned from it.
+	Name() string
+
+	// Authenticate verifies login and password. It returns ErrorUnknownUser if
+	// the provider doesn't know the login and ErrorBadCredentials if the
+	// password doesn't match. Other errors mean the provider is unavailable.
+	Authenticate(login, password string) (*Identity, error)
+}
+
+// GroupRole maps members of a group to a role.
+type GroupRole struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// MapRole returns the role of the first of groupRoles matching any of groups,
+// compared case-insensitively, or defaultRole.
+func MapRole(groupRoles []*GroupRole, groups []string, defaultRole string) string {
+	for _, gr := range groupRoles {
+		for _, g := range groups {
+			if strings.EqualFold(g, gr.Group) {
+				return gr.Role
+			}
+		}
+	}
+	return defaultRole
+}
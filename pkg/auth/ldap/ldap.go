@@ -0,0 +1,218 @@
+// Package ldap implements an authentication provider for LDAP directories,
+// including Active Directory. Users are searched with a service account, then
+// authenticated by binding with their own DN and password.
+package ldap
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/auth"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// Name of the provider.
+const Name = "ldap"
+
+var ErrorInvalidConfig = errors.New("Invalid LDAP configuration")
+
+// Config represents LDAP provider configuration, loaded from JSON.
+type Config struct {
+	URL                string        `json:"url"`       // ldap://host:389 or ldaps://host:636
+	StartTLS           bool          `json:"start_tls"` // Upgrades ldap:// connection with StartTLS
+	InsecureSkipVerify bool          `json:"insecure_skip_verify"`
+	Timeout            time.Duration `json:"-"`
+
+	BindDN       string `json:"bind_dn"` // Service account to search users
+	BindPassword string `json:"bind_password"`
+
+	BaseDN     string `json:"base_dn"`
+	UserFilter string `json:"user_filter"` // %s is replaced with escaped login
+
+	IDAttr    string `json:"id_attr"` // Stable ID of users, their DN if empty
+	LoginAttr string `json:"login_attr"`
+	EmailAttr string `json:"email_attr"`
+	NameAttr  string `json:"name_attr"`
+	GroupAttr string `json:"group_attr"`
+
+	// Roles of group members, first matching group wins. Users of no group get
+	// DefaultRole, or are refused if it's empty.
+	GroupRoles  []*auth.GroupRole `json:"group_roles"` // Groups are DNs
+	DefaultRole string            `json:"default_role"`
+}
+
+// LoadConfig loads Config from JSON file at path. Attributes default to the
+// Active Directory ones.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &Config{
+		UserFilter: "(&(objectClass=user)(sAMAccountName=%s))",
+		LoginAttr:  "sAMAccountName",
+		EmailAttr:  "mail",
+		NameAttr:   "displayName",
+		GroupAttr:  "memberOf",
+		Timeout:    10 * time.Second,
+	}
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.URL == "" || cfg.BaseDN == "" || !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, ErrorInvalidConfig
+	}
+	return cfg, nil
+}
+
+// Conn is the subset of LDAP connection used by the provider, so that a stand-in
+// directory can be used in place of a server.
+type Conn interface {
+	Bind(username, password string) error
+	Search(r *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// Provider authenticates users against an LDAP directory.
+type Provider struct {
+	cfg *Config
+
+	// Dial opens a connection to the directory. It defaults to dial cfg.URL.
+	Dial func(cfg *Config) (Conn, error)
+}
+
+// New returns LDAP Provider with cfg.
+func New(cfg *Config) *Provider {
+	return &Provider{cfg, dial}
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// Authenticate searches the user with login using the service account, then
+// binds as the user with password.
+func (p *Provider) Authenticate(login, password string) (*auth.Identity, error) {
+	// Binding with empty password is an anonymous bind, which succeeds.
+	if login == "" || password == "" {
+		return nil, auth.ErrorBadCredentials
+	}
+
+	conn, err := p.Dial(p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind: %s", err)
+		}
+	}
+
+	req := ldap.NewSearchRequest(
+		p.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(p.cfg.Timeout/time.Second), false,
+		fmt.Sprintf(p.cfg.UserFilter, ldap.EscapeFilter(login)),
+		p.attributes(),
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: search: %s", err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, auth.ErrorUnknownUser
+	}
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, auth.ErrorBadCredentials
+		}
+		return nil, fmt.Errorf("ldap: user bind: %s", err)
+	}
+
+	id := &auth.Identity{
+		Subject: entry.DN,
+		Login:   entry.GetAttributeValue(p.cfg.LoginAttr),
+		Email:   entry.GetAttributeValue(p.cfg.EmailAttr),
+		Name:    entry.GetAttributeValue(p.cfg.NameAttr),
+		Role:    auth.MapRole(p.cfg.GroupRoles, entry.GetAttributeValues(p.cfg.GroupAttr), p.cfg.DefaultRole),
+	}
+	if p.cfg.IDAttr != "" {
+		id.Subject = entry.GetAttributeValue(p.cfg.IDAttr)
+	}
+	if id.Subject == "" {
+		return nil, auth.ErrorUnknownUser
+	}
+	if id.Login == "" {
+		id.Login = login
+	}
+	if id.Role == "" {
+		return nil, auth.ErrorUnknownUser
+	}
+
+	return id, nil
+}
+
+// attributes returns the attributes of users to search.
+func (p *Provider) attributes() []string {
+	attrs := []string{p.cfg.LoginAttr, p.cfg.EmailAttr, p.cfg.NameAttr, p.cfg.GroupAttr}
+	if p.cfg.IDAttr != "" {
+		attrs = append(attrs, p.cfg.IDAttr)
+	}
+	return attrs
+}
+
+// dial connects to cfg.URL, with TLS for ldaps:// or if StartTLS is set.
+func dial(cfg *Config) (Conn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	tlsCfg := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	var conn *ldap.Conn
+	switch u.Scheme {
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+		conn, err = ldap.DialTLS("tcp", host, tlsCfg)
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = ldap.Dial("tcp", host)
+		if err == nil && cfg.StartTLS {
+			if err = conn.StartTLS(tlsCfg); err != nil {
+				conn.Close()
+			}
+		}
+	default:
+		return nil, ErrorInvalidConfig
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(cfg.Timeout)
+	return conn, nil
+}
@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/auth"
+
+	ldap "gopkg.in/ldap.v2"
+)
+
+// directory is a stand-in LDAP directory. Users are searched by the filter of
+// the test config.
+type directory struct {
+	passwords map[string]string // Password by DN, including the service account
+	entries   []*ldap.Entry
+	down      bool
+}
+
+type conn struct {
+	dir *directory
+}
+
+func (c *conn) Bind(dn, password string) error {
+	if pass, ok := c.dir.passwords[dn]; !ok || pass != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (c *conn) Search(r *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	res := new(ldap.SearchResult)
+	for _, e := range c.dir.entries {
+		if r.Filter == fmt.Sprintf("(uid=%s)", e.GetAttributeValue("uid")) {
+			res.Entries = append(res.Entries, e)
+		}
+	}
+	return res, nil
+}
+
+func (c *conn) Close() {}
+
+func (d *directory) dial(cfg *Config) (Conn, error) {
+	if d.down {
+		return nil, errors.New("connection refused")
+	}
+	return &conn{d}, nil
+}
+
+func newEntry(dn string, attrs map[string][]string) *ldap.Entry {
+	e := &ldap.Entry{DN: dn}
+	for name, values := range attrs {
+		e.Attributes = append(e.Attributes, &ldap.EntryAttribute{Name: name, Values: values})
+	}
+	return e
+}
+
+func TestAuthenticate(t *testing.T) {
+	const (
+		serviceDN = "cn=simdoc,ou=services,dc=example,dc=com"
+		adminsDN  = "cn=admins,ou=groups,dc=example,dc=com"
+		aliceDN   = "uid=alice,ou=people,dc=example,dc=com"
+		bobDN     = "uid=bob,ou=people,dc=example,dc=com"
+	)
+	dir := &directory{
+		passwords: map[string]string{
+			serviceDN: "service-secret",
+			aliceDN:   "alice-secret",
+			bobDN:     "bob-secret",
+		},
+		entries: []*ldap.Entry{
+			newEntry(aliceDN, map[string][]string{
+				"uid":       {"alice"},
+				"mail":      {"alice@example.com"},
+				"cn":        {"Alice"},
+				"memberOf":  {adminsDN},
+				"entryUUID": {"a1"},
+			}),
+			newEntry(bobDN, map[string][]string{
+				"uid":  {"bob"},
+				"mail": {"bob@example.com"},
+			}),
+		},
+	}
+	cfg := &Config{
+		BindDN:       serviceDN,
+		BindPassword: "service-secret",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		UserFilter:   "(uid=%s)",
+		LoginAttr:    "uid",
+		EmailAttr:    "mail",
+		NameAttr:     "cn",
+		GroupAttr:    "memberOf",
+		GroupRoles:   []*auth.GroupRole{{Group: adminsDN, Role: "admin"}},
+		DefaultRole:  "user",
+	}
+
+	tests := []struct {
+		name     string
+		cfg      func(cfg *Config)
+		down     bool
+		login    string
+		password string
+		want     *auth.Identity
+		err      error
+	}{
+		{
+			name: "group member", login: "alice", password: "alice-secret",
+			want: &auth.Identity{Subject: aliceDN, Login: "alice", Email: "alice@example.com", Name: "Alice", Role: "admin"},
+		},
+		{
+			name: "default role", login: "bob", password: "bob-secret",
+			want: &auth.Identity{Subject: bobDN, Login: "bob", Email: "bob@example.com", Role: "user"},
+		},
+		{
+			name: "id attribute", login: "alice", password: "alice-secret",
+			cfg:  func(cfg *Config) { cfg.IDAttr = "entryUUID" },
+			want: &auth.Identity{Subject: "a1", Login: "alice", Email: "alice@example.com", Name: "Alice", Role: "admin"},
+		},
+		{
+			name: "missing id attribute", login: "bob", password: "bob-secret",
+			cfg: func(cfg *Config) { cfg.IDAttr = "entryUUID" },
+			err: auth.ErrorUnknownUser,
+		},
+		{
+			name: "no default role", login: "bob", password: "bob-secret",
+			cfg: func(cfg *Config) { cfg.DefaultRole = "" },
+			err: auth.ErrorUnknownUser,
+		},
+		{name: "wrong password", login: "alice", password: "bob-secret", err: auth.ErrorBadCredentials},
+		{name: "empty password", login: "alice", password: "", err: auth.ErrorBadCredentials},
+		{name: "unknown login", login: "carol", password: "alice-secret", err: auth.ErrorUnknownUser},
+		{
+			name: "service account refused", login: "alice", password: "alice-secret",
+			cfg: func(cfg *Config) { cfg.BindPassword = "wrong" },
+			err: errors.New("unavailable"),
+		},
+		{name: "directory down", down: true, login: "alice", password: "alice-secret", err: errors.New("unavailable")},
+	}
+
+	for _, tt := range tests {
+		c := *cfg
+		if tt.cfg != nil {
+			tt.cfg(&c)
+		}
+		dir.down = tt.down
+		p := New(&c)
+		p.Dial = dir.dial
+
+		id, err := p.Authenticate(tt.login, tt.password)
+		switch {
+		case tt.err == nil && err != nil:
+			t.Errorf("%s: error %v", tt.name, err)
+		case tt.err == auth.ErrorBadCredentials || tt.err == auth.ErrorUnknownUser:
+			if err != tt.err {
+				t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+			}
+		case tt.err != nil:
+			// Directory errors must not be mistaken for wrong credentials.
+			if err == nil || err == auth.ErrorBadCredentials || err == auth.ErrorUnknownUser {
+				t.Errorf("%s: error %v, want unavailable", tt.name, err)
+			}
+		case *id != *tt.want:
+			t.Errorf("%s: identity %+v, want %+v", tt.name, id, tt.want)
+		}
+	}
+}
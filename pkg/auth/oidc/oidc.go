@@ -0,0 +1,411 @@
+// Package oidc implements OpenID Connect single sign-on as a relying party,
+// with the authorization code flow and PKCE. The issuer is discovered from its
+// metadata, and ID tokens are verified with the keys it publishes.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/auth"
+	"github.com/gedex/simdoc/pkg/util"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Name of the provider.
+const Name = "oidc"
+
+// keysRefreshInterval is the minimum interval between fetches of the issuer
+// keys, when an ID token is signed with an unknown key.
+const keysRefreshInterval = time.Minute
+
+var (
+	ErrorInvalidConfig   = errors.New("Invalid OpenID Connect configuration")
+	ErrorInvalidGrant    = errors.New("Authorization code is refused by the issuer")
+	ErrorInvalidIDToken  = errors.New("Invalid ID token")
+	ErrorUnverifiedEmail = errors.New("Email is not verified by the issuer")
+)
+
+// Config represents OpenID Connect provider configuration, loaded from JSON.
+type Config struct {
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"` // Empty for public clients
+	RedirectURL  string   `json:"redirect_url"`  // URL of the callback endpoint
+	Scopes       []string `json:"scopes"`
+
+	LoginClaim  string `json:"login_claim"`
+	EmailClaim  string `json:"email_claim"`
+	NameClaim   string `json:"name_claim"`
+	GroupsClaim string `json:"groups_claim"`
+
+	// Roles of group members, first matching group wins. Users of no group get
+	// DefaultRole, or are refused if it's empty.
+	GroupRoles  []*auth.GroupRole `json:"group_roles"`
+	DefaultRole string            `json:"default_role"`
+}
+
+// LoadConfig loads Config from JSON file at path. Claims default to the
+// standard ones and groups.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &Config{
+		Scopes:      []string{"openid", "email", "profile"},
+		LoginClaim:  "preferred_username",
+		EmailClaim:  "email",
+		NameClaim:   "name",
+		GroupsClaim: "groups",
+	}
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, ErrorInvalidConfig
+	}
+	return cfg, nil
+}
+
+// metadata represents the issuer metadata used by the provider.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// issuerKey represents a key of the issuer to verify ID tokens.
+type issuerKey struct {
+	Alg    string
+	Public interface{}
+}
+
+// Provider authenticates users with an OpenID Connect issuer.
+type Provider struct {
+	cfg *Config
+
+	// Client is the HTTP client to reach the issuer.
+	Client *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]*issuerKey // Key is key ID
+	keysFetched time.Time
+}
+
+// New returns OpenID Connect Provider with cfg. The issuer is discovered on
+// first use.
+func New(cfg *Config) *Provider {
+	return &Provider{cfg: cfg, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+// RandomValue returns a random URL-safe value, suitable for state, nonce and
+// PKCE code verifier.
+func RandomValue() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// AuthCodeURL returns URL of the issuer to redirect the user to log in. state
+// and nonce are bound to the user agent, and verifier is the PKCE code verifier
+// to be passed to Exchange.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for tokens, verifies the ID token
+// and returns the identity it asserts. It returns auth.ErrorUnknownUser if the
+// user maps to no role.
+func (p *Provider) Exchange(code, verifier, nonce string) (*auth.Identity, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized:
+		return nil, ErrorInvalidGrant
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("oidc: token endpoint: %s", resp.Status)
+	}
+
+	var tokens = new(struct {
+		IDToken string `json:"id_token"`
+	})
+	if err := json.NewDecoder(resp.Body).Decode(tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, ErrorInvalidIDToken
+	}
+
+	claims, err := p.verify(meta, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.identity(claims)
+}
+
+// verify verifies signature and claims of ID token raw.
+func (p *Provider) verify(meta *metadata, raw, nonce string) (map[string]interface{}, error) {
+	t, err := jwt.Parse(raw, p.keyfunc)
+	if err != nil || !t.Valid {
+		return nil, ErrorInvalidIDToken
+	}
+
+	if iss, _ := t.Claims["iss"].(string); iss != meta.Issuer {
+		return nil, ErrorInvalidIDToken
+	}
+	if _, ok := t.Claims["exp"].(float64); !ok {
+		return nil, ErrorInvalidIDToken
+	}
+
+	// Audience must contain the client, which must be the authorized party if
+	// there are other audiences.
+	switch aud := t.Claims["aud"].(type) {
+	case string:
+		if aud != p.cfg.ClientID {
+			return nil, ErrorInvalidIDToken
+		}
+	case []interface{}:
+		var found bool
+		for _, a := range aud {
+			if s, _ := a.(string); s == p.cfg.ClientID {
+				found = true
+			}
+		}
+		azp, _ := t.Claims["azp"].(string)
+		if !found || (len(aud) > 1 && azp != p.cfg.ClientID) {
+			return nil, ErrorInvalidIDToken
+		}
+	default:
+		return nil, ErrorInvalidIDToken
+	}
+
+	n, _ := t.Claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return nil, ErrorInvalidIDToken
+	}
+
+	return t.Claims, nil
+}
+
+// keyfunc returns the issuer key to verify token t. The token must be signed
+// with the algorithm of the key. Keys are fetched again for unknown key IDs,
+// as the issuer may have rotated them.
+func (p *Provider) keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	k, err := p.key(kid, false)
+	if err == util.ErrorUnknownKey {
+		k, err = p.key(kid, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Method == nil || t.Method.Alg() != k.Alg {
+		return nil, util.ErrorAlgorithmMismatch
+	}
+	return k.Public, nil
+}
+
+// key returns issuer key kid, or the only key if kid is empty. Keys are fetched
+// if not fetched yet, or if refresh is set and they weren't fetched recently.
+func (p *Provider) key(kid string, refresh bool) (*issuerKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil || (refresh && time.Since(p.keysFetched) > keysRefreshInterval) {
+		if err := p.fetchKeys(); err != nil {
+			return nil, err
+		}
+	}
+
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, nil
+		}
+	}
+	k, ok := p.keys[kid]
+	if !ok {
+		return nil, util.ErrorUnknownKey
+	}
+	return k, nil
+}
+
+// fetchKeys fetches the issuer keys. Keys that can't be used to verify
+// signatures are skipped. It must be called with mu held.
+func (p *Provider) fetchKeys() error {
+	if p.meta == nil {
+		return ErrorInvalidConfig
+	}
+
+	var set = new(util.JWKS)
+	if err := p.getJSON(p.meta.JWKSURI, set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*issuerKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, alg, err := jwk.PublicKey()
+		if err != nil || (jwk.Alg != "" && jwk.Alg != alg) {
+			continue
+		}
+		keys[jwk.Kid] = &issuerKey{alg, pub}
+	}
+
+	p.keys = keys
+	p.keysFetched = time.Now()
+	return nil
+}
+
+// discover returns the issuer metadata, fetched on first use.
+func (p *Provider) discover() (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta = new(metadata)
+	if err := p.getJSON(strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != p.cfg.Issuer || meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, ErrorInvalidConfig
+	}
+
+	p.meta = meta
+	return meta, nil
+}
+
+// identity maps claims of ID token to identity.
+func (p *Provider) identity(claims map[string]interface{}) (*auth.Identity, error) {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, ErrorInvalidIDToken
+	}
+
+	// Unverified email would allow to take over users with that email. Issuers
+	// that don't assert it are not trusted either.
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return nil, ErrorUnverifiedEmail
+	}
+
+	id := &auth.Identity{Subject: sub}
+	id.Email, _ = claims[p.cfg.EmailClaim].(string)
+	id.Name, _ = claims[p.cfg.NameClaim].(string)
+	id.Login, _ = claims[p.cfg.LoginClaim].(string)
+	if id.Login == "" {
+		id.Login = strings.SplitN(id.Email, "@", 2)[0]
+	}
+	if id.Login == "" {
+		id.Login = sub
+	}
+
+	var groups []string
+	switch v := claims[p.cfg.GroupsClaim].(type) {
+	case string:
+		groups = []string{v}
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+
+	id.Role = auth.MapRole(p.cfg.GroupRoles, groups, p.cfg.DefaultRole)
+	if id.Role == "" {
+		return nil, auth.ErrorUnknownUser
+	}
+
+	return id, nil
+}
+
+func (p *Provider) getJSON(u string, v interface{}) error {
+	resp, err := p.Client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,216 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/auth"
+	"github.com/gedex/simdoc/pkg/util"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// issuer is a mock OpenID Connect issuer. Its token endpoint accepts code
+// "good" and returns an ID token of claims, signed with key.
+type issuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	claims map[string]interface{}
+	alg    jwt.SigningMethod
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := &issuer{key: key, kid: "k1", alg: jwt.SigningMethodRS256}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 is.URL,
+			"authorization_endpoint": is.URL + "/authorize",
+			"token_endpoint":         is.URL + "/token",
+			"jwks_uri":               is.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := &is.key.PublicKey
+		json.NewEncoder(w).Encode(&util.JWKS{Keys: []*util.JWK{{
+			Kty: "RSA",
+			Kid: is.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good" || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tok := jwt.New(is.alg)
+		tok.Header["kid"] = is.kid
+		tok.Claims = is.claims
+		var key interface{} = is.key
+		if is.alg == jwt.SigningMethodHS256 {
+			key = []byte("secret")
+		}
+		raw, err := tok.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": raw})
+	})
+
+	is.Server = httptest.NewServer(mux)
+	return is
+}
+
+func TestExchange(t *testing.T) {
+	is := newIssuer(t)
+	defer is.Close()
+
+	cfg := &Config{
+		Issuer:      is.URL,
+		ClientID:    "simdoc",
+		RedirectURL: "https://simdoc.example.com/callback",
+		LoginClaim:  "preferred_username",
+		EmailClaim:  "email",
+		NameClaim:   "name",
+		GroupsClaim: "groups",
+		GroupRoles:  []*auth.GroupRole{{Group: "admins", Role: "admin"}},
+		DefaultRole: "user",
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                is.URL,
+			"sub":                "s1",
+			"aud":                "simdoc",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              "n1",
+			"email":              "alice@example.com",
+			"email_verified":     true,
+			"name":               "Alice",
+			"preferred_username": "alice",
+			"groups":             []string{"admins"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		claims func(c map[string]interface{})
+		alg    jwt.SigningMethod
+		code   string
+		want   *auth.Identity
+		err    error
+	}{
+		{
+			name: "valid",
+			want: &auth.Identity{Subject: "s1", Login: "alice", Email: "alice@example.com", Name: "Alice", Role: "admin"},
+		},
+		{
+			name:   "login from email",
+			claims: func(c map[string]interface{}) { delete(c, "preferred_username"); delete(c, "groups") },
+			want:   &auth.Identity{Subject: "s1", Login: "alice", Email: "alice@example.com", Name: "Alice", Role: "user"},
+		},
+		{
+			name:   "audience with authorized party",
+			claims: func(c map[string]interface{}) { c["aud"] = []string{"simdoc", "other"}; c["azp"] = "simdoc" },
+			want:   &auth.Identity{Subject: "s1", Login: "alice", Email: "alice@example.com", Name: "Alice", Role: "admin"},
+		},
+		{name: "bad code", code: "bad", err: ErrorInvalidGrant},
+		{name: "unverified email", claims: func(c map[string]interface{}) { c["email_verified"] = false }, err: ErrorUnverifiedEmail},
+		{name: "email verification missing", claims: func(c map[string]interface{}) { delete(c, "email_verified") }, err: ErrorUnverifiedEmail},
+		{name: "email verified as string", claims: func(c map[string]interface{}) { c["email_verified"] = "true" }, err: ErrorUnverifiedEmail},
+		{name: "no subject", claims: func(c map[string]interface{}) { delete(c, "sub") }, err: ErrorInvalidIDToken},
+		{name: "other issuer", claims: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, err: ErrorInvalidIDToken},
+		{name: "other audience", claims: func(c map[string]interface{}) { c["aud"] = "other" }, err: ErrorInvalidIDToken},
+		{
+			name:   "other authorized party",
+			claims: func(c map[string]interface{}) { c["aud"] = []string{"simdoc", "other"}; c["azp"] = "other" },
+			err:    ErrorInvalidIDToken,
+		},
+		{name: "expired", claims: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, err: ErrorInvalidIDToken},
+		{name: "no expiry", claims: func(c map[string]interface{}) { delete(c, "exp") }, err: ErrorInvalidIDToken},
+		{name: "other nonce", claims: func(c map[string]interface{}) { c["nonce"] = "n2" }, err: ErrorInvalidIDToken},
+		{name: "algorithm of other key", alg: jwt.SigningMethodHS256, err: ErrorInvalidIDToken},
+		{
+			name:   "no role",
+			claims: func(c map[string]interface{}) { c["groups"] = []string{"others"}; cfg.DefaultRole = "" },
+			err:    auth.ErrorUnknownUser,
+		},
+	}
+
+	for _, tt := range tests {
+		cfg.DefaultRole = "user"
+		is.claims = valid()
+		if tt.claims != nil {
+			tt.claims(is.claims)
+		}
+		is.alg = jwt.SigningMethodRS256
+		if tt.alg != nil {
+			is.alg = tt.alg
+		}
+		code := "good"
+		if tt.code != "" {
+			code = tt.code
+		}
+
+		p := New(cfg)
+		id, err := p.Exchange(code, "verifier", "n1")
+		switch {
+		case err != tt.err:
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		case err == nil && *id != *tt.want:
+			t.Errorf("%s: identity %+v, want %+v", tt.name, id, tt.want)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	is := newIssuer(t)
+	defer is.Close()
+
+	p := New(&Config{Issuer: is.URL, ClientID: "simdoc", RedirectURL: "https://simdoc.example.com/callback", DefaultRole: "user"})
+	is.claims = map[string]interface{}{
+		"iss":            is.URL,
+		"sub":            "s1",
+		"aud":            "simdoc",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          "n1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+	if _, err := p.Exchange("good", "verifier", "n1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys are fetched again for an unknown key ID, but not more than once per
+	// refresh interval.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is.key, is.kid = key, "k2"
+	p.keysFetched = time.Now().Add(-2 * keysRefreshInterval)
+	if _, err := p.Exchange("good", "verifier", "n1"); err != nil {
+		t.Errorf("after rotation: %v", err)
+	}
+
+	is.key, is.kid = key, "k3"
+	if _, err := p.Exchange("good", "verifier", "n1"); err != ErrorInvalidIDToken {
+		t.Errorf("key rotated again: error %v, want %v", err, ErrorInvalidIDToken)
+	}
+}
@@ -0,0 +1,73 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type APITokenstore interface {
+	// GetAPITokenById retrieves an API token from the datastore for the given
+	// ID.
+	GetAPITokenById(id int64) (*model.APIToken, error)
+
+	// GetAPITokenByHash retrieves an API token from the datastore for the given
+	// token hash.
+	GetAPITokenByHash(hash string) (*model.APIToken, error)
+
+	// GetAllAPITokens retrieves a list of all API tokens, for the given user ID,
+	// from the datastore.
+	GetAllAPITokens(userId int64) ([]*model.APIToken, error)
+
+	// AddAPIToken adds an API token into the datastore.
+	AddAPIToken(t *model.APIToken) error
+
+	// UpdateAPITokenLastUsed updates the time an API token, for the given ID, is
+	// last used in the datastore.
+	UpdateAPITokenLastUsed(id, lastUsed int64) error
+
+	// DeleteAPIToken deletes an API token, for the given ID, in the datastore.
+	DeleteAPIToken(id int64) error
+
+	// DeleteAllAPITokens deletes all API tokens, for the given user ID, in the
+	// datastore.
+	DeleteAllAPITokens(userId int64) error
+}
+
+// GetAPITokenById retrieves an API token from the datastore for the given ID.
+func GetAPITokenById(c context.Context, id int64) (*model.APIToken, error) {
+	return FromContext(c).GetAPITokenById(id)
+}
+
+// GetAPITokenByHash retrieves an API token from the datastore for the given
+// token hash.
+func GetAPITokenByHash(c context.Context, hash string) (*model.APIToken, error) {
+	return FromContext(c).GetAPITokenByHash(hash)
+}
+
+// GetAllAPITokens retrieves a list of all API tokens, for the given user ID,
+// from the datastore.
+func GetAllAPITokens(c context.Context, userId int64) ([]*model.APIToken, error) {
+	return FromContext(c).GetAllAPITokens(userId)
+}
+
+// AddAPIToken adds an API token into the datastore.
+func AddAPIToken(c context.Context, t *model.APIToken) error {
+	return FromContext(c).AddAPIToken(t)
+}
+
+// UpdateAPITokenLastUsed updates the time an API token, for the given ID, is
+// last used in the datastore.
+func UpdateAPITokenLastUsed(c context.Context, id, lastUsed int64) error {
+	return FromContext(c).UpdateAPITokenLastUsed(id, lastUsed)
+}
+
+// DeleteAPIToken deletes an API token, for the given ID, in the datastore.
+func DeleteAPIToken(c context.Context, id int64) error {
+	return FromContext(c).DeleteAPIToken(id)
+}
+
+// DeleteAllAPITokens deletes all API tokens, for the given user ID, in the
+// datastore.
+func DeleteAllAPITokens(c context.Context, userId int64) error {
+	return FromContext(c).DeleteAllAPITokens(userId)
+}
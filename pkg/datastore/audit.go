@@ -0,0 +1,51 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type Auditstore interface {
+	// AddAuditEvent appends an audit event into the datastore, to be chained
+	// by ChainAuditEvents. Audit events are only updated once chained, and
+	// never deleted.
+	AddAuditEvent(e *model.AuditEvent) error
+
+	// ChainAuditEvents chains audit events not chained yet, in the order they
+	// were added, with key, in the datastore. It returns the number of
+	// chained events.
+	ChainAuditEvents(key []byte) (int, error)
+
+	// GetAuditEvents retrieves a list of audit events matching filter f, newest
+	// first, from the datastore.
+	GetAuditEvents(f *model.AuditFilter) ([]*model.AuditEvent, error)
+
+	// VerifyAuditEvents verifies the chain of all audit events in the
+	// datastore with key.
+	VerifyAuditEvents(key []byte) (*model.AuditVerification, error)
+}
+
+// AddAuditEvent appends an audit event into the datastore, to be chained by
+// ChainAuditEvents. Audit events are only updated once chained, and never
+// deleted.
+func AddAuditEvent(c context.Context, e *model.AuditEvent) error {
+	return FromContext(c).AddAuditEvent(e)
+}
+
+// ChainAuditEvents chains audit events not chained yet, in the order they were
+// added, with key, in the datastore. It returns the number of chained events.
+func ChainAuditEvents(c context.Context, key []byte) (int, error) {
+	return FromContext(c).ChainAuditEvents(key)
+}
+
+// GetAuditEvents retrieves a list of audit events matching filter f, newest
+// first, from the datastore.
+func GetAuditEvents(c context.Context, f *model.AuditFilter) ([]*model.AuditEvent, error) {
+	return FromContext(c).GetAuditEvents(f)
+}
+
+// VerifyAuditEvents verifies the chain of all audit events in the datastore
+// with key.
+func VerifyAuditEvents(c context.Context, key []byte) (*model.AuditVerification, error) {
+	return FromContext(c).VerifyAuditEvents(key)
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"time"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type APITokenstore struct {
+	meddler.DB
+}
+
+func NewAPITokenstore(db meddler.DB) *APITokenstore {
+	return &APITokenstore{db}
+}
+
+func (db *APITokenstore) GetAPITokenById(id int64) (*model.APIToken, error) {
+	var t = new(model.APIToken)
+	var err = meddler.Load(db, apiTokenTable, t, id)
+
+	return t, err
+}
+
+func (db *APITokenstore) GetAPITokenByHash(hash string) (*model.APIToken, error) {
+	var t = new(model.APIToken)
+	var err = meddler.QueryRow(db, t, apiTokenByHashQuery, hash)
+
+	return t, err
+}
+
+func (db *APITokenstore) GetAllAPITokens(userId int64) ([]*model.APIToken, error) {
+	var tokens []*model.APIToken
+	var err = meddler.QueryAll(db, &tokens, apiTokensByUserQuery, userId)
+
+	return tokens, err
+}
+
+func (db *APITokenstore) AddAPIToken(t *model.APIToken) error {
+	if t.Created == 0 {
+		t.Created = time.Now().UTC().Unix()
+	}
+
+	return meddler.Save(db, apiTokenTable, t)
+}
+
+func (db *APITokenstore) UpdateAPITokenLastUsed(id, lastUsed int64) error {
+	var _, err = db.Exec(apiTokenLastUsedQuery, lastUsed, id)
+
+	return err
+}
+
+func (db *APITokenstore) DeleteAPIToken(id int64) error {
+	var _, err = db.Exec(apiTokenDeleteQuery, id)
+
+	return err
+}
+
+func (db *APITokenstore) DeleteAllAPITokens(userId int64) error {
+	var _, err = db.Exec(apiTokensDeleteByUserQuery, userId)
+
+	return err
+}
+
+const apiTokenTable = "api_tokens"
+
+const apiTokenByHashQuery = `
+SELECT * FROM api_tokens
+WHERE hash=? LIMIT 1
+`
+
+const apiTokensByUserQuery = `
+SELECT * FROM api_tokens
+WHERE user_id=?
+ORDER BY created DESC
+`
+
+const apiTokenLastUsedQuery = `
+UPDATE api_tokens SET last_used=?
+WHERE id=?
+`
+
+const apiTokenDeleteQuery = `
+DELETE FROM api_tokens
+WHERE id=?
+`
+
+const apiTokensDeleteByUserQuery = `
+DELETE FROM api_tokens
+WHERE user_id=?
+`
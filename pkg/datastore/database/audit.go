@@ -0,0 +1,213 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type Auditstore struct {
+	*sql.DB
+	orgId int64
+}
+
+func NewAuditstore(db *sql.DB, orgId int64) *Auditstore {
+	return &Auditstore{db, orgId}
+}
+
+// AddAuditEvent inserts e, to be chained by ChainAuditEvents, so that adding
+// events doesn't wait for each other.
+func (db *Auditstore) AddAuditEvent(e *model.AuditEvent) error {
+	if e.Created == 0 {
+		e.Created = time.Now().UTC().Unix()
+	}
+	e.ID = 0
+	e.Seq, e.PrevHash, e.Hash, e.Keyed = 0, "", "", false
+
+	return meddler.Insert(db, auditEventTable, e)
+}
+
+// ChainAuditEvents chains events not chained yet, by batches. Only the unscoped
+// datastore can chain, as the chain spans all organizations.
+func (db *Auditstore) ChainAuditEvents(key []byte) (int, error) {
+	if db.orgId != datastore.AllOrganizations {
+		return 0, datastore.ErrorOutsideOrganization
+	}
+
+	var total int
+	for {
+		n, err := db.chainAuditEvents(key)
+		total += n
+		if err != nil || n < ROWS_LIMIT_MAX {
+			return total, err
+		}
+	}
+}
+
+// chainAuditEvents chains a batch of events not chained yet. The head of the
+// chain is locked, so that concurrent servers chain events one after the other.
+func (db *Auditstore) chainAuditEvents(key []byte) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var head string
+	var seq int64
+	if err := tx.QueryRow(auditChainLockQuery).Scan(&head, &seq); err != nil {
+		return 0, err
+	}
+
+	var events []*model.AuditEvent
+	if err := meddler.QueryAll(tx, &events, auditUnchainedQuery, ROWS_LIMIT_MAX); err != nil {
+		return 0, err
+	}
+	for _, e := range events {
+		seq++
+		e.Seq, e.PrevHash, e.Keyed = seq, head, true
+		e.Hash = e.ComputeHash(key)
+		if _, err := tx.Exec(auditEventChainQuery, e.Seq, e.PrevHash, e.Hash, e.ID); err != nil {
+			return 0, err
+		}
+		head = e.Hash
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	if _, err := tx.Exec(auditChainUpdateQuery, head, seq); err != nil {
+		return 0, err
+	}
+	return len(events), tx.Commit()
+}
+
+func (db *Auditstore) GetAuditEvents(f *model.AuditFilter) ([]*model.AuditEvent, error) {
+	var where = []string{"(?=-1 OR org_id=?)"}
+	var args = []interface{}{db.orgId, db.orgId}
+
+	if f.ActorID != 0 {
+		where = append(where, "actor_id=?")
+		args = append(args, f.ActorID)
+	}
+	if f.DocumentID != 0 {
+		where = append(where, "document_id=?")
+		args = append(args, f.DocumentID)
+	}
+	if f.Action != "" {
+		where = append(where, "action=?")
+		args = append(args, f.Action)
+	}
+	if f.Since != 0 {
+		where = append(where, "created>=?")
+		args = append(args, f.Since)
+	}
+	if f.Until != 0 {
+		where = append(where, "created<?")
+		args = append(args, f.Until)
+	}
+	if f.BeforeID != 0 {
+		where = append(where, "id<?")
+		args = append(args, f.BeforeID)
+	}
+
+	var limit = f.Limit
+	switch {
+	case limit <= 0:
+		limit = ROWS_LIMIT_DEFAULT
+	case limit > ROWS_LIMIT_MAX:
+		limit = ROWS_LIMIT_MAX
+	}
+	args = append(args, limit)
+
+	var events []*model.AuditEvent
+	var query = "SELECT * FROM audit_events WHERE " + strings.Join(where, " AND ") + " ORDER BY id DESC LIMIT ?"
+	var err = meddler.QueryAll(db, &events, query, args...)
+
+	return events, err
+}
+
+// VerifyAuditEvents walks the chain of events from the first one. Only the
+// unscoped datastore can verify, as the chain spans all organizations.
+func (db *Auditstore) VerifyAuditEvents(key []byte) (*model.AuditVerification, error) {
+	if db.orgId != datastore.AllOrganizations {
+		return nil, datastore.ErrorOutsideOrganization
+	}
+
+	var v = &model.AuditVerification{Valid: true}
+	if err := db.QueryRow(auditPendingQuery).Scan(&v.Pending); err != nil {
+		return nil, err
+	}
+
+	var afterSeq int64
+	for {
+		var events []*model.AuditEvent
+		if err := meddler.QueryAll(db, &events, auditChainQuery, afterSeq, ROWS_LIMIT_MAX); err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			if !v.Check(e, key) {
+				return v, nil
+			}
+			afterSeq = e.Seq
+		}
+		if len(events) < ROWS_LIMIT_MAX {
+			break
+		}
+	}
+
+	// Events removed from the end leave a valid, but shorter, chain.
+	var head string
+	if err := db.QueryRow(auditChainHeadQuery).Scan(&head); err != nil {
+		return nil, err
+	}
+	if head != v.Head {
+		v.Valid = false
+	}
+
+	return v, nil
+}
+
+const auditEventTable = "audit_events"
+
+// The chain keeps the sequence of its last event in last_id.
+const auditChainLockQuery = `
+SELECT head, last_id FROM audit_chain
+WHERE id=1 FOR UPDATE
+`
+
+const auditUnchainedQuery = `
+SELECT * FROM audit_events
+WHERE seq=0
+ORDER BY id ASC LIMIT ?
+`
+
+const auditEventChainQuery = `
+UPDATE audit_events SET seq=?, prev_hash=?, hash=?, keyed=TRUE
+WHERE id=? AND seq=0
+`
+
+const auditPendingQuery = `
+SELECT COUNT(*) FROM audit_events
+WHERE seq=0
+`
+
+const auditChainUpdateQuery = `
+UPDATE audit_chain SET head=?, last_id=?
+WHERE id=1
+`
+
+const auditChainHeadQuery = `
+SELECT head FROM audit_chain
+WHERE id=1
+`
+
+const auditChainQuery = `
+SELECT * FROM audit_events
+WHERE seq>?
+ORDER BY seq ASC LIMIT ?
+`
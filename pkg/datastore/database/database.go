@@ -44,6 +44,24 @@ func MustConnect(dsn string) *sql.DB {
 
 	var migrations = []migration.Migrator{
 		migrate.Setup,
+		migrate.AddUserTokens,
+		migrate.AddUserVerified,
+		migrate.AddUserTokenGeneration,
+		migrate.AddAPITokens,
+		migrate.AddUserTOTP,
+		migrate.AddLoginAttempts,
+		migrate.AddRoles,
+		migrate.AddUserDeactivated,
+		migrate.AddUserAuthSource,
+		migrate.AddUserExternalID,
+		migrate.AddGroups,
+		migrate.AddOrganizations,
+		migrate.AddAuditChain,
+		migrate.AddRateLimits,
+		migrate.AddUserPendingEmail,
+		migrate.AddDocumentParticipantsUnique,
+		migrate.AddOrganizationUsage,
+		migrate.AddAuditSeq,
 	}
 
 	db, err := migration.Open("mysql", dsn, migrations)
@@ -53,12 +71,42 @@ func MustConnect(dsn string) *sql.DB {
 	return db
 }
 
+// NewDatastore returns the datastore of db, not scoped to any organization.
 func NewDatastore(db *sql.DB) datastore.Datastore {
-	return struct {
-		*Userstore
-		*Documentstore
-	}{
-		NewUserstore(db),
-		NewDocumentstore(db),
+	return newDatastore(db, datastore.AllOrganizations)
+}
+
+type store struct {
+	*Userstore
+	*Documentstore
+	*Tokenstore
+	*APITokenstore
+	*LoginAttemptstore
+	*Auditstore
+	*Rolestore
+	*Groupstore
+	*Organizationstore
+	*RateLimitstore
+
+	db *sql.DB
+}
+
+func newDatastore(db *sql.DB, orgId int64) *store {
+	return &store{
+		NewUserstore(db, orgId),
+		NewDocumentstore(db, orgId),
+		NewTokenstore(db),
+		NewAPITokenstore(db),
+		NewLoginAttemptstore(db),
+		NewAuditstore(db, orgId),
+		NewRolestore(db),
+		NewGroupstore(db, orgId),
+		NewOrganizationstore(db, orgId),
+		NewRateLimitstore(db),
+		db,
 	}
 }
+
+func (s *store) ForOrganization(orgId int64) datastore.Datastore {
+	return newDatastore(s.db, orgId)
+}
@@ -4,12 +4,14 @@ import (
 	"log"
 	"time"
 
+	"github.com/gedex/simdoc/pkg/datastore"
 	"github.com/gedex/simdoc/pkg/model"
 	"github.com/russross/meddler"
 )
 
 type Documentstore struct {
 	meddler.DB
+	orgId int64
 }
 
 type DocStatus bool
@@ -18,25 +20,36 @@ func init() {
 	meddler.Register("doc_status", DocStatus(false))
 }
 
-func NewDocumentstore(db meddler.DB) *Documentstore {
-	return &Documentstore{db}
+func NewDocumentstore(db meddler.DB, orgId int64) *Documentstore {
+	return &Documentstore{db, orgId}
 }
 
 func (db *Documentstore) GetDocumentById(docId int64) (*model.Document, error) {
 	var doc = new(model.Document)
-	var err = meddler.Load(db, docTable, doc, docId)
+	var err = meddler.QueryRow(db, doc, docByIdQuery, docId, db.orgId, db.orgId)
 
 	return doc, err
 }
 
 func (db *Documentstore) GetAllDocuments() ([]*model.Document, error) {
 	var docs []*model.Document
-	var err = meddler.QueryAll(db, &docs, docListQuery)
+	var err = meddler.QueryAll(db, &docs, docListQuery, db.orgId, db.orgId)
+
+	return docs, err
+}
+
+func (db *Documentstore) GetDocumentsReadableBy(userId int64) ([]*model.Document, error) {
+	var docs []*model.Document
+	var err = meddler.QueryAll(db, &docs, docReadableListQuery, db.orgId, db.orgId,
+		model.DocumentStatusPublished, userId, userId, userId)
 
 	return docs, err
 }
 
 func (db *Documentstore) AddDocument(doc *model.Document) error {
+	if db.orgId != datastore.AllOrganizations {
+		doc.OrgID = db.orgId
+	}
 	if doc.Created == 0 {
 		doc.Created = time.Now().UTC().Unix()
 	}
@@ -46,25 +59,31 @@ func (db *Documentstore) AddDocument(doc *model.Document) error {
 }
 
 func (db *Documentstore) UpdateDocument(doc *model.Document) error {
+	if db.orgId != datastore.AllOrganizations && doc.OrgID != db.orgId {
+		return datastore.ErrorOutsideOrganization
+	}
 	doc.Updated = time.Now().UTC().Unix()
 
 	return meddler.Save(db, docTable, doc)
 }
 
 func (db *Documentstore) DeleteDocument(docId int64) error {
-	var _, err = db.Exec(docDeleteQuery, docId)
+	var _, err = db.Exec(docDeleteQuery, docId, db.orgId, db.orgId)
 
 	return err
 }
 
 func (db *Documentstore) GetAllDocumentFiles(docId int64) ([]*model.DocumentFile, error) {
 	var files []*model.DocumentFile
-	var err = meddler.QueryAll(db, &files, docFilesListQuery, docId)
+	var err = meddler.QueryAll(db, &files, docFilesListQuery, docId, db.orgId, db.orgId)
 
 	return files, err
 }
 
 func (db *Documentstore) AddDocumentFile(f *model.DocumentFile) error {
+	if err := db.checkDocument(f.DocumentID); err != nil {
+		return err
+	}
 	if f.Created == 0 {
 		f.Created = time.Now().UTC().Unix()
 	}
@@ -73,46 +92,180 @@ func (db *Documentstore) AddDocumentFile(f *model.DocumentFile) error {
 	return meddler.Save(db, docFilesTable, f)
 }
 
+func (db *Documentstore) UpdateDocumentFile(f *model.DocumentFile) error {
+	if err := db.checkDocument(f.DocumentID); err != nil {
+		return err
+	}
+	f.Updated = time.Now().UTC().Unix()
+
+	return meddler.Save(db, docFilesTable, f)
+}
+
 func (db *Documentstore) DeleteDocumentFile(fileId int64) error {
-	var _, err = db.Exec(docFileDeleteQuery, fileId)
+	var _, err = db.Exec(docFileDeleteQuery, fileId, db.orgId, db.orgId)
 
 	return err
 }
 
 func (db *Documentstore) DeleteDocumentFiles(docId int64) error {
-	var _, err = db.Exec(docFilesDeleteQuery, docId)
+	var _, err = db.Exec(docFilesDeleteQuery, docId, db.orgId, db.orgId)
+
+	return err
+}
+
+func (db *Documentstore) ReassignDocuments(fromUserId, toUserId int64) error {
+	var _, err = db.Exec(docReassignQuery, toUserId, fromUserId, db.orgId, db.orgId)
+
+	return err
+}
+
+func (db *Documentstore) DeleteUserParticipations(userId int64) error {
+	var _, err = db.Exec(docParticipantsDeleteByUserQuery, userId, db.orgId, db.orgId)
+
+	return err
+}
+
+func (db *Documentstore) GetAllDocumentParticipants(docId int64) ([]*model.DocumentParticipant, error) {
+	var participants []*model.DocumentParticipant
+	var err = meddler.QueryAll(db, &participants, docParticipantsListQuery, docId, db.orgId, db.orgId)
+
+	return participants, err
+}
+
+func (db *Documentstore) AddDocumentParticipant(p *model.DocumentParticipant) (bool, error) {
+	if err := db.checkDocument(p.DocumentID); err != nil {
+		return false, err
+	}
+
+	res, err := db.Exec(docParticipantUpsertQuery, p.DocumentID, p.UserID, p.GroupID, p.Role)
+	if err != nil {
+		return false, err
+	}
+	if p.ID, err = res.LastInsertId(); err != nil {
+		return false, err
+	}
+
+	// One row is affected by inserts, two by updates and none when the role
+	// is unchanged.
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (db *Documentstore) DeleteDocumentParticipant(docId, participantId int64) error {
+	var _, err = db.Exec(docParticipantDeleteQuery, participantId, docId, db.orgId, db.orgId)
 
 	return err
 }
 
+func (db *Documentstore) GetDocumentParticipantRoles(docId, userId int64) ([]string, error) {
+	rows, err := db.Query(docParticipantRolesQuery, docId, db.orgId, db.orgId, userId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// checkDocument checks that document docId is in the organization of db.
+func (db *Documentstore) checkDocument(docId int64) error {
+	if db.orgId == datastore.AllOrganizations {
+		return nil
+	}
+	doc, err := db.GetDocumentById(docId)
+	if err != nil {
+		return err
+	}
+	if doc.OrgID != db.orgId {
+		return datastore.ErrorOutsideOrganization
+	}
+	return nil
+}
+
 const docTable = "documents"
 
+// Queries take the organization twice, for all organizations when -1.
+
+const docByIdQuery = `
+SELECT * FROM documents
+WHERE id=? AND (?=-1 OR org_id=?)
+`
+
 const docListQuery = `
 SELECT * FROM documents
+WHERE ?=-1 OR org_id=?
+ORDER BY name
+`
+
+const docReadableListQuery = `
+SELECT * FROM documents
+WHERE (?=-1 OR org_id=?)
+AND (status=? OR created_by=? OR id IN (
+	SELECT document_participants.document_id FROM document_participants
+	LEFT JOIN group_members ON group_members.group_id=document_participants.group_id
+	WHERE document_participants.user_id=? OR group_members.user_id=?
+))
 ORDER BY name
 `
 
 const docDeleteQuery = `
 DELETE FROM documents
-WHERE id=?
+WHERE id=? AND (?=-1 OR org_id=?)
+`
+
+const docReassignQuery = `
+UPDATE documents SET created_by=?
+WHERE created_by=? AND (?=-1 OR org_id=?)
 `
 
 const docFilesTable = "document_files"
 
 const docFilesListQuery = `
-SELECT * FROM document_files
-WHERE document_id=?
-ORDER BY created
+SELECT document_files.* FROM document_files
+INNER JOIN documents ON documents.id=document_files.document_id
+WHERE document_files.document_id=? AND (?=-1 OR documents.org_id=?)
+ORDER BY document_files.created
 `
 
 const docFileDeleteQuery = `
-DELETE FROM document_files
-WHERE id=?
+DELETE document_files FROM document_files
+INNER JOIN documents ON documents.id=document_files.document_id
+WHERE document_files.id=? AND (?=-1 OR documents.org_id=?)
 `
 
 const docFilesDeleteQuery = `
-DELETE FROM document_files
-WHERE document_id=?
+DELETE document_files FROM document_files
+INNER JOIN documents ON documents.id=document_files.document_id
+WHERE document_files.document_id=? AND (?=-1 OR documents.org_id=?)
+`
+
+const docParticipantsTable = "document_participants"
+
+const docParticipantsListQuery = `
+SELECT document_participants.* FROM document_participants
+INNER JOIN documents ON documents.id=document_participants.document_id
+WHERE document_participants.document_id=? AND (?=-1 OR documents.org_id=?)
+`
+
+const docParticipantUpsertQuery = `
+INSERT INTO document_participants (document_id, user_id, group_id, role)
+VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE id=LAST_INSERT_ID(id), role=VALUES(role)
+`
+
+const docParticipantsDeleteByUserQuery = `
+DELETE document_participants FROM document_participants
+INNER JOIN documents ON documents.id=document_participants.document_id
+WHERE document_participants.user_id=? AND (?=-1 OR documents.org_id=?)
 `
 
 func (ds DocStatus) PreRead(fieldAddr interface{}) (scanTarget interface{}, err error) {
@@ -131,3 +284,18 @@ func (ds DocStatus) PreWrite(field interface{}) (saveValue interface{}, err erro
 	log.Printf("%+v\n", saveValue)
 	return field, nil
 }
+
+const docParticipantDeleteQuery = `
+DELETE document_participants FROM document_participants
+INNER JOIN documents ON documents.id=document_participants.document_id
+WHERE document_participants.id=? AND document_participants.document_id=?
+AND (?=-1 OR documents.org_id=?)
+`
+
+const docParticipantRolesQuery = `
+SELECT DISTINCT document_participants.role FROM document_participants
+INNER JOIN documents ON documents.id=document_participants.document_id
+LEFT JOIN group_members ON group_members.group_id=document_participants.group_id
+WHERE document_participants.document_id=? AND (?=-1 OR documents.org_id=?)
+AND (document_participants.user_id=? OR group_members.user_id=?)
+`
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type Groupstore struct {
+	meddler.DB
+	orgId int64
+}
+
+func NewGroupstore(db meddler.DB, orgId int64) *Groupstore {
+	return &Groupstore{db, orgId}
+}
+
+func (db *Groupstore) GetGroupById(id int64) (*model.Group, error) {
+	var group = new(model.Group)
+	var err = meddler.QueryRow(db, group, groupByIdQuery, id, db.orgId, db.orgId)
+
+	return group, err
+}
+
+func (db *Groupstore) GetGroupByName(name string) (*model.Group, error) {
+	var group = new(model.Group)
+	var err = meddler.QueryRow(db, group, groupByNameQuery, name, db.orgId, db.orgId)
+
+	return group, err
+}
+
+func (db *Groupstore) GetAllGroups() ([]*model.Group, error) {
+	var groups []*model.Group
+	var err = meddler.QueryAll(db, &groups, groupListQuery, db.orgId, db.orgId)
+
+	return groups, err
+}
+
+func (db *Groupstore) AddGroup(group *model.Group) error {
+	if db.orgId != datastore.AllOrganizations {
+		group.OrgID = db.orgId
+	}
+	if group.Created == 0 {
+		group.Created = time.Now().UTC().Unix()
+	}
+	group.Updated = time.Now().UTC().Unix()
+
+	return meddler.Save(db, groupTable, group)
+}
+
+func (db *Groupstore) UpdateGroup(group *model.Group) error {
+	if err := db.checkGroup(group.ID); err != nil {
+		return err
+	}
+	group.Updated = time.Now().UTC().Unix()
+
+	return meddler.Save(db, groupTable, group)
+}
+
+func (db *Groupstore) DeleteGroup(id int64) error {
+	if err := db.checkGroup(id); err != nil {
+		return err
+	}
+	for _, q := range []string{groupMembersDeleteQuery, docParticipantsDeleteByGroupQuery, groupDeleteQuery} {
+		if _, err := db.Exec(q, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Groupstore) GetAllGroupMembers(groupId int64) ([]*model.User, error) {
+	var users []*model.User
+	var err = meddler.QueryAll(db, &users, groupMembersListQuery, groupId, db.orgId, db.orgId)
+
+	return users, err
+}
+
+func (db *Groupstore) AddGroupMember(m *model.GroupMember) error {
+	if err := db.checkGroup(m.GroupID); err != nil {
+		return err
+	}
+	if m.Created == 0 {
+		m.Created = time.Now().UTC().Unix()
+	}
+
+	return meddler.Save(db, groupMembersTable, m)
+}
+
+func (db *Groupstore) DeleteGroupMember(groupId, userId int64) error {
+	if err := db.checkGroup(groupId); err != nil {
+		return err
+	}
+	var _, err = db.Exec(groupMemberDeleteQuery, groupId, userId)
+
+	return err
+}
+
+func (db *Groupstore) DeleteUserMemberships(userId int64) error {
+	var _, err = db.Exec(groupMembersDeleteByUserQuery, userId)
+
+	return err
+}
+
+// checkGroup checks that group id is in the organization of db.
+func (db *Groupstore) checkGroup(id int64) error {
+	if db.orgId == datastore.AllOrganizations {
+		return nil
+	}
+	group, err := db.GetGroupById(id)
+	if err != nil {
+		return err
+	}
+	if group.OrgID != db.orgId {
+		return datastore.ErrorOutsideOrganization
+	}
+	return nil
+}
+
+const groupTable = "user_groups"
+
+// Queries take the organization twice, for all organizations when -1.
+
+const groupByIdQuery = `
+SELECT * FROM user_groups
+WHERE id=? AND (?=-1 OR org_id=?)
+`
+
+const groupByNameQuery = `
+SELECT * FROM user_groups
+WHERE name=? AND (?=-1 OR org_id=?) LIMIT 1
+`
+
+const groupListQuery = `
+SELECT * FROM user_groups
+WHERE ?=-1 OR org_id=?
+ORDER BY name ASC
+`
+
+const groupDeleteQuery = `
+DELETE FROM user_groups
+WHERE id=?
+`
+
+const groupMembersTable = "group_members"
+
+const groupMembersListQuery = `
+SELECT users.* FROM users
+INNER JOIN group_members ON group_members.user_id=users.id
+WHERE group_members.group_id=? AND (?=-1 OR users.org_id=?)
+ORDER BY users.login ASC
+`
+
+const groupMemberDeleteQuery = `
+DELETE FROM group_members
+WHERE group_id=? AND user_id=?
+`
+
+const groupMembersDeleteQuery = `
+DELETE FROM group_members
+WHERE group_id=?
+`
+
+const groupMembersDeleteByUserQuery = `
+DELETE FROM group_members
+WHERE user_id=?
+`
+
+const docParticipantsDeleteByGroupQuery = `
+DELETE FROM document_participants
+WHERE group_id=?
+`
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type LoginAttemptstore struct {
+	*sql.DB
+}
+
+func NewLoginAttemptstore(db *sql.DB) *LoginAttemptstore {
+	return &LoginAttemptstore{db}
+}
+
+func (db *LoginAttemptstore) GetLoginAttempt(key string) (*model.LoginAttempt, error) {
+	var a = new(model.LoginAttempt)
+	var err = meddler.QueryRow(db, a, loginAttemptByKeyQuery, key)
+
+	return a, err
+}
+
+// UpdateLoginAttempt locks the row of key, created first if needed, so that
+// concurrent failures of the key are counted one after the other.
+func (db *LoginAttemptstore) UpdateLoginAttempt(key string, fn func(a *model.LoginAttempt)) (*model.LoginAttempt, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(loginAttemptInsertQuery, key); err != nil {
+		return nil, err
+	}
+	var a = new(model.LoginAttempt)
+	if err := meddler.QueryRow(tx, a, loginAttemptLockQuery, key); err != nil {
+		return nil, err
+	}
+
+	fn(a)
+	a.Key = key
+	if _, err := tx.Exec(loginAttemptUpdateQuery, a.Failures, a.LastFailure, a.LockedUntil, a.Locked, a.Expires, key); err != nil {
+		return nil, err
+	}
+
+	return a, tx.Commit()
+}
+
+func (db *LoginAttemptstore) DeleteLoginAttempt(key string) error {
+	var _, err = db.Exec(loginAttemptDeleteQuery, key)
+
+	return err
+}
+
+const loginAttemptByKeyQuery = `
+SELECT * FROM login_attempts
+WHERE attempt_key=? LIMIT 1
+`
+
+const loginAttemptInsertQuery = `
+INSERT IGNORE INTO login_attempts (attempt_key, failures, last_failure, locked_until, locked, expires)
+VALUES (?, 0, 0, 0, FALSE, 0)
+`
+
+const loginAttemptLockQuery = `
+SELECT * FROM login_attempts
+WHERE attempt_key=? FOR UPDATE
+`
+
+const loginAttemptUpdateQuery = `
+UPDATE login_attempts
+SET failures=?, last_failure=?, locked_until=?, locked=?, expires=?
+WHERE attempt_key=?
+`
+
+const loginAttemptDeleteQuery = `
+DELETE FROM login_attempts
+WHERE attempt_key=?
+`
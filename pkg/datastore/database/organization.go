@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type Organizationstore struct {
+	meddler.DB
+	orgId int64
+}
+
+func NewOrganizationstore(db meddler.DB, orgId int64) *Organizationstore {
+	return &Organizationstore{db, orgId}
+}
+
+func (db *Organizationstore) GetOrganizationById(id int64) (*model.Organization, error) {
+	var org = new(model.Organization)
+	var err = meddler.QueryRow(db, org, orgByIdQuery, id, db.orgId, db.orgId)
+
+	return org, err
+}
+
+func (db *Organizationstore) GetOrganizationBySlug(slug string) (*model.Organization, error) {
+	var org = new(model.Organization)
+	var err = meddler.QueryRow(db, org, orgBySlugQuery, slug, db.orgId, db.orgId)
+
+	return org, err
+}
+
+func (db *Organizationstore) GetAllOrganizations() ([]*model.Organization, error) {
+	var orgs []*model.Organization
+	var err = meddler.QueryAll(db, &orgs, orgListQuery, db.orgId, db.orgId)
+
+	return orgs, err
+}
+
+func (db *Organizationstore) AddOrganization(org *model.Organization) error {
+	if db.orgId != datastore.AllOrganizations {
+		return datastore.ErrorOutsideOrganization
+	}
+	if org.Created == 0 {
+		org.Created = time.Now().UTC().Unix()
+	}
+	org.Updated = time.Now().UTC().Unix()
+
+	return meddler.Save(db, orgTable, org)
+}
+
+func (db *Organizationstore) UpdateOrganization(org *model.Organization) error {
+	if db.orgId != datastore.AllOrganizations && org.ID != db.orgId {
+		return datastore.ErrorOutsideOrganization
+	}
+	org.Updated = time.Now().UTC().Unix()
+
+	return meddler.Save(db, orgTable, org)
+}
+
+func (db *Organizationstore) DeleteOrganization(id int64) error {
+	if db.orgId != datastore.AllOrganizations {
+		return datastore.ErrorOutsideOrganization
+	}
+
+	var cmds = []string{
+		orgGroupMembersDeleteQuery,
+		orgGroupsDeleteQuery,
+		orgDocParticipantsDeleteQuery,
+		orgDocFilesDeleteQuery,
+		orgDocsDeleteQuery,
+		orgDeleteQuery,
+	}
+	for _, q := range cmds {
+		if _, err := db.Exec(q, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Organizationstore) GetOrganizationUsage(id int64) (int64, error) {
+	var bytes sql.NullInt64
+	if err := db.QueryRow(orgUsageQuery, id, db.orgId, db.orgId).Scan(&bytes); err != nil {
+		return 0, err
+	}
+	if !bytes.Valid {
+		return 0, datastore.ErrorUsageUncounted
+	}
+
+	return bytes.Int64, nil
+}
+
+func (db *Organizationstore) InitOrganizationUsage(id, bytes int64) error {
+	var _, err = db.Exec(orgUsageInitQuery, bytes, id, db.orgId, db.orgId)
+
+	return err
+}
+
+func (db *Organizationstore) AddOrganizationUsage(id, bytes, quota int64) (bool, error) {
+	// Unchanged rows are not counted as affected.
+	if bytes == 0 {
+		return true, nil
+	}
+
+	res, err := db.Exec(orgUsageAddQuery, bytes, id, db.orgId, db.orgId, bytes, quota, bytes, quota)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n == 1, err
+}
+
+const orgTable = "organizations"
+
+// Queries take the organization twice, for all organizations when -1.
+
+const orgByIdQuery = `
+SELECT * FROM organizations
+WHERE id=? AND (?=-1 OR id=?)
+`
+
+const orgBySlugQuery = `
+SELECT * FROM organizations
+WHERE slug=? AND (?=-1 OR id=?) LIMIT 1
+`
+
+const orgListQuery = `
+SELECT * FROM organizations
+WHERE ?=-1 OR id=?
+ORDER BY name ASC
+`
+
+const orgUsageQuery = `
+SELECT used_bytes FROM organizations
+WHERE id=? AND (?=-1 OR id=?)
+`
+
+const orgUsageInitQuery = `
+UPDATE organizations SET used_bytes=?
+WHERE id=? AND (?=-1 OR id=?) AND used_bytes IS NULL
+`
+
+// Releases are never refused, and the size never drops below zero.
+const orgUsageAddQuery = `
+UPDATE organizations SET used_bytes=GREATEST(used_bytes+?, 0)
+WHERE id=? AND (?=-1 OR id=?) AND used_bytes IS NOT NULL
+AND (?<0 OR ?=0 OR used_bytes+?<=?)
+`
+
+const orgGroupMembersDeleteQuery = `
+DELETE group_members FROM group_members
+INNER JOIN user_groups ON user_groups.id=group_members.group_id
+WHERE user_groups.org_id=?
+`
+
+const orgGroupsDeleteQuery = `
+DELETE FROM user_groups
+WHERE org_id=?
+`
+
+const orgDocParticipantsDeleteQuery = `
+DELETE document_participants FROM document_participants
+INNER JOIN documents ON documents.id=document_participants.document_id
+WHERE documents.org_id=?
+`
+
+const orgDocFilesDeleteQuery = `
+DELETE document_files FROM document_files
+INNER JOIN documents ON documents.id=document_files.document_id
+WHERE documents.org_id=?
+`
+
+const orgDocsDeleteQuery = `
+DELETE FROM documents
+WHERE org_id=?
+`
+
+const orgDeleteQuery = `
+DELETE FROM organizations
+WHERE id=?
+`
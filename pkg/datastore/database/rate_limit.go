@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type RateLimitstore struct {
+	*sql.DB
+}
+
+func NewRateLimitstore(db *sql.DB) *RateLimitstore {
+	return &RateLimitstore{db}
+}
+
+func (db *RateLimitstore) GetRateLimitBucket(key string) (*model.RateLimitBucket, error) {
+	var b = new(model.RateLimitBucket)
+	var err = meddler.QueryRow(db, b, rateLimitByKeyQuery, key)
+
+	return b, err
+}
+
+// UpdateRateLimitBucket locks the row of key, created first if needed, so that
+// concurrent requests of the key take tokens one after the other.
+func (db *RateLimitstore) UpdateRateLimitBucket(key string, fn func(b *model.RateLimitBucket)) (*model.RateLimitBucket, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rateLimitInsertQuery, key); err != nil {
+		return nil, err
+	}
+	var b = new(model.RateLimitBucket)
+	if err := meddler.QueryRow(tx, b, rateLimitLockQuery, key); err != nil {
+		return nil, err
+	}
+
+	fn(b)
+	b.Key = key
+	if _, err := tx.Exec(rateLimitUpdateQuery, b.Tokens, b.Updated, b.Expires, key); err != nil {
+		return nil, err
+	}
+
+	return b, tx.Commit()
+}
+
+func (db *RateLimitstore) DeleteExpiredRateLimitBuckets(now int64) error {
+	var _, err = db.Exec(rateLimitDeleteExpiredQuery, now)
+
+	return err
+}
+
+const rateLimitByKeyQuery = `
+SELECT * FROM rate_limits
+WHERE bucket_key=? LIMIT 1
+`
+
+const rateLimitInsertQuery = `
+INSERT IGNORE INTO rate_limits (bucket_key, tokens, updated, expires)
+VALUES (?, 0, 0, 0)
+`
+
+const rateLimitLockQuery = `
+SELECT * FROM rate_limits
+WHERE bucket_key=? FOR UPDATE
+`
+
+const rateLimitUpdateQuery = `
+UPDATE rate_limits
+SET tokens=?, updated=?, expires=?
+WHERE bucket_key=?
+`
+
+const rateLimitDeleteExpiredQuery = `
+DELETE FROM rate_limits
+WHERE expires<?
+`
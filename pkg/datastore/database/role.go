@@ -0,0 +1,80 @@
+package database
+
+import (
+	"time"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type Rolestore struct {
+	meddler.DB
+}
+
+func NewRolestore(db meddler.DB) *Rolestore {
+	return &Rolestore{db}
+}
+
+func (db *Rolestore) GetRoleByName(name string) (*model.Role, error) {
+	var role = new(model.Role)
+	var err = meddler.QueryRow(db, role, roleByNameQuery, name)
+
+	return role, err
+}
+
+func (db *Rolestore) GetAllRoles() ([]*model.Role, error) {
+	var roles []*model.Role
+	var err = meddler.QueryAll(db, &roles, roleListQuery)
+
+	return roles, err
+}
+
+func (db *Rolestore) AddRole(role *model.Role) error {
+	if role.Created == 0 {
+		role.Created = time.Now().UTC().Unix()
+	}
+	role.Updated = time.Now().UTC().Unix()
+
+	return meddler.Save(db, roleTable, role)
+}
+
+func (db *Rolestore) UpdateRole(role *model.Role) error {
+	role.Updated = time.Now().UTC().Unix()
+
+	return meddler.Save(db, roleTable, role)
+}
+
+func (db *Rolestore) DeleteRole(id int64) error {
+	var _, err = db.Exec(roleDeleteQuery, id)
+
+	return err
+}
+
+func (db *Rolestore) CountUsersByRole(name string) (int64, error) {
+	var count int64
+	var err = db.QueryRow(usersByRoleCountQuery, name).Scan(&count)
+
+	return count, err
+}
+
+const roleTable = "roles"
+
+const roleByNameQuery = `
+SELECT * FROM roles
+WHERE name=? LIMIT 1
+`
+
+const roleListQuery = `
+SELECT * FROM roles
+ORDER BY name ASC
+`
+
+const roleDeleteQuery = `
+DELETE FROM roles
+WHERE id=?
+`
+
+const usersByRoleCountQuery = `
+SELECT COUNT(*) FROM users
+WHERE role=?
+`
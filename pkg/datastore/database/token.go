@@ -0,0 +1,81 @@
+package database
+
+import (
+	"time"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/russross/meddler"
+)
+
+type Tokenstore struct {
+	meddler.DB
+}
+
+func NewTokenstore(db meddler.DB) *Tokenstore {
+	return &Tokenstore{db}
+}
+
+func (db *Tokenstore) GetUserTokenByHash(kind, hash string) (*model.UserToken, error) {
+	var t = new(model.UserToken)
+	var err = meddler.QueryRow(db, t, userTokenByHashQuery, kind, hash)
+
+	return t, err
+}
+
+func (db *Tokenstore) AddUserToken(t *model.UserToken) error {
+	if t.Created == 0 {
+		t.Created = time.Now().UTC().Unix()
+	}
+
+	return meddler.Save(db, userTokenTable, t)
+}
+
+func (db *Tokenstore) DeleteUserToken(id int64) error {
+	var _, err = db.Exec(userTokenDeleteQuery, id)
+
+	return err
+}
+
+func (db *Tokenstore) ConsumeUserToken(id int64) (bool, error) {
+	res, err := db.Exec(userTokenDeleteQuery, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (db *Tokenstore) DeleteUserTokens(userId int64, kind string) error {
+	var _, err = db.Exec(userTokensDeleteQuery, userId, kind)
+
+	return err
+}
+
+func (db *Tokenstore) DeleteAllUserTokens(userId int64) error {
+	var _, err = db.Exec(userAllTokensDeleteQuery, userId)
+
+	return err
+}
+
+const userTokenTable = "user_tokens"
+
+const userTokenByHashQuery = `
+SELECT * FROM user_tokens
+WHERE kind=? AND hash=? LIMIT 1
+`
+
+const userTokenDeleteQuery = `
+DELETE FROM user_tokens
+WHERE id=?
+`
+
+const userTokensDeleteQuery = `
+DELETE FROM user_tokens
+WHERE user_id=? AND kind=?
+`
+
+const userAllTokensDeleteQuery = `
+DELETE FROM user_tokens
+WHERE user_id=?
+`
@@ -1,49 +1,69 @@
 package database
 
 import (
+	"database/sql"
 	"time"
 
+	"github.com/gedex/simdoc/pkg/datastore"
 	"github.com/gedex/simdoc/pkg/model"
 	"github.com/russross/meddler"
 )
 
 type Userstore struct {
-	meddler.DB
+	*sql.DB
+	orgId int64
 }
 
-func NewUserstore(db meddler.DB) *Userstore {
-	return &Userstore{db}
+func NewUserstore(db *sql.DB, orgId int64) *Userstore {
+	return &Userstore{db, orgId}
 }
 
 func (db *Userstore) GetUserById(id int64) (*model.User, error) {
 	var usr = new(model.User)
-	var err = meddler.Load(db, userTable, usr, id)
+	var err = meddler.QueryRow(db, usr, userByIdQuery, id, db.orgId, db.orgId)
 
 	return usr, err
 }
 
 func (db *Userstore) GetUserByLogin(loginOrEmail string) (*model.User, error) {
 	var usr = new(model.User)
-	var err = meddler.QueryRow(db, usr, userByLoginQuery, loginOrEmail, loginOrEmail)
+	var err = meddler.QueryRow(db, usr, userByLoginQuery, loginOrEmail, loginOrEmail, db.orgId, db.orgId)
 
 	return usr, err
 }
 
-func (db *Userstore) GetUserByLoginAndPassword(loginOrEmail, password string) (*model.User, error) {
+func (db *Userstore) GetUserByLoginName(login string) (*model.User, error) {
 	var usr = new(model.User)
-	var err = meddler.QueryRow(db, usr, userByLoginAndPasswordQuery, loginOrEmail, loginOrEmail, password)
+	var err = meddler.QueryRow(db, usr, userByLoginNameQuery, login, db.orgId, db.orgId)
+
+	return usr, err
+}
+
+func (db *Userstore) GetUserByExternalID(source, id string) (*model.User, error) {
+	var usr = new(model.User)
+	var err = meddler.QueryRow(db, usr, userByExternalIDQuery, source, id, db.orgId, db.orgId)
 
 	return usr, err
 }
 
 func (db *Userstore) GetAllUsers() ([]*model.User, error) {
 	var users []*model.User
-	var err = meddler.QueryAll(db, &users, userListQuery)
+	var err = meddler.QueryAll(db, &users, userListQuery, db.orgId, db.orgId)
 
 	return users, err
 }
 
+func (db *Userstore) CountUsers() (int64, error) {
+	var count int64
+	var err = db.QueryRow(userCountQuery, db.orgId, db.orgId).Scan(&count)
+
+	return count, err
+}
+
 func (db *Userstore) AddUser(user *model.User) error {
+	if db.orgId != datastore.AllOrganizations {
+		user.OrgID = db.orgId
+	}
 	if user.Created == 0 {
 		user.Created = time.Now().UTC().Unix()
 	}
@@ -53,39 +73,99 @@ func (db *Userstore) AddUser(user *model.User) error {
 }
 
 func (db *Userstore) UpdateUser(user *model.User) error {
+	if db.orgId != datastore.AllOrganizations && user.OrgID != db.orgId {
+		return datastore.ErrorOutsideOrganization
+	}
 	user.Updated = time.Now().UTC().Unix()
 
 	return meddler.Save(db, userTable, user)
 }
 
+func (db *Userstore) UpdateUserTOTPStep(id, step int64) (bool, error) {
+	res, err := db.Exec(userTOTPStepUpdateQuery, step, time.Now().UTC().Unix(), id, step, db.orgId, db.orgId)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
 func (db *Userstore) DeleteUser(id int64) error {
-	var _, err = db.Exec(userDeleteQuery, id)
+	var _, err = db.Exec(userDeleteQuery, id, db.orgId, db.orgId)
 
 	return err
 }
 
+// DeleteUserReassigning runs all deletions in a transaction, so that a failure
+// doesn't leave the user with part of their records.
+func (db *Userstore) DeleteUserReassigning(id, toUserId int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{docReassignQuery, []interface{}{toUserId, id, db.orgId, db.orgId}},
+		{docParticipantsDeleteByUserQuery, []interface{}{id, db.orgId, db.orgId}},
+		{groupMembersDeleteByUserQuery, []interface{}{id}},
+		{userAllTokensDeleteQuery, []interface{}{id}},
+		{apiTokensDeleteByUserQuery, []interface{}{id}},
+		{userDeleteQuery, []interface{}{id, db.orgId, db.orgId}},
+	} {
+		if _, err := tx.Exec(q.query, q.args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 const userTable = "users"
 
+// Queries take the organization twice, for all organizations when -1.
+
+const userByIdQuery = `
+SELECT * FROM users
+WHERE id=? AND (?=-1 OR org_id=?)
+`
+
 const userByLoginQuery = `
 SELECT * FROM users
-WHERE login=? OR email=? LIMIT 1
+WHERE (login=? OR email=?) AND (?=-1 OR org_id=?) LIMIT 1
 `
 
-const userByLoginAndPasswordQuery = `
+const userByLoginNameQuery = `
 SELECT * FROM users
-WHERE
-	(login=? OR email=?)
-	AND
-	password=?
-LIMIT 1
+WHERE login=? AND (?=-1 OR org_id=?) LIMIT 1
+`
+
+const userByExternalIDQuery = `
+SELECT * FROM users
+WHERE auth_source=? AND external_id=? AND (?=-1 OR org_id=?) LIMIT 1
 `
 
 const userListQuery = `
 SELECT * FROM users
+WHERE ?=-1 OR org_id=?
 ORDER BY login ASC
 `
 
+const userCountQuery = `
+SELECT COUNT(*) FROM users
+WHERE ?=-1 OR org_id=?
+`
+
+const userTOTPStepUpdateQuery = `
+UPDATE users SET totp_last_step=?, updated=?
+WHERE id=? AND totp_last_step<? AND (?=-1 OR org_id=?)
+`
+
 const userDeleteQuery = `
 DELETE FROM users
-WHERE id=?
+WHERE id=? AND (?=-1 OR org_id=?)
 `
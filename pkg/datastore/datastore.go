@@ -1,6 +1,35 @@
 package datastore
 
+import "errors"
+
+// AllOrganizations scopes a datastore to all organizations, see
+// Datastore.ForOrganization.
+const AllOrganizations int64 = -1
+
+// ErrorOutsideOrganization is returned when writing a record that doesn't
+// belong to the organization the datastore is scoped to.
+var ErrorOutsideOrganization = errors.New("Record outside of organization")
+
+// ErrorUsageUncounted is returned when the storage usage of an organization
+// isn't counted yet, see Organizationstore.InitOrganizationUsage.
+var ErrorUsageUncounted = errors.New("Storage usage of organization is not counted")
+
 type Datastore interface {
 	Userstore
 	Documentstore
+	Tokenstore
+	APITokenstore
+	LoginAttemptstore
+	Auditstore
+	Rolestore
+	Groupstore
+	Organizationstore
+	RateLimitstore
+
+	// ForOrganization returns the datastore scoped to organization orgId:
+	// users, documents, groups and organizations of other organizations are
+	// neither read nor written. orgId 0 scopes to records of no organization,
+	// and AllOrganizations removes the scope. The scope of the datastore
+	// itself doesn't restrict the returned one.
+	ForOrganization(orgId int64) Datastore
 }
@@ -12,6 +12,12 @@ type Documentstore interface {
 	// GetAllDocuments retrieves a list of all documents from the datastore.
 	GetAllDocuments() ([]*model.Document, error)
 
+	// GetDocumentsReadableBy retrieves a list of documents readable by a user,
+	// for the given userId, from the datastore: published documents, documents
+	// created by the user and documents shared with the user, directly or
+	// through a group.
+	GetDocumentsReadableBy(userId int64) ([]*model.Document, error)
+
 	// AddDocuments adds a document into the datastore.
 	AddDocument(doc *model.Document) error
 
@@ -28,12 +34,41 @@ type Documentstore interface {
 	// AddDocumentFile adds a file to a document in the datastore.
 	AddDocumentFile(f *model.DocumentFile) error
 
+	// UpdateDocumentFile updates a file of a document in the datastore.
+	UpdateDocumentFile(f *model.DocumentFile) error
+
 	// DeleteDocumentFile deletes a file, for the given fileId, in the datastore.
 	DeleteDocumentFile(fileId int64) error
 
 	// DeleteDocumentFIles delete all files in a document, for the given docId,
 	// in the datastore.
 	DeleteDocumentFiles(docId int64) error
+
+	// ReassignDocuments reassigns all documents created by a user, for the given
+	// fromUserId, to another user, for the given toUserId, in the datastore.
+	ReassignDocuments(fromUserId, toUserId int64) error
+
+	// DeleteUserParticipations deletes a user, for the given userId, from
+	// participants of all documents in the datastore.
+	DeleteUserParticipations(userId int64) error
+
+	// GetAllDocumentParticipants retrieves a list of all participants of a
+	// document, for the given docId, from the datastore.
+	GetAllDocumentParticipants(docId int64) ([]*model.DocumentParticipant, error)
+
+	// AddDocumentParticipant adds a participant to a document in the datastore,
+	// or updates the role of the participant of the same user or group. It
+	// returns whether the participant is added.
+	AddDocumentParticipant(p *model.DocumentParticipant) (bool, error)
+
+	// DeleteDocumentParticipant deletes a participant, for the given
+	// participantId, from a document, for the given docId, in the datastore.
+	DeleteDocumentParticipant(docId, participantId int64) error
+
+	// GetDocumentParticipantRoles retrieves roles of a user, for the given
+	// userId, in a document, for the given docId, either as participant or as
+	// member of participating groups, from the datastore.
+	GetDocumentParticipantRoles(docId, userId int64) ([]string, error)
 }
 
 // GetDocumentById retrieves a document from the datastore for the given docId.
@@ -46,6 +81,13 @@ func GetAllDocuments(c context.Context) ([]*model.Document, error) {
 	return FromContext(c).GetAllDocuments()
 }
 
+// GetDocumentsReadableBy retrieves a list of documents readable by a user, for
+// the given userId, from the datastore: published documents, documents created
+// by the user and documents shared with the user, directly or through a group.
+func GetDocumentsReadableBy(c context.Context, userId int64) ([]*model.Document, error) {
+	return FromContext(c).GetDocumentsReadableBy(userId)
+}
+
 // AddDocuments adds a document into the datastore.
 func AddDocument(c context.Context, doc *model.Document) error {
 	return FromContext(c).AddDocument(doc)
@@ -72,6 +114,11 @@ func AddDocumentFile(c context.Context, f *model.DocumentFile) error {
 	return FromContext(c).AddDocumentFile(f)
 }
 
+// UpdateDocumentFile updates a file of a document in the datastore.
+func UpdateDocumentFile(c context.Context, f *model.DocumentFile) error {
+	return FromContext(c).UpdateDocumentFile(f)
+}
+
 // DeleteDocumentFile deletes a file, for the given fileId, in the datastore.
 func DeleteDocumentFile(c context.Context, fileId int64) error {
 	return FromContext(c).DeleteDocumentFile(fileId)
@@ -82,3 +129,41 @@ func DeleteDocumentFile(c context.Context, fileId int64) error {
 func DeleteDocumentFiles(c context.Context, docId int64) error {
 	return FromContext(c).DeleteDocumentFiles(docId)
 }
+
+// GetAllDocumentParticipants retrieves a list of all participants of a document,
+// for the given docId, from the datastore.
+func GetAllDocumentParticipants(c context.Context, docId int64) ([]*model.DocumentParticipant, error) {
+	return FromContext(c).GetAllDocumentParticipants(docId)
+}
+
+// AddDocumentParticipant adds a participant to a document in the datastore, or
+// updates the role of the participant of the same user or group. It returns
+// whether the participant is added.
+func AddDocumentParticipant(c context.Context, p *model.DocumentParticipant) (bool, error) {
+	return FromContext(c).AddDocumentParticipant(p)
+}
+
+// ReassignDocuments reassigns all documents created by a user, for the given
+// fromUserId, to another user, for the given toUserId, in the datastore.
+func ReassignDocuments(c context.Context, fromUserId, toUserId int64) error {
+	return FromContext(c).ReassignDocuments(fromUserId, toUserId)
+}
+
+// DeleteUserParticipations deletes a user, for the given userId, from
+// participants of all documents in the datastore.
+func DeleteUserParticipations(c context.Context, userId int64) error {
+	return FromContext(c).DeleteUserParticipations(userId)
+}
+
+// DeleteDocumentParticipant deletes a participant, for the given participantId,
+// from a document, for the given docId, in the datastore.
+func DeleteDocumentParticipant(c context.Context, docId, participantId int64) error {
+	return FromContext(c).DeleteDocumentParticipant(docId, participantId)
+}
+
+// GetDocumentParticipantRoles retrieves roles of a user, for the given userId,
+// in a document, for the given docId, either as participant or as member of
+// participating groups, from the datastore.
+func GetDocumentParticipantRoles(c context.Context, docId, userId int64) ([]string, error) {
+	return FromContext(c).GetDocumentParticipantRoles(docId, userId)
+}
@@ -0,0 +1,96 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type Groupstore interface {
+	// GetGroupById retrieves a group from the datastore for the given ID.
+	GetGroupById(id int64) (*model.Group, error)
+
+	// GetGroupByName retrieves a group from the datastore for the given name.
+	GetGroupByName(name string) (*model.Group, error)
+
+	// GetAllGroups retrieves a list of all groups from the datastore.
+	GetAllGroups() ([]*model.Group, error)
+
+	// AddGroup adds a group into the datastore.
+	AddGroup(group *model.Group) error
+
+	// UpdateGroup updates a group in the datastore.
+	UpdateGroup(group *model.Group) error
+
+	// DeleteGroup deletes a group, for the given ID, along with its members and
+	// document participations, in the datastore.
+	DeleteGroup(id int64) error
+
+	// GetAllGroupMembers retrieves a list of all users member of a group, for
+	// the given groupId, from the datastore.
+	GetAllGroupMembers(groupId int64) ([]*model.User, error)
+
+	// AddGroupMember adds a user to a group in the datastore.
+	AddGroupMember(m *model.GroupMember) error
+
+	// DeleteGroupMember removes a user, for the given userId, from a group, for
+	// the given groupId, in the datastore.
+	DeleteGroupMember(groupId, userId int64) error
+
+	// DeleteUserMemberships removes a user, for the given userId, from all
+	// groups in the datastore.
+	DeleteUserMemberships(userId int64) error
+}
+
+// GetGroupById retrieves a group from the datastore for the given ID.
+func GetGroupById(c context.Context, id int64) (*model.Group, error) {
+	return FromContext(c).GetGroupById(id)
+}
+
+// GetGroupByName retrieves a group from the datastore for the given name.
+func GetGroupByName(c context.Context, name string) (*model.Group, error) {
+	return FromContext(c).GetGroupByName(name)
+}
+
+// GetAllGroups retrieves a list of all groups from the datastore.
+func GetAllGroups(c context.Context) ([]*model.Group, error) {
+	return FromContext(c).GetAllGroups()
+}
+
+// AddGroup adds a group into the datastore.
+func AddGroup(c context.Context, group *model.Group) error {
+	return FromContext(c).AddGroup(group)
+}
+
+// UpdateGroup updates a group in the datastore.
+func UpdateGroup(c context.Context, group *model.Group) error {
+	return FromContext(c).UpdateGroup(group)
+}
+
+// DeleteGroup deletes a group, for the given ID, along with its members and
+// document participations, in the datastore.
+func DeleteGroup(c context.Context, id int64) error {
+	return FromContext(c).DeleteGroup(id)
+}
+
+// GetAllGroupMembers retrieves a list of all users member of a group, for the
+// given groupId, from the datastore.
+func GetAllGroupMembers(c context.Context, groupId int64) ([]*model.User, error) {
+	return FromContext(c).GetAllGroupMembers(groupId)
+}
+
+// AddGroupMember adds a user to a group in the datastore.
+func AddGroupMember(c context.Context, m *model.GroupMember) error {
+	return FromContext(c).AddGroupMember(m)
+}
+
+// DeleteGroupMember removes a user, for the given userId, from a group, for the
+// given groupId, in the datastore.
+func DeleteGroupMember(c context.Context, groupId, userId int64) error {
+	return FromContext(c).DeleteGroupMember(groupId, userId)
+}
+
+// DeleteUserMemberships removes a user, for the given userId, from all groups
+// in the datastore.
+func DeleteUserMemberships(c context.Context, userId int64) error {
+	return FromContext(c).DeleteUserMemberships(userId)
+}
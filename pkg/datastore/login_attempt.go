@@ -0,0 +1,39 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type LoginAttemptstore interface {
+	// GetLoginAttempt retrieves failed login attempts from the datastore for the
+	// given key.
+	GetLoginAttempt(key string) (*model.LoginAttempt, error)
+
+	// UpdateLoginAttempt applies fn to failed login attempts of a key, empty
+	// ones if there are none, and stores them in the datastore. Concurrent
+	// updates of the key are applied one after the other.
+	UpdateLoginAttempt(key string, fn func(a *model.LoginAttempt)) (*model.LoginAttempt, error)
+
+	// DeleteLoginAttempt deletes failed login attempts, for the given key, in
+	// the datastore.
+	DeleteLoginAttempt(key string) error
+}
+
+// GetLoginAttempt retrieves failed login attempts from the datastore for the
+// given key.
+func GetLoginAttempt(c context.Context, key string) (*model.LoginAttempt, error) {
+	return FromContext(c).GetLoginAttempt(key)
+}
+
+// UpdateLoginAttempt applies fn to failed login attempts of a key, empty ones
+// if there are none, and stores them in the datastore.
+func UpdateLoginAttempt(c context.Context, key string, fn func(a *model.LoginAttempt)) (*model.LoginAttempt, error) {
+	return FromContext(c).UpdateLoginAttempt(key, fn)
+}
+
+// DeleteLoginAttempt deletes failed login attempts, for the given key, in the
+// datastore.
+func DeleteLoginAttempt(c context.Context, key string) error {
+	return FromContext(c).DeleteLoginAttempt(key)
+}
@@ -1,7 +1,11 @@
 package migrate
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/BurntSushi/migration"
+	"github.com/gedex/simdoc/pkg/model"
 )
 
 func Setup(tx migration.LimitedTx) error {
@@ -21,6 +25,259 @@ func Setup(tx migration.LimitedTx) error {
 	return nil
 }
 
+// AddUserTokens creates table for single-use user tokens, for instance to reset
+// the password.
+func AddUserTokens(tx migration.LimitedTx) error {
+	_, err := tx.Exec(userTokensTable)
+	return err
+}
+
+// AddUserVerified adds verified flag to users. Existing users are considered
+// verified.
+func AddUserVerified(tx migration.LimitedTx) error {
+	var cmds = []string{
+		userVerifiedColumn,
+		userVerifiedExisting,
+	}
+
+	for _, cmd := range cmds {
+		_, err := tx.Exec(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddUserTokenGeneration adds token generation counter to users, used to revoke
+// all issued tokens of a user.
+func AddUserTokenGeneration(tx migration.LimitedTx) error {
+	_, err := tx.Exec(userTokenGenerationColumn)
+	return err
+}
+
+// AddAPITokens creates table for personal API tokens of users.
+func AddAPITokens(tx migration.LimitedTx) error {
+	_, err := tx.Exec(apiTokensTable)
+	return err
+}
+
+// AddUserTOTP adds TOTP two-factor authentication settings to users.
+func AddUserTOTP(tx migration.LimitedTx) error {
+	_, err := tx.Exec(userTOTPColumns)
+	return err
+}
+
+// AddLoginAttempts creates tables for failed login attempts and audit events.
+func AddLoginAttempts(tx migration.LimitedTx) error {
+	var cmds = []string{
+		loginAttemptsTable,
+		auditEventsTable,
+	}
+
+	for _, cmd := range cmds {
+		_, err := tx.Exec(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRoles creates table for roles and their permissions, with the builtin
+// roles.
+func AddRoles(tx migration.LimitedTx) error {
+	if _, err := tx.Exec(rolesTable); err != nil {
+		return err
+	}
+
+	var now = time.Now().UTC().Unix()
+	for _, role := range model.BuiltinRoles {
+		perms, err := json.Marshal(role.Permissions)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(roleInsert, role.Name, role.Description, string(perms), role.Builtin, now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddUserDeactivated adds deactivated flag to users.
+func AddUserDeactivated(tx migration.LimitedTx) error {
+	_, err := tx.Exec(userDeactivatedColumn)
+	return err
+}
+
+// AddUserAuthSource adds the provider authenticating users.
+func AddUserAuthSource(tx migration.LimitedTx) error {
+	_, err := tx.Exec(userAuthSourceColumn)
+	return err
+}
+
+// AddUserExternalID adds the ID of users at their authentication provider.
+func AddUserExternalID(tx migration.LimitedTx) error {
+	if _, err := tx.Exec(userExternalIDColumn); err != nil {
+		return err
+	}
+	_, err := tx.Exec(userExternalIDIndex)
+	return err
+}
+
+// AddGroups adds groups of users, and groups and roles to document
+// participants.
+func AddGroups(tx migration.LimitedTx) error {
+	var cmds = []string{
+		groupsTable,
+		groupMembersTable,
+		documentParticipantsGroupColumns,
+	}
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddOrganizations adds organizations, scoping users, documents and groups, and
+// the org_admin builtin role.
+func AddOrganizations(tx migration.LimitedTx) error {
+	var cmds = []string{
+		organizationsTable,
+		userOrgColumn,
+		documentOrgColumn,
+		groupOrgColumn,
+	}
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd); err != nil {
+			return err
+		}
+	}
+
+	// Fresh installs already have the role from AddRoles.
+	var now = time.Now().UTC().Unix()
+	for _, role := range model.BuiltinRoles {
+		if role.Name != model.RoleOrgAdmin {
+			continue
+		}
+		perms, err := json.Marshal(role.Permissions)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(roleInsertIgnore, role.Name, role.Description, string(perms), role.Builtin, now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAuditChain adds organization, document, user agent and changes to audit
+// events, and chains them by hash, existing events included.
+func AddAuditChain(tx migration.LimitedTx) error {
+	var cmds = []string{
+		auditEventsChainColumns,
+		auditChainTable,
+	}
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query(auditEventsSelect)
+	if err != nil {
+		return err
+	}
+	var events []*model.AuditEvent
+	for rows.Next() {
+		var e = new(model.AuditEvent)
+		if err := rows.Scan(&e.ID, &e.Action, &e.ActorID, &e.Target, &e.IP, &e.Details, &e.Created); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var head string
+	var lastId int64
+	for _, e := range events {
+		e.PrevHash = head
+		e.Hash = e.ComputeHash(nil)
+		if _, err := tx.Exec(auditEventHashUpdate, e.PrevHash, e.Hash, e.ID); err != nil {
+			return err
+		}
+		head, lastId = e.Hash, e.ID
+	}
+
+	_, err = tx.Exec(auditChainInsert, head, lastId)
+	return err
+}
+
+// AddRateLimits creates table for rate limit buckets.
+func AddRateLimits(tx migration.LimitedTx) error {
+	_, err := tx.Exec(rateLimitsTable)
+	return err
+}
+
+// AddUserPendingEmail adds changed email of users awaiting verification.
+func AddUserPendingEmail(tx migration.LimitedTx) error {
+	_, err := tx.Exec(userPendingEmailColumn)
+	return err
+}
+
+// AddDocumentParticipantsUnique makes participants unique per document, user
+// and group, keeping the first of duplicated participants.
+func AddDocumentParticipantsUnique(tx migration.LimitedTx) error {
+	var cmds = []string{
+		documentParticipantsUserDefault,
+		documentParticipantsDuplicatesDelete,
+		documentParticipantsUniqueIndex,
+	}
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddOrganizationUsage adds the size of files stored by organizations. Sizes of
+// existing organizations are left uncounted, to be counted from their files.
+func AddOrganizationUsage(tx migration.LimitedTx) error {
+	var cmds = []string{
+		organizationUsageColumn,
+		organizationUsageUncounted,
+	}
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAuditSeq adds the position of audit events in the chain, and whether
+// their hash is keyed. Existing events are chained in the order of their ID,
+// without key.
+func AddAuditSeq(tx migration.LimitedTx) error {
+	var cmds = []string{
+		auditEventsSeqColumns,
+		auditEventsSeqExisting,
+	}
+	for _, cmd := range cmds {
+		if _, err := tx.Exec(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var userTable = `
 CREATE TABLE IF NOT EXISTS users (
 	id INTEGER PRIMARY KEY AUTO_INCREMENT,
@@ -69,3 +326,264 @@ CREATE TABLE IF NOT EXISTS document_participants (
 	user_id INTEGER
 )
 `
+
+var userTokensTable = `
+CREATE TABLE IF NOT EXISTS user_tokens (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	user_id INTEGER,
+	kind VARCHAR(255),
+	hash VARCHAR(255),
+	expires INTEGER,
+	created INTEGER,
+	UNIQUE(hash),
+	INDEX(user_id)
+)
+`
+
+var userVerifiedColumn = `
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE
+`
+
+var userVerifiedExisting = `
+UPDATE users SET verified = TRUE
+`
+
+var userTokenGenerationColumn = `
+ALTER TABLE users ADD COLUMN token_generation INTEGER NOT NULL DEFAULT 0
+`
+
+var apiTokensTable = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	user_id INTEGER,
+	name VARCHAR(255),
+	scopes TEXT,
+	hash VARCHAR(255),
+	last_used INTEGER,
+	created INTEGER,
+	UNIQUE(hash),
+	INDEX(user_id)
+)
+`
+
+var userTOTPColumns = `
+ALTER TABLE users
+	ADD COLUMN totp_secret VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0
+`
+
+var loginAttemptsTable = `
+CREATE TABLE IF NOT EXISTS login_attempts (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	attempt_key VARCHAR(255),
+	failures INTEGER,
+	last_failure INTEGER,
+	locked_until INTEGER,
+	locked BOOLEAN NOT NULL DEFAULT FALSE,
+	expires INTEGER,
+	UNIQUE(attempt_key)
+)
+`
+
+var auditEventsTable = `
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	action VARCHAR(255),
+	actor_id INTEGER,
+	target VARCHAR(255),
+	ip VARCHAR(255),
+	details TEXT,
+	created INTEGER,
+	INDEX(action),
+	INDEX(created)
+)
+`
+
+var rolesTable = `
+CREATE TABLE IF NOT EXISTS roles (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	name VARCHAR(255),
+	description VARCHAR(255),
+	permissions TEXT,
+	builtin BOOLEAN NOT NULL DEFAULT FALSE,
+	created INTEGER,
+	updated INTEGER,
+	UNIQUE(name)
+)
+`
+
+var roleInsert = `
+INSERT INTO roles (name, description, permissions, builtin, created, updated)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+var userDeactivatedColumn = `
+ALTER TABLE users ADD COLUMN deactivated BOOLEAN NOT NULL DEFAULT FALSE
+`
+
+var userAuthSourceColumn = `
+ALTER TABLE users ADD COLUMN auth_source VARCHAR(32) NOT NULL DEFAULT ''
+`
+
+var userExternalIDColumn = `
+ALTER TABLE users ADD COLUMN external_id VARCHAR(255) NOT NULL DEFAULT ''
+`
+
+var userExternalIDIndex = `
+CREATE INDEX users_external_id ON users (auth_source, external_id)
+`
+
+var groupsTable = `
+CREATE TABLE IF NOT EXISTS user_groups (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	name VARCHAR(64),
+	description TEXT,
+	created INTEGER,
+	updated INTEGER,
+	UNIQUE(name)
+)
+`
+
+var groupMembersTable = `
+CREATE TABLE IF NOT EXISTS group_members (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	group_id INTEGER,
+	user_id INTEGER,
+	created INTEGER,
+	UNIQUE(group_id, user_id),
+	INDEX(user_id)
+)
+`
+
+var documentParticipantsGroupColumns = `
+ALTER TABLE document_participants
+	ADD COLUMN group_id INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'viewer',
+	ADD INDEX(document_id)
+`
+
+var organizationsTable = `
+CREATE TABLE IF NOT EXISTS organizations (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	slug VARCHAR(64),
+	name VARCHAR(255),
+	quota_users INTEGER NOT NULL DEFAULT 0,
+	quota_bytes BIGINT NOT NULL DEFAULT 0,
+	settings TEXT,
+	created INTEGER,
+	updated INTEGER,
+	UNIQUE(slug)
+)
+`
+
+var userOrgColumn = `
+ALTER TABLE users
+	ADD COLUMN org_id INTEGER NOT NULL DEFAULT 0,
+	ADD INDEX(org_id)
+`
+
+var documentOrgColumn = `
+ALTER TABLE documents
+	ADD COLUMN org_id INTEGER NOT NULL DEFAULT 0,
+	ADD INDEX(org_id)
+`
+
+var groupOrgColumn = `
+ALTER TABLE user_groups
+	ADD COLUMN org_id INTEGER NOT NULL DEFAULT 0,
+	DROP INDEX name,
+	ADD UNIQUE(org_id, name)
+`
+
+var roleInsertIgnore = `
+INSERT IGNORE INTO roles (name, description, permissions, builtin, created, updated)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+var auditEventsChainColumns = `
+ALTER TABLE audit_events
+	ADD COLUMN org_id INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN document_id INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN user_agent VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN changes TEXT,
+	ADD COLUMN prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+	ADD COLUMN hash VARCHAR(64) NOT NULL DEFAULT '',
+	ADD INDEX(org_id),
+	ADD INDEX(actor_id),
+	ADD INDEX(document_id)
+`
+
+var auditChainTable = `
+CREATE TABLE IF NOT EXISTS audit_chain (
+	id INTEGER PRIMARY KEY,
+	head VARCHAR(64) NOT NULL DEFAULT '',
+	last_id INTEGER NOT NULL DEFAULT 0
+)
+`
+
+var auditEventsSelect = `
+SELECT id, COALESCE(action, ''), COALESCE(actor_id, 0), COALESCE(target, ''),
+	COALESCE(ip, ''), COALESCE(details, ''), COALESCE(created, 0)
+FROM audit_events ORDER BY id
+`
+
+var auditEventHashUpdate = `
+UPDATE audit_events SET changes='null', prev_hash=?, hash=?
+WHERE id=?
+`
+
+var auditChainInsert = `
+INSERT INTO audit_chain (id, head, last_id)
+VALUES (1, ?, ?)
+`
+
+var rateLimitsTable = `
+CREATE TABLE IF NOT EXISTS rate_limits (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	bucket_key VARCHAR(255),
+	tokens DOUBLE,
+	updated BIGINT,
+	expires INTEGER,
+	UNIQUE(bucket_key)
+)
+`
+
+var userPendingEmailColumn = `
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255) NOT NULL DEFAULT ''
+`
+
+var documentParticipantsUserDefault = `
+UPDATE document_participants SET user_id=0 WHERE user_id IS NULL
+`
+
+var documentParticipantsDuplicatesDelete = `
+DELETE p FROM document_participants p
+INNER JOIN document_participants first ON first.document_id=p.document_id
+	AND first.user_id=p.user_id AND first.group_id=p.group_id AND first.id<p.id
+`
+
+var documentParticipantsUniqueIndex = `
+ALTER TABLE document_participants
+	MODIFY user_id INTEGER NOT NULL DEFAULT 0,
+	ADD UNIQUE(document_id, user_id, group_id)
+`
+
+var organizationUsageColumn = `
+ALTER TABLE organizations ADD COLUMN used_bytes BIGINT NULL DEFAULT 0
+`
+
+var organizationUsageUncounted = `
+UPDATE organizations SET used_bytes=NULL
+`
+
+var auditEventsSeqColumns = `
+ALTER TABLE audit_events
+	ADD COLUMN seq INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN keyed BOOLEAN NOT NULL DEFAULT FALSE,
+	ADD INDEX(seq)
+`
+
+var auditEventsSeqExisting = `
+UPDATE audit_events SET seq=id
+`
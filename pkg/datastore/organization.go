@@ -0,0 +1,91 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type Organizationstore interface {
+	// GetOrganizationById retrieves an organization from the datastore for the
+	// given ID.
+	GetOrganizationById(id int64) (*model.Organization, error)
+
+	// GetOrganizationBySlug retrieves an organization from the datastore for
+	// the given slug.
+	GetOrganizationBySlug(slug string) (*model.Organization, error)
+
+	// GetAllOrganizations retrieves a list of all organizations from the
+	// datastore.
+	GetAllOrganizations() ([]*model.Organization, error)
+
+	// AddOrganization adds an organization into the datastore.
+	AddOrganization(org *model.Organization) error
+
+	// UpdateOrganization updates an organization in the datastore.
+	UpdateOrganization(org *model.Organization) error
+
+	// DeleteOrganization deletes an organization, for the given ID, along with
+	// its groups and documents, in the datastore.
+	DeleteOrganization(id int64) error
+
+	// GetOrganizationUsage retrieves the size of files stored by an
+	// organization, for the given ID, from the datastore. It returns
+	// ErrorUsageUncounted if the size isn't counted yet.
+	GetOrganizationUsage(id int64) (int64, error)
+
+	// InitOrganizationUsage stores bytes as the size of files stored by an
+	// organization, for the given ID, unless it's counted already.
+	InitOrganizationUsage(id, bytes int64) error
+
+	// AddOrganizationUsage adds bytes, or releases negative bytes, to the size
+	// of files stored by an organization, for the given ID, unless the size
+	// would exceed quota, 0 for unlimited. It returns whether bytes are added.
+	AddOrganizationUsage(id, bytes, quota int64) (bool, error)
+}
+
+// GetOrganizationById retrieves an organization from the datastore for the
+// given ID.
+func GetOrganizationById(c context.Context, id int64) (*model.Organization, error) {
+	return FromContext(c).GetOrganizationById(id)
+}
+
+// GetOrganizationBySlug retrieves an organization from the datastore for the
+// given slug.
+func GetOrganizationBySlug(c context.Context, slug string) (*model.Organization, error) {
+	return FromContext(c).GetOrganizationBySlug(slug)
+}
+
+// GetAllOrganizations retrieves a list of all organizations from the datastore.
+func GetAllOrganizations(c context.Context) ([]*model.Organization, error) {
+	return FromContext(c).GetAllOrganizations()
+}
+
+// AddOrganization adds an organization into the datastore.
+func AddOrganization(c context.Context, org *model.Organization) error {
+	return FromContext(c).AddOrganization(org)
+}
+
+// UpdateOrganization updates an organization in the datastore.
+func UpdateOrganization(c context.Context, org *model.Organization) error {
+	return FromContext(c).UpdateOrganization(org)
+}
+
+// DeleteOrganization deletes an organization, for the given ID, along with its
+// groups and documents, in the datastore.
+func DeleteOrganization(c context.Context, id int64) error {
+	return FromContext(c).DeleteOrganization(id)
+}
+
+// GetOrganizationUsage retrieves the size of files stored by an organization,
+// for the given ID, from the datastore. It returns ErrorUsageUncounted if the
+// size isn't counted yet.
+func GetOrganizationUsage(c context.Context, id int64) (int64, error) {
+	return FromContext(c).GetOrganizationUsage(id)
+}
+
+// AddOrganizationUsage adds bytes, or releases negative bytes, to the size of
+// files stored by an organization, for the given ID, unless the size would
+// exceed quota, 0 for unlimited. It returns whether bytes are added.
+func AddOrganizationUsage(c context.Context, id, bytes, quota int64) (bool, error) {
+	return FromContext(c).AddOrganizationUsage(id, bytes, quota)
+}
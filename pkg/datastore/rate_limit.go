@@ -0,0 +1,39 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type RateLimitstore interface {
+	// GetRateLimitBucket retrieves the rate limit bucket from the datastore for
+	// the given key.
+	GetRateLimitBucket(key string) (*model.RateLimitBucket, error)
+
+	// UpdateRateLimitBucket applies fn to the rate limit bucket of a key, an
+	// empty one if there is none, and stores it in the datastore. Concurrent
+	// updates of the key are applied one after the other.
+	UpdateRateLimitBucket(key string, fn func(b *model.RateLimitBucket)) (*model.RateLimitBucket, error)
+
+	// DeleteExpiredRateLimitBuckets deletes rate limit buckets expired at Unix
+	// time now in the datastore.
+	DeleteExpiredRateLimitBuckets(now int64) error
+}
+
+// GetRateLimitBucket retrieves the rate limit bucket from the datastore for the
+// given key.
+func GetRateLimitBucket(c context.Context, key string) (*model.RateLimitBucket, error) {
+	return FromContext(c).GetRateLimitBucket(key)
+}
+
+// UpdateRateLimitBucket applies fn to the rate limit bucket of a key, an empty
+// one if there is none, and stores it in the datastore.
+func UpdateRateLimitBucket(c context.Context, key string, fn func(b *model.RateLimitBucket)) (*model.RateLimitBucket, error) {
+	return FromContext(c).UpdateRateLimitBucket(key, fn)
+}
+
+// DeleteExpiredRateLimitBuckets deletes rate limit buckets expired at Unix time
+// now in the datastore.
+func DeleteExpiredRateLimitBuckets(c context.Context, now int64) error {
+	return FromContext(c).DeleteExpiredRateLimitBuckets(now)
+}
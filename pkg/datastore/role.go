@@ -0,0 +1,56 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type Rolestore interface {
+	// GetRoleByName retrieves a role from the datastore for the given name.
+	GetRoleByName(name string) (*model.Role, error)
+
+	// GetAllRoles retrieves a list of all roles from the datastore.
+	GetAllRoles() ([]*model.Role, error)
+
+	// AddRole adds a role into the datastore.
+	AddRole(role *model.Role) error
+
+	// UpdateRole updates a role in the datastore.
+	UpdateRole(role *model.Role) error
+
+	// DeleteRole deletes a role, for the given ID, in the datastore.
+	DeleteRole(id int64) error
+
+	// CountUsersByRole counts users, with the given role name, in the datastore.
+	CountUsersByRole(name string) (int64, error)
+}
+
+// GetRoleByName retrieves a role from the datastore for the given name.
+func GetRoleByName(c context.Context, name string) (*model.Role, error) {
+	return FromContext(c).GetRoleByName(name)
+}
+
+// GetAllRoles retrieves a list of all roles from the datastore.
+func GetAllRoles(c context.Context) ([]*model.Role, error) {
+	return FromContext(c).GetAllRoles()
+}
+
+// AddRole adds a role into the datastore.
+func AddRole(c context.Context, role *model.Role) error {
+	return FromContext(c).AddRole(role)
+}
+
+// UpdateRole updates a role in the datastore.
+func UpdateRole(c context.Context, role *model.Role) error {
+	return FromContext(c).UpdateRole(role)
+}
+
+// DeleteRole deletes a role, for the given ID, in the datastore.
+func DeleteRole(c context.Context, id int64) error {
+	return FromContext(c).DeleteRole(id)
+}
+
+// CountUsersByRole counts users, with the given role name, in the datastore.
+func CountUsersByRole(c context.Context, name string) (int64, error) {
+	return FromContext(c).CountUsersByRole(name)
+}
@@ -0,0 +1,65 @@
+package datastore
+
+import (
+	"code.google.com/p/go.net/context"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type Tokenstore interface {
+	// GetUserTokenByHash retrieves a token, of the given kind, from the datastore
+	// for the given token hash.
+	GetUserTokenByHash(kind, hash string) (*model.UserToken, error)
+
+	// AddUserToken adds a token into the datastore.
+	AddUserToken(t *model.UserToken) error
+
+	// DeleteUserToken deletes a token, for the given ID, in the datastore.
+	DeleteUserToken(id int64) error
+
+	// ConsumeUserToken deletes a token, for the given ID, in the datastore and
+	// returns whether it was deleted by this call, so that single-use tokens
+	// are used once by concurrent requests.
+	ConsumeUserToken(id int64) (bool, error)
+
+	// DeleteUserTokens deletes all tokens of the given kind, for the given user
+	// ID, in the datastore.
+	DeleteUserTokens(userId int64, kind string) error
+
+	// DeleteAllUserTokens deletes all tokens, for the given user ID, in the
+	// datastore.
+	DeleteAllUserTokens(userId int64) error
+}
+
+// GetUserTokenByHash retrieves a token, of the given kind, from the datastore
+// for the given token hash.
+func GetUserTokenByHash(c context.Context, kind, hash string) (*model.UserToken, error) {
+	return FromContext(c).GetUserTokenByHash(kind, hash)
+}
+
+// AddUserToken adds a token into the datastore.
+func AddUserToken(c context.Context, t *model.UserToken) error {
+	return FromContext(c).AddUserToken(t)
+}
+
+// DeleteUserToken deletes a token, for the given ID, in the datastore.
+func DeleteUserToken(c context.Context, id int64) error {
+	return FromContext(c).DeleteUserToken(id)
+}
+
+// ConsumeUserToken deletes a token, for the given ID, in the datastore and
+// returns whether it was deleted by this call.
+func ConsumeUserToken(c context.Context, id int64) (bool, error) {
+	return FromContext(c).ConsumeUserToken(id)
+}
+
+// DeleteUserTokens deletes all tokens of the given kind, for the given user ID,
+// in the datastore.
+func DeleteUserTokens(c context.Context, userId int64, kind string) error {
+	return FromContext(c).DeleteUserTokens(userId, kind)
+}
+
+// DeleteAllUserTokens deletes all tokens, for the given user ID, in the
+// datastore.
+func DeleteAllUserTokens(c context.Context, userId int64) error {
+	return FromContext(c).DeleteAllUserTokens(userId)
+}
@@ -13,19 +13,39 @@ type Userstore interface {
 	// (username) or email.
 	GetUserByLogin(loginOrEmail string) (*model.User, error)
 
-	GetUserByLoginAndPassword(loginOrEmail, password string) (*model.User, error)
+	// GetUserByLoginName retrieves a user from the datastore for the specified
+	// login (username) only, not matching emails.
+	GetUserByLoginName(login string) (*model.User, error)
+
+	// GetUserByExternalID retrieves a user from the datastore for the given ID
+	// at the authentication provider source.
+	GetUserByExternalID(source, id string) (*model.User, error)
 
 	// GetAllUsers retrieves a list of all users from the datastore.
 	GetAllUsers() ([]*model.User, error)
 
+	// CountUsers returns the number of users in the datastore.
+	CountUsers() (int64, error)
+
 	// AddUser adds a user into the datastore.
 	AddUser(user *model.User) error
 
 	// UpdateUser update a user in the datastore.
 	UpdateUser(user *model.User) error
 
+	// UpdateUserTOTPStep records step as the time step of the last accepted
+	// TOTP code of a user, for the given ID, unless a code of the same or a
+	// later step was already accepted. It returns whether it was recorded by
+	// this call, so that a code is accepted once by concurrent requests.
+	UpdateUserTOTPStep(id, step int64) (bool, error)
+
 	// DeleteUser deletes a user, for the given ID, in the datastore.
 	DeleteUser(id int64) error
+
+	// DeleteUserReassigning deletes a user, for the given ID, along with their
+	// document participations, group memberships and tokens, and reassigns
+	// their documents to user toUserId, all or nothing, in the datastore.
+	DeleteUserReassigning(id, toUserId int64) error
 }
 
 // GetUserById retrieves a specific user from the datastore for the given ID.
@@ -39,8 +59,16 @@ func GetUserByLogin(c context.Context, loginOrEmail string) (*model.User, error)
 	return FromContext(c).GetUserByLogin(loginOrEmail)
 }
 
-func GetUserByLoginAndPassword(c context.Context, loginOrEmail, password string) (*model.User, error) {
-	return FromContext(c).GetUserByLoginAndPassword(loginOrEmail, password)
+// GetUserByLoginName retrieves a user from the datastore for the specified
+// login (username) only, not matching emails.
+func GetUserByLoginName(c context.Context, login string) (*model.User, error) {
+	return FromContext(c).GetUserByLoginName(login)
+}
+
+// GetUserByExternalID retrieves a user from the datastore for the given ID at
+// the authentication provider source.
+func GetUserByExternalID(c context.Context, source, id string) (*model.User, error) {
+	return FromContext(c).GetUserByExternalID(source, id)
 }
 
 // GetAllUsers retrieves a list of all users from the datastore.
@@ -48,6 +76,11 @@ func GetAllUsers(c context.Context) ([]*model.User, error) {
 	return FromContext(c).GetAllUsers()
 }
 
+// CountUsers returns the number of users in the datastore.
+func CountUsers(c context.Context) (int64, error) {
+	return FromContext(c).CountUsers()
+}
+
 // AddUser adds a user into the datastore.
 func AddUser(c context.Context, user *model.User) error {
 	return FromContext(c).AddUser(user)
@@ -58,7 +91,21 @@ func UpdateUser(c context.Context, user *model.User) error {
 	return FromContext(c).UpdateUser(user)
 }
 
+// UpdateUserTOTPStep records step as the time step of the last accepted TOTP
+// code of a user, for the given ID, and returns whether it was recorded by this
+// call.
+func UpdateUserTOTPStep(c context.Context, id, step int64) (bool, error) {
+	return FromContext(c).UpdateUserTOTPStep(id, step)
+}
+
 // DeleteUser deletes a user, for the given ID, in the datastore.
 func DeleteUser(c context.Context, id int64) error {
 	return FromContext(c).DeleteUser(id)
 }
+
+// DeleteUserReassigning deletes a user, for the given ID, along with their
+// document participations, group memberships and tokens, and reassigns their
+// documents to user toUserId, all or nothing, in the datastore.
+func DeleteUserReassigning(c context.Context, id, toUserId int64) error {
+	return FromContext(c).DeleteUserReassigning(id, toUserId)
+}
@@ -0,0 +1,296 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/lockout"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// UpdateUser accepts a request to update profile and role of a user, for the
+// given login or email. Login can't be changed. A changed email must be
+// verified again, unless verified is set. Neither the current role of the user
+// nor the new one can grant permissions that current user doesn't have.
+//
+// Setting auth_source links the user to a provider, so that the account is used
+// on next login with the provider, matched by external_id if set, otherwise by
+// email or login. Empty auth_source makes the user local again.
+//
+// PATCH /api/admin/users/:login
+// PUT   /api/admin/users/:login
+//
+func UpdateUser(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = getManagedUser(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+
+	var req = new(struct {
+		Login    string  `json:"login"`
+		Email    *string `json:"email"`
+		Name     *string `json:"name"`
+		Role     *string `json:"role"`
+		Verified *bool   `json:"verified"`
+
+		AuthSource *string `json:"auth_source"`
+		ExternalID *string `json:"external_id"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	if req.Login != "" && req.Login != usr.Login {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "login", ErrorFieldImmutable))
+		return
+	}
+
+	var before = *usr
+	var prevEmail = usr.Email
+	if req.Email != nil {
+		usr.Email = *req.Email
+	}
+	if req.Name != nil {
+		usr.Name = *req.Name
+	}
+	if req.Role != nil && *req.Role != usr.Role {
+		if !roleExists(c, *req.Role) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "role", ErrorFieldInvalid))
+			return
+		}
+		if !canAssignRole(c, *req.Role) {
+			respWithError(w, http.StatusForbidden, ErrorForbidden)
+			return
+		}
+		usr.Role = *req.Role
+	}
+	if req.AuthSource != nil && *req.AuthSource != usr.AuthSource {
+		if !isAuthSource(c, *req.AuthSource) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "auth_source", ErrorFieldInvalid))
+			return
+		}
+		usr.AuthSource = *req.AuthSource
+		usr.ExternalID = ""
+	}
+	if req.ExternalID != nil {
+		if usr.IsLocal() && *req.ExternalID != "" {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "external_id", ErrorFieldInvalid))
+			return
+		}
+		usr.ExternalID = *req.ExternalID
+	}
+	if usr.Email != prevEmail {
+		usr.Verified = false
+		usr.PendingEmail = ""
+	}
+	if req.Verified != nil {
+		usr.Verified = *req.Verified
+	}
+
+	if ve := model.Validate(usr); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("user", ve)...)
+		return
+	}
+
+	if err := datastore.UpdateUser(context.FromC(c), usr); err != nil {
+		if isDuplicateLogin(err) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "email", ErrorFieldAlreadyExists))
+		} else {
+			log.Printf("%+v\n", err)
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		}
+		return
+	}
+
+	if !usr.Verified && usr.Email != prevEmail {
+		if err := sendEmailVerification(c, usr); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditUserUpdated,
+		OrgID:   usr.OrgID,
+		Target:  accountLockoutKey(usr),
+		Changes: model.AuditChanges(&before, usr),
+	})
+
+	json.NewEncoder(w).Encode(usr)
+}
+
+// DeactivateUser accepts a request to deactivate a user, for the given login or
+// email. The user can't log in anymore, and issued tokens, including API
+// tokens, are revoked. Admins can't deactivate themselves.
+//
+// POST /api/admin/users/:login/deactivate
+//
+func DeactivateUser(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+
+	var usr = getManagedUser(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+	if isCurrentUser(c, usr) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	usr.Deactivated = true
+	if err := revokeSessions(c, usr); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if err := datastore.DeleteAllUserTokens(ctx, usr.ID); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if err := datastore.DeleteAllAPITokens(ctx, usr.ID); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addUserAuditEvent(c, r, model.AuditUserDeactivated, usr, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ActivateUser accepts a request to activate a deactivated user, for the given
+// login or email.
+//
+// POST /api/admin/users/:login/activate
+//
+func ActivateUser(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = getManagedUser(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+
+	usr.Deactivated = false
+	if err := datastore.UpdateUser(context.FromC(c), usr); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addUserAuditEvent(c, r, model.AuditUserActivated, usr, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetUserPassword accepts a request to send a password reset link to the
+// email of a user, for the given login or email.
+//
+// POST /api/admin/users/:login/reset_password
+//
+func ResetUserPassword(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = getManagedUser(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+	if usr.Deactivated {
+		respWithError(w, http.StatusBadRequest, ErrorAccountDeactivated)
+		return
+	}
+	if !usr.IsLocal() {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "auth_source", ErrorFieldInvalid))
+		return
+	}
+
+	if err := sendPasswordReset(c, usr); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// DeleteUser accepts a request to delete a user, for the given login or email.
+// Documents of the user are reassigned to the user given by reassign_to, or to
+// current user, of the same organization, and the user is removed from document
+// participants. Admins can't delete themselves; deactivating is preferred to
+// keep the history.
+//
+// DELETE /api/admin/users/:login?reassign_to=admin01
+//
+func DeleteUser(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+
+	var usr = getManagedUser(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+	if isCurrentUser(c, usr) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	var to = ToUser(c)
+	if login := r.URL.Query().Get("reassign_to"); login != "" {
+		u, err := datastore.GetUserByLogin(ctx, login)
+		if err != nil {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "reassign_to", ErrorFieldInvalid))
+			return
+		}
+		to = u
+	}
+	if to == nil || to.ID == usr.ID || to.Deactivated || to.OrgID != usr.OrgID {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "reassign_to", ErrorFieldInvalid))
+		return
+	}
+
+	if err := datastore.DeleteUserReassigning(ctx, usr.ID, to.ID); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	// Failed logins of the ID are forgotten, in case it's reused.
+	if limiter, ok := c.Env["accountLimiter"].(*lockout.Limiter); ok {
+		limiter.Reset(accountLockoutKey(usr))
+	}
+
+	addUserAuditEvent(c, r, model.AuditUserDeleted, usr, fmt.Sprintf("Documents reassigned to %s", accountLockoutKey(to)))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getManagedUser retrieves user for the given login or email, like
+// getUserByLogin, if current user can manage it, that is if the role of the
+// user grants no permission that current user doesn't have. Otherwise it
+// responds with forbidden and returns nil.
+func getManagedUser(c web.C, w http.ResponseWriter, loginOrEmail string) *model.User {
+	var usr = getUserByLogin(c, w, loginOrEmail)
+	if usr == nil {
+		return nil
+	}
+	if !canAssignRole(c, usr.Role) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return nil
+	}
+	return usr
+}
+
+// isCurrentUser checks whether usr is current user.
+func isCurrentUser(c web.C, usr *model.User) bool {
+	var cusr = ToUser(c)
+	return cusr != nil && cusr.ID == usr.ID
+}
+
+// addUserAuditEvent records action of current user on usr, possibly the same
+// user, in the audit log.
+func addUserAuditEvent(c web.C, r *http.Request, action string, usr *model.User, details string) {
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  action,
+		OrgID:   usr.OrgID,
+		Target:  accountLockoutKey(usr),
+		Details: details,
+	})
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/lockout"
+	"github.com/gedex/simdoc/pkg/util/mail"
+
+	"github.com/zenazn/goji/web"
+)
+
+// fakeAdminstore is an in-memory datastore of users, their tokens and roles.
+// Other methods of the datastore are not implemented.
+type fakeAdminstore struct {
+	fakeTokenstore
+	roles []*model.Role
+}
+
+func (s *fakeAdminstore) GetRoleByName(name string) (*model.Role, error) {
+	for _, r := range s.roles {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeAdminstore) DeleteAllAPITokens(userId int64) error {
+	return nil
+}
+
+func TestManagedUser(t *testing.T) {
+	var manager = &model.Role{Name: "manager", Permissions: []string{model.PermUsersRead, model.PermUsersUpdate}}
+
+	handlers := []struct {
+		name string
+		h    web.HandlerFunc
+		code int // Status when the user can be managed
+	}{
+		{"deactivate", DeactivateUser, http.StatusNoContent},
+		{"activate", ActivateUser, http.StatusNoContent},
+		{"reset password", ResetUserPassword, http.StatusAccepted},
+		{"revoke sessions", RevokeUserSessions, http.StatusNoContent},
+		{"unlock", UnlockUser, http.StatusNoContent},
+	}
+	tests := []struct {
+		login   string
+		managed bool
+	}{
+		{"viewer", true},
+		{"peer", true},
+		{"admin", false},
+	}
+
+	for _, hh := range handlers {
+		for _, tt := range tests {
+			ds := &fakeAdminstore{roles: []*model.Role{
+				{Name: "viewer", Permissions: []string{model.PermUsersRead}},
+				manager,
+				{Name: "admin", Permissions: []string{model.PermAll}},
+			}}
+			ds.users = []*model.User{
+				{ID: 1, Login: "manager", Email: "manager@example.com", Role: "manager", Verified: true},
+				{ID: 2, Login: "viewer", Email: "viewer@example.com", Role: "viewer", Verified: true},
+				{ID: 3, Login: "peer", Email: "peer@example.com", Role: "manager", Verified: true},
+				{ID: 4, Login: "admin", Email: "admin@example.com", Role: "admin", Verified: true},
+			}
+			c := newAuthTestC(t, ds, nil)
+			c.Env["user"], _ = ds.GetUserById(1)
+			c.Env["role"] = manager
+			c.Env["accountLimiter"] = lockout.New(lockout.NewMemoryStore(), testLockoutPolicy)
+			c.Env["mailer"] = mail.Sender(make(mailbox, 1))
+			c.Env["baseURL"] = "https://simdoc.example.com"
+			c.URLParams = map[string]string{"login": tt.login}
+
+			target, _ := ds.GetUserByLogin(tt.login)
+			w := httptest.NewRecorder()
+			hh.h(c, w, httptest.NewRequest("POST", "/api/admin/users/"+tt.login, nil))
+
+			want := hh.code
+			if !tt.managed {
+				want = http.StatusForbidden
+			}
+			if w.Code != want {
+				t.Errorf("%s %s: status %d, want %d", hh.name, tt.login, w.Code, want)
+			}
+
+			// Users that can't be managed are left untouched.
+			usr, _ := ds.GetUserById(target.ID)
+			if !tt.managed && (*usr != *target || len(ds.events) != 0) {
+				t.Errorf("%s %s: user changed to %+v, events %d", hh.name, tt.login, usr, len(ds.events))
+			}
+		}
+	}
+}
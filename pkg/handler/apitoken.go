@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// newAPIToken represents a created API token. The token itself is only
+// returned once.
+type newAPIToken struct {
+	*model.APIToken
+	Token string `json:"token"`
+}
+
+// GetAPITokens accepts a request to retrieve API tokens of current user and
+// returns in JSON format.
+//
+// GET /api/user/tokens
+//
+func GetAPITokens(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = requireSession(c, w)
+	if usr == nil {
+		return
+	}
+
+	tokens, err := datastore.GetAllAPITokens(context.FromC(c), usr.ID)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if tokens == nil {
+		w.Write([]byte(`[]`))
+	} else {
+		json.NewEncoder(w).Encode(tokens)
+	}
+}
+
+// AddAPIToken accepts a request to create an API token, with given name and
+// scopes, for current user. Only admins can create tokens with admin scope.
+//
+// POST /api/user/tokens
+//
+func AddAPIToken(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = requireSession(c, w)
+	if usr == nil {
+		return
+	}
+
+	var t = new(model.APIToken)
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	t.ID = 0
+	t.UserID = usr.ID
+	t.LastUsed = 0
+	t.Created = 0
+
+	if ve := model.Validate(t); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("tokens", ve)...)
+		return
+	}
+	if len(t.Scopes) == 0 {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("tokens", "scopes", ErrorFieldMissing))
+		return
+	}
+	for _, s := range t.Scopes {
+		if !model.IsValidScope(s) || (s == model.ScopeAdmin && !ToRole(c).IsAdmin()) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("tokens", "scopes", ErrorFieldInvalid))
+			return
+		}
+	}
+
+	token, hash, err := util.GenerateAPIToken()
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	t.Hash = hash
+
+	if err := datastore.AddAPIToken(context.FromC(c), t); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditAPITokenCreated,
+		Target:  "api_token:" + strconv.FormatInt(t.ID, 10),
+		Details: fmt.Sprintf("%s, scopes %s", t.Name, strings.Join(t.Scopes, " ")),
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&newAPIToken{t, token})
+}
+
+// DeleteAPIToken accepts a request to revoke an API token, for given token ID
+// tokenId, of current user.
+//
+// DELETE /api/user/tokens/:tokenId
+//
+func DeleteAPIToken(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+
+	var usr = requireSession(c, w)
+	if usr == nil {
+		return
+	}
+
+	tokenId, _ := strconv.ParseInt(c.URLParams["tokenId"], 10, 64)
+	t, err := datastore.GetAPITokenById(ctx, tokenId)
+	if err != nil || t.UserID != usr.ID {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	if err := datastore.DeleteAPIToken(ctx, t.ID); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditAPITokenDeleted,
+		Target:  "api_token:" + strconv.FormatInt(t.ID, 10),
+		Details: t.Name,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireSession returns current user if the request is authenticated with a
+// session rather than API token, so that tokens can't be used to issue
+// themselves more tokens. Otherwise it responds with an error and returns nil.
+func requireSession(c web.C, w http.ResponseWriter) *model.User {
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return nil
+	}
+	if ToAPIToken(c) != nil {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return nil
+	}
+	return usr
+}
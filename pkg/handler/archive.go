@@ -0,0 +1,336 @@
+package handler
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/upload"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// Maximum number of documents in bulk archive.
+	maxArchiveDocuments = 100
+
+	manifestJSON = "json"
+	manifestCSV  = "csv"
+)
+
+// archiveDocument represents a document, and its files, in the archive manifest.
+type archiveDocument struct {
+	*model.Document
+	Files []*archiveFile `json:"files"`
+}
+
+// archiveFile represents a file of a document in the archive manifest.
+type archiveFile struct {
+	ID       int64             `json:"id"`
+	Name     string            `json:"name"`
+	Mime     string            `json:"mime"`
+	Size     int64             `json:"size"`
+	Path     string            `json:"path"`     // Path of the original file in the archive
+	Versions map[string]string `json:"versions"` // Key is version name, value is path in the archive
+}
+
+// GetDocumentArchive accepts a request to download a document, for given
+// document ID docId, and its files as a ZIP archive. Versions of the files can be
+// included by names, and manifest is either json (default) or csv.
+//
+// GET /api/documents/:docId/archive?versions=thumbnail-120x90,watermarked&manifest=csv
+//
+func GetDocumentArchive(c web.C, w http.ResponseWriter, r *http.Request) {
+	// @todo remove me once DocumentToContextInjector is being used.
+	if ok := docToContext(&c, w); !ok {
+		return
+	}
+
+	var doc = ToDocument(c)
+	if doc == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	serveArchive(c, w, r, fmt.Sprintf("document-%d.zip", doc.ID), []*model.Document{doc})
+}
+
+// GetDocumentsArchive accepts a request to download multiple documents, for
+// given comma separated document IDs, and their files as a ZIP archive.
+//
+// GET /api/documents/archive?ids=1,2,3&versions=watermarked&manifest=json
+//
+func GetDocumentsArchive(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return
+	}
+
+	ids := splitParam(r.URL.Query().Get("ids"))
+	if len(ids) == 0 || len(ids) > maxArchiveDocuments {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("documents", "ids", ErrorFieldInvalid))
+		return
+	}
+
+	docs := make([]*model.Document, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, idStr := range ids {
+		docId, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || docId <= 0 {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("documents", "ids", ErrorFieldInvalid))
+			return
+		}
+		if seen[docId] {
+			continue
+		}
+		seen[docId] = true
+
+		doc, err := datastore.GetDocumentById(ctx, docId)
+		if err != nil {
+			respWithError(w, http.StatusNotFound, ErrorNotFound)
+			return
+		}
+		if !canReadDocument(c, usr, doc) {
+			respWithError(w, http.StatusForbidden, ErrorForbidden)
+			return
+		}
+		docs = append(docs, doc)
+	}
+
+	serveArchive(c, w, r, fmt.Sprintf("documents-%s.zip", time.Now().UTC().Format("20060102-150405")), docs)
+}
+
+// serveArchive streams ZIP archive, named filename, of docs into w.
+func serveArchive(c web.C, w http.ResponseWriter, r *http.Request, filename string, docs []*model.Document) {
+	var ctx = context.FromC(c)
+
+	var versions = splitParam(r.URL.Query().Get("versions"))
+	var manifest = r.URL.Query().Get("manifest")
+	if manifest == "" {
+		manifest = manifestJSON
+	}
+	if manifest != manifestJSON && manifest != manifestCSV {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("archive", "manifest", ErrorFieldInvalid))
+		return
+	}
+
+	// Retrieves and checks all files before the response is started, so that
+	// errors can still be reported with proper status code rather than with a
+	// truncated archive.
+	entries := make([]*archiveDocument, 0, len(docs))
+	items := make([]*archiveItem, 0)
+	for _, doc := range docs {
+		dfs, err := datastore.GetAllDocumentFiles(ctx, doc.ID)
+		if err != nil {
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+			return
+		}
+		if doc.Status == model.DocumentStatusPublished && !canEditDocument(c, ToUser(c), doc) {
+			for _, df := range dfs {
+				restrictToWatermarked(df)
+			}
+		}
+
+		entry := &archiveDocument{doc, make([]*archiveFile, 0, len(dfs))}
+		dir := archiveDirname(doc)
+
+		for _, df := range dfs {
+			af := &archiveFile{
+				ID:       df.ID,
+				Name:     df.Name,
+				Path:     path.Join(dir, archiveFilename(df.ID, df.Name, "")),
+				Versions: make(map[string]string),
+			}
+			if df.Meta != nil {
+				af.Mime = df.Meta.Mime
+				af.Size = df.Meta.Size
+			}
+			items = append(items, &archiveItem{af.Path, df.Filepath, df.Updated})
+
+			for _, vname := range versions {
+				v, ok := df.Versions[vname]
+				if !ok || v == nil || v.Filepath == "" {
+					continue
+				}
+
+				vpath := path.Join(dir, "versions", vname, archiveFilename(df.ID, df.Name, filepath.Ext(v.Filepath)))
+				items = append(items, &archiveItem{vpath, v.Filepath, df.Updated})
+				af.Versions[vname] = vpath
+			}
+
+			entry.Files = append(entry.Files, af)
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, item := range items {
+		if err := item.check(); err != nil {
+			log.Printf("archive: %+v\n", err)
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+
+	// Errors past this point leave the archive without its central directory,
+	// so that clients see it as corrupted rather than complete.
+	zw := zip.NewWriter(w)
+	for _, item := range items {
+		if err := addFileToArchive(zw, item.name, item.fpath, item.modified); err != nil {
+			log.Printf("archive: %+v\n", err)
+			return
+		}
+	}
+
+	if err := addManifestToArchive(zw, manifest, entries); err != nil {
+		log.Printf("archive: %+v\n", err)
+		return
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Printf("archive: %+v\n", err)
+		return
+	}
+
+	for _, doc := range docs {
+		addDocumentAuditEvent(c, r, model.AuditDocumentArchived, doc, "", filename)
+	}
+}
+
+// archiveItem represents a stored file to add into the archive as name.
+type archiveItem struct {
+	name     string
+	fpath    string
+	modified int64
+}
+
+// check checks whether the file of item can be read.
+func (item *archiveItem) check() error {
+	f, _, err := upload.OpenFile(item.fpath)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// addFileToArchive copies file at fpath into the archive as name.
+func addFileToArchive(zw *zip.Writer, name, fpath string, modified int64) error {
+	f, _, err := upload.OpenFile(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fh := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	fh.SetModTime(time.Unix(modified, 0))
+
+	zf, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(zf, f)
+	return err
+}
+
+// addManifestToArchive writes the manifest of entries into the archive, either
+// in JSON or CSV format.
+func addManifestToArchive(zw *zip.Writer, format string, entries []*archiveDocument) error {
+	mf, err := zw.Create("manifest." + format)
+	if err != nil {
+		return err
+	}
+
+	if format == manifestJSON {
+		return json.NewEncoder(mf).Encode(entries)
+	}
+
+	cw := csv.NewWriter(mf)
+	cw.Write([]string{"document_id", "document_name", "status", "created_by", "created_at", "updated_at", "file_id", "file_name", "mime", "size", "path"})
+	for _, entry := range entries {
+		row := []string{
+			strconv.FormatInt(entry.ID, 10),
+			entry.Name,
+			entry.Status,
+			strconv.FormatInt(entry.CreatedBy, 10),
+			strconv.FormatInt(entry.Created, 10),
+			strconv.FormatInt(entry.Updated, 10),
+		}
+		if len(entry.Files) == 0 {
+			cw.Write(append(row, "", "", "", "", ""))
+			continue
+		}
+		for _, af := range entry.Files {
+			cw.Write(append(row,
+				strconv.FormatInt(af.ID, 10),
+				af.Name,
+				af.Mime,
+				strconv.FormatInt(af.Size, 10),
+				af.Path,
+			))
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// archiveDirname returns directory name of doc in the archive.
+func archiveDirname(doc *model.Document) string {
+	return fmt.Sprintf("%d-%s", doc.ID, sanitizeFilename(doc.Name))
+}
+
+// archiveFilename returns name of a file in the archive. File ID is prefixed
+// as names are not unique within a document. If ext is not empty, it replaces
+// extension of name.
+func archiveFilename(fileId int64, name, ext string) string {
+	name = sanitizeFilename(name)
+	if ext != "" {
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ext
+	}
+	return fmt.Sprintf("%d-%s", fileId, name)
+}
+
+// sanitizeFilename replaces path separators, so that name can not escape its
+// directory in the archive.
+func sanitizeFilename(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return name
+}
+
+// splitParam splits comma separated query param into distinct non empty values.
+func splitParam(v string) []string {
+	var out []string
+	var seen = make(map[string]bool)
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
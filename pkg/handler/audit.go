@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// Maximum length of the user agent recorded in audit events.
+const auditUserAgentMax = 255
+
+// GetAuditEvents accepts a request to retrieve audit events, newest first,
+// filtered by actor (login or email), document ID, action and time range,
+// either Unix timestamps or RFC 3339, and returns in JSON format. Older events
+// are paged with before_id, the ID of the last event of the previous page.
+//
+// GET /api/admin/audit?actor=admin01&document=42&action=document.deleted&since=2015-01-01T00:00:00Z&until=...&limit=20&before_id=...
+//
+func GetAuditEvents(c web.C, w http.ResponseWriter, r *http.Request) {
+	var f = parseAuditFilter(c, w, r)
+	if f == nil {
+		return
+	}
+
+	events, err := datastore.GetAuditEvents(context.FromC(c), f)
+	if err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if events == nil {
+		w.Write([]byte(`[]`))
+	} else {
+		json.NewEncoder(w).Encode(events)
+	}
+}
+
+// ExportAuditEvents accepts a request to export all audit events matching the
+// same filters as GetAuditEvents in CSV format, newest first.
+//
+// GET /api/admin/audit/export?actor=admin01&since=2015-01-01T00:00:00Z
+//
+func ExportAuditEvents(c web.C, w http.ResponseWriter, r *http.Request) {
+	var f = parseAuditFilter(c, w, r)
+	if f == nil {
+		return
+	}
+	f.Limit = auditExportPageSize
+
+	var ctx = context.FromC(c)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "org_id", "action", "actor_id", "target", "document_id", "ip", "user_agent", "details", "changes", "prev_hash", "hash"})
+
+	for {
+		events, err := datastore.GetAuditEvents(ctx, f)
+		if err != nil {
+			// Headers are sent already, the export ends truncated.
+			log.Printf("%+v\n", err)
+			break
+		}
+
+		for _, e := range events {
+			var changes []byte
+			if e.Changes != nil {
+				changes, _ = json.Marshal(e.Changes)
+			}
+			cw.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				time.Unix(e.Created, 0).UTC().Format(time.RFC3339),
+				strconv.FormatInt(e.OrgID, 10),
+				csvCell(e.Action),
+				strconv.FormatInt(e.ActorID, 10),
+				csvCell(e.Target),
+				strconv.FormatInt(e.DocumentID, 10),
+				csvCell(e.IP),
+				csvCell(e.UserAgent),
+				csvCell(e.Details),
+				csvCell(string(changes)),
+				e.PrevHash,
+				e.Hash,
+			})
+			f.BeforeID = e.ID
+		}
+		cw.Flush()
+
+		if len(events) < f.Limit {
+			break
+		}
+	}
+}
+
+// VerifyAuditEvents accepts a request to verify the hash chain of all audit
+// events, chaining pending ones first, and returns the result in JSON format.
+// Only global admins can verify, as the chain spans all organizations.
+//
+// GET /api/admin/audit/verify
+//
+func VerifyAuditEvents(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+	var key, _ = c.Env["auditKey"].([]byte)
+
+	_, err := datastore.ChainAuditEvents(ctx, key)
+	var v *model.AuditVerification
+	if err == nil {
+		v, err = datastore.VerifyAuditEvents(ctx, key)
+	}
+	switch {
+	case err == datastore.ErrorOutsideOrganization:
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	case err != nil:
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if !v.Valid {
+		log.Printf("audit: chain broken at event %d\n", v.BrokenID)
+	}
+
+	json.NewEncoder(w).Encode(v)
+}
+
+// Number of audit events fetched at once by ExportAuditEvents.
+const auditExportPageSize = 100
+
+// csvCell returns s as a CSV cell that spreadsheets don't evaluate as a
+// formula, by prefixing values starting like one with a quote.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// truncate returns s cut to at most max bytes, without splitting a UTF-8
+// encoded character.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// parseAuditFilter returns the audit filter of the query of r. If it's
+// invalid, a bad request response is given and nil is returned.
+func parseAuditFilter(c web.C, w http.ResponseWriter, r *http.Request) *model.AuditFilter {
+	var q = r.URL.Query()
+	var f = &model.AuditFilter{Action: q.Get("action")}
+
+	if login := q.Get("actor"); login != "" {
+		usr, err := datastore.GetUserByLogin(context.FromC(c), login)
+		if err != nil {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("audit", "actor", ErrorFieldInvalid))
+			return nil
+		}
+		f.ActorID = usr.ID
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *int64
+		time bool
+	}{
+		{"document", &f.DocumentID, false},
+		{"before_id", &f.BeforeID, false},
+		{"since", &f.Since, true},
+		{"until", &f.Until, true},
+	} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil && p.time {
+			var t time.Time
+			if t, err = time.Parse(time.RFC3339, v); err == nil {
+				n = t.Unix()
+			}
+		}
+		if err != nil || n < 0 {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("audit", p.name, ErrorFieldInvalid))
+			return nil
+		}
+		*p.dst = n
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("audit", "limit", ErrorFieldInvalid))
+			return nil
+		}
+		f.Limit = n
+	}
+
+	return f
+}
+
+// addAuditEvent adds audit event e, made by the client of r. The actor and
+// the organization default to current user. Failures are logged, as they
+// shouldn't fail the request.
+func addAuditEvent(c web.C, r *http.Request, e *model.AuditEvent) {
+	e.IP = util.RemoteIP(r)
+	e.UserAgent = truncate(r.UserAgent(), auditUserAgentMax)
+
+	if usr := ToUser(c); usr != nil {
+		if e.ActorID == 0 {
+			e.ActorID = usr.ID
+		}
+		if e.OrgID == 0 {
+			e.OrgID = usr.OrgID
+		}
+	}
+
+	if err := datastore.AddAuditEvent(context.FromC(c), e); err != nil {
+		log.Printf("audit: %+v %+v\n", err, e)
+	}
+}
+
+// addDocumentAuditEvent records action of current user on doc, or on target
+// within doc, such as a file, in the audit log.
+func addDocumentAuditEvent(c web.C, r *http.Request, action string, doc *model.Document, target, details string) {
+	if target == "" {
+		target = "document:" + strconv.FormatInt(doc.ID, 10)
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:     action,
+		OrgID:      doc.OrgID,
+		Target:     target,
+		DocumentID: doc.ID,
+		Details:    details,
+	})
+}
@@ -0,0 +1,48 @@
+package handler
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"user.login", "user.login"},
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"+1", "'+1"},
+		{"-1+1", "'-1+1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+		{"{\"role\":{\"before\":\"user\"}}", "{\"role\":{\"before\":\"user\"}}"},
+	}
+
+	for _, tt := range tests {
+		if got := csvCell(tt.in); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"Mozilla", 10, "Mozilla"},
+		{"Mozilla", 7, "Mozilla"},
+		{"Mozilla", 3, "Moz"},
+		{"héllo", 2, "h"},  // é is 2 bytes
+		{"héllo", 3, "hé"}, // Cut after é
+		{"日本語", 4, "日"},    // Each is 3 bytes
+		{"日本語", 2, ""},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.in, tt.max); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+
+	"github.com/gedex/simdoc/pkg/auth"
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+var invalidLoginChars = regexp.MustCompile("[^a-zA-Z0-9_]+")
+
+// getAuthProvider returns the external authentication provider, or nil if users
+// authenticate with local password only.
+func getAuthProvider(c web.C) auth.Provider {
+	p, _ := c.Env["authProvider"].(auth.Provider)
+	return p
+}
+
+// isAuthSource checks whether source is the source of local users, empty, or
+// the name of a configured provider.
+func isAuthSource(c web.C, source string) bool {
+	if source == "" {
+		return true
+	}
+	if p := getAuthProvider(c); p != nil && p.Name() == source {
+		return true
+	}
+	if p := getOIDCProvider(c); p != nil && p.Name() == source {
+		return true
+	}
+	return false
+}
+
+// authenticate verifies login and pass of usr, which is nil for unknown login,
+// and returns the authenticated user. With an external provider, unknown logins
+// and users of the provider are authenticated by the provider and provisioned on
+// first login, see provisionUser. Local users, including admins, keep their
+// password, so that the app stays manageable while the provider is unavailable.
+// It returns auth.ErrorBadCredentials if the credentials don't match, and
+// ErrorAccountConflict if they match a provider account that collides with an
+// unlinked user.
+func authenticate(c web.C, login, pass string, usr *model.User) (*model.User, error) {
+	var p = getAuthProvider(c)
+	if p == nil || (usr != nil && usr.IsLocal()) {
+		if usr == nil {
+			// Hash anyway, so that unknown logins take as long as wrong passwords.
+			hashPassword(c, pass)
+			return nil, auth.ErrorBadCredentials
+		}
+		if ok, err := verifyPassword(c, usr, pass); err != nil || !ok {
+			return nil, auth.ErrorBadCredentials
+		}
+		return usr, nil
+	}
+
+	// Users of other providers, such as single sign-on, have no password.
+	if usr != nil && usr.AuthSource != p.Name() {
+		hashPassword(c, pass)
+		return nil, auth.ErrorBadCredentials
+	}
+
+	id, err := p.Authenticate(login, pass)
+	switch err {
+	case nil:
+	case auth.ErrorBadCredentials, auth.ErrorUnknownUser:
+		return nil, auth.ErrorBadCredentials
+	default:
+		return nil, err
+	}
+
+	return provisionUser(c, p.Name(), id)
+}
+
+// checkPassword checks whether pass is the password of usr, verified by the
+// provider of the user.
+func checkPassword(c web.C, usr *model.User, pass string) (bool, error) {
+	if usr.IsLocal() {
+		return verifyPassword(c, usr, pass)
+	}
+
+	var p = getAuthProvider(c)
+	if p == nil || p.Name() != usr.AuthSource {
+		return false, nil
+	}
+
+	id, err := p.Authenticate(usr.Login, pass)
+	switch err {
+	case nil:
+		return id.Subject != "" && id.Subject == usr.ExternalID, nil
+	case auth.ErrorBadCredentials, auth.ErrorUnknownUser:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// provisionUser creates or updates the user authenticated as id by provider
+// source. The user is found by the subject of the identity. Users found by its
+// email or login instead are only linked once an admin has set their auth
+// source to source, see UpdateUser, as they may belong to someone else: other
+// users, including local ones, are a conflict and ErrorAccountConflict is
+// returned.
+func provisionUser(c web.C, source string, id *auth.Identity) (*model.User, error) {
+	var ctx = context.FromC(c)
+
+	if id.Subject == "" {
+		return nil, errors.New("No subject in identity from " + source)
+	}
+
+	var login = invalidLoginChars.ReplaceAllString(id.Login, "_")
+	if len(login) < 5 {
+		login = source + "_" + login
+	}
+
+	usr, err := datastore.GetUserByExternalID(ctx, source, id.Subject)
+	switch {
+	case err == sql.ErrNoRows:
+		usr = nil
+	case err != nil:
+		return nil, err
+	}
+
+	for _, l := range []string{id.Email, login} {
+		if usr != nil || l == "" {
+			break
+		}
+		u, err := datastore.GetUserByLogin(ctx, l)
+		switch {
+		case err == sql.ErrNoRows:
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		if u.AuthSource != source || u.ExternalID != "" {
+			log.Printf("%s: %s: %s\n", source, u.Login, ErrorAccountConflict)
+			return nil, ErrorAccountConflict
+		}
+		usr = u
+	}
+
+	var role = id.Role
+	if role == "" {
+		role = model.RoleUser
+	}
+	if !roleExists(c, role) {
+		return nil, errors.New("Unknown role " + role + " mapped by " + source)
+	}
+
+	if usr == nil {
+		pass, err := hashPassword(c, util.GetRandomString(32))
+		if err != nil {
+			return nil, err
+		}
+
+		usr = &model.User{
+			Login:      login,
+			Email:      id.Email,
+			Password:   pass,
+			Name:       id.Name,
+			Role:       role,
+			Verified:   true,
+			AuthSource: source,
+			ExternalID: id.Subject,
+		}
+		if err := model.Validate(usr); err != nil {
+			return nil, err
+		}
+		if err := datastore.AddUser(ctx, usr); err != nil {
+			return nil, err
+		}
+		return usr, nil
+	}
+
+	// The provider is the source of profile and role of provisioned users.
+	var changed = usr.AuthSource != source || usr.ExternalID != id.Subject || usr.Role != role || !usr.Verified ||
+		(id.Email != "" && usr.Email != id.Email) || (id.Name != "" && usr.Name != id.Name)
+	if !changed {
+		return usr, nil
+	}
+
+	if id.Email != "" {
+		usr.Email = id.Email
+	}
+	if id.Name != "" {
+		usr.Name = id.Name
+	}
+	usr.Role = role
+	usr.Verified = true
+	usr.AuthSource = source
+	usr.ExternalID = id.Subject
+
+	if err := model.Validate(usr); err != nil {
+		return nil, err
+	}
+	if err := datastore.UpdateUser(ctx, usr); err != nil {
+		return nil, err
+	}
+	return usr, nil
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/auth"
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/password"
+
+	gojictx "github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// fakeUserstore is an in-memory datastore of users and builtin roles. Other
+// methods of the datastore are not implemented.
+type fakeUserstore struct {
+	datastore.Datastore
+	users []*model.User
+}
+
+func (s *fakeUserstore) GetUserByExternalID(source, id string) (*model.User, error) {
+	for _, u := range s.users {
+		if u.AuthSource == source && u.ExternalID == id {
+			cu := *u
+			return &cu, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeUserstore) GetUserByLogin(loginOrEmail string) (*model.User, error) {
+	for _, u := range s.users {
+		if u.Login == loginOrEmail || u.Email == loginOrEmail {
+			cu := *u
+			return &cu, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeUserstore) AddUser(usr *model.User) error {
+	usr.ID = int64(len(s.users) + 1)
+	cu := *usr
+	s.users = append(s.users, &cu)
+	return nil
+}
+
+func (s *fakeUserstore) UpdateUser(usr *model.User) error {
+	for i, u := range s.users {
+		if u.ID == usr.ID {
+			cu := *usr
+			s.users[i] = &cu
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *fakeUserstore) GetRoleByName(name string) (*model.Role, error) {
+	for _, r := range model.BuiltinRoles {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// fakeProvider authenticates identities by login with password "secret".
+type fakeProvider struct {
+	name       string
+	identities map[string]*auth.Identity
+	calls      int
+}
+
+func (p *fakeProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeProvider) Authenticate(login, pass string) (*auth.Identity, error) {
+	p.calls++
+	id, ok := p.identities[login]
+	if !ok {
+		return nil, auth.ErrorUnknownUser
+	}
+	if pass != "secret" {
+		return nil, auth.ErrorBadCredentials
+	}
+	return id, nil
+}
+
+// newAuthTestC returns context of requests with ds as datastore and p as
+// authentication provider.
+func newAuthTestC(t *testing.T, ds datastore.Datastore, p auth.Provider) web.C {
+	hasher, err := password.New(password.AlgorithmBcrypt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := web.C{Env: map[interface{}]interface{}{
+		"passwdHasher": hasher,
+		"authProvider": p,
+	}}
+	gojictx.Set(&c, datastore.NewContext(context.Background(), ds))
+	return c
+}
+
+func TestProvisionUser(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []*model.User
+		id    *auth.Identity
+		want  *model.User // Compared on login, email, role, source and subject
+		err   error
+		other bool // Whether any error other than a conflict is expected
+	}{
+		{
+			name: "new user",
+			id:   &auth.Identity{Subject: "s1", Login: "alice", Email: "alice@example.com", Role: "user"},
+			want: &model.User{Login: "alice", Email: "alice@example.com", Role: "user", AuthSource: "ldap", ExternalID: "s1"},
+		},
+		{
+			name:  "linked by subject",
+			users: []*model.User{{ID: 1, Login: "alice01", Email: "old@example.com", Role: "user", Password: "hashed-password", AuthSource: "ldap", ExternalID: "s1"}},
+			id:    &auth.Identity{Subject: "s1", Login: "alice", Email: "alice@example.com", Role: "org_admin"},
+			want:  &model.User{Login: "alice01", Email: "alice@example.com", Role: "org_admin", AuthSource: "ldap", ExternalID: "s1"},
+		},
+		{
+			name:  "linked by admin",
+			users: []*model.User{{ID: 1, Login: "alice01", Email: "alice@example.com", Role: "user", Password: "hashed-password", AuthSource: "ldap"}},
+			id:    &auth.Identity{Subject: "s1", Login: "alice01", Email: "alice@example.com", Role: "user"},
+			want:  &model.User{Login: "alice01", Email: "alice@example.com", Role: "user", AuthSource: "ldap", ExternalID: "s1"},
+		},
+		{
+			name:  "local user of same email",
+			users: []*model.User{{ID: 1, Login: "alice01", Email: "alice@example.com", Role: "user", Password: "hashed-password"}},
+			id:    &auth.Identity{Subject: "s1", Login: "alice01", Email: "alice@example.com", Role: "user"},
+			err:   ErrorAccountConflict,
+		},
+		{
+			name:  "local user of same login",
+			users: []*model.User{{ID: 1, Login: "alice01", Email: "other@example.com", Role: "user", Password: "hashed-password"}},
+			id:    &auth.Identity{Subject: "s1", Login: "alice01", Email: "alice@example.com", Role: "user"},
+			err:   ErrorAccountConflict,
+		},
+		{
+			name:  "local user of custom admin role",
+			users: []*model.User{{ID: 1, Login: "alice01", Email: "alice@example.com", Role: "manager", Password: "hashed-password"}},
+			id:    &auth.Identity{Subject: "s1", Login: "alice01", Email: "alice@example.com", Role: "user"},
+			err:   ErrorAccountConflict,
+		},
+		{
+			name:  "user of another source",
+			users: []*model.User{{ID: 1, Login: "alice01", Email: "alice@example.com", Role: "user", Password: "hashed-password", AuthSource: "oidc", ExternalID: "s1"}},
+			id:    &auth.Identity{Subject: "s1", Login: "alice01", Email: "alice@example.com", Role: "user"},
+			err:   ErrorAccountConflict,
+		},
+		{
+			name:  "user of another subject",
+			users: []*model.User{{ID: 1, Login: "alice01", Email: "alice@example.com", Role: "user", Password: "hashed-password", AuthSource: "ldap", ExternalID: "s2"}},
+			id:    &auth.Identity{Subject: "s1", Login: "alice01", Email: "alice@example.com", Role: "user"},
+			err:   ErrorAccountConflict,
+		},
+		{
+			name:  "unknown role",
+			id:    &auth.Identity{Subject: "s1", Login: "alice", Email: "alice@example.com", Role: "nobody"},
+			other: true,
+		},
+		{
+			name:  "no subject",
+			id:    &auth.Identity{Login: "alice", Email: "alice@example.com", Role: "user"},
+			other: true,
+		},
+	}
+
+	for _, tt := range tests {
+		ds := &fakeUserstore{users: tt.users}
+		c := newAuthTestC(t, ds, nil)
+
+		usr, err := provisionUser(c, "ldap", tt.id)
+		switch {
+		case tt.other:
+			if err == nil || err == ErrorAccountConflict {
+				t.Errorf("%s: error %v, want other error", tt.name, err)
+			}
+			continue
+		case err != tt.err:
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+			continue
+		case err != nil:
+			if len(ds.users) != len(tt.users) || (len(tt.users) > 0 && *ds.users[0] != *tt.users[0]) {
+				t.Errorf("%s: users changed on conflict", tt.name)
+			}
+			continue
+		}
+
+		if usr.Login != tt.want.Login || usr.Email != tt.want.Email || usr.Role != tt.want.Role ||
+			usr.AuthSource != tt.want.AuthSource || usr.ExternalID != tt.want.ExternalID || !usr.Verified {
+			t.Errorf("%s: user %+v, want %+v", tt.name, usr, tt.want)
+		}
+		if stored, _ := ds.GetUserByExternalID("ldap", tt.id.Subject); stored == nil || stored.ID != usr.ID {
+			t.Errorf("%s: user not stored", tt.name)
+		}
+	}
+}
+
+func TestAuthenticateWithProvider(t *testing.T) {
+	p := &fakeProvider{name: "ldap", identities: map[string]*auth.Identity{
+		"alice": {Subject: "s1", Login: "alice", Email: "alice@example.com", Role: "user"},
+		"admin": {Subject: "s2", Login: "admin", Email: "admin@example.com", Role: "user"},
+		"bob":   {Subject: "s3", Login: "bob", Email: "bob@example.com", Role: "user"},
+	}}
+	ds := &fakeUserstore{}
+	c := newAuthTestC(t, ds, p)
+
+	local, err := hashPassword(c, "local-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.users = []*model.User{
+		{ID: 1, Login: "admin", Email: "root@example.com", Password: local, Role: "admin", Verified: true},
+		{ID: 2, Login: "bob", Email: "bob@example.com", Role: "user", Verified: true, AuthSource: "oidc", ExternalID: "b"},
+	}
+
+	tests := []struct {
+		login, pass string
+		wantLogin   string
+		err         error
+		calls       int // Calls of the provider
+	}{
+		{"alice", "secret", "alice", nil, 1},
+		{"alice", "wrong", "", auth.ErrorBadCredentials, 1},
+		{"carol", "secret", "", auth.ErrorBadCredentials, 1},
+		{"admin", "local-secret", "admin", nil, 0},
+		{"admin", "secret", "", auth.ErrorBadCredentials, 0},
+		{"bob", "secret", "", auth.ErrorBadCredentials, 0},
+	}
+
+	for _, tt := range tests {
+		p.calls = 0
+		usr, _ := ds.GetUserByLogin(tt.login)
+
+		got, err := authenticate(c, tt.login, tt.pass, usr)
+		if err != tt.err {
+			t.Errorf("%s/%s: error %v, want %v", tt.login, tt.pass, err, tt.err)
+			continue
+		}
+		if err == nil && got.Login != tt.wantLogin {
+			t.Errorf("%s/%s: user %s, want %s", tt.login, tt.pass, got.Login, tt.wantLogin)
+		}
+		if p.calls != tt.calls {
+			t.Errorf("%s/%s: %d provider calls, want %d", tt.login, tt.pass, p.calls, tt.calls)
+		}
+	}
+}
@@ -31,3 +31,53 @@ func ToDocument(c web.C) *model.Document {
 	}
 	return nil
 }
+
+// ToAPIToken returns the APIToken, the request is authenticated with, from the
+// current request context. If the request is not authenticated with API token
+// a nil value is returned.
+func ToAPIToken(c web.C) *model.APIToken {
+	var v = c.Env["apiToken"]
+
+	if v == nil {
+		return nil
+	}
+	if t, ok := v.(*model.APIToken); ok {
+		return t
+	}
+	return nil
+}
+
+// ToOrganization returns the Organization of current user from the current
+// request context. If the user belongs to no organization a nil value is
+// returned.
+func ToOrganization(c web.C) *model.Organization {
+	var v = c.Env["organization"]
+
+	if v == nil {
+		return nil
+	}
+	if org, ok := v.(*model.Organization); ok {
+		return org
+	}
+	return nil
+}
+
+// ToRole returns the Role of current user from the current request context.
+// If there is no user, or the role doesn't exist, a nil value is returned,
+// which grants no permission.
+func ToRole(c web.C) *model.Role {
+	var v = c.Env["role"]
+
+	if v == nil {
+		return nil
+	}
+	if role, ok := v.(*model.Role); ok {
+		return role
+	}
+	return nil
+}
+
+// can checks whether current user has permission perm.
+func can(c web.C, perm string) bool {
+	return ToRole(c).Can(perm)
+}
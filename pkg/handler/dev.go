@@ -50,14 +50,19 @@ func seedUser(c web.C, fields ...string) (*model.User, error) {
 		Email:    fields[1],
 		Password: fields[2],
 		Role:     fields[3],
+		Verified: true,
 	}
 
 	// Sets password.
 	if usr.Password == "" {
 		usr.Password = util.GetRandomString(8)
 	}
-	// Encrypt the password.
-	usr.Password = encryptPassword(c, usr.Password)
+	// Hash the password.
+	var err error
+	usr.Password, err = hashPassword(c, usr.Password)
+	if err != nil {
+		return nil, err
+	}
 
 	// Add new user into the datastore.
 	if err := datastore.AddUser(context.FromC(c), usr); err != nil {
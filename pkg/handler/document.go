@@ -1,9 +1,9 @@
 package handler
 
 import (
-	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -14,6 +14,7 @@ import (
 	"github.com/gedex/simdoc/pkg/model"
 	"github.com/gedex/simdoc/pkg/util/upload"
 	"github.com/gedex/simdoc/pkg/util/upload/processor"
+	"github.com/gedex/simdoc/pkg/util/watermark"
 
 	"code.google.com/p/go-uuid/uuid"
 
@@ -21,6 +22,15 @@ import (
 	"github.com/zenazn/goji/web"
 )
 
+const (
+	// versionOriginal is the name of the file version stored as uploaded.
+	versionOriginal = processor.VersionOriginal
+
+	// versionWatermarked is the name of watermarked file version of published
+	// documents.
+	versionWatermarked = processor.VersionWatermarked
+)
+
 // GetAllDocuments accepts a request to retrieve all docuemnts from the datastore and
 // returns in JSON format.
 //
@@ -29,7 +39,20 @@ import (
 func GetAllDocuments(c web.C, w http.ResponseWriter, r *http.Request) {
 	var ctx = context.FromC(c)
 
-	docs, err := datastore.GetAllDocuments(ctx)
+	// Only lists documents readable by current user.
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return
+	}
+
+	var docs []*model.Document
+	var err error
+	if can(c, model.PermDocumentsReadAny) {
+		docs, err = datastore.GetAllDocuments(ctx)
+	} else {
+		docs, err = datastore.GetDocumentsReadableBy(ctx, usr.ID)
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -99,6 +122,8 @@ func AddDocument(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	addDocumentAuditEvent(c, r, model.AuditDocumentCreated, doc, "", doc.Name)
+
 	// @todo send notification to registered listeners.
 
 	w.WriteHeader(http.StatusCreated)
@@ -131,7 +156,7 @@ func DeleteDocument(c web.C, w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	// Check if current user has priviledge to delete the document.
-	if doc.CreatedBy == usr.ID || usr.IsAdmin() {
+	if doc.CreatedBy == usr.ID || can(c, model.PermDocumentsDeleteAny) {
 		err = datastore.DeleteDocument(context.FromC(c), doc.ID)
 	} else {
 		respWithError(w, http.StatusForbidden, ErrorForbidden)
@@ -141,13 +166,123 @@ func DeleteDocument(c web.C, w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
 	} else {
+		addDocumentAuditEvent(c, r, model.AuditDocumentDeleted, doc, "", doc.Name)
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-// @todo implement me
+// PublishDocument accepts a request to publish a document specified by docId in
+// the URL. Watermarked versions of the document files are (re)generated, so
+// publishing an already published document refreshes its watermarks.
+//
+// POST /api/documents/:docId/publish
+//
+func PublishDocument(c web.C, w http.ResponseWriter, r *http.Request) {
+	// @todo remove me once DocumentToContextInjector is being used.
+	if ok := docToContext(&c, w); !ok {
+		return
+	}
+
+	var doc = ToDocument(c)
+	if doc == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return
+	}
+
+	// Check if current user has priviledge to publish the document.
+	if !(doc.CreatedBy == usr.ID && can(c, model.PermDocumentsPublish)) && !can(c, model.PermDocumentsPublishAny) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	var ctx = context.FromC(c)
+
+	// Files are watermarked before the document is published, so that they're
+	// never readable by everyone without watermark.
+	var before = doc.Status
+	doc.Status = model.DocumentStatusPublished
+
+	wm, err := getWatermark(c, doc)
+	if err != nil {
+		doc.Status = before
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if wm != nil {
+		if err := watermarkDocumentFiles(c, doc, wm); err != nil {
+			log.Printf("%+v\n", err)
+			doc.Status = before
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+			return
+		}
+	}
+
+	if err := datastore.UpdateDocument(ctx, doc); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addDocumentAuditEvent(c, r, model.AuditDocumentPublished, doc, "", "Status "+before+" to "+doc.Status)
+
+	json.NewEncoder(w).Encode(doc)
+}
+
+// GetDocumentFiles accepts a request to retrieve all files of a document, for
+// given document ID docId, from the datastore and returns in JSON format. URLs
+// of the files are signed for current user and expire after a while.
+//
+// GET /api/documents/:docId/files?disposition=attachment
+//
 func GetDocumentFiles(c web.C, w http.ResponseWriter, r *http.Request) {
-	respWithError(w, http.StatusNotImplemented, ErrorNotImplemented)
+	// @todo remove me once DocumentToContextInjector is being used.
+	if ok := docToContext(&c, w); !ok {
+		return
+	}
+
+	var doc = ToDocument(c)
+	if doc == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return
+	}
+
+	var disposition = r.URL.Query().Get("disposition")
+	if !isValidDisposition(disposition) {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("document_files", "disposition", ErrorFieldInvalid))
+		return
+	}
+
+	files, err := datastore.GetAllDocumentFiles(context.FromC(c), doc.ID)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if files == nil {
+		w.Write([]byte(`[]`))
+		return
+	}
+
+	var restricted = doc.Status == model.DocumentStatusPublished && !canEditDocument(c, usr, doc)
+	for _, f := range files {
+		if restricted {
+			restrictToWatermarked(f)
+		}
+		signDocumentFile(c, f, usr, disposition)
+	}
+
+	json.NewEncoder(w).Encode(files)
 }
 
 // @todo refactor me!
@@ -155,10 +290,37 @@ func GetDocumentFiles(c web.C, w http.ResponseWriter, r *http.Request) {
 // POST /api/documents/:docId/files
 //
 func AddDocumentFile(c web.C, w http.ResponseWriter, r *http.Request) {
+	// @todo remove me once DocumentToContextInjector is being used.
+	if ok := docToContext(&c, w); !ok {
+		return
+	}
+
+	var doc = ToDocument(c)
+	if doc == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	if !canEditDocument(c, ToUser(c), doc) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
 	fsRoot := c.Env["fsRoot"].(string)
 	prefix := c.Env["filesPrefix"].(string)
 
-	files, err := upload.FromHttp(r, fsRoot)
+	// Files of organizations are stored apart, within their storage quota.
+	orgRoot := upload.OrganizationRoot(fsRoot, doc.OrgID)
+	if exceeded, err := exceedsStorageQuota(c, doc); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	} else if exceeded {
+		respWithError(w, http.StatusRequestEntityTooLarge, ErrorQuotaExceeded)
+		return
+	}
+
+	files, err := upload.FromHttp(r, orgRoot)
 	switch {
 	case err != nil && err == upload.ErrorIncomplete:
 		w.WriteHeader(http.StatusOK)
@@ -189,47 +351,107 @@ func AddDocumentFile(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Callback function that's called after each processor.Process.
-	afterFn := func(out *upload.File, err error) (*upload.File, error) {
-		if err != nil || out == nil {
-			return out, err
-		}
-		if out.Filepath == "" {
-			return out, err
-		}
-		if rel, relErr := filepath.Rel(fsRoot, out.Filepath); relErr == nil {
-			out.URL = filepath.Join(prefix, rel)
-		}
-		return out, err
+	// Published documents get a watermarked copy of each file.
+	wm, err := getWatermark(c, doc)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	// Processors pipeline run against each uploaded file.
+	pl, ok := c.Env["pipeline"].(*processor.Pipeline)
+	if !ok || pl == nil {
+		pl = processor.DefaultPipeline
 	}
 
 	resp := make([]*upload.FileResult, 0)
 	for _, f := range files {
 		var fr *upload.FileResult
 
-		bpath, err := upload.CreateDir(fsRoot, f.Type)
+		bpath, err := upload.CreateDir(orgRoot, f.Type)
 		if err != nil {
 			f.Error = err
 			fr = &upload.FileResult{f, nil}
 		} else {
-			fr = upload.ProcessFile(
-				f,
-				upload.AfterProcessFn(afterFn),
-				processor.Mover("default", upload.SourceOriginal, filepath.Join(bpath.Abs(), generateFilename(f, "default"))),
-				processor.Resizer("thumbnail-120x90", "default", filepath.Join(bpath.Abs(), generateFilename(f, "thumbnail-120x90")), 120, 90),
-			)
+			procs, err := pl.Build(func(name, ext string) string {
+				return filepath.Join(bpath.Abs(), upload.GenerateFilename(f, name, ext))
+			})
+			if err != nil {
+				respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+				return
+			}
+			if wm != nil && hasProcessor(procs, versionOriginal) {
+				procs = append(procs, processor.Watermarker(versionWatermarked, versionOriginal, filepath.Join(bpath.Abs(), upload.GenerateFilename(f, versionWatermarked, "")), wm))
+			}
+
+			fr = upload.ProcessFile(f, upload.URLFn(fsRoot, prefix), procs...)
 		}
 
 		resp = append(resp, fr)
 	}
 
+	var size int64
+	for _, fr := range resp {
+		size += fr.StoredSize()
+	}
+	if ok, err := reserveStorage(c, doc, size); err != nil || !ok {
+		for _, fr := range resp {
+			fr.Remove()
+		}
+		if err != nil {
+			log.Printf("%+v\n", err)
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		} else {
+			respWithError(w, http.StatusRequestEntityTooLarge, ErrorQuotaExceeded)
+		}
+		return
+	}
+
+	var ctx = context.FromC(c)
+
+	added := make([]*model.DocumentFile, 0, len(resp))
+	rollback := func(err error) {
+		log.Printf("%+v\n", err)
+		for _, df := range added {
+			datastore.DeleteDocumentFile(ctx, df.ID)
+		}
+		for _, fr := range resp {
+			fr.Remove()
+		}
+		addStorageUsage(c, doc, -size)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+	}
+
+	for _, fr := range resp {
+		if fr.Error != nil {
+			continue
+		}
+		// Files are stored as their original version, without which they
+		// would be left orphaned.
+		if v, ok := fr.Versions[versionOriginal]; !ok {
+			rollback(fmt.Errorf("upload: missing %s version of %s", versionOriginal, fr.Sid))
+			return
+		} else if v.Error != nil {
+			rollback(v.Error)
+			return
+		}
+
+		df := fr.DocumentFile(doc.ID, versionOriginal)
+		if err := datastore.AddDocumentFile(ctx, df); err != nil {
+			rollback(err)
+			return
+		}
+		added = append(added, df)
+
+		signFileResult(c, fr, ToUser(c))
+		addDocumentAuditEvent(c, r, model.AuditFileUploaded, doc, "file:"+fr.Sid, fr.Name)
+	}
+
 	// Wrap resp so that JS uploader can consumes the response.
 	wrapResp := struct {
 		Files []*upload.FileResult `json:"files"`
 	}{resp}
 
-	// @todo write to DB.
-
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(wrapResp)
 }
@@ -285,8 +507,6 @@ func checkDoc(c *web.C, w http.ResponseWriter, docId int64) bool {
 	ctx := context.FromC(*c)
 	user := middleware.ToUser(c)
 
-	// @todo check document participants?
-
 	doc, err := datastore.GetDocumentById(ctx, docId)
 	switch {
 	case err != nil && user == nil:
@@ -295,6 +515,12 @@ func checkDoc(c *web.C, w http.ResponseWriter, docId int64) bool {
 	case err != nil && user != nil:
 		respWithError(w, http.StatusNotFound, ErrorNotFound)
 		return false
+	case user == nil:
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return false
+	case !canReadDocument(*c, user, doc):
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return false
 	}
 
 	middleware.DocToC(c, doc)
@@ -302,13 +528,206 @@ func checkDoc(c *web.C, w http.ResponseWriter, docId int64) bool {
 	return true
 }
 
-func generateFilename(f *upload.File, suffix string) string {
-	t := time.Now()
-	ts := int64(t.Hour()*3600 + t.Minute()*60 + t.Second())
+// getWatermark returns the configured watermark rendered for doc. A nil value
+// is returned if doc is not published or no watermark is configured.
+func getWatermark(c web.C, doc *model.Document) (*watermark.Watermark, error) {
+	wm, ok := c.Env["watermark"].(*watermark.Watermark)
+	if !ok || wm == nil || doc.Status != model.DocumentStatusPublished {
+		return nil, nil
+	}
+
+	// Organizations may stamp their own text.
+	if doc.OrgID != 0 {
+		org, err := datastore.GetOrganizationById(context.FromC(c), doc.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		if org.Settings != nil && org.Settings.WatermarkText != "" {
+			var owm = *wm
+			owm.Text = org.Settings.WatermarkText
+			wm = &owm
+		}
+	}
+
+	return wm.Render(&watermark.Vars{
+		DocumentID:   doc.ID,
+		DocumentName: doc.Name,
+		Date:         time.Now().Format("2006-01-02"),
+	})
+}
+
+// exceedsStorageQuota checks whether files of the organization of doc reach
+// its storage quota.
+func exceedsStorageQuota(c web.C, doc *model.Document) (bool, error) {
+	if doc.OrgID == 0 {
+		return false, nil
+	}
+
+	var ctx = context.FromC(c)
+	org, err := datastore.GetOrganizationById(ctx, doc.OrgID)
+	if err != nil {
+		return false, err
+	}
+	if org.QuotaBytes == 0 {
+		return false, nil
+	}
+
+	size, err := datastore.GetOrganizationUsage(ctx, org.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return size >= org.QuotaBytes, nil
+}
+
+// reserveStorage adds bytes to the storage usage of the organization of doc,
+// unless it would exceed its storage quota. It returns whether bytes are
+// added.
+func reserveStorage(c web.C, doc *model.Document, bytes int64) (bool, error) {
+	if doc.OrgID == 0 {
+		return true, nil
+	}
+
+	var ctx = context.FromC(c)
+	org, err := datastore.GetOrganizationById(ctx, doc.OrgID)
+	if err != nil {
+		return false, err
+	}
+
+	return datastore.AddOrganizationUsage(ctx, org.ID, bytes, org.QuotaBytes)
+}
+
+// addStorageUsage adds bytes, negative for removed files, to the storage usage
+// of the organization of doc, regardless of its storage quota.
+func addStorageUsage(c web.C, doc *model.Document, bytes int64) {
+	if doc.OrgID == 0 {
+		return
+	}
+	if _, err := datastore.AddOrganizationUsage(context.FromC(c), doc.OrgID, bytes, 0); err != nil {
+		log.Printf("%+v\n", err)
+	}
+}
 
-	if suffix != "" {
-		suffix = "-" + suffix
+// watermarkDocumentFiles (re)generates watermarked version of all files of doc.
+func watermarkDocumentFiles(c web.C, doc *model.Document, wm *watermark.Watermark) error {
+	var ctx = context.FromC(c)
+	fsRoot := c.Env["fsRoot"].(string)
+	prefix := c.Env["filesPrefix"].(string)
+
+	files, err := datastore.GetAllDocumentFiles(ctx, doc.ID)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Sprintf("%x_%s%s%s", md5.Sum([]byte(f.Sid)), strconv.FormatInt(ts, 36), suffix, filepath.Ext(f.Name))
+	for _, df := range files {
+		if df.Meta == nil || !watermark.IsSupported(df.Meta.Mime) {
+			continue
+		}
+
+		f := &upload.File{
+			Name:     df.Name,
+			Sid:      strconv.FormatInt(df.ID, 10),
+			Mime:     df.Meta.Mime,
+			Type:     df.Meta.Type,
+			Filepath: df.Filepath,
+			Size:     df.Meta.Size,
+		}
+		dst := filepath.Join(filepath.Dir(df.Filepath), upload.GenerateFilename(f, versionWatermarked, ""))
+
+		fr := upload.ProcessFile(f, upload.URLFn(fsRoot, prefix), processor.Watermarker(versionWatermarked, upload.SourceOriginal, dst, wm))
+
+		v, ok := fr.Versions[versionWatermarked]
+		if !ok {
+			continue
+		}
+		if v.Error != nil {
+			return v.Error
+		}
+
+		// The watermarked version replaces the previous one, if any, which is
+		// not counted anymore.
+		var size = fr.StoredSize()
+		if prev, ok := df.Versions[versionWatermarked]; ok && prev != nil && prev.Meta != nil && prev.Filepath == v.Filepath {
+			size -= prev.Meta.Size
+		}
+		addStorageUsage(c, doc, size)
+
+		if df.Versions == nil {
+			df.Versions = make(map[string]*model.DocumentFileVersion, 1)
+		}
+		df.Versions[versionWatermarked] = v.DocumentFileVersion
+
+		if err := datastore.UpdateDocumentFile(ctx, df); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restrictToWatermarked hides versions of file f other than the watermarked
+// one, if any, from readers of published documents.
+func restrictToWatermarked(f *model.DocumentFile) {
+	v, ok := f.Versions[versionWatermarked]
+	if !ok || v == nil {
+		return
+	}
+
+	f.Filepath = v.Filepath
+	f.URL = v.URL
+	f.Versions = map[string]*model.DocumentFileVersion{versionWatermarked: v}
+}
+
+// canReadDocument checks whether current user usr can read doc. Draft
+// documents are only readable by their creator, their participants, directly
+// or through a group, and users allowed to read any document.
+func canReadDocument(c web.C, usr *model.User, doc *model.Document) bool {
+	if usr == nil {
+		return false
+	}
+	if doc.Status == model.DocumentStatusPublished {
+		return true
+	}
+	if doc.CreatedBy == usr.ID || can(c, model.PermDocumentsReadAny) {
+		return true
+	}
+	return len(participantRoles(c, usr, doc)) > 0
+}
+
+// canEditDocument checks whether current user usr can add files to doc. Only
+// the creator, editor participants and users allowed to update any document can.
+func canEditDocument(c web.C, usr *model.User, doc *model.Document) bool {
+	if usr == nil {
+		return false
+	}
+	if doc.CreatedBy == usr.ID || can(c, model.PermDocumentsUpdateAny) {
+		return true
+	}
+	for _, role := range participantRoles(c, usr, doc) {
+		if role == model.ParticipantRoleEditor {
+			return true
+		}
+	}
+	return false
+}
+
+// participantRoles returns roles of usr as participant of doc, including roles
+// of groups usr is member of.
+func participantRoles(c web.C, usr *model.User, doc *model.Document) []string {
+	roles, err := datastore.GetDocumentParticipantRoles(context.FromC(c), doc.ID, usr.ID)
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return nil
+	}
+	return roles
+}
+
+// hasProcessor checks whether a processor named name exists in procs.
+func hasProcessor(procs []upload.Processor, name string) bool {
+	for _, p := range procs {
+		if p.GetName() == name {
+			return true
+		}
+	}
+	return false
 }
@@ -64,6 +64,11 @@ const (
 	ErrorValidationFailed
 	ErrorNotImplemented
 	ErrorForbidden
+	ErrorEmailNotVerified
+	ErrorTooManyRequests
+	ErrorAccountDeactivated
+	ErrorQuotaExceeded
+	ErrorAccountConflict
 )
 
 var errorText = [...]string{
@@ -75,6 +80,11 @@ var errorText = [...]string{
 	"Validation failed",
 	"Not implemented",
 	"This resource is forbidden",
+	"Email is not verified",
+	"Too many requests",
+	"Account is deactivated",
+	"Organization quota exceeded",
+	"Account exists and is not linked to the provider",
 }
 
 func (e errorType) Error() string {
@@ -1,20 +1,56 @@
 package handler
 
 import (
+	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/middleware"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/upload"
+
+	"github.com/zenazn/goji/web"
 )
 
 type fileServer struct {
 	rootPath  string
 	urlPrefix string
+
+	// secret to verify signed URLs.
+	secret []byte
+
+	// allowUnsigned allows unsigned access to files. Signed URLs are still
+	// verified when provided.
+	allowUnsigned bool
+
+	// audit records downloads, nil to not record them.
+	audit datastore.Auditstore
+}
+
+// NewFileServer returns a handler that serves files under rootPath. Requested
+// URL must be signed with secret, see util.SignURL, unless allowUnsigned is true.
+// Downloads are recorded into audit, if not nil.
+func NewFileServer(rootPath, urlPrefix string, secret []byte, allowUnsigned bool, audit datastore.Auditstore) *fileServer {
+	return &fileServer{rootPath, urlPrefix, secret, allowUnsigned, audit}
 }
 
-func NewFileServer(rootPath, urlPrefix string) *fileServer {
-	return &fileServer{rootPath, urlPrefix}
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
 }
 
 func (f *fileServer) absPath(fp string) string {
@@ -25,25 +61,87 @@ func (f *fileServer) absPath(fp string) string {
 	return filepath.Join(f.rootPath, path.Clean(fp))
 }
 
-func (f *fileServer) serveFile(w http.ResponseWriter, r *http.Request, fp string) {
-	fo, err := os.Open(fp)
+func (f *fileServer) serveFile(w http.ResponseWriter, r *http.Request, fp string) bool {
+	s, err := os.Stat(fp)
+	if err != nil || s.IsDir() {
+		http.NotFound(w, r)
+		return false
+	}
+
+	// Encrypted files are decrypted on the fly, including for range requests.
+	fo, _, err := upload.OpenFile(fp)
 	if err != nil {
+		log.Printf("files: %s: %+v\n", fp, err)
 		http.NotFound(w, r)
-		return
+		return false
 	}
 	defer fo.Close()
 
-	s, err1 := fo.Stat()
-	if err1 != nil {
-		http.NotFound(w, r)
+	// Versions of files are never changed, so they're cached along with their
+	// signed URL.
+	if upload.IsGeneratedFilename(filepath.Base(fp)) {
+		w.Header().Set("Cache-Control", middleware.CacheImmutable)
+	} else {
+		w.Header().Set("Cache-Control", middleware.CacheRevalidate)
+	}
+
+	var sr = &statusRecorder{w, http.StatusOK}
+	http.ServeContent(sr, r, fp, s.ModTime(), fo)
+
+	return sr.status == http.StatusOK || sr.status == http.StatusPartialContent
+}
+
+// isHidden checks whether file or a directory of relative path rel starts with
+// a dot.
+func isHidden(rel string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDownload records the download of file rel by the client of r in the
+// audit log. Subsequent range requests of the same download, as issued by
+// media players, are not recorded.
+func (f *fileServer) recordDownload(r *http.Request, su *util.SignedURL, rel string) {
+	if f.audit == nil || r.Method != "GET" {
 		return
 	}
-	if s.IsDir() {
-		http.NotFound(w, r)
+	if rg := r.Header.Get("Range"); rg != "" && !strings.HasPrefix(rg, "bytes=0-") {
 		return
 	}
 
-	http.ServeContent(w, r, fp, s.ModTime(), fo)
+	var e = &model.AuditEvent{
+		Action:    model.AuditFileDownloaded,
+		Target:    "file:" + rel,
+		IP:        util.RemoteIP(r),
+		UserAgent: truncate(r.UserAgent(), auditUserAgentMax),
+	}
+	if su != nil {
+		e.ActorID = su.UserID
+	}
+	// Files of organizations are stored under org/<orgId>, see
+	// upload.OrganizationRoot.
+	if parts := strings.SplitN(filepath.ToSlash(rel), "/", 3); len(parts) == 3 && parts[0] == "org" {
+		e.OrgID, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+
+	if err := f.audit.AddAuditEvent(e); err != nil {
+		log.Printf("audit: %+v %+v\n", err, e)
+	}
+}
+
+// verify checks the signature of requested URL. The returned claims is nil
+// if the URL is not signed and unsigned access is allowed.
+func (f *fileServer) verify(r *http.Request) (*util.SignedURL, error) {
+	su, err := util.VerifySignedURL(f.secret, r.URL)
+	if err == util.ErrorSignatureMissing && f.allowUnsigned {
+		return nil, nil
+	}
+
+	return su, err
 }
 
 func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +149,73 @@ func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		f.urlPrefix = "/" + f.urlPrefix
 	}
 
+	su, err := f.verify(r)
+	if err != nil {
+		respWithError(w, http.StatusForbidden, err)
+		return
+	}
+
 	rel, _ := filepath.Rel(f.urlPrefix, r.URL.Path)
 
-	f.serveFile(w, r, f.absPath(rel))
+	// Hidden files, such as decrypted copies and files being written, are
+	// never served.
+	if isHidden(rel) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if su != nil && su.Disposition != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(su.Disposition, map[string]string{
+			"filename": path.Base(r.URL.Path),
+		}))
+	}
+
+	if f.serveFile(w, r, f.absPath(rel)) {
+		f.recordDownload(r, su, rel)
+	}
+}
+
+// signFileURL signs URL of a file for user usr, so that the file can be
+// downloaded from the file server until the URL expires.
+func signFileURL(c web.C, fileURL string, usr *model.User, disposition string) string {
+	if fileURL == "" || usr == nil {
+		return fileURL
+	}
+
+	var secret = c.Env["filesSecret"].(string)
+	var ttl = c.Env["filesURLTTL"].(time.Duration)
+
+	return util.SignURL([]byte(secret), &util.SignedURL{
+		Path:        fileURL,
+		UserID:      usr.ID,
+		Expires:     time.Now().UTC().Add(ttl).Unix(),
+		Disposition: disposition,
+	})
+}
+
+// signDocumentFile signs URLs of file f and its versions for user usr.
+func signDocumentFile(c web.C, f *model.DocumentFile, usr *model.User, disposition string) {
+	f.URL = signFileURL(c, f.URL, usr, disposition)
+	for _, v := range f.Versions {
+		if v != nil {
+			v.URL = signFileURL(c, v.URL, usr, disposition)
+		}
+	}
+}
+
+// signFileResult signs URLs of processed file fr and its versions for user usr.
+func signFileResult(c web.C, fr *upload.FileResult, usr *model.User) {
+	if fr.File != nil {
+		fr.URL = signFileURL(c, fr.URL, usr, "")
+	}
+	for _, v := range fr.Versions {
+		if v != nil && v.DocumentFileVersion != nil {
+			v.URL = signFileURL(c, v.URL, usr, "")
+		}
+	}
+}
+
+// isValidDisposition checks whether disposition d can be used in signed URL.
+func isValidDisposition(d string) bool {
+	return d == "" || d == util.DispositionInline || d == util.DispositionAttachment
 }
@@ -0,0 +1,24 @@
+package handler
+
+import "testing"
+
+func TestIsHidden(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"image/2026/10/a.png", false},
+		{"org/1/image/a.png", false},
+		{"image/a.b.png", false},
+		{".plain/plain-1.png", true},
+		{"image/2026/10/.a.png.123", true},
+		{"org/.hidden/a.png", true},
+		{"../secret", true},
+	}
+
+	for _, tt := range tests {
+		if got := isHidden(tt.rel); got != tt.want {
+			t.Errorf("isHidden(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// GetAllGroups accepts a request to retrieve all groups from the datastore and
+// returns in JSON format.
+//
+// GET /api/admin/groups
+//
+func GetAllGroups(c web.C, w http.ResponseWriter, r *http.Request) {
+	groups, err := datastore.GetAllGroups(context.FromC(c))
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if groups == nil {
+		w.Write([]byte(`[]`))
+	} else {
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+// GetGroup accepts a request to retrieve a group, for the given groupId, along
+// with its members, and returns in JSON format.
+//
+// GET /api/admin/groups/:groupId
+//
+func GetGroup(c web.C, w http.ResponseWriter, r *http.Request) {
+	var group = getGroup(c, w)
+	if group == nil {
+		return
+	}
+
+	members, err := datastore.GetAllGroupMembers(context.FromC(c), group.ID)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []*model.User{}
+	}
+
+	json.NewEncoder(w).Encode(&struct {
+		*model.Group
+		Members []*model.User `json:"members"`
+	}{group, members})
+}
+
+// AddGroup accepts a request to add a group into the datastore. Groups are
+// added to the organization of current user, global admins may choose any.
+//
+// POST /api/admin/groups
+//
+func AddGroup(c web.C, w http.ResponseWriter, r *http.Request) {
+	var group = new(model.Group)
+	if err := json.NewDecoder(r.Body).Decode(group); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	group.ID = 0
+	group.Created = 0
+
+	if ve := model.Validate(group); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("groups", ve)...)
+		return
+	}
+
+	var ctx = context.FromC(c)
+	if group.OrgID != 0 {
+		if _, err := datastore.GetOrganizationById(ctx, group.OrgID); err != nil {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("groups", "org_id", ErrorFieldInvalid))
+			return
+		}
+	}
+
+	if err := datastore.AddGroup(ctx, group); err != nil {
+		if isDuplicateEntryError(err) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("groups", "name", ErrorFieldAlreadyExists))
+		} else {
+			log.Printf("%+v\n", err)
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		}
+		return
+	}
+
+	addGroupAuditEvent(c, r, model.AuditGroupCreated, group, "", model.AuditChanges(struct{}{}, group))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// UpdateGroup accepts a request to update name and description of a group, for
+// the given groupId.
+//
+// PATCH /api/admin/groups/:groupId
+// PUT   /api/admin/groups/:groupId
+//
+func UpdateGroup(c web.C, w http.ResponseWriter, r *http.Request) {
+	var group = getGroup(c, w)
+	if group == nil {
+		return
+	}
+
+	var req = new(struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	var before = *group
+	if req.Name != nil {
+		group.Name = *req.Name
+	}
+	if req.Description != nil {
+		group.Description = *req.Description
+	}
+
+	if ve := model.Validate(group); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("groups", ve)...)
+		return
+	}
+
+	if err := datastore.UpdateGroup(context.FromC(c), group); err != nil {
+		if isDuplicateEntryError(err) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("groups", "name", ErrorFieldAlreadyExists))
+		} else {
+			log.Printf("%+v\n", err)
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		}
+		return
+	}
+
+	addGroupAuditEvent(c, r, model.AuditGroupUpdated, group, "", model.AuditChanges(&before, group))
+
+	json.NewEncoder(w).Encode(group)
+}
+
+// DeleteGroup accepts a request to delete a group, for the given groupId. Its
+// members lose access to documents shared with the group.
+//
+// DELETE /api/admin/groups/:groupId
+//
+func DeleteGroup(c web.C, w http.ResponseWriter, r *http.Request) {
+	var group = getGroup(c, w)
+	if group == nil {
+		return
+	}
+
+	if err := datastore.DeleteGroup(context.FromC(c), group.ID); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addGroupAuditEvent(c, r, model.AuditGroupDeleted, group, group.Name, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddGroupMember accepts a request to add a user, for the given login or
+// email, to a group, for the given groupId.
+//
+// PUT /api/admin/groups/:groupId/members/:login
+//
+func AddGroupMember(c web.C, w http.ResponseWriter, r *http.Request) {
+	var group = getGroup(c, w)
+	if group == nil {
+		return
+	}
+	var usr = getUserByLogin(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+	if usr.OrgID != group.OrgID {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("group_members", "login", ErrorFieldInvalid))
+		return
+	}
+
+	err := datastore.AddGroupMember(context.FromC(c), &model.GroupMember{GroupID: group.ID, UserID: usr.ID})
+	if err != nil && !isDuplicateEntryError(err) {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if err == nil {
+		addGroupAuditEvent(c, r, model.AuditGroupMemberAdded, group, accountLockoutKey(usr), nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteGroupMember accepts a request to remove a user, for the given login or
+// email, from a group, for the given groupId.
+//
+// DELETE /api/admin/groups/:groupId/members/:login
+//
+func DeleteGroupMember(c web.C, w http.ResponseWriter, r *http.Request) {
+	var group = getGroup(c, w)
+	if group == nil {
+		return
+	}
+	var usr = getUserByLogin(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+
+	if err := datastore.DeleteGroupMember(context.FromC(c), group.ID, usr.ID); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addGroupAuditEvent(c, r, model.AuditGroupMemberRemoved, group, accountLockoutKey(usr), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getGroup retrieves group for groupId in the URL. Otherwise it responds with
+// not found, or internal server error, and returns nil.
+func getGroup(c web.C, w http.ResponseWriter) *model.Group {
+	groupId, _ := strconv.ParseInt(c.URLParams["groupId"], 10, 64)
+
+	group, err := datastore.GetGroupById(context.FromC(c), groupId)
+	switch {
+	case err == sql.ErrNoRows:
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return nil
+	case err != nil:
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return nil
+	}
+	return group
+}
+
+// addGroupAuditEvent records action of current user on group in the audit log.
+func addGroupAuditEvent(c web.C, r *http.Request, action string, group *model.Group, details string, changes map[string]*model.AuditChange) {
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  action,
+		OrgID:   group.OrgID,
+		Target:  "group:" + strconv.FormatInt(group.ID, 10),
+		Details: details,
+		Changes: changes,
+	})
+}
@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/importer"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/upload/processor"
+	"github.com/gedex/simdoc/pkg/util/watermark"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+var (
+	ErrorImportDisabled = errors.New("Import through the API is disabled")
+	ErrorImportRunning  = errors.New("An import with the same journal is running")
+)
+
+const (
+	importRunning = "running"
+	importDone    = "done"
+
+	// Lifetime of finished imports in the list of imports.
+	importJobTTL = 24 * time.Hour
+)
+
+// importJob represents an import run in background.
+type importJob struct {
+	ID       string           `json:"id"`
+	Status   string           `json:"status"`
+	Source   string           `json:"source"`
+	Report   *importer.Report `json:"report,omitempty"`
+	Started  int64            `json:"started_at"`
+	Finished int64            `json:"finished_at,omitempty"`
+
+	journal string
+}
+
+// importJobs are the imports run by this instance, keyed by ID.
+var importJobs = struct {
+	sync.Mutex
+	m map[string]*importJob
+}{m: make(map[string]*importJob)}
+
+// ImportDocuments accepts a request to import documents from a directory or ZIP
+// archive on the server, optionally described by a CSV or JSON manifest. The
+// source, manifest and journal are paths relative to the import root of the
+// server. Files already recorded in the journal are skipped, so the request
+// can be repeated to resume an interrupted import. Documents without owner in
+// the manifest are owned by current user.
+//
+// The import runs in background: the response is the import, whose report is
+// retrieved with GetImport once it's done.
+//
+// POST /api/import
+//
+func ImportDocuments(c web.C, w http.ResponseWriter, r *http.Request) {
+	root, _ := c.Env["importRoot"].(string)
+	if root == "" {
+		respWithError(w, http.StatusForbidden, ErrorImportDisabled)
+		return
+	}
+
+	var req = new(struct {
+		Source   string `json:"source"`
+		Manifest string `json:"manifest"`
+		Journal  string `json:"journal"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	if req.Source == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("import", "source", ErrorFieldMissing))
+		return
+	}
+
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return
+	}
+
+	source, err := importPath(root, req.Source)
+	if err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("import", "source", ErrorFieldInvalid))
+		return
+	}
+	manifest, err := importPath(root, req.Manifest)
+	if err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("import", "manifest", ErrorFieldInvalid))
+		return
+	}
+	journal, err := importPath(root, req.Journal)
+	if err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("import", "journal", ErrorFieldInvalid))
+		return
+	}
+
+	src, err := importer.OpenSource(source)
+	if err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("import", "source", ErrorFieldInvalid))
+		return
+	}
+
+	var entries []*importer.Entry
+	if manifest != "" {
+		entries, err = importer.LoadManifest(manifest)
+	} else {
+		entries, err = importer.EntriesFromSource(src)
+	}
+	if err != nil {
+		src.Close()
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("import", "manifest", ErrorFieldInvalid))
+		return
+	}
+
+	job := &importJob{
+		ID:      uuid.New(),
+		Status:  importRunning,
+		Source:  req.Source,
+		Started: time.Now().Unix(),
+		journal: journal,
+	}
+	if !startImportJob(job) {
+		src.Close()
+		respWithError(w, http.StatusConflict, ErrorImportRunning)
+		return
+	}
+
+	j, err := importer.OpenJournal(journal)
+	if err != nil {
+		src.Close()
+		finishImportJob(job, nil)
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("import", "journal", ErrorFieldInvalid))
+		return
+	}
+
+	pl, ok := c.Env["pipeline"].(*processor.Pipeline)
+	if !ok || pl == nil {
+		pl = processor.DefaultPipeline
+	}
+	fsRoot := c.Env["fsRoot"].(string)
+	prefix := c.Env["filesPrefix"].(string)
+
+	ds := datastore.FromContext(context.FromC(c))
+	im := importer.New(ds, pl, fsRoot, prefix, usr, j)
+	if wm, ok := c.Env["watermark"].(*watermark.Watermark); ok {
+		im.SetWatermark(wm)
+	}
+
+	go func() {
+		defer src.Close()
+		defer j.Close()
+
+		rep := im.Run(src, entries)
+		finishImportJob(job, rep)
+
+		addAuditEvent(c, r, &model.AuditEvent{
+			Action:  model.AuditDocumentsImported,
+			Target:  "import:" + req.Source,
+			Details: fmt.Sprintf("%d imported, %d skipped, %d failed", rep.Imported, rep.Skipped, rep.Failed),
+		})
+	}()
+
+	w.Header().Set("Location", "/api/import/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(getImportJob(job.ID))
+}
+
+// GetImport accepts a request to retrieve an import, for given import ID
+// importId, along with its report once it's done.
+//
+// GET /api/import/:importId
+//
+func GetImport(c web.C, w http.ResponseWriter, r *http.Request) {
+	job := getImportJob(c.URLParams["importId"])
+	if job == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(job)
+}
+
+// importPath returns the path of p relative to the import root, or an error if
+// it leads out of root, including through symbolic links. Empty p stays empty.
+func importPath(root, p string) (string, error) {
+	if p == "" {
+		return "", nil
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	fp := filepath.Join(realRoot, filepath.Clean("/"+p))
+
+	// Files such as journals may not exist yet, their directory must.
+	real, err := filepath.EvalSymlinks(fp)
+	if os.IsNotExist(err) {
+		var dir string
+		dir, err = filepath.EvalSymlinks(filepath.Dir(fp))
+		real = filepath.Join(dir, filepath.Base(fp))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if real != realRoot && !strings.HasPrefix(real, realRoot+string(filepath.Separator)) {
+		return "", ErrorForbidden
+	}
+	return real, nil
+}
+
+// startImportJob adds job to the running imports, unless an import with the
+// same journal is running, as both would import the same files.
+func startImportJob(job *importJob) bool {
+	importJobs.Lock()
+	defer importJobs.Unlock()
+
+	var now = time.Now().Unix()
+	for id, j := range importJobs.m {
+		if j.Status == importRunning && job.journal != "" && j.journal == job.journal {
+			return false
+		}
+		if j.Status == importDone && now-j.Finished > int64(importJobTTL/time.Second) {
+			delete(importJobs.m, id)
+		}
+	}
+
+	importJobs.m[job.ID] = job
+	return true
+}
+
+// finishImportJob records report rep of job. Failed jobs, without report, are
+// removed.
+func finishImportJob(job *importJob, rep *importer.Report) {
+	importJobs.Lock()
+	defer importJobs.Unlock()
+
+	if rep == nil {
+		delete(importJobs.m, job.ID)
+		return
+	}
+
+	job.Status = importDone
+	job.Report = rep
+	job.Finished = time.Now().Unix()
+	log.Printf("import %s: %d imported, %d skipped, %d failed\n", job.ID, rep.Imported, rep.Skipped, rep.Failed)
+}
+
+// getImportJob returns a copy of job importId, nil if there is none.
+func getImportJob(id string) *importJob {
+	importJobs.Lock()
+	defer importJobs.Unlock()
+
+	job, ok := importJobs.m[id]
+	if !ok {
+		return nil
+	}
+	var out = *job
+	return &out
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+
+	os.Mkdir(filepath.Join(root, "share"), 0755)
+	os.Symlink("/etc", filepath.Join(root, "etc"))
+
+	tests := []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{"", "", false},
+		{"share", filepath.Join(root, "share"), false},
+		{"/share/new.journal", filepath.Join(root, "share", "new.journal"), false},
+		{"../../etc/passwd", filepath.Join(root, "etc", "passwd"), true},
+		{"etc/passwd", "", true},
+		{"missing/dir/file", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := importPath(root, tt.path)
+		if tt.err {
+			if err == nil {
+				t.Errorf("importPath(%q) = %q, want error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("importPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gedex/simdoc/pkg/util"
+)
+
+type jwksServer struct {
+	keys *util.KeySet
+}
+
+// NewJWKSServer returns a handler that publishes public keys of keys as JSON
+// Web Key Set, so that other services can verify tokens issued by this one.
+//
+// GET /.well-known/jwks.json
+//
+func NewJWKSServer(keys *util.KeySet) *jwksServer {
+	return &jwksServer{keys}
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(s.keys.JWKS())
+}
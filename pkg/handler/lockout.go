@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/lockout"
+
+	"github.com/zenazn/goji/web"
+)
+
+// loginAttempt represents lockout keys of a login attempt, both throttled.
+type loginAttempt struct {
+	Account string
+	IP      string
+
+	limits []*attemptLimit // Set when the attempt is counted
+}
+
+// attemptLimit represents a key of login attempt counted by its limiter.
+type attemptLimit struct {
+	limiter *lockout.Limiter
+	key     string
+	action  string           // Audit action of lockout of the key
+	counter *lockout.Counter // Counter of the key, once the attempt is counted
+	locked  bool             // Whether counting the attempt locked the key out
+}
+
+// newLoginAttempt returns lockout keys of an attempt to log in as usr, or as
+// login if the user is unknown, so that unknown logins are throttled the same.
+func newLoginAttempt(r *http.Request, login string, usr *model.User) *loginAttempt {
+	var account = "login:" + strings.ToLower(login)
+	if usr != nil {
+		account = accountLockoutKey(usr)
+	}
+
+	return &loginAttempt{Account: account, IP: "ip:" + util.RemoteIP(r)}
+}
+
+// accountLockoutKey returns lockout key of usr.
+func accountLockoutKey(usr *model.User) string {
+	return "user:" + strconv.FormatInt(usr.ID, 10)
+}
+
+// UnlockUser accepts a request to unlock an account, for the given login or
+// email, locked out after failed logins.
+//
+// POST /api/admin/users/:login/unlock
+//
+func UnlockUser(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = getManagedUser(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+
+	var limiter = c.Env["accountLimiter"].(*lockout.Limiter)
+	if err := limiter.Reset(accountLockoutKey(usr)); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	var actorId int64
+	if admin := ToUser(c); admin != nil {
+		actorId = admin.ID
+	}
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditUserUnlocked,
+		ActorID: actorId,
+		Target:  accountLockoutKey(usr),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkLoginAttempt checks whether attempt is allowed and counts it as failed
+// before credentials are verified, so that concurrent attempts can't guess
+// more than the policy allows. The attempt must then be settled with either
+// failLoginAttempt, resetLoginAttempt or cancelLoginAttempt. If it isn't
+// allowed, it responds with too many requests, along with when to retry, and
+// returns false.
+func checkLoginAttempt(c web.C, w http.ResponseWriter, attempt *loginAttempt) bool {
+	attempt.limits = []*attemptLimit{
+		{limiter: c.Env["accountLimiter"].(*lockout.Limiter), key: attempt.Account, action: model.AuditUserLocked},
+		{limiter: c.Env["ipLimiter"].(*lockout.Limiter), key: attempt.IP, action: model.AuditIPLocked},
+	}
+
+	var wait time.Duration
+	for _, l := range attempt.limits {
+		counter, d, locked, err := l.limiter.Attempt(l.key)
+		if err != nil {
+			log.Printf("%+v\n", err)
+			continue
+		}
+		if d > wait {
+			wait = d
+		}
+		if d <= 0 {
+			l.counter, l.locked = counter, locked
+		}
+	}
+
+	if wait <= 0 {
+		return true
+	}
+
+	// Refused attempt is not counted by any key.
+	cancelLoginAttempt(attempt)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+	respWithError(w, http.StatusTooManyRequests, ErrorTooManyRequests)
+	return false
+}
+
+// failLoginAttempt records failed attempt in the audit log, along with the
+// lockouts it causes.
+func failLoginAttempt(c web.C, r *http.Request, attempt *loginAttempt) {
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action: model.AuditUserLoginFailed,
+		Target: attempt.Account,
+	})
+
+	for _, l := range attempt.limits {
+		if !l.locked {
+			continue
+		}
+		addAuditEvent(c, r, &model.AuditEvent{
+			Action:  l.action,
+			Target:  l.key,
+			Details: fmt.Sprintf("Locked out after %d failed logins until %s", l.counter.Failures, l.counter.LockedUntil.UTC().Format(time.RFC3339)),
+		})
+	}
+}
+
+// resetLoginAttempt forgets failed attempts of the account after a successful
+// login. Attempts of the IP are kept, as it may be trying many accounts, but
+// the successful one is uncounted.
+func resetLoginAttempt(attempt *loginAttempt) {
+	for _, l := range attempt.limits {
+		if l.counter == nil {
+			continue
+		}
+		var err error
+		if l.key == attempt.Account {
+			err = l.limiter.Reset(l.key)
+		} else {
+			err = l.limiter.Cancel(l.key)
+		}
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+}
+
+// cancelLoginAttempt uncounts attempt, refused or whose credentials weren't
+// wrong, for instance when a second factor is still required.
+func cancelLoginAttempt(attempt *loginAttempt) {
+	for _, l := range attempt.limits {
+		if l.counter == nil {
+			continue
+		}
+		if err := l.limiter.Cancel(l.key); err != nil {
+			log.Printf("%+v\n", err)
+		}
+		l.counter = nil
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/lockout"
+
+	"github.com/zenazn/goji/web"
+)
+
+var testLockoutPolicy = &lockout.Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Minute,
+	MaxDelay:        time.Hour,
+	LockoutAfter:    5,
+	LockoutDuration: time.Hour,
+	Window:          time.Hour,
+}
+
+// newLockoutTestC returns context of login requests with ds as datastore, and
+// alice, whose password is "secret", and bob, who has two-factor authentication
+// too.
+func newLockoutTestC(t *testing.T) (web.C, *fakeTokenstore) {
+	ds := &fakeTokenstore{}
+	c := newSessionTestC(t, ds)
+	c.Env["accountLimiter"] = lockout.New(lockout.NewMemoryStore(), testLockoutPolicy)
+	c.Env["ipLimiter"] = lockout.New(lockout.NewMemoryStore(), testLockoutPolicy)
+
+	hash, err := hashPassword(c, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.users = []*model.User{
+		{ID: 1, Login: "alice", Password: hash, Verified: true},
+		{ID: 2, Login: "bob", Password: hash, Verified: true, TOTPEnabled: true},
+	}
+	return c, ds
+}
+
+// login logs in as login with password pass, and returns the response status.
+func login(c web.C, login, pass string) int {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/user/login", strings.NewReader(`{"login":"`+login+`","password":"`+pass+`"}`))
+	UserLogin(c, w, r)
+	return w.Code
+}
+
+// failures returns failed attempts counted by limiter for key.
+func failures(t *testing.T, c web.C, limiter, key string) int {
+	l := c.Env[limiter].(*lockout.Limiter)
+	counter, _, err := l.Fail(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Cancel(key)
+	return counter.Failures - 1
+}
+
+func TestUserLoginAttempts(t *testing.T) {
+	c, _ := newLockoutTestC(t)
+	ip := "ip:" + util.RemoteIP(httptest.NewRequest("POST", "/api/user/login", nil))
+
+	for _, pass := range []string{"wrong", "wrong"} {
+		if code := login(c, "alice", pass); code != http.StatusBadRequest {
+			t.Fatalf("wrong password: status %d", code)
+		}
+	}
+	if code := login(c, "alice", "secret"); code != http.StatusOK {
+		t.Fatalf("right password: status %d", code)
+	}
+
+	// Successful login resets the account, but not failures of the IP.
+	if n := failures(t, c, "accountLimiter", "user:1"); n != 0 {
+		t.Errorf("account failures %d, want 0", n)
+	}
+	if n := failures(t, c, "ipLimiter", ip); n != 2 {
+		t.Errorf("IP failures %d, want 2", n)
+	}
+
+	// Right password of account with two-factor authentication leaves failures
+	// of the account until the second step.
+	c, _ = newLockoutTestC(t)
+	login(c, "bob", "wrong")
+	if code := login(c, "bob", "secret"); code != http.StatusOK {
+		t.Fatalf("two-factor challenge: status %d", code)
+	}
+	if n := failures(t, c, "accountLimiter", "user:2"); n != 1 {
+		t.Errorf("account failures after challenge %d, want 1", n)
+	}
+}
+
+func TestUserLoginAttemptsConcurrent(t *testing.T) {
+	c, _ := newLockoutTestC(t)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code := login(c, "alice", "wrong")
+			mu.Lock()
+			codes[code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Attempts are counted before passwords are verified, so that concurrent
+	// ones can't guess beyond free attempts.
+	if want := testLockoutPolicy.FreeAttempts + 1; codes[http.StatusBadRequest] != want {
+		t.Errorf("%d passwords verified, want %d", codes[http.StatusBadRequest], want)
+	}
+	if codes[http.StatusTooManyRequests] != 10-testLockoutPolicy.FreeAttempts-1 {
+		t.Errorf("responses %v", codes)
+	}
+
+	// Right password is refused while delayed.
+	if code := login(c, "alice", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("right password while delayed: status %d", code)
+	}
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/auth"
+	"github.com/gedex/simdoc/pkg/auth/oidc"
+	"github.com/gedex/simdoc/pkg/util"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// Cookie binding an OpenID Connect login to the user agent.
+	oidcCookieName = "simdoc_oidc"
+
+	// Time allowed to log in at the issuer.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+// OIDCLogin accepts a request to log in with the OpenID Connect issuer. The user
+// agent is redirected to the issuer, which redirects back to OIDCCallback.
+//
+// GET /api/user/login/oidc
+//
+func OIDCLogin(c web.C, w http.ResponseWriter, r *http.Request) {
+	var p = getOIDCProvider(c)
+	if p == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	var values = map[string]string{
+		"state":    oidc.RandomValue(),
+		"nonce":    oidc.RandomValue(),
+		"verifier": oidc.RandomValue(),
+	}
+
+	u, err := p.AuthCodeURL(values["state"], values["nonce"], values["verifier"])
+	if err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	token, err := util.GenerateStateToken(context.FromC(c), values, oidcLoginTTL)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    token,
+		Path:     "/api/user/login/oidc",
+		MaxAge:   int(oidcLoginTTL / time.Second),
+		Secure:   strings.HasPrefix(c.Env["baseURL"].(string), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// OIDCCallback accepts the redirect from the OpenID Connect issuer. The code is
+// exchanged for an ID token, whose user is provisioned on first login, and a
+// session is issued the same way as with UserLogin.
+//
+// GET /api/user/login/oidc/callback?code=...&state=...
+//
+func OIDCCallback(c web.C, w http.ResponseWriter, r *http.Request) {
+	var p = getOIDCProvider(c)
+	if p == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	// The login can only be completed once.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Path:     "/api/user/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	if e := r.FormValue("error"); e != "" {
+		log.Printf("oidc: %s: %s\n", e, r.FormValue("error_description"))
+		respWithError(w, http.StatusBadRequest, ErrorBadCredentials)
+		return
+	}
+
+	var code, state = r.FormValue("code"), r.FormValue("state")
+	if code == "" || state == "" {
+		respWithError(w, http.StatusBadRequest, ErrorBadCredentials)
+		return
+	}
+
+	var values map[string]string
+	if cookie, err := r.Cookie(oidcCookieName); err == nil {
+		values, _ = util.ParseStateToken(context.FromC(c), cookie.Value)
+	}
+	if values == nil || subtle.ConstantTimeCompare([]byte(values["state"]), []byte(state)) != 1 {
+		respWithError(w, http.StatusBadRequest, ErrorBadCredentials)
+		return
+	}
+
+	id, err := p.Exchange(code, values["verifier"], values["nonce"])
+	switch err {
+	case nil:
+	case auth.ErrorUnknownUser, oidc.ErrorUnverifiedEmail:
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	case oidc.ErrorInvalidGrant, oidc.ErrorInvalidIDToken:
+		respWithError(w, http.StatusBadRequest, ErrorBadCredentials)
+		return
+	default:
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	usr, err := provisionUser(c, p.Name(), id)
+	switch {
+	case err == ErrorAccountConflict:
+		respWithError(w, http.StatusConflict, ErrorAccountConflict)
+		return
+	case err != nil:
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if usr.Deactivated {
+		respWithError(w, http.StatusForbidden, ErrorAccountDeactivated)
+		return
+	}
+
+	if usr.TOTPEnabled {
+		respWithTwoFactorChallenge(c, w, usr)
+		return
+	}
+
+	respWithSession(c, w, r, usr)
+}
+
+// getOIDCProvider returns the OpenID Connect provider, or nil if disabled.
+func getOIDCProvider(c web.C) *oidc.Provider {
+	p, _ := c.Env["oidcProvider"].(*oidc.Provider)
+	return p
+}
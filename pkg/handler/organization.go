@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// organizationUsage represents an organization along with its usage of the
+// quotas.
+type organizationUsage struct {
+	*model.Organization
+	Users int64 `json:"users"`
+	Bytes int64 `json:"bytes"`
+}
+
+// GetAllOrganizations accepts a request to retrieve all organizations from the
+// datastore and returns in JSON format.
+//
+// GET /api/admin/organizations
+//
+func GetAllOrganizations(c web.C, w http.ResponseWriter, r *http.Request) {
+	orgs, err := datastore.GetAllOrganizations(context.FromC(c))
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if orgs == nil {
+		w.Write([]byte(`[]`))
+	} else {
+		json.NewEncoder(w).Encode(orgs)
+	}
+}
+
+// GetOrganization accepts a request to retrieve an organization, for the given
+// orgId, along with its usage, and returns in JSON format.
+//
+// GET /api/admin/organizations/:orgId
+//
+func GetOrganization(c web.C, w http.ResponseWriter, r *http.Request) {
+	var org = getOrganization(c, w)
+	if org == nil {
+		return
+	}
+
+	respWithOrganizationUsage(c, w, org)
+}
+
+// AddOrganization accepts a request to add an organization into the datastore.
+// Users are then added to it by global admins, or by its org admins.
+//
+// POST /api/admin/organizations
+//
+func AddOrganization(c web.C, w http.ResponseWriter, r *http.Request) {
+	var org = new(model.Organization)
+	if err := json.NewDecoder(r.Body).Decode(org); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	org.ID = 0
+	org.Created = 0
+	if org.Settings == nil {
+		org.Settings = new(model.OrganizationSettings)
+	}
+
+	if !validateOrganization(c, w, org) {
+		return
+	}
+
+	if err := datastore.AddOrganization(context.FromC(c), org); err != nil {
+		if isDuplicateEntryError(err) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("organizations", "slug", ErrorFieldAlreadyExists))
+		} else {
+			log.Printf("%+v\n", err)
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		}
+		return
+	}
+
+	addOrganizationAuditEvent(c, r, model.AuditOrganizationCreated, org, model.AuditChanges(struct{}{}, org))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(org)
+}
+
+// UpdateOrganization accepts a request to update name, quotas and settings of
+// an organization, for the given orgId. Slug can't be changed.
+//
+// PATCH /api/admin/organizations/:orgId
+// PUT   /api/admin/organizations/:orgId
+//
+func UpdateOrganization(c web.C, w http.ResponseWriter, r *http.Request) {
+	var org = getOrganization(c, w)
+	if org == nil {
+		return
+	}
+
+	var req = new(struct {
+		Slug       string                      `json:"slug"`
+		Name       *string                     `json:"name"`
+		QuotaUsers *int64                      `json:"quota_users"`
+		QuotaBytes *int64                      `json:"quota_bytes"`
+		Settings   *model.OrganizationSettings `json:"settings"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	// Storage paths don't depend on the slug, but scripts may.
+	if req.Slug != "" && req.Slug != org.Slug {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("organizations", "slug", ErrorFieldImmutable))
+		return
+	}
+
+	var before = *org
+	if req.Name != nil {
+		org.Name = *req.Name
+	}
+	if req.QuotaUsers != nil {
+		org.QuotaUsers = *req.QuotaUsers
+	}
+	if req.QuotaBytes != nil {
+		org.QuotaBytes = *req.QuotaBytes
+	}
+	if req.Settings != nil {
+		org.Settings = req.Settings
+	}
+
+	if !validateOrganization(c, w, org) {
+		return
+	}
+
+	if err := datastore.UpdateOrganization(context.FromC(c), org); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addOrganizationAuditEvent(c, r, model.AuditOrganizationUpdated, org, model.AuditChanges(&before, org))
+
+	json.NewEncoder(w).Encode(org)
+}
+
+// DeleteOrganization accepts a request to delete an organization, for the given
+// orgId, along with its groups and documents. Organizations with users can't be
+// deleted. Stored files are left to be removed by the operator.
+//
+// DELETE /api/admin/organizations/:orgId
+//
+func DeleteOrganization(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+
+	var org = getOrganization(c, w)
+	if org == nil {
+		return
+	}
+
+	count, err := datastore.FromContext(ctx).ForOrganization(org.ID).CountUsers()
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if count > 0 {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("organizations", "users", ErrorFieldInvalid))
+		return
+	}
+
+	if err := datastore.DeleteOrganization(ctx, org.ID); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addOrganizationAuditEvent(c, r, model.AuditOrganizationDeleted, org, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCurrentOrganization accepts a request to retrieve the organization of
+// current user, along with its usage, and returns in JSON format.
+//
+// GET /api/organization
+//
+func GetCurrentOrganization(c web.C, w http.ResponseWriter, r *http.Request) {
+	var org = ToOrganization(c)
+	if org == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	respWithOrganizationUsage(c, w, org)
+}
+
+// UpdateCurrentOrganization accepts a request to update settings of the
+// organization of current user. Name and quotas are managed by global admins.
+//
+// PATCH /api/organization
+// PUT   /api/organization
+//
+func UpdateCurrentOrganization(c web.C, w http.ResponseWriter, r *http.Request) {
+	var org = ToOrganization(c)
+	if org == nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	var req = new(struct {
+		Settings *model.OrganizationSettings `json:"settings"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	var before = *org
+	if req.Settings != nil {
+		org.Settings = req.Settings
+	}
+
+	if !validateOrganization(c, w, org) {
+		return
+	}
+
+	if err := datastore.UpdateOrganization(context.FromC(c), org); err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addOrganizationAuditEvent(c, r, model.AuditOrganizationUpdated, org, model.AuditChanges(&before, org))
+
+	json.NewEncoder(w).Encode(org)
+}
+
+// getOrganization returns the organization for the orgId URL param. If it
+// doesn't exist, a not found response is given and nil is returned.
+func getOrganization(c web.C, w http.ResponseWriter) *model.Organization {
+	orgId, _ := strconv.ParseInt(c.URLParams["orgId"], 10, 64)
+
+	org, err := datastore.GetOrganizationById(context.FromC(c), orgId)
+	switch {
+	case err == sql.ErrNoRows:
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return nil
+	case err != nil:
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return nil
+	}
+	return org
+}
+
+// validateOrganization validates org, giving a bad request response if it's
+// invalid.
+func validateOrganization(c web.C, w http.ResponseWriter, org *model.Organization) bool {
+	if ve := model.Validate(org); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("organizations", ve)...)
+		return false
+	}
+	if org.QuotaUsers < 0 {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("organizations", "quota_users", ErrorFieldInvalid))
+		return false
+	}
+	if org.QuotaBytes < 0 {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("organizations", "quota_bytes", ErrorFieldInvalid))
+		return false
+	}
+	if org.Settings != nil && org.Settings.DefaultRole != "" && !canAssignRole(c, org.Settings.DefaultRole) {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("organizations", "default_role", ErrorFieldInvalid))
+		return false
+	}
+	return true
+}
+
+// respWithOrganizationUsage responds with org along with its usage.
+func respWithOrganizationUsage(c web.C, w http.ResponseWriter, org *model.Organization) {
+	var ds = datastore.FromContext(context.FromC(c)).ForOrganization(org.ID)
+
+	users, err := ds.CountUsers()
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	bytes, err := ds.GetOrganizationUsage(org.ID)
+	if err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&organizationUsage{org, users, bytes})
+}
+
+// addOrganizationAuditEvent records action of current user on org in the audit
+// log.
+func addOrganizationAuditEvent(c web.C, r *http.Request, action string, org *model.Organization, changes map[string]*model.AuditChange) {
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  action,
+		OrgID:   org.ID,
+		Target:  "organization:" + strconv.FormatInt(org.ID, 10),
+		Details: org.Slug,
+		Changes: changes,
+	})
+}
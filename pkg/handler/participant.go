@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// GetDocumentParticipants accepts a request to retrieve all participants, users
+// and groups, of a document from the datastore and returns in JSON format.
+//
+// GET /api/documents/:docId/participants
+//
+func GetDocumentParticipants(c web.C, w http.ResponseWriter, r *http.Request) {
+	// @todo remove me once DocumentToContextInjector is being used.
+	if ok := docToContext(&c, w); !ok {
+		return
+	}
+
+	var doc = ToDocument(c)
+
+	participants, err := datastore.GetAllDocumentParticipants(context.FromC(c), doc.ID)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if participants == nil {
+		w.Write([]byte(`[]`))
+	} else {
+		json.NewEncoder(w).Encode(participants)
+	}
+}
+
+// AddDocumentParticipant accepts a request to share a document with a user, for
+// the given login or email, or with a group, for the given name, with a role,
+// either viewer or editor. Sharing again with the same user or group updates
+// the role. Documents are only shared within their organization.
+//
+// POST /api/documents/:docId/participants
+//
+func AddDocumentParticipant(c web.C, w http.ResponseWriter, r *http.Request) {
+	// @todo remove me once DocumentToContextInjector is being used.
+	if ok := docToContext(&c, w); !ok {
+		return
+	}
+
+	var doc = ToDocument(c)
+	if !canShareDocument(c, doc) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	var req = new(struct {
+		User  string `json:"user"`
+		Group string `json:"group"`
+		Role  string `json:"role"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	var ctx = context.FromC(c)
+	var ds = datastore.FromContext(ctx).ForOrganization(doc.OrgID)
+
+	var p = &model.DocumentParticipant{DocumentID: doc.ID, Role: req.Role}
+	if p.Role == "" {
+		p.Role = model.DefaultParticipantRole
+	}
+
+	var target string
+	switch {
+	case req.User != "" && req.Group == "":
+		usr, err := ds.GetUserByLogin(req.User)
+		if err != nil {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("document_participants", "user", ErrorFieldInvalid))
+			return
+		}
+		p.UserID = usr.ID
+		target = "user:" + strconv.FormatInt(usr.ID, 10)
+	case req.Group != "" && req.User == "":
+		group, err := ds.GetGroupByName(req.Group)
+		if err != nil {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("document_participants", "group", ErrorFieldInvalid))
+			return
+		}
+		p.GroupID = group.ID
+		target = "group:" + strconv.FormatInt(group.ID, 10)
+	default:
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("document_participants", "user or group", ErrorFieldMissing))
+		return
+	}
+
+	if ve := model.Validate(p); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("document_participants", ve)...)
+		return
+	}
+
+	added, err := datastore.AddDocumentParticipant(ctx, p)
+	if err != nil {
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	var status = http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+
+	addDocumentAuditEvent(c, r, model.AuditDocumentShared, doc, target, "Role "+p.Role)
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// DeleteDocumentParticipant accepts a request to remove a participant, for the
+// given participantId, from a document.
+//
+// DELETE /api/documents/:docId/participants/:participantId
+//
+func DeleteDocumentParticipant(c web.C, w http.ResponseWriter, r *http.Request) {
+	// @todo remove me once DocumentToContextInjector is being used.
+	if ok := docToContext(&c, w); !ok {
+		return
+	}
+
+	var doc = ToDocument(c)
+	if !canShareDocument(c, doc) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	participantId, _ := strconv.ParseInt(c.URLParams["participantId"], 10, 64)
+	if err := datastore.DeleteDocumentParticipant(context.FromC(c), doc.ID, participantId); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addDocumentAuditEvent(c, r, model.AuditDocumentUnshared, doc, "", "Participant "+c.URLParams["participantId"])
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// canShareDocument checks whether current user can manage participants of doc.
+// Only the creator and users allowed to update any document can.
+func canShareDocument(c web.C, doc *model.Document) bool {
+	var usr = ToUser(c)
+	if usr == nil {
+		return false
+	}
+	return doc.CreatedBy == usr.ID || can(c, model.PermDocumentsUpdateAny)
+}
@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/mail"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// Minimum length of password.
+	passwordMinLength = 6
+
+	// Lifetime of password reset token.
+	passwordResetTTL = time.Hour
+)
+
+// ChangePassword accepts a request to change password of current user. The
+// current password is required. Other sessions are ended, and new tokens are
+// returned for the current one.
+//
+// POST /api/user/password
+//
+func ChangePassword(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return
+	}
+
+	// Password of external users is managed by their provider.
+	if !usr.IsLocal() {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	var req = new(struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	if req.CurrentPassword == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "current_password", ErrorFieldMissing))
+		return
+	}
+	if fe := validatePassword(req.Password); fe != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, fe)
+		return
+	}
+
+	if ok, err := verifyPassword(c, usr, req.CurrentPassword); err != nil || !ok {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "current_password", ErrorFieldInvalid))
+		return
+	}
+
+	if err := setPassword(c, usr, req.Password); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addUserAuditEvent(c, r, model.AuditPasswordChanged, usr, "")
+
+	tokens, err := issueSession(c, r, usr)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// ForgotPassword accepts a request to send password reset token to the email
+// of the user for the given login or email. The response is the same whether
+// the user exists or not, and is given before the user is looked up so that
+// its timing doesn't tell either.
+//
+// POST /api/user/password/forgot
+//
+func ForgotPassword(c web.C, w http.ResponseWriter, r *http.Request) {
+	var req = new(struct {
+		Login string `json:"login"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	if req.Login == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "login", ErrorFieldMissing))
+		return
+	}
+
+	go func() {
+		usr, err := datastore.GetUserByLogin(context.FromC(c), req.Login)
+		if err == nil && usr != nil && !usr.Deactivated && usr.IsLocal() {
+			if err := sendPasswordReset(c, usr); err != nil {
+				log.Printf("%+v\n", err)
+			}
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword accepts a request to set new password with the token sent by
+// ForgotPassword. The token can only be used once.
+//
+// POST /api/user/password/reset
+//
+func ResetPassword(c web.C, w http.ResponseWriter, r *http.Request) {
+	var req = new(struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	if req.Token == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "token", ErrorFieldMissing))
+		return
+	}
+	if fe := validatePassword(req.Password); fe != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, fe)
+		return
+	}
+
+	usr, ok := useUserToken(c, model.TokenPasswordReset, req.Token)
+	if !ok || !usr.IsLocal() {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "token", ErrorFieldInvalid))
+		return
+	}
+
+	// The token was sent to the email, so it's verified too.
+	usr.Verified = true
+
+	if err := setPassword(c, usr, req.Password); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditPasswordReset,
+		ActorID: usr.ID,
+		OrgID:   usr.OrgID,
+		Target:  accountLockoutKey(usr),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validatePassword returns field error if pass is not acceptable as password.
+func validatePassword(pass string) *fieldError {
+	if pass == "" {
+		return newFieldError("user", "password", ErrorFieldMissing)
+	}
+	if len(pass) < passwordMinLength {
+		return newFieldError("user", "password", ErrorFieldInvalid)
+	}
+	return nil
+}
+
+// setPassword hashes and stores new password pass of usr. All sessions of usr
+// are revoked.
+func setPassword(c web.C, usr *model.User, pass string) error {
+	h, err := hashPassword(c, pass)
+	if err != nil {
+		return err
+	}
+	usr.Password = h
+
+	return revokeSessions(c, usr)
+}
+
+// sendPasswordReset issues password reset token to usr, replacing previous
+// ones, and sends it to usr email.
+func sendPasswordReset(c web.C, usr *model.User) error {
+	token, err := issueUserToken(c, usr, model.TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	var baseURL = c.Env["baseURL"].(string)
+
+	return sendMail(c, &mail.Message{
+		To:      []string{usr.Email},
+		Subject: "Reset your SIMDOC password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone requested to reset the password of your account. If it was you, open following link within %s:\n\n%s/reset-password?token=%s\n\nOtherwise you can ignore this email.\n",
+			usr.Login, passwordResetTTL, baseURL, token,
+		),
+	})
+}
+
+// issueUserToken issues token of the given kind to usr, replacing previous
+// tokens of that kind.
+func issueUserToken(c web.C, usr *model.User, kind string, ttl time.Duration) (string, error) {
+	if err := datastore.DeleteUserTokens(context.FromC(c), usr.ID, kind); err != nil {
+		return "", err
+	}
+
+	return newUserToken(c, usr, kind, ttl)
+}
+
+// newUserToken issues token of the given kind to usr. Only the hash of the
+// token is stored.
+func newUserToken(c web.C, usr *model.User, kind string, ttl time.Duration) (string, error) {
+	var ctx = context.FromC(c)
+
+	token, err := util.GetRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	t := &model.UserToken{
+		UserID:  usr.ID,
+		Kind:    kind,
+		Hash:    util.HashToken(token),
+		Expires: time.Now().UTC().Add(ttl).Unix(),
+	}
+	if err := datastore.AddUserToken(ctx, t); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// useUserToken returns user of token of the given kind and deletes the token.
+// It returns false if the token is unknown or expired, or the user is
+// deactivated.
+func useUserToken(c web.C, kind, token string) (*model.User, bool) {
+	var ctx = context.FromC(c)
+
+	t, err := datastore.GetUserTokenByHash(ctx, kind, util.HashToken(token))
+	if err != nil || t == nil {
+		return nil, false
+	}
+
+	// Token is single-use, even when it has expired. Only the request that
+	// deletes it can use it.
+	if ok, err := datastore.ConsumeUserToken(ctx, t.ID); err != nil || !ok {
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+		return nil, false
+	}
+	if time.Now().UTC().Unix() > t.Expires {
+		return nil, false
+	}
+
+	usr, err := datastore.GetUserById(ctx, t.UserID)
+	if err != nil || usr == nil || usr.Deactivated {
+		return nil, false
+	}
+
+	return usr, true
+}
+
+// sendMail sends message m with the configured mail sender.
+func sendMail(c web.C, m *mail.Message) error {
+	var sender = c.Env["mailer"].(mail.Sender)
+
+	return sender.Send(m)
+}
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/mail"
+)
+
+// fakeTokenstore is an in-memory datastore of users and their tokens, safe for
+// concurrent use. Other methods of the datastore are not implemented.
+type fakeTokenstore struct {
+	datastore.Datastore
+
+	mu     sync.Mutex
+	users  []*model.User
+	tokens []*model.UserToken
+	events []*model.AuditEvent
+}
+
+func (s *fakeTokenstore) GetUserById(id int64) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.ID == id {
+			cu := *u
+			return &cu, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeTokenstore) GetUserByLogin(loginOrEmail string) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Login == loginOrEmail || u.Email == loginOrEmail {
+			cu := *u
+			return &cu, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeTokenstore) UpdateUser(usr *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, u := range s.users {
+		if u.ID == usr.ID {
+			cu := *usr
+			s.users[i] = &cu
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *fakeTokenstore) GetUserTokenByHash(kind, hash string) (*model.UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tokens {
+		if t.Kind == kind && t.Hash == hash {
+			ct := *t
+			return &ct, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeTokenstore) AddUserToken(t *model.UserToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.ID = int64(len(s.tokens) + 1000)
+	ct := *t
+	s.tokens = append(s.tokens, &ct)
+	return nil
+}
+
+func (s *fakeTokenstore) DeleteUserToken(id int64) error {
+	_, err := s.ConsumeUserToken(id)
+	return err
+}
+
+func (s *fakeTokenstore) ConsumeUserToken(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, t := range s.tokens {
+		if t.ID == id {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeTokenstore) DeleteUserTokens(userId int64, kind string) error {
+	s.deleteTokens(func(t *model.UserToken) bool { return t.UserID == userId && t.Kind == kind })
+	return nil
+}
+
+func (s *fakeTokenstore) DeleteAllUserTokens(userId int64) error {
+	s.deleteTokens(func(t *model.UserToken) bool { return t.UserID == userId })
+	return nil
+}
+
+func (s *fakeTokenstore) deleteTokens(match func(t *model.UserToken) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.tokens[:0]
+	for _, t := range s.tokens {
+		if !match(t) {
+			kept = append(kept, t)
+		}
+	}
+	s.tokens = kept
+}
+
+func (s *fakeTokenstore) AddAuditEvent(e *model.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+	return nil
+}
+
+// countTokens returns the number of tokens of user userId of the given kind.
+func (s *fakeTokenstore) countTokens(userId int64, kind string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, t := range s.tokens {
+		if t.UserID == userId && t.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// mailbox is a mail sender keeping sent messages.
+type mailbox chan *mail.Message
+
+func (m mailbox) Send(msg *mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestUseUserToken(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{
+		{ID: 1, Login: "alice", Email: "alice@example.com", Verified: true},
+		{ID: 2, Login: "bob", Email: "bob@example.com", Deactivated: true},
+	}}
+	c := newAuthTestC(t, ds, nil)
+
+	alice, _ := ds.GetUserById(1)
+	bob, _ := ds.GetUserById(2)
+	valid, err := newUserToken(c, alice, model.TokenPasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := newUserToken(c, alice, model.TokenEmailVerification, -time.Minute)
+	deactivated, _ := newUserToken(c, bob, model.TokenPasswordReset, time.Hour)
+
+	tests := []struct {
+		name  string
+		kind  string
+		token string
+		user  int64 // ID of returned user, zero if the token is refused
+	}{
+		{"other kind", model.TokenRefresh, valid, 0},
+		{"valid", model.TokenPasswordReset, valid, 1},
+		{"used", model.TokenPasswordReset, valid, 0},
+		{"expired", model.TokenEmailVerification, expired, 0},
+		{"deactivated user", model.TokenPasswordReset, deactivated, 0},
+		{"unknown", model.TokenPasswordReset, "unknown", 0},
+	}
+
+	for _, tt := range tests {
+		usr, ok := useUserToken(c, tt.kind, tt.token)
+		switch {
+		case ok != (tt.user != 0):
+			t.Errorf("%s: ok = %v", tt.name, ok)
+		case ok && usr.ID != tt.user:
+			t.Errorf("%s: user %d, want %d", tt.name, usr.ID, tt.user)
+		}
+	}
+
+	// Refused tokens are single-use too, except those of another kind.
+	if n := len(ds.tokens); n != 0 {
+		t.Errorf("%d tokens left, want 0", n)
+	}
+}
+
+func TestUseUserTokenConcurrent(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{{ID: 1, Login: "alice", Verified: true}}}
+	c := newAuthTestC(t, ds, nil)
+
+	token, err := newUserToken(c, ds.users[0], model.TokenPasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var used int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := useUserToken(c, model.TokenPasswordReset, token); ok {
+				mu.Lock()
+				used++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if used != 1 {
+		t.Errorf("token used %d times, want once", used)
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{
+		{ID: 1, Login: "alice", Email: "alice@example.com", Verified: true},
+		{ID: 2, Login: "bob", Email: "bob@example.com", AuthSource: "ldap"},
+	}}
+	c := newAuthTestC(t, ds, nil)
+	box := make(mailbox, 1)
+	c.Env["mailer"] = mail.Sender(box)
+	c.Env["baseURL"] = "https://simdoc.example.com"
+
+	tests := []struct {
+		login string
+		sent  bool
+	}{
+		{"alice", true},
+		{"alice@example.com", true},
+		{"carol", false},
+		{"bob", false}, // Password managed by the provider
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/api/user/password/forgot", strings.NewReader(`{"login":"`+tt.login+`"}`))
+		ForgotPassword(c, w, r)
+
+		// The response is the same whether the user exists or not.
+		if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+			t.Errorf("%s: response %d %q", tt.login, w.Code, w.Body)
+		}
+
+		select {
+		case m := <-box:
+			if !tt.sent {
+				t.Errorf("%s: reset sent to %v", tt.login, m.To)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tt.sent {
+				t.Errorf("%s: no reset sent", tt.login)
+			}
+		}
+	}
+
+	// Only the last token is valid.
+	if n := ds.countTokens(1, model.TokenPasswordReset); n != 1 {
+		t.Errorf("%d reset tokens, want 1", n)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{{ID: 1, Login: "alice", Email: "alice@example.com", Password: "old"}}}
+	c := newAuthTestC(t, ds, nil)
+
+	token, err := newUserToken(c, ds.users[0], model.TokenPasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUserToken(c, ds.users[0], model.TokenRefresh, time.Hour)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"short password", `{"token":"` + token + `","password":"abc"}`, http.StatusBadRequest},
+		{"missing token", `{"password":"new-secret"}`, http.StatusBadRequest},
+		{"unknown token", `{"token":"unknown","password":"new-secret"}`, http.StatusBadRequest},
+		{"valid", `{"token":"` + token + `","password":"new-secret"}`, http.StatusNoContent},
+		{"used token", `{"token":"` + token + `","password":"other-secret"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/api/user/password/reset", bytes.NewBufferString(tt.body))
+		ResetPassword(c, w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+
+	usr, _ := ds.GetUserById(1)
+	if ok, err := verifyPassword(c, usr, "new-secret"); !ok || err != nil {
+		t.Errorf("new password not set: %v", err)
+	}
+	if !usr.Verified || usr.TokenGeneration != 1 {
+		t.Errorf("user %+v not verified or sessions not revoked", usr)
+	}
+	if n := ds.countTokens(1, model.TokenRefresh); n != 0 {
+		t.Errorf("%d refresh tokens left, want 0", n)
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// GetAllRoles accepts a request to retrieve all roles, along with known
+// permissions, from the datastore and returns in JSON format.
+//
+// GET /api/admin/roles
+//
+func GetAllRoles(c web.C, w http.ResponseWriter, r *http.Request) {
+	roles, err := datastore.GetAllRoles(context.FromC(c))
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if roles == nil {
+		roles = []*model.Role{}
+	}
+
+	json.NewEncoder(w).Encode(&struct {
+		Roles       []*model.Role `json:"roles"`
+		Permissions []string      `json:"permissions"`
+	}{roles, model.Permissions})
+}
+
+// AddRole accepts a request to add a custom role into the datastore.
+//
+// POST /api/admin/roles
+//
+func AddRole(c web.C, w http.ResponseWriter, r *http.Request) {
+	var role = new(model.Role)
+	if err := json.NewDecoder(r.Body).Decode(role); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	role.ID = 0
+	role.Builtin = false
+	role.Created = 0
+
+	if ve := model.Validate(role); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("roles", ve)...)
+		return
+	}
+
+	// Permissions are only granted by users having them.
+	if !ToRole(c).CanAll(role.Permissions) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	if err := datastore.AddRole(context.FromC(c), role); err != nil {
+		if isDuplicateEntryError(err) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("roles", "name", ErrorFieldAlreadyExists))
+		} else {
+			log.Printf("%+v\n", err)
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		}
+		return
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditRoleCreated,
+		Target:  "role:" + role.Name,
+		Changes: model.AuditChanges(struct{}{}, role),
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// UpdateRole accepts a request to update description and permissions of a
+// role, for the given name. Permissions of the admin role can't be changed,
+// and current user can only change roles granting no more than their own.
+//
+// PATCH /api/admin/roles/:role
+// PUT   /api/admin/roles/:role
+//
+func UpdateRole(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+
+	role, err := datastore.GetRoleByName(ctx, c.URLParams["role"])
+	if err != nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+
+	var req = new(struct {
+		Name        string    `json:"name"`
+		Description *string   `json:"description"`
+		Permissions *[]string `json:"permissions"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	// Users refer to roles by name.
+	if req.Name != "" && req.Name != role.Name {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("roles", "name", ErrorFieldImmutable))
+		return
+	}
+	if req.Permissions != nil && role.Name == model.RoleAdmin {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("roles", "permissions", ErrorFieldImmutable))
+		return
+	}
+
+	if !ToRole(c).CanAll(role.Permissions) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	var before = *role
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		role.Permissions = *req.Permissions
+	}
+
+	if ve := model.Validate(role); ve != nil {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("roles", ve)...)
+		return
+	}
+	if !ToRole(c).CanAll(role.Permissions) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	if err := datastore.UpdateRole(ctx, role); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditRoleUpdated,
+		Target:  "role:" + role.Name,
+		Changes: model.AuditChanges(&before, role),
+	})
+
+	json.NewEncoder(w).Encode(role)
+}
+
+// DeleteRole accepts a request to delete a custom role, for the given name.
+// Builtin roles, roles of existing users and roles granting more than the
+// role of current user can't be deleted.
+//
+// DELETE /api/admin/roles/:role
+//
+func DeleteRole(c web.C, w http.ResponseWriter, r *http.Request) {
+	var ctx = context.FromC(c)
+
+	role, err := datastore.GetRoleByName(ctx, c.URLParams["role"])
+	if err != nil {
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return
+	}
+	if role.Builtin || !ToRole(c).CanAll(role.Permissions) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	// Roles are shared by organizations, so are counted users.
+	var ds = datastore.FromContext(ctx).ForOrganization(datastore.AllOrganizations)
+	count, err := ds.CountUsersByRole(role.Name)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if count > 0 {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("roles", "users", ErrorFieldInvalid))
+		return
+	}
+
+	if err := datastore.DeleteRole(ctx, role.ID); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action: model.AuditRoleDeleted,
+		Target: "role:" + role.Name,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// roleExists checks whether role, for the given name, exists in the datastore.
+func roleExists(c web.C, name string) bool {
+	_, err := datastore.GetRoleByName(context.FromC(c), name)
+	return err == nil
+}
+
+// canAssignRole checks whether current user can assign role, for the given
+// name, to users, that is whether it grants no permission that the role of
+// current user doesn't grant.
+func canAssignRole(c web.C, name string) bool {
+	role, err := datastore.GetRoleByName(context.FromC(c), name)
+	return err == nil && ToRole(c).CanAll(role.Permissions)
+}
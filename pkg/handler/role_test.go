@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	gojictx "github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// fakeRolestore is an in-memory datastore of roles and users, whose users are
+// scoped to an organization like the database one. Other methods of the
+// datastore are not implemented.
+type fakeRolestore struct {
+	datastore.Datastore
+	orgId int64
+	roles map[string]*model.Role
+	users []*model.User
+}
+
+func (s *fakeRolestore) ForOrganization(orgId int64) datastore.Datastore {
+	cs := *s
+	cs.orgId = orgId
+	return &cs
+}
+
+func (s *fakeRolestore) GetRoleByName(name string) (*model.Role, error) {
+	if r, ok := s.roles[name]; ok {
+		return r, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeRolestore) CountUsersByRole(name string) (int64, error) {
+	var n int64
+	for _, u := range s.users {
+		if u.Role == name && (s.orgId == datastore.AllOrganizations || u.OrgID == s.orgId) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *fakeRolestore) DeleteRole(id int64) error {
+	for name, r := range s.roles {
+		if r.ID == id {
+			delete(s.roles, name)
+		}
+	}
+	return nil
+}
+
+func (s *fakeRolestore) AddAuditEvent(e *model.AuditEvent) error {
+	return nil
+}
+
+func TestDeleteRole(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []*model.User
+		code  int
+	}{
+		{"unused", nil, http.StatusNoContent},
+		{"used in organization", []*model.User{{ID: 2, OrgID: 1, Role: "editor"}}, http.StatusBadRequest},
+		{"used in other organization", []*model.User{{ID: 2, OrgID: 2, Role: "editor"}}, http.StatusBadRequest},
+		{"used without organization", []*model.User{{ID: 2, Role: "editor"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		ds := &fakeRolestore{
+			roles: map[string]*model.Role{"editor": {ID: 10, Name: "editor", Permissions: []string{model.PermDocumentsReadAny}}},
+			users: tt.users,
+		}
+
+		// Requests of organization managers are scoped to their organization.
+		c := web.C{Env: map[interface{}]interface{}{}, URLParams: map[string]string{"role": "editor"}}
+		c.Env["user"] = &model.User{ID: 1, OrgID: 1, Role: "manager"}
+		c.Env["role"] = &model.Role{Name: "manager", Permissions: []string{model.PermRolesManage, model.PermDocumentsReadAny}}
+		gojictx.Set(&c, datastore.NewContext(context.Background(), ds.ForOrganization(1)))
+
+		w := httptest.NewRecorder()
+		DeleteRole(c, w, httptest.NewRequest("DELETE", "/api/admin/roles/editor", nil))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if _, exists := ds.roles["editor"]; exists == (tt.code == http.StatusNoContent) {
+			t.Errorf("%s: role exists %v", tt.name, exists)
+		}
+	}
+}
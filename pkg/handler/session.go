@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// Lifetime of refresh token.
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// sessionTokens represents tokens of a user session. The access token is a
+// short-lived JWT, the refresh token is exchanged for new tokens.
+type sessionTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Lifetime of access token in seconds
+}
+
+// RefreshToken accepts a request to exchange a refresh token for new access and
+// refresh tokens. The refresh token can only be used once.
+//
+// POST /api/user/token
+//
+func RefreshToken(c web.C, w http.ResponseWriter, r *http.Request) {
+	var req = new(struct {
+		RefreshToken string `json:"refresh_token"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "refresh_token", ErrorFieldMissing))
+		return
+	}
+
+	usr, ok := useUserToken(c, model.TokenRefresh, req.RefreshToken)
+	if !ok {
+		detectRefreshReuse(c, r, req.RefreshToken)
+		respWithError(w, http.StatusUnauthorized, ErrorBadCredentials)
+		return
+	}
+	markRefreshUsed(c, usr, req.RefreshToken)
+	if !usr.Verified {
+		respWithError(w, http.StatusUnauthorized, ErrorBadCredentials)
+		return
+	}
+
+	tokens, err := issueSession(c, r, usr)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// UserLogout accepts a request to end the session of the given refresh token.
+// If all is true, all sessions of current user are ended and issued access
+// tokens are revoked.
+//
+// POST /api/user/logout
+//
+func UserLogout(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = ToUser(c)
+	if usr == nil {
+		respWithError(w, http.StatusUnauthorized, ErrorRequireAuthentication)
+		return
+	}
+
+	var req = new(struct {
+		RefreshToken string `json:"refresh_token"`
+		All          bool   `json:"all"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	if req.All {
+		if err := revokeSessions(c, usr); err != nil {
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+			return
+		}
+		addUserAuditEvent(c, r, model.AuditUserLogout, usr, "All sessions")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var ctx = context.FromC(c)
+
+	if req.RefreshToken != "" {
+		t, err := datastore.GetUserTokenByHash(ctx, model.TokenRefresh, util.HashToken(req.RefreshToken))
+		if err == nil && t != nil && t.UserID == usr.ID {
+			if err := datastore.DeleteUserToken(ctx, t.ID); err != nil {
+				respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+				return
+			}
+		}
+	}
+
+	addUserAuditEvent(c, r, model.AuditUserLogout, usr, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeUserSessions accepts a request to end all sessions of a user, for the
+// given login or email, and revoke issued access tokens.
+//
+// POST /api/admin/users/:login/revoke_sessions
+//
+func RevokeUserSessions(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = getManagedUser(c, w, c.URLParams["login"])
+	if usr == nil {
+		return
+	}
+
+	if err := revokeSessions(c, usr); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addUserAuditEvent(c, r, model.AuditUserSessionsRevoked, usr, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueSession issues access and refresh tokens to usr.
+func issueSession(c web.C, r *http.Request, usr *model.User) (*sessionTokens, error) {
+	var ctx = context.FromC(c)
+
+	token, err := util.GenerateToken(ctx, r, usr)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := newUserToken(c, usr, model.TokenRefresh, refreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionTokens{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(util.AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// markRefreshUsed records that refresh token of usr is exchanged, so that its
+// reuse is detected until it would have expired.
+func markRefreshUsed(c web.C, usr *model.User, token string) {
+	t := &model.UserToken{
+		UserID:  usr.ID,
+		Kind:    model.TokenRefreshUsed,
+		Hash:    util.HashToken(token),
+		Expires: time.Now().UTC().Add(refreshTokenTTL).Unix(),
+	}
+	if err := datastore.AddUserToken(context.FromC(c), t); err != nil {
+		log.Printf("%+v\n", err)
+	}
+}
+
+// detectRefreshReuse revokes sessions of the user of token if it's a refresh
+// token already exchanged: either the user or an attacker holds a stolen copy,
+// and the session can't be trusted anymore.
+func detectRefreshReuse(c web.C, r *http.Request, token string) {
+	var ctx = context.FromC(c)
+
+	t, err := datastore.GetUserTokenByHash(ctx, model.TokenRefreshUsed, util.HashToken(token))
+	if err != nil || t == nil || time.Now().UTC().Unix() > t.Expires {
+		return
+	}
+	usr, err := datastore.GetUserById(ctx, t.UserID)
+	if err != nil || usr == nil {
+		return
+	}
+
+	if err := revokeSessions(c, usr); err != nil {
+		log.Printf("%+v\n", err)
+		return
+	}
+	addUserAuditEvent(c, r, model.AuditUserSessionsRevoked, usr, "Refresh token reused")
+}
+
+// revokeSessions deletes refresh tokens of usr and revokes issued access tokens
+// by increasing token generation of usr.
+func revokeSessions(c web.C, usr *model.User) error {
+	var ctx = context.FromC(c)
+
+	usr.TokenGeneration++
+	if err := datastore.UpdateUser(ctx, usr); err != nil {
+		return err
+	}
+
+	return datastore.DeleteUserTokens(ctx, usr.ID, model.TokenRefresh)
+}
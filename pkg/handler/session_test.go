@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// newSessionTestC returns context of requests with ds as datastore and an
+// HMAC key set to sign access tokens.
+func newSessionTestC(t *testing.T, ds *fakeTokenstore) web.C {
+	c := newAuthTestC(t, ds, nil)
+	c.Env["jwtKeys"] = util.NewHMACKeySet("secret", "simdoc", "simdoc")
+	return c
+}
+
+// refresh exchanges refresh token for new session tokens. It returns nil if the
+// refresh token is refused.
+func refresh(t *testing.T, c web.C, token string) *sessionTokens {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/user/token", strings.NewReader(`{"refresh_token":"`+token+`"}`))
+	RefreshToken(c, w, r)
+	if w.Code != http.StatusOK {
+		return nil
+	}
+
+	tokens := new(sessionTokens)
+	if err := json.NewDecoder(w.Body).Decode(tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// bearerUser returns user authenticated with access token, if valid.
+func bearerUser(c web.C, token string) *model.User {
+	r := httptest.NewRequest("GET", "/api/user", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return util.GetUserFromRequest(context.FromC(c), r)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{{ID: 1, Login: "alice", Verified: true}}}
+	c := newSessionTestC(t, ds)
+
+	tokens, err := issueSession(c, httptest.NewRequest("POST", "/api/user/login", nil), ds.users[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr := bearerUser(c, tokens.Token); usr == nil || usr.ID != 1 {
+		t.Fatalf("access token refused")
+	}
+
+	// Each refresh token is exchanged once for a new one.
+	prev := tokens.RefreshToken
+	for i := 0; i < 3; i++ {
+		next := refresh(t, c, prev)
+		if next == nil {
+			t.Fatalf("refresh %d: refused", i)
+		}
+		if next.RefreshToken == prev {
+			t.Fatalf("refresh %d: refresh token not rotated", i)
+		}
+		if usr := bearerUser(c, next.Token); usr == nil || usr.ID != 1 {
+			t.Fatalf("refresh %d: access token refused", i)
+		}
+		prev = next.RefreshToken
+	}
+
+	if n := ds.countTokens(1, model.TokenRefresh); n != 1 {
+		t.Errorf("%d refresh tokens, want 1", n)
+	}
+	if refresh(t, c, "unknown") != nil {
+		t.Errorf("unknown refresh token accepted")
+	}
+	if usr, _ := ds.GetUserById(1); usr.TokenGeneration != 0 {
+		t.Errorf("sessions revoked by unknown refresh token")
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{{ID: 1, Login: "alice", Verified: true}}}
+	c := newSessionTestC(t, ds)
+
+	tokens, err := issueSession(c, httptest.NewRequest("POST", "/api/user/login", nil), ds.users[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	stolen := tokens.RefreshToken
+	next := refresh(t, c, stolen)
+	if next == nil {
+		t.Fatal("refresh refused")
+	}
+
+	// Reusing exchanged refresh token ends every session of the user.
+	if refresh(t, c, stolen) != nil {
+		t.Fatal("reused refresh token accepted")
+	}
+	if refresh(t, c, next.RefreshToken) != nil {
+		t.Errorf("refresh token of revoked session accepted")
+	}
+	if bearerUser(c, next.Token) != nil {
+		t.Errorf("access token of revoked session accepted")
+	}
+
+	usr, _ := ds.GetUserById(1)
+	if usr.TokenGeneration != 1 {
+		t.Errorf("token generation %d, want 1", usr.TokenGeneration)
+	}
+	if len(ds.events) != 1 || ds.events[0].Action != model.AuditUserSessionsRevoked {
+		t.Errorf("audit events %+v, want sessions revoked", ds.events)
+	}
+}
+
+func TestUserLogout(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		session bool // Whether the other session survives
+		gen     int64
+	}{
+		{"current session", `{"refresh_token":"%s"}`, true, 0},
+		{"all sessions", `{"all":true}`, false, 1},
+	}
+
+	for _, tt := range tests {
+		ds := &fakeTokenstore{users: []*model.User{{ID: 1, Login: "alice", Verified: true}}}
+		c := newSessionTestC(t, ds)
+		login := httptest.NewRequest("POST", "/api/user/login", nil)
+		current, _ := issueSession(c, login, ds.users[0])
+		other, err := issueSession(c, login, ds.users[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Env["user"] = bearerUser(c, current.Token)
+
+		w := httptest.NewRecorder()
+		body := strings.Replace(tt.body, "%s", current.RefreshToken, 1)
+		UserLogout(c, w, httptest.NewRequest("POST", "/api/user/logout", strings.NewReader(body)))
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: status %d", tt.name, w.Code)
+			continue
+		}
+
+		if refresh(t, c, current.RefreshToken) != nil {
+			t.Errorf("%s: refresh token of ended session accepted", tt.name)
+		}
+		if ok := bearerUser(c, other.Token) != nil; ok != tt.session {
+			t.Errorf("%s: access token of other session accepted %v, want %v", tt.name, ok, tt.session)
+		}
+		if ok := refresh(t, c, other.RefreshToken) != nil; ok != tt.session {
+			t.Errorf("%s: refresh token of other session accepted %v, want %v", tt.name, ok, tt.session)
+		}
+		if usr, _ := ds.GetUserById(1); usr.TokenGeneration != tt.gen {
+			t.Errorf("%s: token generation %d, want %d", tt.name, usr.TokenGeneration, tt.gen)
+		}
+	}
+}
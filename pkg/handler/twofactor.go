@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/totp"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+const (
+	// Lifetime of the challenge token returned by the first login step.
+	twoFactorChallengeTTL = 5 * time.Minute
+
+	// Number of recovery codes issued on enrollment.
+	recoveryCodesCount = 10
+
+	// Issuer shown in authenticator apps.
+	totpIssuer = "SIMDOC"
+)
+
+// twoFactorChallenge represents response of the first login step of a user
+// with two-factor authentication.
+type twoFactorChallenge struct {
+	Required       bool   `json:"two_factor_required"`
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int64  `json:"expires_in"` // Lifetime of challenge token in seconds
+}
+
+// UserLoginTwoFactor accepts a request to complete login with the challenge
+// token returned by UserLogin and either a TOTP code or a recovery code. The
+// challenge token can only be used once.
+//
+// POST /api/user/login/2fa
+//
+func UserLoginTwoFactor(c web.C, w http.ResponseWriter, r *http.Request) {
+	var req = new(struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	var fe []*fieldError
+	if req.ChallengeToken == "" {
+		fe = append(fe, newFieldError("user", "challenge_token", ErrorFieldMissing))
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		fe = append(fe, newFieldError("user", "code", ErrorFieldMissing))
+	}
+	if len(fe) > 0 {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, fe...)
+		return
+	}
+
+	usr, ok := useUserToken(c, model.TokenTwoFactor, req.ChallengeToken)
+	if !ok || !usr.TOTPEnabled {
+		respWithError(w, http.StatusUnauthorized, ErrorBadCredentials)
+		return
+	}
+
+	// Codes are throttled along with passwords of the account.
+	var attempt = newLoginAttempt(r, usr.Login, usr)
+	if !checkLoginAttempt(c, w, attempt) {
+		return
+	}
+
+	if req.Code != "" {
+		ok = verifyTOTP(c, usr, req.Code)
+	} else {
+		ok = useRecoveryCode(c, usr, req.RecoveryCode)
+	}
+	if !ok {
+		failLoginAttempt(c, r, attempt)
+		respWithError(w, http.StatusUnauthorized, ErrorBadCredentials)
+		return
+	}
+
+	resetLoginAttempt(attempt)
+	respWithSession(c, w, r, usr)
+}
+
+// EnrollTOTP accepts a request to start TOTP enrollment of current user. A new
+// secret is returned, along with its provisioning URI to be rendered as QR
+// code. Enrollment is completed with ConfirmTOTP.
+//
+// POST /api/user/2fa/totp
+//
+func EnrollTOTP(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = requireSession(c, w)
+	if usr == nil {
+		return
+	}
+	if usr.TOTPEnabled {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "totp", ErrorFieldAlreadyExists))
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	usr.TOTPSecret = secret
+	usr.TOTPLastStep = 0
+
+	if err := datastore.UpdateUser(context.FromC(c), usr); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		secret,
+		totp.ProvisioningURI(secret, totpIssuer, usr.Login),
+	})
+}
+
+// ConfirmTOTP accepts a request to complete TOTP enrollment of current user
+// with a code of the new secret. Recovery codes are returned, only once.
+//
+// POST /api/user/2fa/totp/confirm
+//
+func ConfirmTOTP(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = requireSession(c, w)
+	if usr == nil {
+		return
+	}
+
+	var req = new(struct {
+		Code string `json:"code"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	if usr.TOTPEnabled || usr.TOTPSecret == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "totp", ErrorFieldInvalid))
+		return
+	}
+	if req.Code == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "code", ErrorFieldMissing))
+		return
+	}
+
+	if !verifyTOTP(c, usr, req.Code) {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "code", ErrorFieldInvalid))
+		return
+	}
+
+	// TOTP is enabled once recovery codes are stored, so that the user can't be
+	// left with TOTP but without recovery codes.
+	codes, err := issueRecoveryCodes(c, usr)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	usr.TOTPEnabled = true
+	if err := datastore.UpdateUser(context.FromC(c), usr); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addUserAuditEvent(c, r, model.AuditTOTPEnabled, usr, "")
+
+	json.NewEncoder(w).Encode(&struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+// DisableTOTP accepts a request to disable two-factor authentication of
+// current user. The password is required. Admins can't disable it when it's
+// required for their role.
+//
+// DELETE /api/user/2fa/totp
+//
+func DisableTOTP(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = requireSession(c, w)
+	if usr == nil {
+		return
+	}
+
+	var req = new(struct {
+		Password string `json:"password"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	if ok, err := checkPassword(c, usr, req.Password); err != nil || !ok {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "password", ErrorFieldInvalid))
+		return
+	}
+
+	if requireTwoFactor(c) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
+	var ctx = context.FromC(c)
+
+	usr.TOTPEnabled = false
+	usr.TOTPSecret = ""
+	usr.TOTPLastStep = 0
+	if err := datastore.UpdateUser(ctx, usr); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+	if err := datastore.DeleteUserTokens(ctx, usr.ID, model.TokenRecoveryCode); err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addUserAuditEvent(c, r, model.AuditTOTPDisabled, usr, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes accepts a request to replace recovery codes of
+// current user. A TOTP code is required.
+//
+// POST /api/user/2fa/recovery_codes
+//
+func RegenerateRecoveryCodes(c web.C, w http.ResponseWriter, r *http.Request) {
+	var usr = requireSession(c, w)
+	if usr == nil {
+		return
+	}
+
+	var req = new(struct {
+		Code string `json:"code"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+
+	if !usr.TOTPEnabled {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "totp", ErrorFieldInvalid))
+		return
+	}
+	if !verifyTOTP(c, usr, req.Code) {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "code", ErrorFieldInvalid))
+		return
+	}
+
+	codes, err := issueRecoveryCodes(c, usr)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+// verifyTOTP checks TOTP code of usr. Accepted code is recorded so that it
+// can't be used again, including by a concurrent request.
+func verifyTOTP(c web.C, usr *model.User, code string) bool {
+	step, ok := totp.Validate(usr.TOTPSecret, code, time.Now().UTC(), usr.TOTPLastStep)
+	if !ok {
+		return false
+	}
+
+	if ok, err := datastore.UpdateUserTOTPStep(context.FromC(c), usr.ID, step); err != nil || !ok {
+		if err != nil {
+			log.Printf("%+v\n", err)
+		}
+		return false
+	}
+	usr.TOTPLastStep = step
+	return true
+}
+
+// issueRecoveryCodes issues recovery codes to usr, replacing previous ones.
+// Only hashes of the codes are stored.
+func issueRecoveryCodes(c web.C, usr *model.User) ([]string, error) {
+	var ctx = context.FromC(c)
+
+	if err := datastore.DeleteUserTokens(ctx, usr.ID, model.TokenRecoveryCode); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := totp.RecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		t := &model.UserToken{
+			UserID: usr.ID,
+			Kind:   model.TokenRecoveryCode,
+			Hash:   hashRecoveryCode(usr, code),
+		}
+		if err := datastore.AddUserToken(ctx, t); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// useRecoveryCode checks recovery code of usr and deletes it. Only the request
+// that deletes it can use it.
+func useRecoveryCode(c web.C, usr *model.User, code string) bool {
+	var ctx = context.FromC(c)
+
+	t, err := datastore.GetUserTokenByHash(ctx, model.TokenRecoveryCode, hashRecoveryCode(usr, code))
+	if err != nil || t == nil || t.UserID != usr.ID {
+		return false
+	}
+
+	ok, err := datastore.ConsumeUserToken(ctx, t.ID)
+	if err != nil {
+		log.Printf("%+v\n", err)
+	}
+	return ok && err == nil
+}
+
+// hashRecoveryCode returns hash of recovery code of usr. Codes are short, so
+// the user ID is hashed along to keep hashes unique across users.
+func hashRecoveryCode(usr *model.User, code string) string {
+	return util.HashToken(fmt.Sprintf("%d:%s", usr.ID, totp.NormalizeRecoveryCode(code)))
+}
+
+// requireTwoFactor checks whether current user must have two-factor
+// authentication, according to the policy.
+func requireTwoFactor(c web.C) bool {
+	required, _ := c.Env["require2FAAdmin"].(bool)
+
+	return required && ToRole(c).IsAdmin()
+}
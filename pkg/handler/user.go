@@ -1,16 +1,18 @@
 package handler
 
 import (
-	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/gedex/simdoc/pkg/auth"
 	"github.com/gedex/simdoc/pkg/datastore"
 	"github.com/gedex/simdoc/pkg/model"
 	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/password"
 
 	"github.com/goji/context"
 	"github.com/zenazn/goji/web"
@@ -20,14 +22,11 @@ import (
 // login or email, from the datastore and returns in JSON format.
 //
 // GET /api/users/:login
+// GET /api/admin/users/:login
 //
 func GetUserByLogin(c web.C, w http.ResponseWriter, r *http.Request) {
-	var ctx = context.FromC(c)
-	var loginOrEmail = c.URLParams["login"]
-
-	user, err := datastore.GetUserByLogin(ctx, loginOrEmail)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	var user = getUserByLogin(c, w, c.URLParams["login"])
+	if user == nil {
 		return
 	}
 
@@ -38,13 +37,15 @@ func GetUserByLogin(c web.C, w http.ResponseWriter, r *http.Request) {
 // returns in JSON format.
 //
 // GET /api/users
+// GET /api/admin/users
 //
 func GetAllUsers(c web.C, w http.ResponseWriter, r *http.Request) {
 	var ctx = context.FromC(c)
 
 	users, err := datastore.GetAllUsers(ctx)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
 		return
 	}
 
@@ -58,6 +59,7 @@ func GetAllUsers(c web.C, w http.ResponseWriter, r *http.Request) {
 // AddUser accepts a request to add new user into the datastore.
 //
 // POST /api/users
+// POST /api/admin/users
 //
 func AddUser(c web.C, w http.ResponseWriter, r *http.Request) {
 	usr, err := parseSubmittedUser(r)
@@ -69,22 +71,79 @@ func AddUser(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Checks if login exists in datastore. No need to check the email, as both
 	// login and email are unique.
 
+	var ctx = context.FromC(c)
+
+	// Users are added to the organization of current user. Global admins may
+	// add users to any organization.
+	if !can(c, model.PermOrganizationsManage) {
+		usr.OrgID = ToUser(c).OrgID
+	}
+	var org *model.Organization
+	if usr.OrgID != 0 {
+		org, err = datastore.GetOrganizationById(ctx, usr.OrgID)
+		if err != nil {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("users", "org_id", ErrorFieldInvalid))
+			return
+		}
+	}
+
+	// Default role, unless the organization has its own.
+	if usr.Role == "" {
+		usr.Role = model.RoleUser
+		if org != nil && org.Settings != nil && org.Settings.DefaultRole != "" {
+			usr.Role = org.Settings.DefaultRole
+		}
+	}
+
 	// Validate the model.
 	if ve := model.Validate(usr); ve != nil {
 		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("users", ve)...)
 		return
 	}
 
+	if !roleExists(c, usr.Role) {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("users", "role", ErrorFieldInvalid))
+		return
+	}
+	if !canAssignRole(c, usr.Role) {
+		respWithError(w, http.StatusForbidden, ErrorForbidden)
+		return
+	}
+
 	// Sets password.
 	if usr.Password == "" {
 		usr.Password = util.GetRandomString(8)
 	}
-	// Encrypt the password.
-	usr.Password = encryptPassword(c, usr.Password)
+	// Hash the password.
+	usr.Password, err = hashPassword(c, usr.Password)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	// New user is unverified until the link sent to the email is followed.
+	usr.Verified = false
 
-	// @todo send notification to email informing about email being used in
-	// this app. Email contains a link to verify the user.
-	err = datastore.AddUser(context.FromC(c), usr)
+	// Users of external providers are provisioned on their first login.
+	usr.AuthSource = ""
+	usr.Deactivated = false
+
+	// Two-factor authentication is enrolled by the user.
+	usr.TOTPEnabled = false
+
+	if org != nil && org.QuotaUsers > 0 {
+		count, err := datastore.FromContext(ctx).ForOrganization(org.ID).CountUsers()
+		if err != nil {
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+			return
+		}
+		if count >= org.QuotaUsers {
+			respWithError(w, http.StatusForbidden, ErrorQuotaExceeded)
+			return
+		}
+	}
+
+	err = datastore.AddUser(ctx, usr)
 	if err != nil {
 		// @todo refactor this by checking the given login first from the datastore.
 		// @todo also validate posted email and login.
@@ -98,6 +157,12 @@ func AddUser(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := sendEmailVerification(c, usr); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	addUserAuditEvent(c, r, model.AuditUserCreated, usr, "")
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(usr)
 }
@@ -130,30 +195,97 @@ func UserLogin(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 
 	var ctx = context.FromC(c)
-	var salt = c.Env["passwdSalt"].(string)
 
-	// Encrypt the password.
-	encPass := util.PBKDF2([]byte(loginInfo.Password), []byte(salt), 10000, 50, sha256.New)
-	loginInfo.Password = fmt.Sprintf("%x", encPass)
+	usr, err := datastore.GetUserByLogin(ctx, loginInfo.Login)
+	if err != nil {
+		usr = nil
+	}
 
-	usr, err := datastore.GetUserByLoginAndPassword(ctx, loginInfo.Login, loginInfo.Password)
-	if err != nil || usr == nil {
+	var attempt = newLoginAttempt(r, loginInfo.Login, usr)
+	if !checkLoginAttempt(c, w, attempt) {
+		return
+	}
+
+	usr, err = authenticate(c, loginInfo.Login, loginInfo.Password, usr)
+	if err == auth.ErrorBadCredentials {
+		failLoginAttempt(c, r, attempt)
 		respWithError(w, http.StatusBadRequest, ErrorBadCredentials)
 		return
 	}
+	if err != nil || usr.Deactivated || !usr.Verified || usr.TOTPEnabled {
+		// Credentials aren't wrong, yet only a complete login, along with the
+		// second factor if any, resets failed attempts of the account.
+		cancelLoginAttempt(attempt)
+	}
+
+	switch {
+	case err == ErrorAccountConflict:
+		respWithError(w, http.StatusConflict, ErrorAccountConflict)
+		return
+	case err != nil:
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	if usr.Deactivated {
+		respWithError(w, http.StatusForbidden, ErrorAccountDeactivated)
+		return
+	}
 
-	tokenStr, err := util.GenerateToken(ctx, r, usr)
+	if !usr.Verified {
+		respWithError(w, http.StatusForbidden, ErrorEmailNotVerified)
+		return
+	}
+
+	// Second step is required, see UserLoginTwoFactor.
+	if usr.TOTPEnabled {
+		respWithTwoFactorChallenge(c, w, usr)
+		return
+	}
+
+	resetLoginAttempt(attempt)
+	respWithSession(c, w, r, usr)
+}
+
+// respWithTwoFactorChallenge issues a challenge token to usr, to complete login
+// with UserLoginTwoFactor.
+func respWithTwoFactorChallenge(c web.C, w http.ResponseWriter, usr *model.User) {
+	challenge, err := issueUserToken(c, usr, model.TokenTwoFactor, twoFactorChallengeTTL)
 	if err != nil {
 		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
 		return
 	}
 
+	json.NewEncoder(w).Encode(&twoFactorChallenge{
+		Required:       true,
+		ChallengeToken: challenge,
+		ExpiresIn:      int64(twoFactorChallengeTTL / time.Second),
+	})
+}
+
+// respWithSession issues a session to usr and responds with usr and the
+// session tokens.
+func respWithSession(c web.C, w http.ResponseWriter, r *http.Request, usr *model.User) {
+	tokens, err := issueSession(c, r, usr)
+	if err != nil {
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditUserLogin,
+		ActorID: usr.ID,
+		OrgID:   usr.OrgID,
+		Target:  accountLockoutKey(usr),
+	})
+
 	resp := struct {
 		*model.User
-		Token string `json:"token"`
+		*sessionTokens
 	}{
 		usr,
-		tokenStr,
+		tokens,
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -205,18 +337,54 @@ func UpdateCurrentUser(c web.C, w http.ResponseWriter, r *http.Request) {
 	usr.Password = cusr.Password // Update password should be handled separately.
 	usr.Created = cusr.Created   // Created is immutable
 
-	// If current user is not an admin, prevent updating role.
-	if !cusr.IsAdmin() {
-		usr.Role = cusr.Role
+	// Sessions and two-factor authentication are handled separately.
+	usr.TokenGeneration = cusr.TokenGeneration
+	usr.TOTPSecret = cusr.TOTPSecret
+	usr.TOTPEnabled = cusr.TOTPEnabled
+	usr.TOTPLastStep = cusr.TOTPLastStep
+
+	// Account state is handled by admins.
+	usr.OrgID = cusr.OrgID
+	usr.Deactivated = cusr.Deactivated
+	usr.AuthSource = cusr.AuthSource
+	usr.ExternalID = cusr.ExternalID
+
+	// Email of external users is managed by their provider.
+	if !cusr.IsLocal() && usr.Email != cusr.Email {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "email", ErrorFieldImmutable))
+		return
+	}
+
+	// Current email stays in use until the changed one is verified.
+	usr.Verified = cusr.Verified
+	usr.PendingEmail = cusr.PendingEmail
+	if usr.Email == cusr.Email {
+		usr.PendingEmail = ""
+	} else if other, err := datastore.GetUserByLogin(context.FromC(c), usr.Email); err == nil && other != nil && other.ID != usr.ID {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "email", ErrorFieldAlreadyExists))
+		return
+	}
+
+	// Role is assigned by admins, see UpdateUser.
+	if usr.Role != "" && usr.Role != cusr.Role {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "role", ErrorFieldImmutable))
+		return
 	}
+	usr.Role = cusr.Role
 
 	// Validate the model.
 	if ve := model.Validate(usr); ve != nil {
-		log.Printf("%+v\n", usr)
+		log.Printf("%+v\n", ve)
 		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, getValidationErrors("user", ve)...)
 		return
 	}
 
+	var emailChanged = usr.Email != cusr.Email
+	if emailChanged {
+		usr.PendingEmail = usr.Email
+		usr.Email = cusr.Email
+	}
+
 	// @todo send notification to email informing updated profile information.
 	err = datastore.UpdateUser(context.FromC(c), usr)
 	if err != nil {
@@ -224,6 +392,18 @@ func UpdateCurrentUser(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if emailChanged {
+		if err := sendEmailVerification(c, usr); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+
+	addAuditEvent(c, r, &model.AuditEvent{
+		Action:  model.AuditUserUpdated,
+		Target:  accountLockoutKey(usr),
+		Changes: model.AuditChanges(cusr, usr),
+	})
+
 	json.NewEncoder(w).Encode(usr)
 }
 
@@ -237,6 +417,23 @@ func GetCurrentUserDocuments(c web.C, w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w)
 }
 
+// getUserByLogin retrieves user for the given login or email. Otherwise it
+// responds with not found, or internal server error for other errors than a
+// missing user, and returns nil.
+func getUserByLogin(c web.C, w http.ResponseWriter, loginOrEmail string) *model.User {
+	usr, err := datastore.GetUserByLogin(context.FromC(c), loginOrEmail)
+	switch {
+	case err == sql.ErrNoRows:
+		respWithError(w, http.StatusNotFound, ErrorNotFound)
+		return nil
+	case err != nil:
+		log.Printf("%+v\n", err)
+		respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		return nil
+	}
+	return usr
+}
+
 // parseSubmittedUser returns User through POST or PUT.
 func parseSubmittedUser(r *http.Request) (*model.User, error) {
 	decoder := json.NewDecoder(r.Body)
@@ -276,8 +473,31 @@ func isDuplicateEntryError(err error) bool {
 	return false
 }
 
-func encryptPassword(c web.C, pass string) string {
-	var salt = c.Env["passwdSalt"].(string)
+// hashPassword returns encoded hash of pass with a random salt.
+func hashPassword(c web.C, pass string) (string, error) {
+	var hasher = c.Env["passwdHasher"].(password.Hasher)
+
+	return hasher.Hash(pass)
+}
+
+// verifyPassword checks whether pass matches the password of usr. Hash made
+// with outdated algorithm or parameters is upgraded on success.
+func verifyPassword(c web.C, usr *model.User, pass string) (bool, error) {
+	var hasher = c.Env["passwdHasher"].(password.Hasher)
+
+	ok, err := hasher.Verify(pass, usr.Password)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	if hasher.NeedsRehash(usr.Password) {
+		if h, err := hasher.Hash(pass); err == nil {
+			usr.Password = h
+			if err := datastore.UpdateUser(context.FromC(c), usr); err != nil {
+				log.Printf("%+v\n", err)
+			}
+		}
+	}
 
-	return fmt.Sprintf("%x", util.PBKDF2([]byte(pass), []byte(salt), 10000, 50, sha256.New))
+	return true, nil
 }
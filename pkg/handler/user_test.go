@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+func TestUpdateCurrentUserLogsNoSecret(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{
+		{ID: 1, Login: "alice", Email: "alice@example.com", Password: "hashed-password", TOTPSecret: "totp-secret", Role: "user", Verified: true},
+	}}
+	c := newAuthTestC(t, ds, nil)
+	c.Env["user"], _ = ds.GetUserById(1)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	w := httptest.NewRecorder()
+	UpdateCurrentUser(c, w, httptest.NewRequest("PATCH", "/api/user", strings.NewReader(`{"email":"invalid"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	for _, secret := range []string{"hashed-password", "totp-secret"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("log contains %s: %s", secret, buf.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/mail"
+
+	"github.com/goji/context"
+	"github.com/zenazn/goji/web"
+)
+
+// Lifetime of email verification token.
+const emailVerificationTTL = 72 * time.Hour
+
+// VerifyEmail accepts a request to verify email of a user with the token sent
+// on registration or email change. Changed email replaces the current one once
+// verified.
+//
+// POST /api/user/verify
+//
+func VerifyEmail(c web.C, w http.ResponseWriter, r *http.Request) {
+	var req = new(struct {
+		Token string `json:"token"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	if req.Token == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "token", ErrorFieldMissing))
+		return
+	}
+
+	usr, ok := useUserToken(c, model.TokenEmailVerification, req.Token)
+	if !ok {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "token", ErrorFieldInvalid))
+		return
+	}
+
+	usr.Verified = true
+	if usr.PendingEmail != "" {
+		usr.Email = usr.PendingEmail
+		usr.PendingEmail = ""
+	}
+	if err := datastore.UpdateUser(context.FromC(c), usr); err != nil {
+		if isDuplicateLogin(err) {
+			respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "email", ErrorFieldAlreadyExists))
+		} else {
+			respWithError(w, http.StatusInternalServerError, ErrorInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendEmailVerification accepts a request to send a new verification token
+// to the email of unverified user, or to the changed email of user, for the
+// given login or email. The response is the same whether the user exists or
+// not, and is sent before the lookup so that its timing doesn't tell either.
+//
+// POST /api/user/verify/resend
+//
+func ResendEmailVerification(c web.C, w http.ResponseWriter, r *http.Request) {
+	var req = new(struct {
+		Login string `json:"login"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		respWithError(w, http.StatusBadRequest, ErrorInvalidJSONRequest)
+		return
+	}
+	if req.Login == "" {
+		respWithError(w, http.StatusBadRequest, ErrorValidationFailed, newFieldError("user", "login", ErrorFieldMissing))
+		return
+	}
+
+	go func() {
+		usr, err := datastore.GetUserByLogin(context.FromC(c), req.Login)
+		if err == nil && usr != nil && (!usr.Verified || usr.PendingEmail != "") {
+			if err := sendEmailVerification(c, usr); err != nil {
+				log.Printf("%+v\n", err)
+			}
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendEmailVerification issues verification token to usr, replacing previous
+// ones, and sends it to usr changed email if any, otherwise to usr email.
+func sendEmailVerification(c web.C, usr *model.User) error {
+	var to = usr.Email
+	if usr.PendingEmail != "" {
+		to = usr.PendingEmail
+	}
+
+	token, err := issueUserToken(c, usr, model.TokenEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	var baseURL = c.Env["baseURL"].(string)
+
+	return sendMail(c, &mail.Message{
+		To:      []string{to},
+		Subject: "Verify your SIMDOC email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThis email is used by an account in SIMDOC. To verify it, open following link within %s:\n\n%s/verify-email?token=%s\n\nIf you did not create the account, you can ignore this email.\n",
+			usr.Login, emailVerificationTTL, baseURL, token,
+		),
+	})
+}
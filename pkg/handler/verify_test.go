@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/mail"
+)
+
+func TestUpdateCurrentUserEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		code     int
+		email    string // Email after the update
+		pending  string // Changed email awaiting verification
+		verified bool
+		sentTo   string
+	}{
+		{"name only", `{"name":"Alice"}`, http.StatusOK, "alice@example.com", "", true, ""},
+		{"same email", `{"email":"alice@example.com"}`, http.StatusOK, "alice@example.com", "", true, ""},
+		{"changed email", `{"email":"new@example.com"}`, http.StatusOK, "alice@example.com", "new@example.com", true, "new@example.com"},
+		{"email of other user", `{"email":"bob@example.com"}`, http.StatusBadRequest, "alice@example.com", "", true, ""},
+		{"invalid email", `{"email":"new"}`, http.StatusBadRequest, "alice@example.com", "", true, ""},
+	}
+
+	for _, tt := range tests {
+		ds := &fakeTokenstore{users: []*model.User{
+			{ID: 1, Login: "alice", Email: "alice@example.com", Password: "hashed-password", Role: "user", Verified: true},
+			{ID: 2, Login: "bob", Email: "bob@example.com", Password: "hashed-password", Role: "user", Verified: true},
+		}}
+		c := newAuthTestC(t, ds, nil)
+		box := make(mailbox, 1)
+		c.Env["mailer"] = mail.Sender(box)
+		c.Env["baseURL"] = "https://simdoc.example.com"
+		c.Env["user"], _ = ds.GetUserById(1)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PATCH", "/api/user", strings.NewReader(tt.body))
+		UpdateCurrentUser(c, w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+			continue
+		}
+
+		usr, _ := ds.GetUserById(1)
+		if usr.Email != tt.email || usr.PendingEmail != tt.pending || usr.Verified != tt.verified {
+			t.Errorf("%s: email %s, pending %s, verified %v", tt.name, usr.Email, usr.PendingEmail, usr.Verified)
+		}
+
+		select {
+		case m := <-box:
+			if tt.sentTo == "" || m.To[0] != tt.sentTo {
+				t.Errorf("%s: verification sent to %v, want %q", tt.name, m.To, tt.sentTo)
+			}
+		default:
+			if tt.sentTo != "" {
+				t.Errorf("%s: no verification sent", tt.name)
+			}
+		}
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{
+		{ID: 1, Login: "alice", Email: "alice@example.com"},
+		{ID: 2, Login: "bob", Email: "bob@example.com", PendingEmail: "new@example.com", Verified: true},
+	}}
+	c := newAuthTestC(t, ds, nil)
+
+	alice, _ := ds.GetUserById(1)
+	bob, _ := ds.GetUserById(2)
+	aliceToken, _ := newUserToken(c, alice, model.TokenEmailVerification, time.Hour)
+	bobToken, _ := newUserToken(c, bob, model.TokenEmailVerification, time.Hour)
+	resetToken, _ := newUserToken(c, alice, model.TokenPasswordReset, time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"missing token", "", http.StatusBadRequest},
+		{"token of other kind", resetToken, http.StatusBadRequest},
+		{"new account", aliceToken, http.StatusNoContent},
+		{"changed email", bobToken, http.StatusNoContent},
+		{"used token", aliceToken, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"token": tt.token})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/api/user/verify", strings.NewReader(string(body)))
+		VerifyEmail(c, w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+
+	if alice, _ = ds.GetUserById(1); !alice.Verified || alice.Email != "alice@example.com" {
+		t.Errorf("new account %+v", alice)
+	}
+	if bob, _ = ds.GetUserById(2); !bob.Verified || bob.Email != "new@example.com" || bob.PendingEmail != "" {
+		t.Errorf("changed email %+v", bob)
+	}
+}
+
+func TestResendEmailVerification(t *testing.T) {
+	ds := &fakeTokenstore{users: []*model.User{
+		{ID: 1, Login: "alice", Email: "alice@example.com"},
+		{ID: 2, Login: "bob", Email: "bob@example.com", PendingEmail: "new@example.com", Verified: true},
+		{ID: 3, Login: "carol", Email: "carol@example.com", Verified: true},
+	}}
+	c := newAuthTestC(t, ds, nil)
+	box := make(mailbox, 1)
+	c.Env["mailer"] = mail.Sender(box)
+	c.Env["baseURL"] = "https://simdoc.example.com"
+
+	tests := []struct {
+		login  string
+		sentTo string
+	}{
+		{"alice", "alice@example.com"},
+		{"bob", "new@example.com"},
+		{"carol", ""}, // Already verified
+		{"dave", ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/api/user/verify/resend", strings.NewReader(`{"login":"`+tt.login+`"}`))
+		ResendEmailVerification(c, w, r)
+		if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+			t.Errorf("%s: response %d %q", tt.login, w.Code, w.Body)
+		}
+
+		select {
+		case m := <-box:
+			if tt.sentTo == "" || m.To[0] != tt.sentTo {
+				t.Errorf("%s: verification sent to %v, want %q", tt.login, m.To, tt.sentTo)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tt.sentTo != "" {
+				t.Errorf("%s: no verification sent", tt.login)
+			}
+		}
+	}
+}
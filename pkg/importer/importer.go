@@ -0,0 +1,416 @@
+// Package importer imports documents in bulk from a directory tree or a ZIP
+// archive, running files through the same processors pipeline as uploads.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+	"github.com/gedex/simdoc/pkg/util/mimetype"
+	"github.com/gedex/simdoc/pkg/util/upload"
+	"github.com/gedex/simdoc/pkg/util/upload/processor"
+	"github.com/gedex/simdoc/pkg/util/watermark"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+var (
+	ErrorMissingOwner         = errors.New("Missing document owner")
+	ErrorOrganizationMismatch = errors.New("Participant outside of the organization of the owner")
+	ErrorQuotaExceeded        = errors.New("Organization quota exceeded")
+)
+
+const (
+	// originalVersion is the name of processor whose output is stored as the
+	// file of the document.
+	originalVersion = processor.VersionOriginal
+
+	// watermarkedVersion is the name of watermarked file version of published
+	// documents.
+	watermarkedVersion = processor.VersionWatermarked
+)
+
+// Report represents result of an import.
+type Report struct {
+	Imported int        `json:"imported"`
+	Skipped  int        `json:"skipped"` // Already imported according to the journal
+	Failed   int        `json:"failed"`
+	Failures []*Failure `json:"failures"`
+}
+
+// Failure represents a file that can not be imported.
+type Failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Importer imports files into the datastore.
+type Importer struct {
+	ds       datastore.Datastore
+	pipeline *processor.Pipeline
+	fsRoot   string
+	prefix   string
+	owner    *model.User // Default owner of documents without one in the manifest
+	journal  *Journal
+	wm       *watermark.Watermark
+
+	docs  map[string]int64       // Imported documents keyed by name
+	users map[string]*model.User // Resolved users keyed by login or email
+}
+
+// New returns an Importer that stores files under fsRoot, served with URL
+// prefix, and records imported files in journal j.
+func New(ds datastore.Datastore, pl *processor.Pipeline, fsRoot, prefix string, owner *model.User, j *Journal) *Importer {
+	return &Importer{
+		ds:       ds,
+		pipeline: pl,
+		fsRoot:   fsRoot,
+		prefix:   prefix,
+		owner:    owner,
+		journal:  j,
+		docs:     j.Documents(),
+		users:    make(map[string]*model.User),
+	}
+}
+
+// SetWatermark sets the watermark stamped on files of published documents, as
+// on uploads.
+func (im *Importer) SetWatermark(wm *watermark.Watermark) {
+	im.wm = wm
+}
+
+// Run imports files described by entries from src. Files already recorded in
+// the journal are skipped, so running it again resumes an interrupted import.
+func (im *Importer) Run(src Source, entries []*Entry) *Report {
+	rep := &Report{Failures: make([]*Failure, 0)}
+
+	for _, e := range entries {
+		if _, done := im.journal.Done(e.Path); done {
+			rep.Skipped++
+			continue
+		}
+
+		if err := im.importEntry(src, e); err != nil {
+			rep.Failed++
+			rep.Failures = append(rep.Failures, &Failure{e.Path, err.Error()})
+			continue
+		}
+		rep.Imported++
+	}
+
+	return rep
+}
+
+func (im *Importer) importEntry(src Source, e *Entry) error {
+	if e.Path == "" {
+		return errors.New("Missing path")
+	}
+	if e.Document == "" {
+		name := path.Base(e.Path)
+		e.Document = name[:len(name)-len(path.Ext(name))]
+	}
+
+	doc, err := im.getDocument(e)
+	if err != nil {
+		return err
+	}
+
+	// The file in flight when the import was interrupted may be stored already.
+	if p, ok := im.journal.Pending(e.Path); ok && p.DocumentID == doc.ID {
+		df, err := im.findFile(doc.ID, p.Sid)
+		if err != nil {
+			return err
+		}
+		if df != nil {
+			return im.journal.Add(&journalEntry{
+				Path:       e.Path,
+				Document:   e.Document,
+				DocumentID: doc.ID,
+				FileID:     df.ID,
+			})
+		}
+	}
+
+	f, err := im.copyToTemp(src, e.Path)
+	if err != nil {
+		return err
+	}
+	// Processors move the file away on success.
+	defer os.Remove(f.Filepath)
+
+	root := upload.OrganizationRoot(im.fsRoot, doc.OrgID)
+	if err := im.checkQuota(doc.OrgID); err != nil {
+		return err
+	}
+
+	bpath, err := upload.CreateDir(root, f.Type)
+	if err != nil {
+		return err
+	}
+
+	procs, err := im.pipeline.Build(func(name, ext string) string {
+		return filepath.Join(bpath.Abs(), upload.GenerateFilename(f, name, ext))
+	})
+	if err != nil {
+		return err
+	}
+
+	wm, err := im.watermarkFor(doc)
+	if err != nil {
+		return err
+	}
+	if wm != nil {
+		procs = append(procs, processor.Watermarker(watermarkedVersion, originalVersion, filepath.Join(bpath.Abs(), upload.GenerateFilename(f, watermarkedVersion, "")), wm))
+	}
+
+	if err := im.journal.Begin(&journalEntry{
+		Path:       e.Path,
+		Document:   e.Document,
+		DocumentID: doc.ID,
+		Sid:        f.Sid,
+	}); err != nil {
+		return err
+	}
+
+	fr := upload.ProcessFile(f, upload.URLFn(im.fsRoot, im.prefix), procs...)
+	if fr.Error != nil {
+		return fr.Error
+	}
+	if err := im.reserveQuota(doc.OrgID, fr.StoredSize()); err != nil {
+		fr.Remove()
+		return err
+	}
+	if v, ok := fr.Versions[originalVersion]; !ok {
+		return fmt.Errorf("Missing %s version", originalVersion)
+	} else if v.Error != nil {
+		return v.Error
+	}
+
+	df := fr.DocumentFile(doc.ID, originalVersion)
+	if err := im.ds.AddDocumentFile(df); err != nil {
+		return err
+	}
+
+	return im.journal.Add(&journalEntry{
+		Path:       e.Path,
+		Document:   e.Document,
+		DocumentID: doc.ID,
+		FileID:     df.ID,
+	})
+}
+
+// getDocument returns document of entry e, creating it, with its participants,
+// if it's not imported yet.
+func (im *Importer) getDocument(e *Entry) (*model.Document, error) {
+	if docId, ok := im.docs[e.Document]; ok {
+		return im.ds.GetDocumentById(docId)
+	}
+
+	owner := im.owner
+	if e.Owner != "" {
+		usr, err := im.getUser(e.Owner)
+		if err != nil {
+			return nil, err
+		}
+		owner = usr
+	}
+	if owner == nil {
+		return nil, ErrorMissingOwner
+	}
+
+	participants := make([]*model.User, 0, len(e.Participants))
+	for _, login := range e.Participants {
+		usr, err := im.getUser(login)
+		if err != nil {
+			return nil, err
+		}
+		if usr.OrgID != owner.OrgID {
+			return nil, ErrorOrganizationMismatch
+		}
+		participants = append(participants, usr)
+	}
+
+	doc := &model.Document{
+		Name:      e.Document,
+		Status:    e.Status,
+		CreatedBy: owner.ID,
+		OrgID:     owner.OrgID,
+	}
+	if doc.Status == "" {
+		doc.Status = model.DefaultDocumentStatus
+	}
+	if err := model.Validate(doc); err != nil {
+		return nil, err
+	}
+
+	if err := im.ds.AddDocument(doc); err != nil {
+		return nil, err
+	}
+	im.docs[doc.Name] = doc.ID
+
+	for _, usr := range participants {
+		p := &model.DocumentParticipant{DocumentID: doc.ID, UserID: usr.ID, Role: model.DefaultParticipantRole}
+		if _, err := im.ds.AddDocumentParticipant(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// findFile returns the file of document docId stored from the upload sid, or
+// nil if there is none.
+func (im *Importer) findFile(docId int64, sid string) (*model.DocumentFile, error) {
+	if sid == "" {
+		return nil, nil
+	}
+
+	dfs, err := im.ds.GetAllDocumentFiles(docId)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := upload.FilenamePrefix(sid)
+	for _, df := range dfs {
+		if strings.HasPrefix(filepath.Base(df.Filepath), prefix) {
+			return df, nil
+		}
+	}
+	return nil, nil
+}
+
+// watermarkFor returns the watermark rendered for doc, or nil if doc is not
+// published or no watermark is set. Organizations may stamp their own text.
+func (im *Importer) watermarkFor(doc *model.Document) (*watermark.Watermark, error) {
+	if im.wm == nil || doc.Status != model.DocumentStatusPublished {
+		return nil, nil
+	}
+
+	wm := im.wm
+	if doc.OrgID != 0 {
+		org, err := im.ds.GetOrganizationById(doc.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		if org.Settings != nil && org.Settings.WatermarkText != "" {
+			var owm = *wm
+			owm.Text = org.Settings.WatermarkText
+			wm = &owm
+		}
+	}
+
+	return wm.Render(&watermark.Vars{
+		DocumentID:   doc.ID,
+		DocumentName: doc.Name,
+		Date:         time.Now().Format("2006-01-02"),
+	})
+}
+
+// checkQuota checks that files of organization orgId don't reach its storage
+// quota.
+func (im *Importer) checkQuota(orgId int64) error {
+	if orgId == 0 {
+		return nil
+	}
+
+	org, err := im.ds.GetOrganizationById(orgId)
+	if err != nil {
+		return err
+	}
+	if org.QuotaBytes == 0 {
+		return nil
+	}
+
+	size, err := im.ds.GetOrganizationUsage(orgId)
+	if err != nil {
+		return err
+	}
+	if size >= org.QuotaBytes {
+		return ErrorQuotaExceeded
+	}
+	return nil
+}
+
+// reserveQuota adds bytes of stored files to the storage usage of organization
+// orgId, unless they exceed its storage quota.
+func (im *Importer) reserveQuota(orgId int64, bytes int64) error {
+	if orgId == 0 {
+		return nil
+	}
+
+	org, err := im.ds.GetOrganizationById(orgId)
+	if err != nil {
+		return err
+	}
+
+	ok, err := im.ds.AddOrganizationUsage(orgId, bytes, org.QuotaBytes)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrorQuotaExceeded
+	}
+	return nil
+}
+
+// getUser returns user for the given login or email.
+func (im *Importer) getUser(loginOrEmail string) (*model.User, error) {
+	if usr, ok := im.users[loginOrEmail]; ok {
+		return usr, nil
+	}
+
+	usr, err := im.ds.GetUserByLogin(loginOrEmail)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown user %s", loginOrEmail)
+	}
+	im.users[loginOrEmail] = usr
+
+	return usr, nil
+}
+
+// copyToTemp copies file fpath of the source into a temporary file, as the
+// processors may move their source.
+func (im *Importer) copyToTemp(src Source, fpath string) (*upload.File, error) {
+	r, err := src.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	tmp, err := ioutil.TempFile(os.TempDir(), "simdoc")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	f := &upload.File{
+		Name:     path.Base(fpath),
+		Sid:      uuid.New(),
+		Filepath: tmp.Name(),
+		Size:     size,
+	}
+
+	f.Mime, err = mimetype.FromFilepath(f.Filepath)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	f.Type = mimetype.Base(f.Mime)
+
+	return f, nil
+}
@@ -0,0 +1,148 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// journalEntry represents an imported file, or a file being imported if
+// Pending is set.
+type journalEntry struct {
+	Path       string `json:"path"`
+	Document   string `json:"document"`
+	DocumentID int64  `json:"document_id"`
+	FileID     int64  `json:"file_id,omitempty"`
+	Sid        string `json:"sid,omitempty"` // Sid of the file being imported
+	Pending    bool   `json:"pending,omitempty"`
+}
+
+// Journal records imported files, one JSON object per line, so that an
+// interrupted import can be resumed by skipping files already imported. Files
+// are recorded as pending before being imported, so that the file in flight
+// when the import was interrupted is found rather than imported twice.
+type Journal struct {
+	mu      sync.Mutex
+	f       *os.File
+	done    map[string]*journalEntry // key is path in the source
+	pending map[string]*journalEntry // key is path in the source
+}
+
+// OpenJournal opens, or creates, the journal file fpath. If fpath is empty the
+// journal is only kept in memory.
+func OpenJournal(fpath string) (*Journal, error) {
+	j := &Journal{
+		done:    make(map[string]*journalEntry),
+		pending: make(map[string]*journalEntry),
+	}
+	if fpath == "" {
+		return j, nil
+	}
+
+	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := new(journalEntry)
+		// Skips partially written line of interrupted import.
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			continue
+		}
+		if e.Pending {
+			j.pending[e.Path] = e
+		} else {
+			j.done[e.Path] = e
+			delete(j.pending, e.Path)
+		}
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.f = f
+
+	return j, nil
+}
+
+// Done returns the journal entry of imported file for the given path.
+func (j *Journal) Done(path string) (*journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.done[path]
+	return e, ok
+}
+
+// Pending returns the journal entry of file, for the given path, whose import
+// was started but not recorded as done.
+func (j *Journal) Pending(path string) (*journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e, ok := j.pending[path]
+	return e, ok
+}
+
+// Documents returns IDs of imported documents keyed by document name,
+// including documents of pending files.
+func (j *Journal) Documents() map[string]int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	docs := make(map[string]int64, len(j.done)+len(j.pending))
+	for _, e := range j.pending {
+		docs[e.Document] = e.DocumentID
+	}
+	for _, e := range j.done {
+		docs[e.Document] = e.DocumentID
+	}
+	return docs
+}
+
+// Begin records file e as being imported.
+func (j *Journal) Begin(e *journalEntry) error {
+	e.Pending = true
+	return j.write(e)
+}
+
+// Add records imported file e.
+func (j *Journal) Add(e *journalEntry) error {
+	e.Pending = false
+	return j.write(e)
+}
+
+func (j *Journal) write(e *journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if e.Pending {
+		j.pending[e.Path] = e
+	} else {
+		j.done[e.Path] = e
+		delete(j.pending, e.Path)
+	}
+	if j.f == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	if j.f == nil {
+		return nil
+	}
+	return j.f.Close()
+}
@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, "import.journal")
+
+	j, err := OpenJournal(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Begin(&journalEntry{Path: "a.pdf", Document: "a", DocumentID: 1, Sid: "s1"})
+	j.Add(&journalEntry{Path: "a.pdf", Document: "a", DocumentID: 1, FileID: 10})
+	j.Begin(&journalEntry{Path: "b.pdf", Document: "b", DocumentID: 2, Sid: "s2"})
+	j.Close()
+
+	// Partially written line of an interrupted import.
+	f, _ := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"path":"c.pdf","docu`)
+	f.Close()
+
+	j, err = OpenJournal(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	tests := []struct {
+		path    string
+		done    bool
+		pending bool
+	}{
+		{"a.pdf", true, false},
+		{"b.pdf", false, true},
+		{"c.pdf", false, false},
+	}
+	for _, tt := range tests {
+		if _, ok := j.Done(tt.path); ok != tt.done {
+			t.Errorf("Done(%q) = %v, want %v", tt.path, ok, tt.done)
+		}
+		if _, ok := j.Pending(tt.path); ok != tt.pending {
+			t.Errorf("Pending(%q) = %v, want %v", tt.path, ok, tt.pending)
+		}
+	}
+
+	if p, _ := j.Pending("b.pdf"); p.Sid != "s2" {
+		t.Errorf("Sid = %q, want s2", p.Sid)
+	}
+	docs := j.Documents()
+	if docs["a"] != 1 || docs["b"] != 2 {
+		t.Errorf("Documents() = %v", docs)
+	}
+}
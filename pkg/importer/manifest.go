@@ -0,0 +1,120 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrorUnknownManifest = errors.New("Unknown manifest format, expecting .csv or .json")
+
+// Entry represents a file to import and the document it belongs to. Files with
+// the same document name are imported into the same document.
+type Entry struct {
+	Path         string   `json:"path"`         // Path of the file in the source
+	Document     string   `json:"document"`     // Name of the document
+	Status       string   `json:"status"`       // Status of the document, default to draft
+	Owner        string   `json:"owner"`        // Login or email of the document creator
+	Participants []string `json:"participants"` // Logins or emails of the document participants
+}
+
+// LoadManifest reads entries from manifest file fpath, either in CSV or JSON
+// format. The CSV has a header row with path, document, status, owner and
+// participants columns, participants are separated by ";". The JSON is an
+// array of Entry.
+func LoadManifest(fpath string) ([]*Entry, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(fpath)) {
+	case ".csv":
+		return loadCSVManifest(f)
+	case ".json":
+		var entries []*Entry
+		if err := json.NewDecoder(f).Decode(&entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	default:
+		return nil, ErrorUnknownManifest
+	}
+}
+
+// EntriesFromSource returns an entry for every file in the source, each one
+// as its own document named after the file without extension.
+func EntriesFromSource(src Source) ([]*Entry, error) {
+	files, err := src.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(files))
+	for _, fpath := range files {
+		name := filepath.Base(fpath)
+		entries = append(entries, &Entry{
+			Path:     fpath,
+			Document: strings.TrimSuffix(name, filepath.Ext(name)),
+		})
+	}
+
+	return entries, nil
+}
+
+func loadCSVManifest(r io.Reader) ([]*Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["path"]; !ok {
+		return nil, errors.New("manifest: missing path column")
+	}
+
+	var entries []*Entry
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("manifest: line %d: %s", line, err)
+		}
+
+		get := func(col string) string {
+			if i, ok := cols[col]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+
+		e := &Entry{
+			Path:     get("path"),
+			Document: get("document"),
+			Status:   get("status"),
+			Owner:    get("owner"),
+		}
+		for _, p := range strings.Split(get("participants"), ";") {
+			if p = strings.TrimSpace(p); p != "" {
+				e.Participants = append(e.Participants, p)
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,132 @@
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrorFileNotFound = errors.New("File not found in source")
+
+// Source represents a tree of files to import, either a directory or a ZIP
+// archive. Paths are relative to the root of the source and use forward slashes.
+type Source interface {
+	// Files returns sorted paths of all regular files in the source.
+	Files() ([]string, error)
+
+	// Open opens file for the given path.
+	Open(path string) (io.ReadCloser, error)
+
+	Close() error
+}
+
+// OpenSource opens directory or ZIP archive, for the given fpath, as Source.
+func OpenSource(fpath string) (Source, error) {
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &dirSource{fpath}, nil
+	}
+
+	zr, err := zip.OpenReader(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	zs := &zipSource{zr, make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isHidden(f.Name) {
+			continue
+		}
+		zs.files[f.Name] = f
+	}
+
+	return zs, nil
+}
+
+type dirSource struct {
+	root string
+}
+
+func (s *dirSource) Files() ([]string, error) {
+	var files []string
+	err := filepath.Walk(s.root, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, fpath)
+		if err != nil {
+			return err
+		}
+		if rel != "." && isHidden(rel) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func (s *dirSource) Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.root, filepath.FromSlash(filepath.Clean("/"+path))))
+	if os.IsNotExist(err) {
+		return nil, ErrorFileNotFound
+	}
+	return f, err
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+type zipSource struct {
+	*zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func (s *zipSource) Files() ([]string, error) {
+	files := make([]string, 0, len(s.files))
+	for name := range s.files {
+		files = append(files, name)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func (s *zipSource) Open(path string) (io.ReadCloser, error) {
+	f, ok := s.files[path]
+	if !ok {
+		return nil, ErrorFileNotFound
+	}
+	return f.Open()
+}
+
+// isHidden checks whether any element of path starts with a dot, for instance
+// ".git" or ".DS_Store".
+func isHidden(path string) bool {
+	for _, e := range strings.Split(filepath.ToSlash(path), "/") {
+		if strings.HasPrefix(e, ".") {
+			return true
+		}
+	}
+	return false
+}
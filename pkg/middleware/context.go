@@ -36,3 +36,42 @@ func ToDoc(c *web.C) *model.Document {
 	}
 	return d
 }
+
+// APITokenToC sets the APIToken, the request is authenticated with, in the
+// current web context.
+func APITokenToC(c *web.C, token *model.APIToken) {
+	c.Env["apiToken"] = token
+}
+
+// ToAPIToken returns the APIToken from the current request context.
+func ToAPIToken(c *web.C) *model.APIToken {
+	var v = c.Env["apiToken"]
+
+	t, ok := v.(*model.APIToken)
+	if !ok {
+		return nil
+	}
+	return t
+}
+
+// OrganizationToC sets the Organization of current user in the current web
+// context.
+func OrganizationToC(c *web.C, org *model.Organization) {
+	c.Env["organization"] = org
+}
+
+// RoleToC sets the Role of current user in the current web context.
+func RoleToC(c *web.C, role *model.Role) {
+	c.Env["role"] = role
+}
+
+// ToRole returns the Role of current user from the current request context.
+func ToRole(c *web.C) *model.Role {
+	var v = c.Env["role"]
+
+	r, ok := v.(*model.Role)
+	if !ok {
+		return nil
+	}
+	return r
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/zenazn/goji/web"
+)
+
+var ErrorInvalidCORSConfig = errors.New("Invalid CORS configuration")
+
+// CORSConfig represents the cross-origin resource sharing policy of the API.
+// Requests from origins not allowed get no CORS header, so that browsers
+// refuse them.
+type CORSConfig struct {
+	// Allowed origins, either exact, such as "https://app.example.com", or
+	// patterns, such as "https://*.example.com". "*" allows any origin.
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+
+	// Seconds preflight responses can be cached by browsers.
+	MaxAge int `json:"max_age"`
+
+	// Policies of routes under a path, overriding allowed methods and adding
+	// allowed headers. The longest matching path applies.
+	Routes []*CORSRoute `json:"routes"`
+}
+
+// CORSRoute represents the CORS policy of the routes under Path.
+type CORSRoute struct {
+	Path           string   `json:"path"`
+	AllowedMethods []string `json:"allowed_methods"` // Default to the methods of CORSConfig
+	AllowedHeaders []string `json:"allowed_headers"` // Added to the headers of CORSConfig
+}
+
+// DefaultCORSConfig is the policy used without configuration. No origin is
+// allowed, so that only same origin requests are made by browsers.
+var DefaultCORSConfig = newCORSConfig()
+
+func newCORSConfig() *CORSConfig {
+	return &CORSConfig{
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		ExposedHeaders: []string{"Link", "Content-Disposition", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
+		MaxAge:         600,
+		Routes: []*CORSRoute{
+			{Path: "/api/documents", AllowedHeaders: []string{"Content-Range", "Content-Disposition"}},
+		},
+	}
+}
+
+// LoadCORSConfig loads CORS policy from JSON file at path. Unset fields default
+// to those of DefaultCORSConfig. Origins allowing any site can't be allowed
+// credentials, as any site could then make requests on behalf of users.
+func LoadCORSConfig(path string) (*CORSConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := newCORSConfig()
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxAge < 0 {
+		return nil, ErrorInvalidCORSConfig
+	}
+	for _, o := range cfg.AllowedOrigins {
+		if _, err := matchOrigin(o, ""); err != nil {
+			return nil, ErrorInvalidCORSConfig
+		}
+		if cfg.AllowCredentials && anyOrigin(o) {
+			return nil, ErrorInvalidCORSConfig
+		}
+	}
+	for _, rt := range cfg.Routes {
+		if !strings.HasPrefix(rt.Path, "/") {
+			return nil, ErrorInvalidCORSConfig
+		}
+	}
+	return cfg, nil
+}
+
+// CORS returns a middleware applying policy cfg. Preflight requests are
+// answered with the methods and headers allowed on the requested route, and
+// aren't passed to the router.
+func CORS(cfg *CORSConfig) func(*web.C, http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			var origin = r.Header.Get("Origin")
+			if origin == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+
+			var preflight = r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if !cfg.allowsOrigin(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if !preflight {
+				cfg.setOriginHeaders(w, origin)
+				if len(cfg.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			methods, headers := cfg.routePolicy(r.URL.Path)
+			if !containsFold(methods, r.Header.Get("Access-Control-Request-Method")) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			for _, rh := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if rh = strings.TrimSpace(rh); rh != "" && !containsFold(headers, rh) {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+
+			cfg.setOriginHeaders(w, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// allowsOrigin checks whether requests from origin are allowed.
+func (cfg *CORSConfig) allowsOrigin(origin string) bool {
+	for _, o := range cfg.AllowedOrigins {
+		if ok, _ := matchOrigin(o, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setOriginHeaders sets the response headers allowing origin. The origin is
+// sent back rather than "*" with credentials, as browsers require.
+func (cfg *CORSConfig) setOriginHeaders(w http.ResponseWriter, origin string) {
+	if cfg.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	} else if containsFold(cfg.AllowedOrigins, "*") {
+		origin = "*"
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+}
+
+// routePolicy returns the methods and headers allowed on path p.
+func (cfg *CORSConfig) routePolicy(p string) ([]string, []string) {
+	var methods, headers = cfg.AllowedMethods, cfg.AllowedHeaders
+
+	var route *CORSRoute
+	for _, rt := range cfg.Routes {
+		if (p == rt.Path || strings.HasPrefix(p, strings.TrimSuffix(rt.Path, "/")+"/")) && (route == nil || len(rt.Path) > len(route.Path)) {
+			route = rt
+		}
+	}
+	if route != nil {
+		if len(route.AllowedMethods) > 0 {
+			methods = route.AllowedMethods
+		}
+		headers = append(append([]string{}, headers...), route.AllowedHeaders...)
+	}
+
+	if !containsFold(methods, "OPTIONS") {
+		methods = append(append([]string{}, methods...), "OPTIONS")
+	}
+	return methods, headers
+}
+
+// matchOrigin checks whether origin matches pattern. "*" in pattern matches
+// any sequence of characters other than "/", such as subdomains.
+func matchOrigin(pattern, origin string) (bool, error) {
+	if pattern == "*" {
+		return true, nil
+	}
+	return path.Match(strings.ToLower(pattern), strings.ToLower(origin))
+}
+
+// anyOrigin checks whether pattern matches origins of any site, such as "*" or
+// "https://*".
+func anyOrigin(pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	i := strings.Index(pattern, "://")
+	return i >= 0 && strings.Trim(pattern[i+3:], "*") == ""
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCORSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		json  string
+		valid bool
+	}{
+		{"defaults", `{}`, true},
+		{"origins", `{"allowed_origins": ["https://app.example.com", "https://*.example.org"]}`, true},
+		{"origins with credentials", `{"allowed_origins": ["https://app.example.com", "https://*.example.org"], "allow_credentials": true}`, true},
+		{"any origin", `{"allowed_origins": ["*"]}`, true},
+		{"any origin with credentials", `{"allowed_origins": ["*"], "allow_credentials": true}`, false},
+		{"any origin among others with credentials", `{"allowed_origins": ["https://app.example.com", "*"], "allow_credentials": true}`, false},
+		{"any https origin with credentials", `{"allowed_origins": ["https://*"], "allow_credentials": true}`, false},
+		{"bad pattern", `{"allowed_origins": ["https://[.example.com"]}`, false},
+		{"negative max age", `{"max_age": -1}`, false},
+		{"relative route", `{"routes": [{"path": "api"}]}`, false},
+		{"not json", `allowed_origins`, false},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(dir, string('a'+rune(i))+".json")
+		if err := ioutil.WriteFile(path, []byte(tt.json), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := LoadCORSConfig(path)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: error %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
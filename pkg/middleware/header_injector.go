@@ -2,51 +2,96 @@ package middleware
 
 import (
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/zenazn/goji/web"
 )
 
-// OptionsRequestHeaderInjector injects HTTP header fields for OPTIONS request.
-func OptionsRequestHeaderInjector(c *web.C, h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "OPTIONS" {
-			h := []string{"Authorization", "Content-Type"}
+// Cache-Control policies of responses.
+const (
+	// CacheNoStore prevents responses, such as API ones, from being stored by
+	// browsers and shared caches.
+	CacheNoStore = "private, no-store"
 
-			if strings.HasPrefix(r.URL.Path, "/api/documents") {
-				h = append(h, "Content-Range")
-				h = append(h, "Content-Disposition")
-			}
+	// CacheRevalidate lets browsers store responses but revalidate them before
+	// each use.
+	CacheRevalidate = "private, no-cache"
 
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", strings.Join(h, ","))
-			w.Header().Set("Allow", "HEAD,GET,POST,PUT,DELETE,OPTIONS")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	// CacheImmutable lets browsers reuse responses, whose content never changes
+	// for a given URL, for a year without revalidation.
+	CacheImmutable = "private, max-age=31536000, immutable"
+)
 
-		h.ServeHTTP(w, r)
-	})
+// HeaderPolicy represents security headers sent with responses. Empty fields
+// aren't sent.
+type HeaderPolicy struct {
+	ContentSecurityPolicy string
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+
+	// Strict-Transport-Security is only sent over HTTPS, as browsers ignore it
+	// over HTTP. Disabled if zero.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+}
+
+// DefaultHeaderPolicy is suited to the API and uploaded files: nothing is
+// loaded nor framed by responses, and signed URLs don't leak to other sites
+// through the Referer header.
+var DefaultHeaderPolicy = &HeaderPolicy{
+	ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+	ReferrerPolicy:        "no-referrer",
+	PermissionsPolicy:     "camera=(), microphone=(), geolocation=(), payment=()",
+	HSTSMaxAge:            180 * 24 * time.Hour,
+}
 
+// SecurityHeaders returns a middleware setting the security headers of policy
+// p on the responses.
+func SecurityHeaders(p *HeaderPolicy) func(*web.C, http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		return p.Handler(h)
+	}
 }
 
-// HeaderInjector injects HTTP header fields into the response.
-func HeaderInjector(c *web.C, h http.Handler) http.Handler {
+// Handler returns a handler setting the security headers of p before calling
+// h, for handlers outside of the routers such as the file server.
+func (p *HeaderPolicy) Handler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Access-Control-Allow-Origin", "*")
-		w.Header().Add("X-Frame-Options", "DENY")
-		w.Header().Add("X-Content-Type-Options", "nosniff")
-		w.Header().Add("X-XSS-Protection", "1; mode=block")
-		w.Header().Add("Cache-Control", "no-cache")
-		w.Header().Add("Cache-Control", "no-store")
-		w.Header().Add("Cache-Control", "max-age=0")
-		w.Header().Add("Cache-Control", "must-revalidate")
-		w.Header().Add("Cache-Control", "value")
-		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
-		w.Header().Set("Expires", "Thu, 01 Jan 1970 00:00:00 GMT")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-XSS-Protection", "1; mode=block")
+
+		if p.ContentSecurityPolicy != "" {
+			w.Header().Set("Content-Security-Policy", p.ContentSecurityPolicy)
+		}
+		if p.ReferrerPolicy != "" {
+			w.Header().Set("Referrer-Policy", p.ReferrerPolicy)
+		}
+		if p.PermissionsPolicy != "" {
+			w.Header().Set("Permissions-Policy", p.PermissionsPolicy)
+		}
+		if p.HSTSMaxAge > 0 && r.TLS != nil {
+			v := "max-age=" + strconv.FormatInt(int64(p.HSTSMaxAge/time.Second), 10)
+			if p.HSTSIncludeSubdomains {
+				v += "; includeSubDomains"
+			}
+			w.Header().Set("Strict-Transport-Security", v)
+		}
 
 		h.ServeHTTP(w, r)
 	})
 }
+
+// CacheControl returns a middleware setting Cache-Control of the responses to
+// policy, such as CacheNoStore. Handlers can still set their own.
+func CacheControl(policy string) func(*web.C, http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", policy)
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zenazn/goji/web"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *HeaderPolicy
+		https  bool
+		want   map[string]string // Expected headers, empty value means not sent
+	}{
+		{
+			name:   "default over HTTP",
+			policy: DefaultHeaderPolicy,
+			want: map[string]string{
+				"X-Frame-Options":           "DENY",
+				"X-Content-Type-Options":    "nosniff",
+				"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+				"Referrer-Policy":           "no-referrer",
+				"Permissions-Policy":        "camera=(), microphone=(), geolocation=(), payment=()",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name:   "default over HTTPS",
+			policy: DefaultHeaderPolicy,
+			https:  true,
+			want:   map[string]string{"Strict-Transport-Security": "max-age=15552000"},
+		},
+		{
+			name:   "HSTS with subdomains",
+			policy: &HeaderPolicy{HSTSMaxAge: time.Hour, HSTSIncludeSubdomains: true},
+			https:  true,
+			want:   map[string]string{"Strict-Transport-Security": "max-age=3600; includeSubDomains"},
+		},
+		{
+			name:   "disabled",
+			policy: &HeaderPolicy{},
+			https:  true,
+			want: map[string]string{
+				"X-Frame-Options":           "DENY",
+				"X-Content-Type-Options":    "nosniff",
+				"Content-Security-Policy":   "",
+				"Referrer-Policy":           "",
+				"Permissions-Policy":        "",
+				"Strict-Transport-Security": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/documents", nil)
+		if tt.https {
+			r.TLS = &tls.ConnectionState{}
+		}
+
+		// Headers are set both by the middleware and the handler.
+		for _, h := range []http.Handler{
+			SecurityHeaders(tt.policy)(&web.C{}, http.NotFoundHandler()),
+			tt.policy.Handler(http.NotFoundHandler()),
+		} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			for name, want := range tt.want {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s: %s = %q, want %q", tt.name, name, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler string // Cache-Control set by the handler, if any
+		want    string
+	}{
+		{"policy", "", CacheNoStore},
+		{"set by handler", CacheImmutable, CacheImmutable},
+	}
+
+	for _, tt := range tests {
+		h := CacheControl(CacheNoStore)(&web.C{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.handler != "" {
+				w.Header().Set("Cache-Control", tt.handler)
+			}
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api/documents", nil))
+
+		if got := w.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/ratelimit"
+
+	"github.com/zenazn/goji/web"
+)
+
+var ErrorRateLimited = errors.New("Rate limit exceeded")
+
+// RateLimit limits API calls of each authenticated user, or of each IP for
+// anonymous requests, with the "apiRateLimiter" of the env.
+func RateLimit(c *web.C, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !takeRateLimit(c, w, "api", rateLimitKey(c, r), 1) {
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// LimitLogins returns handler that limits login attempts of each IP, whether
+// they succeed or not, with the "loginRateLimiter" of the env before calling
+// h.
+func LimitLogins(h web.HandlerFunc) web.HandlerFunc {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		if !takeRateLimit(&c, w, "login", "ip:"+util.RemoteIP(r), 1) {
+			return
+		}
+		h(c, w, r)
+	}
+}
+
+// LimitUploads returns handler that limits uploaded bytes of each user, or of
+// each IP for anonymous requests, with the "uploadRateLimiter" of the env
+// before calling h. Bodies of unknown length are limited while being read.
+func LimitUploads(h web.HandlerFunc) web.HandlerFunc {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var key = rateLimitKey(&c, r)
+
+		if r.ContentLength >= 0 {
+			if !takeRateLimit(&c, w, "upload", key, float64(r.ContentLength)) {
+				return
+			}
+		} else if l, ok := c.Env["uploadRateLimiter"].(*ratelimit.Limiter); ok && l != nil {
+			r.Body = &rateLimitedBody{r.Body, l, "upload:" + key}
+		}
+		h(c, w, r)
+	}
+}
+
+// rateLimitKey returns the key of the client of r: current user if any, its
+// IP otherwise.
+func rateLimitKey(c *web.C, r *http.Request) string {
+	if usr := ToUser(c); usr != nil {
+		return "user:" + strconv.FormatInt(usr.ID, 10)
+	}
+	return "ip:" + util.RemoteIP(r)
+}
+
+// takeRateLimit takes n tokens of key from the limiter of budget, such as
+// "api" for the "apiRateLimiter" of the env, setting RateLimit headers. If the
+// limit is exceeded, a too many requests response is given and false is
+// returned. Requests are allowed when there is no limiter or the store fails.
+func takeRateLimit(c *web.C, w http.ResponseWriter, budget, key string, n float64) bool {
+	l, ok := c.Env[budget+"RateLimiter"].(*ratelimit.Limiter)
+	if !ok || l == nil {
+		return true
+	}
+
+	// Budgets may share their store.
+	res, err := l.Take(budget+":"+key, n)
+	if err != nil {
+		log.Printf("ratelimit: %+v\n", err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// ceilSeconds returns d in seconds, rounded up.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// rateLimitedBody takes a token of key for each byte read, failing once the
+// limit is exceeded.
+type rateLimitedBody struct {
+	io.ReadCloser
+	limiter *ratelimit.Limiter
+	key     string
+}
+
+func (b *rateLimitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		res, terr := b.limiter.Take(b.key, float64(n))
+		if terr == nil && !res.Allowed {
+			return n, ErrorRateLimited
+		}
+	}
+	return n, err
+}
@@ -6,16 +6,56 @@ import (
 	"github.com/goji/context"
 	"github.com/zenazn/goji/web"
 
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
 	"github.com/gedex/simdoc/pkg/util"
 )
 
-// UserToContextInjector injects user information into the context.
+// UserToContextInjector injects user information into the context. Requests
+// authenticated with API token have the token injected too, see APITokenScope.
+// The datastore is scoped to the organization of the user, unless the user is
+// a global admin, see model.Organization. A client certificate is a single
+// factor, so it doesn't authenticate users who have, or must have, two-factor
+// authentication.
 func UserToContextInjector(c *web.C, h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		var ctx = context.FromC(*c)
-		var user = util.GetUserFromRequest(ctx, r)
+		var user *model.User
+		if util.IsAPITokenRequest(r) {
+			var token *model.APIToken
+			token, user = util.GetAPITokenFromRequest(ctx, r)
+			if token != nil {
+				APITokenToC(c, token)
+			}
+		} else {
+			user = util.GetUserFromRequest(ctx, r)
+		}
 		if user != nil && user.ID != 0 {
+			role, err := datastore.GetRoleByName(ctx, user.Role)
+			if user.OrgID != 0 {
+				role = role.InOrganization()
+			}
+
+			var require2FA, _ = c.Env["require2FAAdmin"].(bool)
+			if util.IsClientCertLogin(r) && (user.TOTPEnabled || err != nil || (require2FA && role.IsAdmin())) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
 			UserToC(c, user)
+			if user.OrgID != 0 {
+				if org, err := datastore.GetOrganizationById(ctx, user.OrgID); err == nil {
+					OrganizationToC(c, org)
+				}
+			}
+			if err == nil && user.Verified {
+				RoleToC(c, role)
+			}
+
+			if !ToRole(c).Can(model.PermOrganizationsManage) {
+				var ds = datastore.FromContext(ctx).ForOrganization(user.OrgID)
+				context.Set(c, datastore.NewContext(ctx, ds))
+			}
 		}
 		h.ServeHTTP(w, r)
 	}
@@ -23,6 +63,32 @@ func UserToContextInjector(c *web.C, h http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// APITokenScope returns middleware that restricts requests authenticated with
+// API token to the scopes of the token. Safe requests (GET, HEAD and OPTIONS)
+// require readScope, others require writeScope. Requests authenticated
+// otherwise are not restricted.
+func APITokenScope(readScope, writeScope string) func(*web.C, http.Handler) http.Handler {
+	return func(c *web.C, h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			var token = ToAPIToken(c)
+			if token != nil {
+				var scope = writeScope
+				switch r.Method {
+				case "GET", "HEAD", "OPTIONS":
+					scope = readScope
+				}
+				if !token.HasScope(scope) {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
 // UserAuthorizer verifies whether current context has authenticated user information.
 // If not, gives unauthorized response.
 func UserAuthorizer(c *web.C, h http.Handler) http.Handler {
@@ -37,22 +103,26 @@ func UserAuthorizer(c *web.C, h http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-// UserAdminAuthorizer verifies whether current context has authenticated user
-// with admin role. If not, gives unauthorized or forbidden response.
-func UserAdminAuthorizer(c *web.C, h http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		var user = ToUser(c)
+// RequirePermission returns handler that verifies whether current context has
+// authenticated user whose role grants permission perm before calling h. If
+// not, gives unauthorized or forbidden response. When the policy requires it,
+// users whose role grants admin permissions must have two-factor
+// authentication too.
+func RequirePermission(perm string, h web.HandlerFunc) web.HandlerFunc {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var user = ToUser(&c)
+		var require2FA, _ = c.Env["require2FAAdmin"].(bool)
 		switch {
 		case user == nil:
 			w.WriteHeader(http.StatusUnauthorized)
 			return
-		// @todo FIXME
-		case user != nil && user.ID != 1:
+		case !ToRole(&c).Can(perm):
+			w.WriteHeader(http.StatusForbidden)
+			return
+		case require2FA && ToRole(&c).IsAdmin() && !user.TOTPEnabled:
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
-		h.ServeHTTP(w, r)
+		h(c, w, r)
 	}
-
-	return http.HandlerFunc(fn)
 }
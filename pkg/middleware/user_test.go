@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gojictx "github.com/goji/context"
+	"github.com/zenazn/goji/web"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+func TestRequirePermission(t *testing.T) {
+	var (
+		user    = &model.User{ID: 1, Role: model.RoleAdmin} // Role name alone doesn't matter
+		enabled = &model.User{ID: 2, Role: "manager", TOTPEnabled: true}
+		manager = &model.Role{Name: "manager", Permissions: []string{model.PermUsersUpdate}}
+		viewer  = &model.Role{Name: "viewer", Permissions: []string{model.PermUsersRead}}
+	)
+
+	tests := []struct {
+		name       string
+		user       *model.User
+		role       *model.Role
+		perm       string
+		require2FA bool
+		want       int
+	}{
+		{"anonymous", nil, nil, model.PermUsersRead, false, http.StatusUnauthorized},
+		{"without permission", user, viewer, model.PermUsersUpdate, false, http.StatusForbidden},
+		{"with permission", user, viewer, model.PermUsersRead, false, http.StatusOK},
+		{"custom admin role without 2FA", user, manager, model.PermUsersUpdate, true, http.StatusForbidden},
+		{"custom admin role with 2FA", enabled, manager, model.PermUsersUpdate, true, http.StatusOK},
+		{"non admin role without 2FA", user, viewer, model.PermUsersRead, true, http.StatusOK},
+		{"2FA not required", user, manager, model.PermUsersUpdate, false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		c := web.C{Env: map[interface{}]interface{}{"require2FAAdmin": tt.require2FA}}
+		if tt.user != nil {
+			UserToC(&c, tt.user)
+			RoleToC(&c, tt.role)
+		}
+
+		h := RequirePermission(tt.perm, func(c web.C, w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		h(c, w, httptest.NewRequest("GET", "/", nil))
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+// fakeUserstore is an in-memory datastore of users and roles. Other methods of
+// the datastore are not implemented.
+type fakeUserstore struct {
+	datastore.Datastore
+	users []*model.User
+	roles []*model.Role
+}
+
+func (s *fakeUserstore) GetUserByLoginName(login string) (*model.User, error) {
+	for _, u := range s.users {
+		if u.Login == login {
+			cu := *u
+			return &cu, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeUserstore) GetRoleByName(name string) (*model.Role, error) {
+	for _, r := range s.roles {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeUserstore) ForOrganization(orgId int64) datastore.Datastore {
+	return s
+}
+
+func TestUserToContextInjectorClientCert(t *testing.T) {
+	ds := &fakeUserstore{
+		users: []*model.User{
+			{ID: 1, Login: "alice", Email: "alice@example.com", Role: "viewer", Verified: true},
+			{ID: 2, Login: "bob", Email: "bob@example.com", Role: "viewer", Verified: true, TOTPEnabled: true},
+			{ID: 3, Login: "carol", Email: "carol@example.com", Role: "manager", Verified: true},
+			{ID: 4, Login: "dave", Email: "dave@example.com", Role: "viewer"},
+		},
+		roles: []*model.Role{
+			{Name: "viewer", Permissions: []string{model.PermUsersRead}},
+			{Name: "manager", Permissions: []string{model.PermUsersUpdate}},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		cn         string
+		emails     []string
+		require2FA bool
+		want       int64 // ID of the injected user, zero if none
+	}{
+		{name: "login", cn: "alice", want: 1},
+		{name: "email as login", cn: "alice@example.com"},
+		{name: "email address", emails: []string{"alice@example.com"}},
+		{name: "unverified", cn: "dave"},
+		{name: "two-factor enabled", cn: "bob"},
+		{name: "admin role without policy", cn: "carol", want: 3},
+		{name: "admin role with policy", cn: "carol", require2FA: true},
+		{name: "non admin role with policy", cn: "alice", require2FA: true, want: 1},
+		{name: "unknown login", cn: "eve"},
+	}
+
+	for _, tt := range tests {
+		c := web.C{Env: map[interface{}]interface{}{"require2FAAdmin": tt.require2FA}}
+		gojictx.Set(&c, datastore.NewContext(context.Background(), ds))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.cn}, EmailAddresses: tt.emails}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+		var got *model.User
+		h := UserToContextInjector(&c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ToUser(&c)
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		switch {
+		case tt.want == 0 && got != nil:
+			t.Errorf("%s: user %s, want none", tt.name, got.Login)
+		case tt.want != 0 && (got == nil || got.ID != tt.want):
+			t.Errorf("%s: user %+v, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAPITokenScope(t *testing.T) {
+	var (
+		read  = &model.APIToken{Scopes: []string{model.ScopeRead}}
+		write = &model.APIToken{Scopes: []string{model.ScopeDocumentsWrite}}
+		admin = &model.APIToken{Scopes: []string{model.ScopeAdmin}}
+	)
+
+	tests := []struct {
+		name   string
+		token  *model.APIToken
+		method string
+		want   int
+	}{
+		{"not API token", nil, "DELETE", http.StatusOK},
+		{"read with read scope", read, "GET", http.StatusOK},
+		{"head with read scope", read, "HEAD", http.StatusOK},
+		{"write with read scope", read, "POST", http.StatusForbidden},
+		{"read with write scope", write, "GET", http.StatusOK},
+		{"write with write scope", write, "PATCH", http.StatusOK},
+		{"delete with write scope", write, "DELETE", http.StatusOK},
+		{"write with admin scope", admin, "PUT", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		c := web.C{Env: map[interface{}]interface{}{}}
+		if tt.token != nil {
+			APITokenToC(&c, tt.token)
+		}
+
+		h := APITokenScope(model.ScopeRead, model.ScopeDocumentsWrite)(&c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, "/", nil))
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	// Admin routes require admin scope even to read.
+	c := web.C{Env: map[interface{}]interface{}{}}
+	APITokenToC(&c, write)
+	h := APITokenScope(model.ScopeAdmin, model.ScopeAdmin)(&c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("admin route with write scope: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,54 @@
+package model
+
+const (
+	// ScopeRead allows read-only requests.
+	ScopeRead = "read"
+
+	// ScopeDocumentsWrite allows to create, update and delete documents and
+	// their files. It implies ScopeRead.
+	ScopeDocumentsWrite = "documents:write"
+
+	// ScopeAdmin allows any request the user is allowed to. It implies other
+	// scopes.
+	ScopeAdmin = "admin"
+)
+
+// Scopes lists valid scopes of API token.
+var Scopes = []string{ScopeRead, ScopeDocumentsWrite, ScopeAdmin}
+
+// APIToken represents a long-lived personal token of a user, used by scripts
+// and integrations instead of the password. Only the hash of the token is
+// stored.
+type APIToken struct {
+	ID       int64    `meddler:"id,pk"       json:"id"`
+	UserID   int64    `meddler:"user_id"     json:"user_id"`
+	Name     string   `meddler:"name"        validate:"nonzero,max=255" json:"name"`
+	Scopes   []string `meddler:"scopes,json" json:"scopes"`
+	Hash     string   `meddler:"hash"        json:"-"`
+	LastUsed int64    `meddler:"last_used"   json:"last_used_at"` // Unix time, updated at most once a minute
+	Created  int64    `meddler:"created"     json:"created_at"`
+}
+
+// HasScope checks whether token t is granted scope, directly or by a scope
+// that implies it.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		switch {
+		case s == scope, s == ScopeAdmin:
+			return true
+		case s == ScopeDocumentsWrite && scope == ScopeRead:
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidScope checks whether scope is a known scope.
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package model
+
+import "testing"
+
+func TestAPITokenHasScope(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{nil, ScopeRead, false},
+		{[]string{ScopeRead}, ScopeRead, true},
+		{[]string{ScopeRead}, ScopeDocumentsWrite, false},
+		{[]string{ScopeRead}, ScopeAdmin, false},
+		{[]string{ScopeDocumentsWrite}, ScopeRead, true},
+		{[]string{ScopeDocumentsWrite}, ScopeDocumentsWrite, true},
+		{[]string{ScopeDocumentsWrite}, ScopeAdmin, false},
+		{[]string{ScopeAdmin}, ScopeRead, true},
+		{[]string{ScopeAdmin}, ScopeDocumentsWrite, true},
+		{[]string{ScopeAdmin}, ScopeAdmin, true},
+		{[]string{"unknown"}, ScopeRead, false},
+	}
+
+	for _, tt := range tests {
+		tok := &APIToken{Scopes: tt.scopes}
+		if got := tok.HasScope(tt.scope); got != tt.want {
+			t.Errorf("%v: HasScope(%s) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+}
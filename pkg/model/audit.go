@@ -0,0 +1,199 @@
+package model
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+const (
+	AuditUserLocked          = "user.locked"
+	AuditUserUnlocked        = "user.unlocked"
+	AuditUserCreated         = "user.created"
+	AuditUserUpdated         = "user.updated"
+	AuditUserDeactivated     = "user.deactivated"
+	AuditUserActivated       = "user.activated"
+	AuditUserDeleted         = "user.deleted"
+	AuditUserLogin           = "user.login"
+	AuditUserLoginFailed     = "user.login_failed"
+	AuditUserLogout          = "user.logout"
+	AuditUserSessionsRevoked = "user.sessions_revoked"
+	AuditPasswordChanged     = "user.password_changed"
+	AuditPasswordReset       = "user.password_reset"
+	AuditTOTPEnabled         = "user.totp_enabled"
+	AuditTOTPDisabled        = "user.totp_disabled"
+	AuditAPITokenCreated     = "api_token.created"
+	AuditAPITokenDeleted     = "api_token.deleted"
+	AuditIPLocked            = "ip.locked"
+
+	AuditRoleCreated         = "role.created"
+	AuditRoleUpdated         = "role.updated"
+	AuditRoleDeleted         = "role.deleted"
+	AuditGroupCreated        = "group.created"
+	AuditGroupUpdated        = "group.updated"
+	AuditGroupDeleted        = "group.deleted"
+	AuditGroupMemberAdded    = "group.member_added"
+	AuditGroupMemberRemoved  = "group.member_removed"
+	AuditOrganizationCreated = "organization.created"
+	AuditOrganizationUpdated = "organization.updated"
+	AuditOrganizationDeleted = "organization.deleted"
+
+	AuditDocumentCreated   = "document.created"
+	AuditDocumentDeleted   = "document.deleted"
+	AuditDocumentPublished = "document.published"
+	AuditDocumentShared    = "document.shared"
+	AuditDocumentUnshared  = "document.unshared"
+	AuditDocumentArchived  = "document.archive_downloaded"
+	AuditDocumentsImported = "document.imported"
+	AuditFileUploaded      = "file.uploaded"
+	AuditFileDeleted       = "file.deleted"
+	AuditFileDownloaded    = "file.downloaded"
+)
+
+// AuditEvent represents a security or document relevant event, for instance
+// an account locked out after failed logins, or a downloaded file. Events are
+// append-only and hash-chained: Hash covers the event along with the hash of
+// the previous event, so that altered, inserted or removed events are
+// detected, see Verify. Events are chained shortly after they're added, in the
+// order of Seq, with an HMAC whose key is kept outside the datastore, so that
+// the chain can't be rebuilt by whoever can write to the datastore.
+type AuditEvent struct {
+	ID         int64                   `meddler:"id,pk"        json:"id"`
+	OrgID      int64                   `meddler:"org_id"       json:"org_id"` // Organization the event belongs to
+	Action     string                  `meddler:"action"       json:"action"`
+	ActorID    int64                   `meddler:"actor_id"     json:"actor_id"` // User who caused the event, zero if anonymous
+	Target     string                  `meddler:"target"       json:"target"`   // What the event is about, for instance "user:42"
+	DocumentID int64                   `meddler:"document_id"  json:"document_id,omitempty"`
+	IP         string                  `meddler:"ip"           json:"ip"`
+	UserAgent  string                  `meddler:"user_agent"   json:"user_agent"`
+	Details    string                  `meddler:"details"      json:"details"`
+	Changes    map[string]*AuditChange `meddler:"changes,json" json:"changes,omitempty"` // Changed fields of the target, keyed by JSON name
+	Created    int64                   `meddler:"created"      json:"created_at"`
+	Seq        int64                   `meddler:"seq"          json:"seq"` // Position in the chain, zero until chained
+	PrevHash   string                  `meddler:"prev_hash"    json:"prev_hash"`
+	Hash       string                  `meddler:"hash"         json:"hash"`
+	Keyed      bool                    `meddler:"keyed"        json:"keyed"` // Whether Hash is an HMAC, false for events chained before the key was set
+}
+
+// AuditChange represents a changed field, in JSON.
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// AuditFilter represents criteria of audit events to retrieve. Zero fields
+// match any event.
+type AuditFilter struct {
+	ActorID    int64
+	DocumentID int64
+	Action     string
+	Since      int64 // Unix timestamp, inclusive
+	Until      int64 // Unix timestamp, exclusive
+	BeforeID   int64 // Only events older than this one, to page through events
+	Limit      int
+}
+
+// AuditVerification represents result of the verification of the audit events
+// chain.
+type AuditVerification struct {
+	Events   int64  `json:"events"`
+	Legacy   int64  `json:"legacy"`  // Events chained before the key was set
+	Pending  int64  `json:"pending"` // Events not chained yet
+	Valid    bool   `json:"valid"`
+	BrokenID int64  `json:"broken_id,omitempty"` // First event not matching the chain, zero if events were removed from the end
+	Head     string `json:"head"`                // Hash of the last event
+}
+
+// Check checks that e follows the events checked so far with key, and adds it
+// to v. Events chained without key are only accepted before keyed ones. If e
+// doesn't follow, v is marked invalid and false is returned.
+func (v *AuditVerification) Check(e *AuditEvent, key []byte) bool {
+	if (!e.Keyed && v.Events > v.Legacy) || !e.Verify(v.Head, key) {
+		v.Valid = false
+		v.BrokenID = e.ID
+		return false
+	}
+
+	v.Events++
+	if !e.Keyed {
+		v.Legacy++
+	}
+	v.Head = e.Hash
+	return true
+}
+
+// ComputeHash returns the hash of e, chained to e.PrevHash: an HMAC with key,
+// or a plain hash of legacy events if key is nil. ID, Seq and Hash are not
+// covered, as they're set on insert and when chained.
+func (e *AuditEvent) ComputeHash(key []byte) string {
+	b, _ := json.Marshal([]interface{}{
+		e.PrevHash,
+		e.OrgID,
+		e.Action,
+		e.ActorID,
+		e.Target,
+		e.DocumentID,
+		e.IP,
+		e.UserAgent,
+		e.Details,
+		e.Changes,
+		e.Created,
+	})
+	if key == nil {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that e is chained to the previous event hash prev, with key if
+// e is keyed.
+func (e *AuditEvent) Verify(prev string, key []byte) bool {
+	if !e.Keyed {
+		key = nil
+	} else if key == nil {
+		return false
+	}
+	return e.PrevHash == prev && hmac.Equal([]byte(e.Hash), []byte(e.ComputeHash(key)))
+}
+
+// AuditChanges returns the fields that differ between before and after, as
+// encoded in JSON, so that fields hidden from JSON, such as passwords, are
+// never recorded. The updated time is left out.
+func AuditChanges(before, after interface{}) map[string]*AuditChange {
+	var b, a map[string]json.RawMessage
+	if !decodeFields(before, &b) || !decodeFields(after, &a) {
+		return nil
+	}
+
+	var changes = make(map[string]*AuditChange)
+	for k, av := range a {
+		if bv := b[k]; !bytes.Equal(bv, av) {
+			changes[k] = &AuditChange{bv, av}
+		}
+	}
+	for k, bv := range b {
+		if _, ok := a[k]; !ok {
+			changes[k] = &AuditChange{bv, nil}
+		}
+	}
+	delete(changes, "updated_at")
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func decodeFields(v interface{}, fields *map[string]json.RawMessage) bool {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(j, fields) == nil
+}
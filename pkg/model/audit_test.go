@@ -0,0 +1,152 @@
+package model
+
+import "testing"
+
+// chain returns events chained in order, the first legacy ones without key.
+func chain(key []byte, legacy int, events ...*AuditEvent) []*AuditEvent {
+	var head string
+	for i, e := range events {
+		e.ID = int64(i + 1)
+		e.Seq = e.ID
+		e.PrevHash = head
+		if i < legacy {
+			e.Hash = e.ComputeHash(nil)
+		} else {
+			e.Keyed = true
+			e.Hash = e.ComputeHash(key)
+		}
+		head = e.Hash
+	}
+	return events
+}
+
+func newEvents() []*AuditEvent {
+	return []*AuditEvent{
+		{Action: AuditUserLogin, ActorID: 1, Target: "user:1", IP: "10.0.0.1", Created: 1},
+		{Action: AuditDocumentCreated, ActorID: 1, Target: "document:7", DocumentID: 7, Created: 2},
+		{Action: AuditFileDownloaded, ActorID: 2, Target: "file:a.pdf", Details: "a.pdf", Created: 3},
+		{Action: AuditUserLogout, ActorID: 1, Target: "user:1", Created: 4},
+	}
+}
+
+func TestComputeHash(t *testing.T) {
+	e := &AuditEvent{Action: AuditUserLogin, ActorID: 1, Created: 1}
+
+	if e.ComputeHash([]byte("k1")) == e.ComputeHash(nil) {
+		t.Errorf("keyed hash equals plain hash")
+	}
+	if e.ComputeHash([]byte("k1")) == e.ComputeHash([]byte("k2")) {
+		t.Errorf("hashes of different keys are equal")
+	}
+	if e.ComputeHash([]byte("k1")) != e.ComputeHash([]byte("k1")) {
+		t.Errorf("hash is not deterministic")
+	}
+}
+
+func TestAuditVerificationCheck(t *testing.T) {
+	key := []byte("secret")
+
+	tests := []struct {
+		name   string
+		events func() []*AuditEvent
+		key    []byte
+		broken int64 // ID of the first broken event, zero if valid
+		legacy int64
+	}{
+		{
+			name:   "keyed",
+			events: func() []*AuditEvent { return chain(key, 0, newEvents()...) },
+			key:    key,
+		},
+		{
+			name:   "legacy then keyed",
+			events: func() []*AuditEvent { return chain(key, 2, newEvents()...) },
+			key:    key,
+			legacy: 2,
+		},
+		{
+			name:   "wrong key",
+			events: func() []*AuditEvent { return chain(key, 0, newEvents()...) },
+			key:    []byte("other"),
+			broken: 1,
+		},
+		{
+			name:   "no key",
+			events: func() []*AuditEvent { return chain(key, 1, newEvents()...) },
+			broken: 2,
+			legacy: 1,
+		},
+		{
+			name: "altered event",
+			events: func() []*AuditEvent {
+				events := chain(key, 0, newEvents()...)
+				events[2].Details = "b.pdf"
+				return events
+			},
+			key:    key,
+			broken: 3,
+		},
+		{
+			name: "altered legacy event rehashed",
+			events: func() []*AuditEvent {
+				events := chain(key, 2, newEvents()...)
+				events[1].Details = "forged"
+				events[1].Hash = events[1].ComputeHash(nil)
+				return events
+			},
+			key:    key,
+			broken: 3,
+			legacy: 2,
+		},
+		{
+			name: "keyed event downgraded to legacy",
+			events: func() []*AuditEvent {
+				events := chain(key, 0, newEvents()...)
+				events[3].Keyed = false
+				events[3].Hash = events[3].ComputeHash(nil)
+				return events
+			},
+			key:    key,
+			broken: 4,
+		},
+		{
+			name: "removed event",
+			events: func() []*AuditEvent {
+				events := chain(key, 0, newEvents()...)
+				return append(events[:1], events[2:]...)
+			},
+			key:    key,
+			broken: 3,
+		},
+		{
+			name: "swapped events",
+			events: func() []*AuditEvent {
+				events := chain(key, 0, newEvents()...)
+				events[1], events[2] = events[2], events[1]
+				return events
+			},
+			key:    key,
+			broken: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		events := tt.events()
+		v := &AuditVerification{Valid: true}
+		for _, e := range events {
+			if !v.Check(e, tt.key) {
+				break
+			}
+		}
+
+		if v.Valid != (tt.broken == 0) || v.BrokenID != tt.broken {
+			t.Errorf("%s: valid %v, broken at %d, want broken at %d", tt.name, v.Valid, v.BrokenID, tt.broken)
+		}
+		if v.Legacy != tt.legacy {
+			t.Errorf("%s: %d legacy events, want %d", tt.name, v.Legacy, tt.legacy)
+		}
+		if v.Valid && (v.Events != int64(len(events)) || v.Head != events[len(events)-1].Hash) {
+			t.Errorf("%s: %d events, head %s", tt.name, v.Events, v.Head)
+		}
+	}
+}
@@ -10,6 +10,7 @@ var (
 // and one-to-many DocumentParticipant.
 type Document struct {
 	ID        int64  `meddler:"id,pk"       json:"id"`
+	OrgID     int64  `meddler:"org_id"      json:"org_id"` // Organization of the creator
 	Status    string `meddler:"status"      validate:"doc_status" json:"status"`
 	Name      string `meddler:"name"        validate:"nonzero" json:"name"`
 	CreatedBy int64  `meddler:"created_by"  json:"created_by"`
@@ -17,11 +18,20 @@ type Document struct {
 	Updated   int64  `meddler:"updated"     json:"updated_at"`
 }
 
-// DocumentParticipant represents participant in a document.
+const (
+	ParticipantRoleViewer  = "viewer" // Reads the document
+	ParticipantRoleEditor  = "editor" // Reads and adds files to the document
+	DefaultParticipantRole = ParticipantRoleViewer
+)
+
+// DocumentParticipant represents participant in a document, either a user or a
+// group whose members participate with the role of the group.
 type DocumentParticipant struct {
-	ID         int64 `meddler:"id,pk" json:"id"`
-	DocumentID int64 `meddler:"document_id"`
-	UserID     int64 `meddler:"user_id"`
+	ID         int64  `meddler:"id,pk"       json:"id"`
+	DocumentID int64  `meddler:"document_id" json:"document_id"`
+	UserID     int64  `meddler:"user_id"     json:"user_id,omitempty"`
+	GroupID    int64  `meddler:"group_id"    json:"group_id,omitempty"`
+	Role       string `meddler:"role"        validate:"participant_role" json:"role"`
 }
 
 // DocumentFile represents attached file in a document.
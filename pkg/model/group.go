@@ -0,0 +1,20 @@
+package model
+
+// Group represents a group of users, such as a department or a team. Groups
+// participate in documents on behalf of their members.
+type Group struct {
+	ID          int64  `meddler:"id,pk"       json:"id"`
+	OrgID       int64  `meddler:"org_id"      json:"org_id"`
+	Name        string `meddler:"name"        validate:"nonzero,max=64" json:"name"`
+	Description string `meddler:"description" json:"description"`
+	Created     int64  `meddler:"created"     json:"created_at"`
+	Updated     int64  `meddler:"updated"     json:"updated_at"`
+}
+
+// GroupMember represents membership of a user in a group.
+type GroupMember struct {
+	ID      int64 `meddler:"id,pk"    json:"id"`
+	GroupID int64 `meddler:"group_id" json:"group_id"`
+	UserID  int64 `meddler:"user_id"  json:"user_id"`
+	Created int64 `meddler:"created"  json:"created_at"`
+}
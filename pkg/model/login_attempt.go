@@ -0,0 +1,13 @@
+package model
+
+// LoginAttempt represents failed login attempts of a key, for instance an
+// account or an IP. Times are Unix time.
+type LoginAttempt struct {
+	ID          int64  `meddler:"id,pk"        json:"id"`
+	Key         string `meddler:"attempt_key"  json:"key"`
+	Failures    int    `meddler:"failures"     json:"failures"`
+	LastFailure int64  `meddler:"last_failure" json:"last_failure_at"`
+	LockedUntil int64  `meddler:"locked_until" json:"locked_until"`
+	Locked      bool   `meddler:"locked"       json:"locked"`
+	Expires     int64  `meddler:"expires"      json:"expires_at"`
+}
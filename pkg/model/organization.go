@@ -0,0 +1,23 @@
+package model
+
+// Organization represents a tenant, such as a client office. Users, documents
+// and groups belong to at most one organization, and only see those of their
+// organization. Users of no organization with organizations:manage are global
+// admins, which see all organizations.
+type Organization struct {
+	ID         int64                 `meddler:"id,pk"         json:"id"`
+	Slug       string                `meddler:"slug"          validate:"org_slug" json:"slug"`
+	Name       string                `meddler:"name"          validate:"nonzero,max=255" json:"name"`
+	QuotaUsers int64                 `meddler:"quota_users"   json:"quota_users"` // Maximum number of users, 0 for unlimited
+	QuotaBytes int64                 `meddler:"quota_bytes"   json:"quota_bytes"` // Maximum size of stored files, 0 for unlimited
+	Settings   *OrganizationSettings `meddler:"settings,json" json:"settings"`
+	Created    int64                 `meddler:"created"       json:"created_at"`
+	Updated    int64                 `meddler:"updated"       json:"updated_at"`
+}
+
+// OrganizationSettings represents settings of an organization, managed by its
+// admins.
+type OrganizationSettings struct {
+	DefaultRole   string `json:"default_role"`   // Role of new users, default to user
+	WatermarkText string `json:"watermark_text"` // Replaces text of the watermark of published documents
+}
@@ -0,0 +1,11 @@
+package model
+
+// RateLimitBucket represents the token bucket of a key, for instance a user or
+// an IP, limiting its rate of requests.
+type RateLimitBucket struct {
+	ID      int64   `meddler:"id,pk"      json:"id"`
+	Key     string  `meddler:"bucket_key" json:"key"`
+	Tokens  float64 `meddler:"tokens"     json:"tokens"`
+	Updated int64   `meddler:"updated"    json:"updated_at"` // Unix time in milliseconds, tokens are refilled since then
+	Expires int64   `meddler:"expires"    json:"expires_at"` // Unix time, the bucket is full again then
+}
@@ -0,0 +1,177 @@
+package model
+
+const (
+	PermUsersRead   = "users:read"
+	PermUsersCreate = "users:create"
+	PermUsersUpdate = "users:update"
+	PermUsersDelete = "users:delete"
+
+	PermRolesManage         = "roles:manage"
+	PermOrganizationsManage = "organizations:manage" // Manage all organizations, users of no organization only
+	PermOrganizationUpdate  = "organization:update"  // Update settings of own organization
+	PermGroupsManage        = "groups:manage"
+	PermAuditRead           = "audit:read" // Query the audit log of own organization, or all for global admins
+
+	PermDocumentsReadAny    = "documents:read_any"    // Read drafts of other users
+	PermDocumentsUpdateAny  = "documents:update_any"  // Add files to and share documents of other users
+	PermDocumentsDeleteAny  = "documents:delete_any"  // Delete documents of other users
+	PermDocumentsPublish    = "documents:publish"     // Publish own documents
+	PermDocumentsPublishAny = "documents:publish_any" // Publish documents of other users
+	PermDocumentsImport     = "documents:import"
+
+	// PermAll grants all permissions, including ones added later.
+	PermAll = "*"
+)
+
+// Permissions lists all known permissions.
+var Permissions = []string{
+	PermUsersRead,
+	PermUsersCreate,
+	PermUsersUpdate,
+	PermUsersDelete,
+	PermRolesManage,
+	PermOrganizationsManage,
+	PermOrganizationUpdate,
+	PermGroupsManage,
+	PermAuditRead,
+	PermDocumentsReadAny,
+	PermDocumentsUpdateAny,
+	PermDocumentsDeleteAny,
+	PermDocumentsPublish,
+	PermDocumentsPublishAny,
+	PermDocumentsImport,
+}
+
+// GlobalPermissions are never granted to users of an organization, whatever
+// their role, see Role.InOrganization.
+var GlobalPermissions = []string{
+	PermRolesManage,
+	PermOrganizationsManage,
+}
+
+// AdminPermissions are permissions to administer users, roles, groups or
+// organizations. Roles granting any of them are admin roles, see Role.IsAdmin.
+var AdminPermissions = []string{
+	PermUsersCreate,
+	PermUsersUpdate,
+	PermUsersDelete,
+	PermRolesManage,
+	PermOrganizationsManage,
+	PermOrganizationUpdate,
+	PermGroupsManage,
+	PermAuditRead,
+}
+
+// BuiltinRoles are created on setup and can't be deleted. Permissions of the
+// admin role can't be changed either, so that it can't be locked out.
+var BuiltinRoles = []*Role{
+	{Name: RoleAdmin, Description: "Administrator", Permissions: []string{PermAll}, Builtin: true},
+	{Name: RoleUser, Description: "User", Permissions: []string{PermUsersRead, PermDocumentsPublish}, Builtin: true},
+	{Name: RoleOrgAdmin, Description: "Organization administrator", Permissions: OrgAdminPermissions, Builtin: true},
+}
+
+// OrgAdminPermissions are permissions of the org_admin role, to administer an
+// organization.
+var OrgAdminPermissions = []string{
+	PermUsersRead,
+	PermUsersCreate,
+	PermUsersUpdate,
+	PermUsersDelete,
+	PermOrganizationUpdate,
+	PermGroupsManage,
+	PermAuditRead,
+	PermDocumentsReadAny,
+	PermDocumentsUpdateAny,
+	PermDocumentsDeleteAny,
+	PermDocumentsPublish,
+	PermDocumentsPublishAny,
+	PermDocumentsImport,
+}
+
+// Role represents a named set of permissions. Users have a single role, see
+// User.Role.
+type Role struct {
+	ID          int64    `meddler:"id,pk"            json:"id"`
+	Name        string   `meddler:"name"             validate:"role" json:"name"`
+	Description string   `meddler:"description"      json:"description"`
+	Permissions []string `meddler:"permissions,json" json:"permissions"`
+	Builtin     bool     `meddler:"builtin"          json:"builtin"`
+	Created     int64    `meddler:"created"          json:"created_at"`
+	Updated     int64    `meddler:"updated"          json:"updated_at"`
+}
+
+// Can checks whether role r grants permission perm.
+func (r *Role) Can(perm string) bool {
+	if r == nil {
+		return false
+	}
+	for _, p := range r.Permissions {
+		if p == perm || p == PermAll {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAll checks whether role r grants all permissions perms. PermAll is only
+// granted by roles granting PermAll themselves.
+func (r *Role) CanAll(perms []string) bool {
+	for _, p := range perms {
+		if !r.Can(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAdmin checks whether role r grants any of AdminPermissions, whatever its
+// name.
+func (r *Role) IsAdmin() bool {
+	for _, p := range AdminPermissions {
+		if r.Can(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidPermission checks whether perm is a known permission.
+func IsValidPermission(perm string) bool {
+	if perm == PermAll {
+		return true
+	}
+	for _, p := range Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// InOrganization returns role r as granted to users of an organization, that
+// is without GlobalPermissions.
+func (r *Role) InOrganization() *Role {
+	if r == nil {
+		return nil
+	}
+
+	var perms []string
+	for _, p := range Permissions {
+		if r.Can(p) && !isGlobalPermission(p) {
+			perms = append(perms, p)
+		}
+	}
+
+	role := *r
+	role.Permissions = perms
+	return &role
+}
+
+func isGlobalPermission(perm string) bool {
+	for _, p := range GlobalPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
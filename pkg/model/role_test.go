@@ -0,0 +1,49 @@
+package model
+
+import "testing"
+
+func TestRoleCanAll(t *testing.T) {
+	custom := &Role{Name: "editor", Permissions: []string{PermRolesManage, PermDocumentsReadAny}}
+
+	tests := []struct {
+		role  *Role
+		perms []string
+		want  bool
+	}{
+		{custom, nil, true},
+		{custom, []string{PermDocumentsReadAny}, true},
+		{custom, []string{PermRolesManage, PermDocumentsReadAny}, true},
+		{custom, []string{PermRolesManage, PermUsersDelete}, false},
+		{custom, []string{PermAll}, false},
+		{BuiltinRoles[0], []string{PermAll, PermUsersDelete}, true},
+		{BuiltinRoles[2].InOrganization(), []string{PermAll}, false},
+		{nil, nil, true},
+		{nil, []string{PermUsersRead}, false},
+	}
+
+	for i, tt := range tests {
+		if got := tt.role.CanAll(tt.perms); got != tt.want {
+			t.Errorf("%d: CanAll(%v) = %v, want %v", i, tt.perms, got, tt.want)
+		}
+	}
+}
+
+func TestRoleIsAdmin(t *testing.T) {
+	tests := []struct {
+		role *Role
+		want bool
+	}{
+		{&Role{Name: RoleAdmin, Permissions: []string{PermAll}}, true},
+		{&Role{Name: "auditor", Permissions: []string{PermAuditRead}}, true},
+		{&Role{Name: "manager", Permissions: []string{PermUsersRead, PermUsersUpdate}}, true},
+		{&Role{Name: RoleAdmin, Permissions: []string{PermUsersRead}}, false},
+		{&Role{Name: RoleUser, Permissions: []string{PermUsersRead, PermDocumentsPublish}}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.IsAdmin(); got != tt.want {
+			t.Errorf("IsAdmin of %+v = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package model
+
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+	TokenRefresh           = "refresh"
+	TokenRefreshUsed       = "refresh_used" // Refresh token already exchanged, kept to detect its reuse
+	TokenTwoFactor         = "two_factor"
+	TokenRecoveryCode      = "recovery_code"
+)
+
+// UserToken represents a single-use, expiring token issued to a user, for
+// instance to reset the password. Only the hash of the token is stored.
+type UserToken struct {
+	ID      int64  `meddler:"id,pk"   json:"id"`
+	UserID  int64  `meddler:"user_id" json:"user_id"`
+	Kind    string `meddler:"kind"    json:"kind"`
+	Hash    string `meddler:"hash"    json:"-"`
+	Expires int64  `meddler:"expires" json:"expires_at"` // Zero for recovery codes, which don't expire
+	Created int64  `meddler:"created" json:"created_at"`
+}
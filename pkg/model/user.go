@@ -1,22 +1,40 @@
 package model
 
 type User struct {
-	ID       int64  `meddler:"id,pk"    json:"user_id"`
-	Login    string `meddler:"login"    validate:"login" json:"login"`
-	Email    string `meddler:"email"    validate:"email" json:"email"`
-	Password string `meddler:"password" validate:"min=6" json:"-"`
-	Name     string `meddler:"name"     json:"name"`
-	Role     string `meddler:"role"     validate:"role" json:"role"`
-	Created  int64  `meddler:"created"  json:"created_at"`
-	Updated  int64  `meddler:"updated"  json:"updated_at"`
+	ID              int64  `meddler:"id,pk"            json:"user_id"`
+	OrgID           int64  `meddler:"org_id"           json:"org_id"` // Organization of the user, 0 for none
+	Login           string `meddler:"login"            validate:"login" json:"login"`
+	Email           string `meddler:"email"            validate:"email" json:"email"`
+	Password        string `meddler:"password"         validate:"min=6" json:"-"`
+	Name            string `meddler:"name"             json:"name"`
+	Role            string `meddler:"role"             validate:"role" json:"role"`
+	Verified        bool   `meddler:"verified"         json:"verified"`                // Whether email is verified
+	PendingEmail    string `meddler:"pending_email"    json:"pending_email,omitempty"` // Changed email, used once verified
+	TokenGeneration int64  `meddler:"token_generation" json:"-"`                       // Increased to revoke all issued tokens
+	TOTPSecret      string `meddler:"totp_secret"      json:"-"`                       // Base32 TOTP secret, set on enrollment
+	TOTPEnabled     bool   `meddler:"totp_enabled"     json:"totp_enabled"`            // Whether TOTP is required on login
+	TOTPLastStep    int64  `meddler:"totp_last_step"   json:"-"`                       // Time step of last accepted code
+	Deactivated     bool   `meddler:"deactivated"      json:"deactivated"`             // Deactivated users can't log in
+	AuthSource      string `meddler:"auth_source"      json:"auth_source"`             // Provider authenticating the user, empty for local password
+	ExternalID      string `meddler:"external_id"      json:"-"`                       // Stable ID of the user at the provider, such as OIDC sub
+	Created         int64  `meddler:"created"          json:"created_at"`
+	Updated         int64  `meddler:"updated"          json:"updated_at"`
 }
 
 const (
-	RoleAdmin = "admin"
-	RoleUser  = "user"
+	RoleAdmin    = "admin"
+	RoleUser     = "user"
+	RoleOrgAdmin = "org_admin"
 )
 
-// IsAdmin checks whether user has admin role or not.
+// IsLocal checks whether user authenticates with local password, rather than
+// with an external provider such as LDAP.
+func (u *User) IsLocal() bool {
+	return u.AuthSource == ""
+}
+
+// IsAdmin checks whether user has admin role or not. Admin role is not effective
+// until the email is verified, nor once the user is deactivated.
 func (u *User) IsAdmin() bool {
-	return u.Role == RoleAdmin
+	return u.Role == RoleAdmin && u.Verified && !u.Deactivated
 }
@@ -8,17 +8,27 @@ import (
 )
 
 var (
-	ErrorInvalidLogin          = errors.New("Invalid login format")
-	ErrorInvalidEmail          = errors.New("Invalid email format")
-	ErrorInvalidRole           = errors.New("Invalid user role")
-	ErrorInvalidDocumentStatus = errors.New("Invalid document status")
+	ErrorInvalidLogin            = errors.New("Invalid login format")
+	ErrorInvalidEmail            = errors.New("Invalid email format")
+	ErrorInvalidRole             = errors.New("Invalid user role")
+	ErrorInvalidPermission       = errors.New("Invalid permission")
+	ErrorInvalidDocumentStatus   = errors.New("Invalid document status")
+	ErrorInvalidParticipant      = errors.New("Participant must be either a user or a group")
+	ErrorInvalidParticipantRole  = errors.New("Invalid document participant role")
+	ErrorInvalidOrganizationSlug = errors.New("Invalid organization slug")
 )
 
+var roleNameExp = regexp.MustCompile("^[a-z][a-z0-9_-]{1,63}$")
+
+var orgSlugExp = regexp.MustCompile("^[a-z0-9][a-z0-9-]{1,62}$")
+
 func init() {
 	validator.SetValidationFunc("login", validateLogin)
 	validator.SetValidationFunc("email", validateEmail)
 	validator.SetValidationFunc("role", validateRole)
 	validator.SetValidationFunc("doc_status", validateDocumentStatus)
+	validator.SetValidationFunc("participant_role", validateParticipantRole)
+	validator.SetValidationFunc("org_slug", validateOrganizationSlug)
 }
 
 func Validate(v interface{}) error {
@@ -27,6 +37,16 @@ func Validate(v interface{}) error {
 		return vv.Validate()
 	case *Document:
 		return vv.Validate()
+	case *APIToken:
+		return vv.Validate()
+	case *Role:
+		return vv.Validate()
+	case *Group:
+		return vv.Validate()
+	case *DocumentParticipant:
+		return vv.Validate()
+	case *Organization:
+		return vv.Validate()
 	default:
 		return validator.ErrUnsupported
 	}
@@ -40,6 +60,41 @@ func (d *Document) Validate() error {
 	return validator.Validate(d)
 }
 
+func (t *APIToken) Validate() error {
+	return validator.Validate(t)
+}
+
+func (r *Role) Validate() error {
+	if err := validator.Validate(r); err != nil {
+		return err
+	}
+	for _, p := range r.Permissions {
+		if !IsValidPermission(p) {
+			return validator.ErrorMap{"Permissions": validator.ErrorArray{ErrorInvalidPermission}}
+		}
+	}
+	return nil
+}
+
+func (g *Group) Validate() error {
+	return validator.Validate(g)
+}
+
+// Validate checks that participant p is either a user or a group.
+func (p *DocumentParticipant) Validate() error {
+	if err := validator.Validate(p); err != nil {
+		return err
+	}
+	if (p.UserID == 0) == (p.GroupID == 0) {
+		return validator.ErrorMap{"Participant": validator.ErrorArray{ErrorInvalidParticipant}}
+	}
+	return nil
+}
+
+func (o *Organization) Validate() error {
+	return validator.Validate(o)
+}
+
 func validateLogin(v interface{}, param string) error {
 	vv, ok := v.(string)
 	if !ok {
@@ -70,7 +125,9 @@ func validateRole(v interface{}, param string) error {
 	if !ok {
 		return ErrorInvalidRole
 	}
-	if vv != RoleUser && vv != RoleAdmin {
+	// Roles are stored in the datastore, so only the format of the name is
+	// checked here.
+	if !roleNameExp.MatchString(vv) {
 		return ErrorInvalidRole
 	}
 	return nil
@@ -89,3 +146,19 @@ func validateDocumentStatus(v interface{}, param string) error {
 
 	return nil
 }
+
+func validateParticipantRole(v interface{}, param string) error {
+	vv, ok := v.(string)
+	if !ok || (vv != ParticipantRoleViewer && vv != ParticipantRoleEditor) {
+		return ErrorInvalidParticipantRole
+	}
+	return nil
+}
+
+func validateOrganizationSlug(v interface{}, param string) error {
+	vv, ok := v.(string)
+	if !ok || !orgSlugExp.MatchString(vv) {
+		return ErrorInvalidOrganizationSlug
+	}
+	return nil
+}
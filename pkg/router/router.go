@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/gedex/simdoc/pkg/handler"
 	"github.com/gedex/simdoc/pkg/middleware"
+	"github.com/gedex/simdoc/pkg/model"
 
 	"github.com/zenazn/goji/web"
 )
@@ -11,28 +12,59 @@ func New() *web.Mux {
 	mux := web.New()
 
 	// Public endpoints.
-	mux.Post("/api/user/login", handler.UserLogin)
+	mux.Post("/api/user/login", middleware.LimitLogins(handler.UserLogin))
+	mux.Post("/api/user/login/2fa", middleware.LimitLogins(handler.UserLoginTwoFactor))
+	mux.Get("/api/user/login/oidc", handler.OIDCLogin)
+	mux.Get("/api/user/login/oidc/callback", handler.OIDCCallback)
+	mux.Post("/api/user/password/forgot", middleware.LimitLogins(handler.ForgotPassword))
+	mux.Post("/api/user/password/reset", middleware.LimitLogins(handler.ResetPassword))
+	mux.Post("/api/user/verify", middleware.LimitLogins(handler.VerifyEmail))
+	mux.Post("/api/user/verify/resend", middleware.LimitLogins(handler.ResendEmailVerification))
+	mux.Post("/api/user/token", middleware.LimitLogins(handler.RefreshToken))
 
-	// @todo pass UserAuthorizer middleware on following endpoints.
-	// @todo authenticated user should be able to get list of all users.
-	// @todo however adding user to users must have 'admin' role.
-	mux.Get("/api/users/:login", handler.GetUserByLogin)
-	mux.Get("/api/users", handler.GetAllUsers)
-	mux.Post("/api/users", handler.AddUser)
+	// Users endpoints.
+	users := web.New()
+	users.Use(middleware.UserAuthorizer)
+	users.Use(middleware.APITokenScope(model.ScopeRead, model.ScopeAdmin))
+	users.Get("/api/users/:login", middleware.RequirePermission(model.PermUsersRead, handler.GetUserByLogin))
+	users.Get("/api/users", middleware.RequirePermission(model.PermUsersRead, handler.GetAllUsers))
+	users.Post("/api/users", middleware.RequirePermission(model.PermUsersCreate, handler.AddUser))
+	mux.Handle("/api/users", users)
+	mux.Handle("/api/users/*", users)
 
 	// Authenticated user endpoints.
 	user := web.New()
 	user.Use(middleware.UserAuthorizer)
+	user.Use(middleware.APITokenScope(model.ScopeRead, model.ScopeAdmin))
 	user.Get("/api/user", handler.GetCurrentUser)
 	user.Patch("/api/user", handler.UpdateCurrentUser)
 	user.Put("/api/user", handler.UpdateCurrentUser)
 	user.Get("/api/user/documents", handler.GetCurrentUserDocuments)
+	user.Post("/api/user/password", handler.ChangePassword)
+	user.Post("/api/user/logout", handler.UserLogout)
+	user.Get("/api/user/tokens", handler.GetAPITokens)
+	user.Post("/api/user/tokens", handler.AddAPIToken)
+	user.Delete("/api/user/tokens/:tokenId", handler.DeleteAPIToken)
+	user.Post("/api/user/2fa/totp", handler.EnrollTOTP)
+	user.Delete("/api/user/2fa/totp", handler.DisableTOTP)
+	user.Post("/api/user/2fa/totp/confirm", handler.ConfirmTOTP)
+	user.Post("/api/user/2fa/recovery_codes", handler.RegenerateRecoveryCodes)
+	user.Get("/api/organization", handler.GetCurrentOrganization)
+	user.Patch("/api/organization", middleware.RequirePermission(model.PermOrganizationUpdate, handler.UpdateCurrentOrganization))
+	user.Put("/api/organization", middleware.RequirePermission(model.PermOrganizationUpdate, handler.UpdateCurrentOrganization))
 	mux.Handle("/api/user", user)
 	mux.Handle("/api/user/documents", user)
+	mux.Handle("/api/user/password", user)
+	mux.Handle("/api/user/logout", user)
+	mux.Handle("/api/user/tokens", user)
+	mux.Handle("/api/user/tokens/*", user)
+	mux.Handle("/api/user/2fa/*", user)
+	mux.Handle("/api/organization", user)
 
 	// Document endpoints.
 	doc := web.New()
 	doc.Use(middleware.UserAuthorizer)
+	doc.Use(middleware.APITokenScope(model.ScopeRead, model.ScopeDocumentsWrite))
 
 	// @todo uncommented until middleware can captures parsed URL Params.
 	// https://github.com/zenazn/goji/issues/76
@@ -42,18 +74,70 @@ func New() *web.Mux {
 	doc.Get("/api/documents", handler.GetAllDocuments)
 	doc.Post("/api/documents", handler.AddDocument)
 
+	doc.Get("/api/documents/archive", handler.GetDocumentsArchive)
+
 	doc.Get("/api/documents/:docId", handler.GetDocumentById)
 	doc.Delete("/api/documents/:docId", handler.DeleteDocument)
+	doc.Post("/api/documents/:docId/publish", handler.PublishDocument)
+	doc.Get("/api/documents/:docId/archive", handler.GetDocumentArchive)
+
+	// Document participants.
+	doc.Get("/api/documents/:docId/participants", handler.GetDocumentParticipants)
+	doc.Post("/api/documents/:docId/participants", handler.AddDocumentParticipant)
+	doc.Delete("/api/documents/:docId/participants/:participantId", handler.DeleteDocumentParticipant)
 
 	// Document files.
 	doc.Get("/api/documents/:docId/files", handler.GetDocumentFiles)
-	doc.Post("/api/documents/:docId/files", handler.AddDocumentFile)
+	doc.Post("/api/documents/:docId/files", middleware.LimitUploads(handler.AddDocumentFile))
 	doc.Get("/api/documents/:docId/files/sid", handler.GetDocumentFilesSid)
 	doc.Delete("/api/documents/:docId/files/:fileid", handler.DeleteDocumentFile)
 
 	mux.Handle("/api/documents", doc)
 	mux.Handle("/api/documents/*", doc)
 
+	// Admin endpoints.
+	admin := web.New()
+	admin.Use(middleware.UserAuthorizer)
+	admin.Use(middleware.APITokenScope(model.ScopeAdmin, model.ScopeAdmin))
+	admin.Post("/api/import", middleware.RequirePermission(model.PermDocumentsImport, handler.ImportDocuments))
+	admin.Get("/api/import/:importId", middleware.RequirePermission(model.PermDocumentsImport, handler.GetImport))
+	admin.Get("/api/admin/users", middleware.RequirePermission(model.PermUsersRead, handler.GetAllUsers))
+	admin.Post("/api/admin/users", middleware.RequirePermission(model.PermUsersCreate, handler.AddUser))
+	admin.Get("/api/admin/users/:login", middleware.RequirePermission(model.PermUsersRead, handler.GetUserByLogin))
+	admin.Patch("/api/admin/users/:login", middleware.RequirePermission(model.PermUsersUpdate, handler.UpdateUser))
+	admin.Put("/api/admin/users/:login", middleware.RequirePermission(model.PermUsersUpdate, handler.UpdateUser))
+	admin.Delete("/api/admin/users/:login", middleware.RequirePermission(model.PermUsersDelete, handler.DeleteUser))
+	admin.Post("/api/admin/users/:login/deactivate", middleware.RequirePermission(model.PermUsersUpdate, handler.DeactivateUser))
+	admin.Post("/api/admin/users/:login/activate", middleware.RequirePermission(model.PermUsersUpdate, handler.ActivateUser))
+	admin.Post("/api/admin/users/:login/reset_password", middleware.RequirePermission(model.PermUsersUpdate, handler.ResetUserPassword))
+	admin.Post("/api/admin/users/:login/revoke_sessions", middleware.RequirePermission(model.PermUsersUpdate, handler.RevokeUserSessions))
+	admin.Post("/api/admin/users/:login/unlock", middleware.RequirePermission(model.PermUsersUpdate, handler.UnlockUser))
+	admin.Get("/api/admin/roles", middleware.RequirePermission(model.PermRolesManage, handler.GetAllRoles))
+	admin.Post("/api/admin/roles", middleware.RequirePermission(model.PermRolesManage, handler.AddRole))
+	admin.Patch("/api/admin/roles/:role", middleware.RequirePermission(model.PermRolesManage, handler.UpdateRole))
+	admin.Put("/api/admin/roles/:role", middleware.RequirePermission(model.PermRolesManage, handler.UpdateRole))
+	admin.Delete("/api/admin/roles/:role", middleware.RequirePermission(model.PermRolesManage, handler.DeleteRole))
+	admin.Get("/api/admin/groups", middleware.RequirePermission(model.PermGroupsManage, handler.GetAllGroups))
+	admin.Post("/api/admin/groups", middleware.RequirePermission(model.PermGroupsManage, handler.AddGroup))
+	admin.Get("/api/admin/groups/:groupId", middleware.RequirePermission(model.PermGroupsManage, handler.GetGroup))
+	admin.Patch("/api/admin/groups/:groupId", middleware.RequirePermission(model.PermGroupsManage, handler.UpdateGroup))
+	admin.Put("/api/admin/groups/:groupId", middleware.RequirePermission(model.PermGroupsManage, handler.UpdateGroup))
+	admin.Delete("/api/admin/groups/:groupId", middleware.RequirePermission(model.PermGroupsManage, handler.DeleteGroup))
+	admin.Put("/api/admin/groups/:groupId/members/:login", middleware.RequirePermission(model.PermGroupsManage, handler.AddGroupMember))
+	admin.Delete("/api/admin/groups/:groupId/members/:login", middleware.RequirePermission(model.PermGroupsManage, handler.DeleteGroupMember))
+	admin.Get("/api/admin/organizations", middleware.RequirePermission(model.PermOrganizationsManage, handler.GetAllOrganizations))
+	admin.Post("/api/admin/organizations", middleware.RequirePermission(model.PermOrganizationsManage, handler.AddOrganization))
+	admin.Get("/api/admin/organizations/:orgId", middleware.RequirePermission(model.PermOrganizationsManage, handler.GetOrganization))
+	admin.Patch("/api/admin/organizations/:orgId", middleware.RequirePermission(model.PermOrganizationsManage, handler.UpdateOrganization))
+	admin.Put("/api/admin/organizations/:orgId", middleware.RequirePermission(model.PermOrganizationsManage, handler.UpdateOrganization))
+	admin.Delete("/api/admin/organizations/:orgId", middleware.RequirePermission(model.PermOrganizationsManage, handler.DeleteOrganization))
+	admin.Get("/api/admin/audit", middleware.RequirePermission(model.PermAuditRead, handler.GetAuditEvents))
+	admin.Get("/api/admin/audit/export", middleware.RequirePermission(model.PermAuditRead, handler.ExportAuditEvents))
+	admin.Get("/api/admin/audit/verify", middleware.RequirePermission(model.PermAuditRead, handler.VerifyAuditEvents))
+	mux.Handle("/api/import", admin)
+	mux.Handle("/api/import/*", admin)
+	mux.Handle("/api/admin/*", admin)
+
 	// Dev endpoints. Provide helper handlers during development.
 	dev := web.New()
 	dev.Use(middleware.DevEnv)
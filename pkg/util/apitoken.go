@@ -0,0 +1,70 @@
+package util
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+// APITokenPrefix prefixes API tokens, so that leaked tokens are recognizable.
+const APITokenPrefix = "sdt_"
+
+// apiTokenLastUsedInterval is the minimum interval between updates of the last
+// used time of API token, to not write on every request.
+const apiTokenLastUsedInterval = time.Minute
+
+// GenerateAPIToken returns a new API token and the hash to store.
+func GenerateAPIToken() (token, hash string, err error) {
+	t, err := GetRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	token = APITokenPrefix + t
+
+	return token, HashToken(token), nil
+}
+
+// IsAPITokenRequest checks whether http.Request is authenticated with an API
+// token, that is "Authorization: Token <token>" header.
+func IsAPITokenRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), "Token ")
+}
+
+// GetAPITokenFromRequest gets the API token, and its user, the http.Request is
+// authenticated with. Nil values are returned if the token is missing or
+// unknown.
+func GetAPITokenFromRequest(c context.Context, r *http.Request) (*model.APIToken, *model.User) {
+	if !IsAPITokenRequest(r) {
+		return nil, nil
+	}
+	var token = strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Token "))
+	if token == "" {
+		return nil, nil
+	}
+
+	t, err := datastore.GetAPITokenByHash(c, HashToken(token))
+	if err != nil || t == nil || t.ID == 0 {
+		return nil, nil
+	}
+
+	user, err := datastore.GetUserById(c, t.UserID)
+	if err != nil || !user.Verified || user.Deactivated {
+		return nil, nil
+	}
+
+	var now = time.Now().UTC().Unix()
+	if now-t.LastUsed >= int64(apiTokenLastUsedInterval/time.Second) {
+		if err := datastore.UpdateAPITokenLastUsed(c, t.ID, now); err != nil {
+			log.Printf("%+v\n", err)
+		}
+		t.LastUsed = now
+	}
+
+	return t, user
+}
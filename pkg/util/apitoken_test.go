@@ -0,0 +1,128 @@
+package util
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+// fakeAPITokenstore is an in-memory datastore of users and their API tokens.
+// Other methods of the datastore are not implemented.
+type fakeAPITokenstore struct {
+	datastore.Datastore
+	users  []*model.User
+	tokens []*model.APIToken
+}
+
+func (s *fakeAPITokenstore) GetUserById(id int64) (*model.User, error) {
+	for _, u := range s.users {
+		if u.ID == id {
+			cu := *u
+			return &cu, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeAPITokenstore) GetAPITokenByHash(hash string) (*model.APIToken, error) {
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			ct := *t
+			return &ct, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeAPITokenstore) UpdateAPITokenLastUsed(id, lastUsed int64) error {
+	for _, t := range s.tokens {
+		if t.ID == id {
+			t.LastUsed = lastUsed
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func TestGenerateAPIToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		token, hash, err := GenerateAPIToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(token, APITokenPrefix) {
+			t.Errorf("token %s without prefix %s", token, APITokenPrefix)
+		}
+		if hash != HashToken(token) || strings.Contains(hash, strings.TrimPrefix(token, APITokenPrefix)) {
+			t.Errorf("hash %s of token %s", hash, token)
+		}
+		if seen[token] {
+			t.Errorf("token %s generated twice", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestGetAPITokenFromRequest(t *testing.T) {
+	var tokens = make([]string, 4)
+	ds := &fakeAPITokenstore{users: []*model.User{
+		{ID: 1, Login: "alice", Verified: true},
+		{ID: 2, Login: "bob", Verified: true, Deactivated: true},
+		{ID: 3, Login: "carol"},
+	}}
+	for i := range tokens {
+		token, hash, err := GenerateAPIToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[i] = token
+		ds.tokens = append(ds.tokens, &model.APIToken{ID: int64(i + 1), UserID: int64(i + 1), Hash: hash})
+	}
+	recent := time.Now().UTC().Unix() - 1
+	ds.tokens[3].UserID, ds.tokens[3].LastUsed = 1, recent
+
+	tests := []struct {
+		name   string
+		header string
+		token  int64 // ID of returned token, zero if the request isn't authenticated
+	}{
+		{"valid", "Token " + tokens[0], 1},
+		{"deactivated user", "Token " + tokens[1], 0},
+		{"unverified user", "Token " + tokens[2], 0},
+		{"unknown", "Token " + APITokenPrefix + "unknown", 0},
+		{"hash as token", "Token " + ds.tokens[0].Hash, 0},
+		{"empty", "Token ", 0},
+		{"bearer", "Bearer " + tokens[0], 0},
+		{"recently used", "Token " + tokens[3], 4},
+	}
+
+	c := datastore.NewContext(context.Background(), ds)
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/user", nil)
+		r.Header.Set("Authorization", tt.header)
+
+		tok, usr := GetAPITokenFromRequest(c, r)
+		switch {
+		case tt.token == 0 && (tok != nil || usr != nil):
+			t.Errorf("%s: token %+v, want none", tt.name, tok)
+		case tt.token != 0 && (tok == nil || tok.ID != tt.token || usr == nil || usr.ID != tok.UserID):
+			t.Errorf("%s: token %+v, user %+v", tt.name, tok, usr)
+		}
+	}
+
+	// Last used time is updated at most once a minute.
+	if ds.tokens[0].LastUsed == 0 {
+		t.Errorf("last used time of token not updated")
+	}
+	if ds.tokens[3].LastUsed != recent {
+		t.Errorf("last used time of recently used token updated")
+	}
+}
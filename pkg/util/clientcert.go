@@ -0,0 +1,42 @@
+package util
+
+import (
+	"net/http"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+// IsClientCertRequest checks whether the request was made over HTTPS with a
+// client certificate verified against the client CAs, see tlsutil.NewConfig.
+func IsClientCertRequest(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0
+}
+
+// IsClientCertLogin checks whether the user of the request is authenticated
+// with its client certificate, rather than with a token.
+func IsClientCertLogin(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "" && IsClientCertRequest(r)
+}
+
+// getUserClientCert gets the user of the verified client certificate of the
+// request. The common name of the subject is the login of the user. Emails of
+// the certificate are not matched, as any user may have the email of another
+// user's login.
+func getUserClientCert(c context.Context, r *http.Request) *model.User {
+	var cert = r.TLS.VerifiedChains[0][0]
+
+	var login = cert.Subject.CommonName
+	if login == "" {
+		return nil
+	}
+
+	user, err := datastore.GetUserByLoginName(c, login)
+	if err != nil || !user.Verified || user.Deactivated {
+		return nil
+	}
+
+	return user
+}
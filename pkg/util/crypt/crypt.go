@@ -0,0 +1,399 @@
+// Package crypt encrypts files with envelope encryption: each file has its own
+// random data key, wrapped with a master key of the keyring and stored in the
+// header of the file. The content is encrypted with AES-256-GCM in chunks, so
+// that it's streamed and read at any offset without decrypting the whole file.
+// Master keys are rotated by adding a new current key to the keyring and
+// rewrapping the data keys, leaving the content untouched.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	ErrorInvalidKeyFile = errors.New("Invalid master key file")
+	ErrorUnknownKey     = errors.New("Unknown master key")
+	ErrorInvalidFile    = errors.New("Invalid or altered encrypted file")
+)
+
+// magic starts encrypted files.
+var magic = []byte("simdoc-enc-v1\n")
+
+const (
+	keySize   = 32        // AES-256
+	chunkSize = 64 * 1024 // Plaintext bytes per chunk
+	tagSize   = 16        // GCM tag appended to each chunk
+	nonceSize = 12        // GCM nonce
+
+	wrappedKeySize = nonceSize + keySize + tagSize
+)
+
+// Keyring represents master keys. The current key wraps data keys of new
+// files, the others only unwrap data keys of existing files.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// LoadKeyring loads master keys from file at path, one per line as an ID and
+// a base64 encoded 32 bytes key separated by a space, the first being the
+// current key. Blank lines and lines starting with # are ignored. A key is
+// generated with:
+//
+//	echo "$(date +%Y%m%d) $(head -c 32 /dev/urandom | base64)"
+//
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) > 255 {
+			return nil, ErrorInvalidKeyFile
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != keySize {
+			return nil, ErrorInvalidKeyFile
+		}
+		if _, exists := k.keys[fields[0]]; exists {
+			return nil, ErrorInvalidKeyFile
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[fields[0]] = aead
+		if k.current == "" {
+			k.current = fields[0]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if k.current == "" {
+		return nil, ErrorInvalidKeyFile
+	}
+	return k, nil
+}
+
+// Current returns the ID of the current master key.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// IsEncrypted checks whether content of r is encrypted.
+func IsEncrypted(r io.ReaderAt) bool {
+	buf := make([]byte, len(magic))
+	n, _ := r.ReadAt(buf, 0)
+	return n == len(magic) && bytes.Equal(buf, magic)
+}
+
+// header represents the header of an encrypted file.
+type header struct {
+	keyID      string
+	wrappedKey []byte
+}
+
+func (h *header) size() int64 {
+	return int64(len(magic) + 1 + len(h.keyID) + wrappedKeySize)
+}
+
+func (h *header) bytes() []byte {
+	b := make([]byte, 0, h.size())
+	b = append(b, magic...)
+	b = append(b, byte(len(h.keyID)))
+	b = append(b, h.keyID...)
+	return append(b, h.wrappedKey...)
+}
+
+// readHeader reads the header of encrypted file r.
+func readHeader(r io.ReaderAt) (*header, error) {
+	if !IsEncrypted(r) {
+		return nil, ErrorInvalidFile
+	}
+
+	idLen := make([]byte, 1)
+	if _, err := r.ReadAt(idLen, int64(len(magic))); err != nil {
+		return nil, ErrorInvalidFile
+	}
+	b := make([]byte, int(idLen[0])+wrappedKeySize)
+	if _, err := r.ReadAt(b, int64(len(magic)+1)); err != nil {
+		return nil, ErrorInvalidFile
+	}
+
+	return &header{string(b[:idLen[0]]), b[idLen[0]:]}, nil
+}
+
+// wrap returns header of data key wrapped with the current master key.
+func (k *Keyring) wrap(dataKey []byte) (*header, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	h := &header{keyID: k.current}
+	h.wrappedKey = k.keys[k.current].Seal(nonce, nonce, dataKey, []byte(h.keyID))
+	return h, nil
+}
+
+// unwrap returns data key of header h.
+func (k *Keyring) unwrap(h *header) ([]byte, error) {
+	aead, ok := k.keys[h.keyID]
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+
+	dataKey, err := aead.Open(nil, h.wrappedKey[:nonceSize], h.wrappedKey[nonceSize:], []byte(h.keyID))
+	if err != nil {
+		return nil, ErrorInvalidFile
+	}
+	return dataKey, nil
+}
+
+// NewWriter returns a writer encrypting into w with a new data key. Close must
+// be called to write the last chunk; it doesn't close w.
+func (k *Keyring) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	h, err := k.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(h.bytes()); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+type writer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+	err   error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 && w.err == nil {
+		// A full chunk is written once more data comes, as the last chunk
+		// is sealed differently.
+		if len(w.buf) == chunkSize {
+			w.err = w.flush(false)
+			continue
+		}
+		c := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, w.err
+}
+
+func (w *writer) Close() error {
+	if w.err == nil {
+		w.err = w.flush(true)
+		if w.err == nil {
+			w.err = errors.New("crypt: writer closed")
+			return nil
+		}
+	}
+	return w.err
+}
+
+func (w *writer) flush(last bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.index, last), w.buf, nil)
+	if _, err := w.w.Write(sealed); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Reader reads the plaintext of an encrypted file at any offset.
+type Reader struct {
+	r      io.ReaderAt
+	aead   cipher.AEAD
+	offset int64 // Offset of the first chunk
+	chunks int64
+	size   int64
+
+	pos   int64
+	index int64 // Index of the decrypted chunk in buf, -1 if none
+	buf   []byte
+}
+
+// NewReader returns a reader decrypting encrypted file r of size bytes.
+func (k *Keyring) NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := k.unwrap(h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body := size - h.size()
+	chunks := (body + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	if body < tagSize || body-chunks*tagSize < (chunks-1)*chunkSize {
+		return nil, ErrorInvalidFile
+	}
+
+	return &Reader{
+		r:      r,
+		aead:   aead,
+		offset: h.size(),
+		chunks: chunks,
+		size:   body - chunks*tagSize,
+		index:  -1,
+	}, nil
+}
+
+// Size returns the size of the plaintext.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.pos / chunkSize
+	if index != r.index {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf[r.pos-index*chunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("crypt: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("crypt: negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// load decrypts chunk index into buf.
+func (r *Reader) load(index int64) error {
+	last := index == r.chunks-1
+
+	n := int64(chunkSize + tagSize)
+	if last {
+		n = r.size - index*chunkSize + tagSize
+	}
+	sealed := make([]byte, n)
+	if _, err := r.r.ReadAt(sealed, r.offset+index*(chunkSize+tagSize)); err != nil && err != io.EOF {
+		return err
+	}
+
+	buf, err := r.aead.Open(r.buf[:0], chunkNonce(uint64(index), last), sealed, nil)
+	if err != nil {
+		r.index = -1
+		return ErrorInvalidFile
+	}
+	r.buf = buf
+	r.index = index
+	return nil
+}
+
+// Rewrap writes encrypted file r of size bytes into w, with its data key
+// wrapped with the current master key. The content is copied as is.
+func (k *Keyring) Rewrap(r io.ReaderAt, size int64, w io.Writer) error {
+	h, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	dataKey, err := k.unwrap(h)
+	if err != nil {
+		return err
+	}
+	nh, err := k.wrap(dataKey)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(nh.bytes()); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.NewSectionReader(r, h.size(), size-h.size()))
+	return err
+}
+
+// KeyID returns the ID of the master key of encrypted file r.
+func KeyID(r io.ReaderAt) (string, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return "", err
+	}
+	return h.keyID, nil
+}
+
+// chunkNonce returns nonce of chunk index. The last chunk has a distinct nonce,
+// so that truncated files are detected.
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,274 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newKeyring returns keyring of master keys ids, the first being current.
+// Keys are derived from their ID, so that keyrings share keys of same ID.
+func newKeyring(t *testing.T, ids ...string) *Keyring {
+	var buf bytes.Buffer
+	buf.WriteString("# test keys\n\n")
+	for _, id := range ids {
+		key := bytes.Repeat([]byte(id), keySize)[:keySize]
+		buf.WriteString(id + " " + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.txt")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// encrypt returns plain encrypted with k, written in writes of step bytes.
+func encrypt(t *testing.T, k *Keyring, plain []byte, step int) []byte {
+	var buf bytes.Buffer
+	w, err := k.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := plain; len(p) > 0; {
+		n := step
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, keySize))
+	short := base64.StdEncoding.EncodeToString(make([]byte, keySize-1))
+
+	tests := []struct {
+		name    string
+		content string
+		current string
+		err     error
+	}{
+		{"first key current", "# keys\nk2 " + key + "\n\nk1 " + key + "\n", "k2", nil},
+		{"empty", "# keys\n", "", ErrorInvalidKeyFile},
+		{"missing key", "k1\n", "", ErrorInvalidKeyFile},
+		{"short key", "k1 " + short + "\n", "", ErrorInvalidKeyFile},
+		{"not base64", "k1 !!!\n", "", ErrorInvalidKeyFile},
+		{"duplicated ID", "k1 " + key + "\nk1 " + key + "\n", "", ErrorInvalidKeyFile},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, "keys.txt")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		k, err := LoadKeyring(path)
+		switch {
+		case err != tt.err:
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		case err == nil && k.Current() != tt.current:
+			t.Errorf("%s: current key %s, want %s", tt.name, k.Current(), tt.current)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := newKeyring(t, "k1")
+
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize, 2*chunkSize + 7}
+	steps := []int{1000, chunkSize, 3 * chunkSize}
+
+	for _, size := range sizes {
+		plain := randomBytes(t, size)
+		for _, step := range steps {
+			enc := encrypt(t, k, plain, step)
+			if !IsEncrypted(bytes.NewReader(enc)) {
+				t.Errorf("size %d: not encrypted", size)
+			}
+			// Short plaintext may appear in random bytes by chance.
+			if size >= 16 && bytes.Contains(enc, plain) {
+				t.Errorf("size %d: plaintext found in encrypted file", size)
+			}
+
+			r, err := k.NewReader(bytes.NewReader(enc), int64(len(enc)))
+			if err != nil {
+				t.Fatalf("size %d, step %d: %v", size, step, err)
+			}
+			if r.Size() != int64(size) {
+				t.Errorf("size %d, step %d: Size = %d", size, step, r.Size())
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Errorf("size %d, step %d: %v", size, step, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("size %d, step %d: decrypted content differs", size, step)
+			}
+		}
+	}
+}
+
+func TestAlteredFile(t *testing.T) {
+	k := newKeyring(t, "k1")
+	plain := randomBytes(t, 2*chunkSize+100)
+	enc := encrypt(t, k, plain, chunkSize)
+	hsize := len(enc) - 3*tagSize - len(plain)
+
+	tests := []struct {
+		name  string
+		alter func(b []byte) []byte
+	}{
+		{"last chunk removed", func(b []byte) []byte { return b[:hsize+2*(chunkSize+tagSize)] }},
+		{"truncated within last chunk", func(b []byte) []byte { return b[:len(b)-10] }},
+		{"truncated within a chunk", func(b []byte) []byte { return b[:hsize+chunkSize] }},
+		{"header only", func(b []byte) []byte { return b[:hsize] }},
+		{"byte flipped", func(b []byte) []byte { b[hsize+chunkSize+tagSize+5] ^= 1; return b }},
+		{"chunks swapped", func(b []byte) []byte {
+			c := chunkSize + tagSize
+			first := append([]byte(nil), b[hsize:hsize+c]...)
+			copy(b[hsize:], b[hsize+c:hsize+2*c])
+			copy(b[hsize+c:], first)
+			return b
+		}},
+		{"wrapped key altered", func(b []byte) []byte { b[hsize-1] ^= 1; return b }},
+	}
+
+	for _, tt := range tests {
+		b := tt.alter(append([]byte(nil), enc...))
+		r, err := k.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if err != ErrorInvalidFile {
+			t.Errorf("%s: error %v, want %v", tt.name, err, ErrorInvalidFile)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	k := newKeyring(t, "k1")
+	plain := randomBytes(t, 3*chunkSize+50)
+	enc := encrypt(t, k, plain, len(plain))
+
+	r, err := k.NewReader(bytes.NewReader(enc), int64(len(enc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ranges as requested by range requests, within and across chunks.
+	tests := []struct {
+		offset int64
+		whence int
+		n      int
+		pos    int64 // Expected position after seeking
+	}{
+		{0, io.SeekStart, 10, 0},
+		{chunkSize - 5, io.SeekStart, 10, chunkSize - 5},
+		{2*chunkSize + 1, io.SeekStart, chunkSize, 2*chunkSize + 1},
+		{-20, io.SeekEnd, 20, int64(len(plain)) - 20},
+		{-chunkSize - 30, io.SeekCurrent, 30, int64(len(plain)) - chunkSize - 30},
+		{100, io.SeekStart, 2 * chunkSize, 100},
+	}
+
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos != tt.pos {
+			t.Errorf("Seek(%d, %d) = %d, want %d", tt.offset, tt.whence, pos, tt.pos)
+		}
+		got := make([]byte, tt.n)
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("at %d: %v", pos, err)
+		}
+		if !bytes.Equal(got, plain[pos:pos+int64(tt.n)]) {
+			t.Errorf("at %d: content of %d bytes differs", pos, tt.n)
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read at end = %d, %v, want 0, EOF", n, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Seek before start succeeded")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old := newKeyring(t, "k1")
+	plain := randomBytes(t, chunkSize+10)
+	enc := encrypt(t, old, plain, len(plain))
+
+	k := newKeyring(t, "k2", "k1")
+	var buf bytes.Buffer
+	if err := k.Rewrap(bytes.NewReader(enc), int64(len(enc)), &buf); err != nil {
+		t.Fatal(err)
+	}
+	rewrapped := buf.Bytes()
+
+	if id, err := KeyID(bytes.NewReader(rewrapped)); err != nil || id != "k2" {
+		t.Errorf("KeyID = %s, %v, want k2", id, err)
+	}
+	// The content is copied as is, only the header changes.
+	body := len(plain) + 2*tagSize
+	if !bytes.Equal(rewrapped[len(rewrapped)-body:], enc[len(enc)-body:]) {
+		t.Errorf("content changed by rewrapping")
+	}
+
+	// Former master keys are no longer needed.
+	current := newKeyring(t, "k2")
+	r, err := current.NewReader(bytes.NewReader(rewrapped), int64(len(rewrapped)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("rewrapped content differs, %v", err)
+	}
+
+	if _, err := current.NewReader(bytes.NewReader(enc), int64(len(enc))); err != ErrorUnknownKey {
+		t.Errorf("former key: error %v, want %v", err, ErrorUnknownKey)
+	}
+	if err := current.Rewrap(bytes.NewReader(enc), int64(len(enc)), ioutil.Discard); err != ErrorUnknownKey {
+		t.Errorf("rewrap with former key removed: error %v, want %v", err, ErrorUnknownKey)
+	}
+}
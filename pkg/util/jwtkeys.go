@@ -0,0 +1,312 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrorUnknownKey        = errors.New("Unknown JWT key")
+	ErrorAlgorithmMismatch = errors.New("JWT algorithm does not match the key")
+	ErrorUnsupportedKey    = errors.New("Unsupported JWT key type")
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+
+	// hmacKeyID is the key ID of the HMAC secret, used when no key files are
+	// configured.
+	hmacKeyID = "hs"
+)
+
+// JWTKey represents a key to sign or verify JWT. Its algorithm is pinned, so
+// that tokens declaring a different algorithm are rejected.
+type JWTKey struct {
+	ID        string
+	Alg       string
+	Private   interface{} // Key to sign. Nil for verification only key
+	Public    interface{} // Key to verify
+	Symmetric bool        // Whether it's an HMAC secret, never published
+}
+
+// Method returns the signing method of the key.
+func (k *JWTKey) Method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+// KeySet represents keys to sign and verify JWT. Tokens are signed with one key
+// and verified with any key of the set, so keys can be rotated by adding a new
+// signing key while keeping previous ones for verification.
+type KeySet struct {
+	Issuer   string
+	Audience string
+
+	signing *JWTKey
+	keys    map[string]*JWTKey // Key is key ID
+}
+
+// NewHMACKeySet returns KeySet with single HS256 secret.
+func NewHMACKeySet(secret, issuer, audience string) *KeySet {
+	k := &JWTKey{
+		ID:        hmacKeyID,
+		Alg:       AlgorithmHS256,
+		Private:   []byte(secret),
+		Public:    []byte(secret),
+		Symmetric: true,
+	}
+
+	return &KeySet{issuer, audience, k, map[string]*JWTKey{k.ID: k}}
+}
+
+// LoadKeySet loads keys from PEM files, named "<key ID>.pem", in dir. Each
+// file contains either a private key, to sign and verify, or a public key, to
+// verify only. RSA keys are used with RS256, P-256 keys with ES256 and Ed25519
+// keys with EdDSA. Tokens are signed with the key signingKeyID.
+func LoadKeySet(dir, signingKeyID, issuer, audience string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{Issuer: issuer, Audience: audience, keys: make(map[string]*JWTKey, len(files))}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+		k, err := parseJWTKey(kid, b)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %s", kid, err)
+		}
+		ks.keys[kid] = k
+	}
+
+	k, ok := ks.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt key %s: %s", signingKeyID, ErrorUnknownKey)
+	}
+	if k.Private == nil {
+		return nil, fmt.Errorf("jwt key %s: signing key must be a private key", signingKeyID)
+	}
+	ks.signing = k
+
+	return ks, nil
+}
+
+// SigningKey returns the key to sign new tokens.
+func (ks *KeySet) SigningKey() *JWTKey {
+	return ks.signing
+}
+
+// Key returns key for the given key ID.
+func (ks *KeySet) Key(kid string) (*JWTKey, bool) {
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+// Keyfunc returns the key to verify token t. The token must declare a known key
+// ID and the algorithm of that key.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	k, ok := ks.Key(kid)
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+	if t.Method == nil || t.Method.Alg() != k.Alg {
+		return nil, ErrorAlgorithmMismatch
+	}
+
+	return k.Public, nil
+}
+
+// JWK represents a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS represents a set of public keys in JSON Web Key format.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// JWKS returns public keys of the set. HMAC secrets are never included.
+func (ks *KeySet) JWKS() *JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := &JWKS{Keys: make([]*JWK, 0, len(kids))}
+	for _, kid := range kids {
+		k := ks.keys[kid]
+		if k.Symmetric {
+			continue
+		}
+
+		jwk := &JWK{Kid: k.ID, Use: "sig", Alg: k.Alg}
+		switch pub := k.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(pub.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = b64(pad(pub.X.Bytes(), size))
+			jwk.Y = b64(pad(pub.Y.Bytes(), size))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// PublicKey returns the public key of k and its algorithm, for keys usable with
+// RS256, ES256 or EdDSA.
+func (k *JWK) PublicKey() (interface{}, string, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := unb64(k.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := unb64(k.E)
+		if err != nil {
+			return nil, "", err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, AlgorithmRS256, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := unb64(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := unb64(k.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, "", ErrorUnsupportedKey
+		}
+		return pub, AlgorithmES256, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := unb64(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", ErrorUnsupportedKey
+		}
+		return ed25519.PublicKey(x), AlgorithmEdDSA, nil
+	default:
+		return nil, "", ErrorUnsupportedKey
+	}
+}
+
+// parseJWTKey parses private or public key in PEM format.
+func parseJWTKey(kid string, b []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := &JWTKey{ID: kid}
+	if signer, ok := key.(crypto.Signer); ok {
+		k.Private = key
+		key = signer.Public()
+	}
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		k.Alg = AlgorithmRS256
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, ErrorUnsupportedKey
+		}
+		k.Alg = AlgorithmES256
+	case ed25519.PublicKey:
+		k.Alg = AlgorithmEdDSA
+	default:
+		return nil, ErrorUnsupportedKey
+	}
+	k.Public = key
+
+	return k, nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func unb64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// pad left-pads b with zeros to size bytes.
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
@@ -0,0 +1,276 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// writeKey writes private key of the given algorithm to dir as key kid, and
+// returns its public key in PEM format.
+func writeKey(t *testing.T, dir, kid, alg string) []byte {
+	var priv interface{}
+	var err error
+	switch alg {
+	case AlgorithmRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, kid+".pem"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := parseJWTKey(kid, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(k.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// signToken signs token with valid claims for ks, changed by claims, with the
+// given algorithm, key ID and key.
+func signToken(t *testing.T, ks *KeySet, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	now := time.Now().UTC().Unix()
+
+	token := jwt.New(jwt.GetSigningMethod(alg))
+	token.Header["kid"] = kid
+	token.Claims["iss"] = ks.Issuer
+	token.Claims["aud"] = ks.Audience
+	token.Claims["iat"] = now
+	token.Claims["nbf"] = now
+	token.Claims["exp"] = now + 60
+	for name, v := range claims {
+		if v == nil {
+			delete(token.Claims, name)
+			continue
+		}
+		token.Claims[name] = v
+	}
+
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// validToken checks whether token is valid for ks.
+func validToken(ks *KeySet, token string) bool {
+	t, err := jwt.Parse(token, ks.Keyfunc)
+	return err == nil && t.Valid && validClaims(ks, t.Claims)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for kid, alg := range map[string]string{"rs": AlgorithmRS256, "es": AlgorithmES256, "ed": AlgorithmEdDSA} {
+		writeKey(t, dir, kid, alg)
+
+		ks, err := LoadKeySet(dir, kid, "simdoc", "simdoc")
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		k := ks.SigningKey()
+		if k.ID != kid || k.Alg != alg {
+			t.Errorf("%s: signing key %s with %s", alg, k.ID, k.Alg)
+		}
+		if token := signToken(t, ks, k.Alg, k.ID, k.Private, nil); !validToken(ks, token) {
+			t.Errorf("%s: token refused", alg)
+		}
+	}
+
+	if _, err := LoadKeySet(dir, "unknown", "simdoc", "simdoc"); err == nil {
+		t.Errorf("unknown signing key loaded")
+	}
+
+	// Public keys only verify tokens.
+	pub := writeKey(t, dir, "priv", AlgorithmRS256)
+	if err := ioutil.WriteFile(filepath.Join(dir, "pub.pem"), pub, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeySet(dir, "pub", "simdoc", "simdoc"); err == nil {
+		t.Errorf("public key loaded as signing key")
+	}
+}
+
+func TestKeyfuncAlgorithm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pub := writeKey(t, dir, "rs", AlgorithmRS256)
+	ks, err := LoadKeySet(dir, "rs", "simdoc", "simdoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := ks.SigningKey()
+	hs := NewHMACKeySet("secret", "simdoc", "simdoc").SigningKey()
+
+	tests := []struct {
+		name  string
+		alg   string
+		kid   string
+		key   interface{}
+		valid bool
+	}{
+		{"pinned algorithm", AlgorithmRS256, "rs", rs.Private, true},
+		{"other RSA algorithm", "RS512", "rs", rs.Private, false},
+		{"HMAC with public key", AlgorithmHS256, "rs", pub, false},
+		{"unknown key ID", AlgorithmRS256, "other", rs.Private, false},
+		{"missing key ID", AlgorithmRS256, "", rs.Private, false},
+		{"key of other set", AlgorithmHS256, hs.ID, hs.Private, false},
+	}
+
+	for _, tt := range tests {
+		token := signToken(t, ks, tt.alg, tt.kid, tt.key, nil)
+		if valid := validToken(ks, token); valid != tt.valid {
+			t.Errorf("%s: valid %v, want %v", tt.name, valid, tt.valid)
+		}
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeKey(t, dir, "2020", AlgorithmES256)
+	ks, err := LoadKeySet(dir, "2020", "simdoc", "simdoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := signToken(t, ks, ks.SigningKey().Alg, "2020", ks.SigningKey().Private, nil)
+
+	// New signing key is added, previous one still verifies tokens.
+	writeKey(t, dir, "2021", AlgorithmEdDSA)
+	if ks, err = LoadKeySet(dir, "2021", "simdoc", "simdoc"); err != nil {
+		t.Fatal(err)
+	}
+	if !validToken(ks, old) {
+		t.Errorf("token of previous key refused")
+	}
+	if kids := len(ks.JWKS().Keys); kids != 2 {
+		t.Errorf("%d published keys, want 2", kids)
+	}
+
+	// Previous key is removed, its tokens are refused.
+	os.Remove(filepath.Join(dir, "2020.pem"))
+	if ks, err = LoadKeySet(dir, "2021", "simdoc", "simdoc"); err != nil {
+		t.Fatal(err)
+	}
+	if validToken(ks, old) {
+		t.Errorf("token of removed key accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	algs := map[string]string{"rs": AlgorithmRS256, "es": AlgorithmES256, "ed": AlgorithmEdDSA}
+	for kid, alg := range algs {
+		writeKey(t, dir, kid, alg)
+	}
+	ks, err := LoadKeySet(dir, "rs", "simdoc", "simdoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Published keys verify tokens signed with their private keys.
+	set := ks.JWKS()
+	if len(set.Keys) != len(algs) {
+		t.Fatalf("%d published keys, want %d", len(set.Keys), len(algs))
+	}
+	for _, jwk := range set.Keys {
+		pub, alg, err := jwk.PublicKey()
+		if err != nil {
+			t.Errorf("%s: %s", jwk.Kid, err)
+			continue
+		}
+		if alg != algs[jwk.Kid] || jwk.Alg != alg {
+			t.Errorf("%s: algorithm %s, want %s", jwk.Kid, alg, algs[jwk.Kid])
+		}
+
+		k, _ := ks.Key(jwk.Kid)
+		token, err := jwt.Parse(signToken(t, ks, k.Alg, k.ID, k.Private, nil), func(*jwt.Token) (interface{}, error) {
+			return pub, nil
+		})
+		if err != nil || !token.Valid {
+			t.Errorf("%s: token refused by published key: %v", jwk.Kid, err)
+		}
+	}
+
+	// HMAC secret is never published.
+	if keys := NewHMACKeySet("secret", "simdoc", "simdoc").JWKS().Keys; len(keys) != 0 {
+		t.Errorf("HMAC secret published: %+v", keys[0])
+	}
+}
+
+func TestValidClaims(t *testing.T) {
+	ks := NewHMACKeySet("secret", "simdoc", "simdoc")
+	k := ks.SigningKey()
+	future := time.Now().UTC().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{"valid", nil, true},
+		{"audience in list", map[string]interface{}{"aud": []string{"other", "simdoc"}}, true},
+		{"other issuer", map[string]interface{}{"iss": "other"}, false},
+		{"missing issuer", map[string]interface{}{"iss": nil}, false},
+		{"other audience", map[string]interface{}{"aud": "other"}, false},
+		{"audience not in list", map[string]interface{}{"aud": []string{"other"}}, false},
+		{"missing audience", map[string]interface{}{"aud": nil}, false},
+		{"issued in future", map[string]interface{}{"iat": future}, false},
+		{"missing iat", map[string]interface{}{"iat": nil}, false},
+		{"not yet valid", map[string]interface{}{"nbf": future}, false},
+		{"expired", map[string]interface{}{"exp": time.Now().UTC().Add(-time.Hour).Unix()}, false},
+	}
+
+	for _, tt := range tests {
+		token := signToken(t, ks, k.Alg, k.ID, k.Private, tt.claims)
+		if valid := validToken(ks, token); valid != tt.valid {
+			t.Errorf("%s: valid %v, want %v", tt.name, valid, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package lockout
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type datastoreStore struct {
+	ds datastore.LoginAttemptstore
+}
+
+// NewDatastoreStore returns Store that keeps counters in the datastore, so that
+// they survive restarts and are shared between instances.
+func NewDatastoreStore(ds datastore.LoginAttemptstore) Store {
+	return &datastoreStore{ds}
+}
+
+func (s *datastoreStore) Get(key string) (*Counter, error) {
+	a, err := s.ds.GetLoginAttempt(key)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > a.Expires {
+		return nil, nil
+	}
+
+	return toCounter(a), nil
+}
+
+func (s *datastoreStore) Update(key string, fn func(c *Counter)) (*Counter, error) {
+	var c *Counter
+	_, err := s.ds.UpdateLoginAttempt(key, func(a *model.LoginAttempt) {
+		c = new(Counter)
+		if time.Now().Unix() <= a.Expires {
+			c = toCounter(a)
+		}
+		fn(c)
+
+		a.Failures = c.Failures
+		a.LastFailure = toUnix(c.LastFailure)
+		a.LockedUntil = toUnix(c.LockedUntil)
+		a.Locked = c.Locked
+		a.Expires = toUnix(c.Expires)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *datastoreStore) Delete(key string) error {
+	return s.ds.DeleteLoginAttempt(key)
+}
+
+// toCounter returns counter of failed attempts a.
+func toCounter(a *model.LoginAttempt) *Counter {
+	return &Counter{
+		Failures:    a.Failures,
+		LastFailure: fromUnix(a.LastFailure),
+		LockedUntil: fromUnix(a.LockedUntil),
+		Locked:      a.Locked,
+		Expires:     fromUnix(a.Expires),
+	}
+}
+
+// toUnix returns Unix time of t, or zero for zero time.
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// fromUnix returns time of Unix time sec, or zero time for zero.
+func fromUnix(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
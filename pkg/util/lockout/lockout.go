@@ -0,0 +1,180 @@
+// Package lockout tracks failed login attempts per key, for instance per
+// account and per IP, and delays further attempts with exponential backoff
+// until the key is locked out for a while.
+package lockout
+
+import (
+	"time"
+)
+
+// Counter represents failed attempts of a key.
+type Counter struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time // Attempts are refused until then
+	Locked      bool      // Whether the key is locked out, rather than delayed
+	Expires     time.Time // Counter is forgotten after then
+}
+
+// Store stores counters of keys.
+type Store interface {
+	// Get returns counter of key, or nil if there is none or it has expired.
+	Get(key string) (*Counter, error)
+
+	// Update applies fn to counter of key, a new one if there is none or it has
+	// expired, and stores it. Concurrent updates of key are applied one after
+	// the other, so that no failure is lost. It returns the updated counter.
+	Update(key string, fn func(c *Counter)) (*Counter, error)
+
+	// Delete deletes counter of key.
+	Delete(key string) error
+}
+
+// Policy represents how failed attempts are throttled.
+type Policy struct {
+	FreeAttempts    int           // Failures allowed before backoff starts
+	BaseDelay       time.Duration // Delay after first failure beyond free attempts, doubled on each one
+	MaxDelay        time.Duration // Maximum backoff delay
+	LockoutAfter    int           // Failures after which the key is locked out
+	LockoutDuration time.Duration // Lockout duration
+	Window          time.Duration // Failures are forgotten after this time without failure
+}
+
+var (
+	// AccountPolicy throttles attempts per account.
+	AccountPolicy = &Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+
+	// IPPolicy throttles attempts per IP, which may be shared by many users.
+	IPPolicy = &Policy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+)
+
+// Limiter throttles attempts of keys according to a policy.
+type Limiter struct {
+	store  Store
+	policy *Policy
+	now    func() time.Time
+}
+
+// New returns Limiter that stores counters in store.
+func New(store Store, policy *Policy) *Limiter {
+	return &Limiter{store, policy, time.Now}
+}
+
+// Check returns how long to wait before the next attempt of key. Zero means
+// the attempt is allowed.
+func (l *Limiter) Check(key string) (time.Duration, error) {
+	c, err := l.store.Get(key)
+	if err != nil || c == nil {
+		return 0, err
+	}
+
+	if wait := c.LockedUntil.Sub(l.now()); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Fail records a failed attempt of key. It returns the updated counter, whose
+// Locked is true if this failure locked the key out.
+func (l *Limiter) Fail(key string) (*Counter, bool, error) {
+	var locked bool
+	c, err := l.store.Update(key, func(c *Counter) {
+		locked = l.fail(c, l.now())
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return c, locked, nil
+}
+
+// Attempt checks whether an attempt of key is allowed and, if so, counts it as
+// failed in the same update, so that concurrent attempts can't exceed the
+// policy while they are verified. A successful attempt is then uncounted with
+// Cancel, or Reset. It returns the counter, how long to wait if the attempt is
+// refused, in which case it's not counted, and whether counting it locked the
+// key out.
+func (l *Limiter) Attempt(key string) (c *Counter, wait time.Duration, locked bool, err error) {
+	c, err = l.store.Update(key, func(c *Counter) {
+		var now = l.now()
+		if wait = c.LockedUntil.Sub(now); wait > 0 {
+			return
+		}
+		wait = 0
+		locked = l.fail(c, now)
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return c, wait, locked, nil
+}
+
+// Cancel uncounts an attempt of key counted by Attempt, which didn't fail.
+// Backoff and lockout are derived from the remaining failures.
+func (l *Limiter) Cancel(key string) error {
+	_, err := l.store.Update(key, func(c *Counter) {
+		if c.Failures > 0 {
+			c.Failures--
+		}
+		if c.Failures >= l.policy.LockoutAfter {
+			return
+		}
+		c.Locked = false
+		c.LockedUntil = time.Time{}
+		if c.Failures > l.policy.FreeAttempts {
+			c.LockedUntil = c.LastFailure.Add(l.delay(c.Failures - l.policy.FreeAttempts))
+		}
+	})
+	return err
+}
+
+// fail counts a failed attempt in c at time now. It returns true if the
+// failure locked the key out.
+func (l *Limiter) fail(c *Counter, now time.Time) bool {
+	var wasLocked = c.Locked && now.Before(c.LockedUntil)
+	c.Failures++
+	c.LastFailure = now
+	c.Expires = now.Add(l.policy.Window)
+	switch {
+	case c.Failures >= l.policy.LockoutAfter:
+		c.Locked = true
+		c.LockedUntil = now.Add(l.policy.LockoutDuration)
+	case c.Failures > l.policy.FreeAttempts:
+		c.LockedUntil = now.Add(l.delay(c.Failures - l.policy.FreeAttempts))
+	}
+	if c.LockedUntil.After(c.Expires) {
+		c.Expires = c.LockedUntil
+	}
+	return c.Locked && !wasLocked
+}
+
+// Reset forgets failed attempts of key, after a successful attempt or to
+// unlock it.
+func (l *Limiter) Reset(key string) error {
+	return l.store.Delete(key)
+}
+
+// delay returns backoff delay after n failures beyond free attempts.
+func (l *Limiter) delay(n int) time.Duration {
+	d := l.policy.BaseDelay
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= l.policy.MaxDelay {
+			return l.policy.MaxDelay
+		}
+	}
+	return d
+}
@@ -0,0 +1,197 @@
+package lockout
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = &Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        4 * time.Second,
+	LockoutAfter:    6,
+	LockoutDuration: time.Hour,
+	Window:          time.Hour,
+}
+
+func TestLimiterFail(t *testing.T) {
+	now := time.Now()
+	l := New(NewMemoryStore(), testPolicy)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		wait   time.Duration // Wait after the failure
+		locked bool          // Whether the failure locks the key out
+	}{
+		{0, false},
+		{0, false},
+		{time.Second, false},
+		{2 * time.Second, false},
+		{4 * time.Second, false},
+		{time.Hour, true},
+		{time.Hour, false}, // Already locked out
+	}
+
+	for i, tt := range tests {
+		c, locked, err := l.Fail("k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Failures != i+1 {
+			t.Errorf("failure %d: Failures = %d", i+1, c.Failures)
+		}
+		if locked != tt.locked {
+			t.Errorf("failure %d: locked = %v, want %v", i+1, locked, tt.locked)
+		}
+		wait, err := l.Check("k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != tt.wait {
+			t.Errorf("failure %d: Check = %s, want %s", i+1, wait, tt.wait)
+		}
+	}
+
+	if err := l.Reset("k"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.Check("k"); wait != 0 {
+		t.Errorf("Check after Reset = %s, want 0", wait)
+	}
+}
+
+func TestLimiterFailConcurrent(t *testing.T) {
+	const n = 50
+	l := New(NewMemoryStore(), testPolicy)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var lockouts int
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, locked, err := l.Fail("k")
+			if err != nil {
+				t.Error(err)
+			}
+			if locked {
+				mu.Lock()
+				lockouts++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, err := l.store.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Failures != n {
+		t.Errorf("Failures = %d, want %d", c.Failures, n)
+	}
+	if lockouts != 1 {
+		t.Errorf("lockouts = %d, want 1", lockouts)
+	}
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	s := NewMemoryStore()
+	s.Update("k", func(c *Counter) {
+		c.Failures = 3
+		c.Expires = time.Now().Add(-time.Second)
+	})
+
+	if c, _ := s.Get("k"); c != nil {
+		t.Errorf("Get of expired counter = %+v, want nil", c)
+	}
+	c, _ := s.Update("k", func(c *Counter) {
+		c.Failures++
+		c.Expires = time.Now().Add(time.Hour)
+	})
+	if c.Failures != 1 {
+		t.Errorf("Failures after expiry = %d, want 1", c.Failures)
+	}
+}
+
+func TestLimiterAttempt(t *testing.T) {
+	now := time.Now()
+	l := New(NewMemoryStore(), testPolicy)
+	l.now = func() time.Time { return now }
+
+	// Attempts are counted as failures until cancelled.
+	for i := 0; i < 3; i++ {
+		c, wait, _, err := l.Attempt("k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 || c.Failures != i+1 {
+			t.Errorf("attempt %d: wait = %s, Failures = %d", i+1, wait, c.Failures)
+		}
+	}
+	c, wait, _, err := l.Attempt("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != time.Second || c.Failures != 3 {
+		t.Errorf("delayed attempt: wait = %s, Failures = %d, want refused", wait, c.Failures)
+	}
+
+	// Cancelled attempt lifts the backoff it caused.
+	if err := l.Cancel("k"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.Check("k"); wait != 0 {
+		t.Errorf("Check after Cancel = %s, want 0", wait)
+	}
+	c, _ = l.store.Get("k")
+	if c.Failures != 2 {
+		t.Errorf("Failures after Cancel = %d, want 2", c.Failures)
+	}
+
+	// Attempt that locks the key out, once cancelled, unlocks it.
+	for i := 0; i < 3; i++ {
+		l.Fail("k")
+	}
+	now = now.Add(time.Minute)
+	if _, _, locked, _ := l.Attempt("k"); !locked {
+		t.Errorf("attempt %d didn't lock out", testPolicy.LockoutAfter)
+	}
+	l.Cancel("k")
+	c, _ = l.store.Get("k")
+	if c.Locked || c.Failures != testPolicy.LockoutAfter-1 {
+		t.Errorf("cancelled lockout: Locked = %v, Failures = %d", c.Locked, c.Failures)
+	}
+}
+
+func TestLimiterAttemptConcurrent(t *testing.T) {
+	const n = 50
+	l := New(NewMemoryStore(), testPolicy)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var allowed int
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, wait, _, err := l.Attempt("k")
+			if err != nil {
+				t.Error(err)
+			}
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Attempts beyond free ones are delayed, even before any is known to fail.
+	if want := testPolicy.FreeAttempts + 1; allowed != want {
+		t.Errorf("allowed = %d, want %d", allowed, want)
+	}
+}
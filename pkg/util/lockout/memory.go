@@ -0,0 +1,69 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepSize is the number of counters beyond which expired ones are swept.
+const sweepSize = 10000
+
+type memoryStore struct {
+	sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewMemoryStore returns Store that keeps counters in memory. Counters are
+// lost on restart and not shared between instances.
+func NewMemoryStore() Store {
+	return &memoryStore{counters: make(map[string]*Counter)}
+}
+
+func (s *memoryStore) Get(key string) (*Counter, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(c.Expires) {
+		delete(s.counters, key)
+		return nil, nil
+	}
+
+	cc := *c
+	return &cc, nil
+}
+
+func (s *memoryStore) Update(key string, fn func(c *Counter)) (*Counter, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if len(s.counters) >= sweepSize {
+		for k, v := range s.counters {
+			if now.After(v.Expires) {
+				delete(s.counters, k)
+			}
+		}
+	}
+
+	c, ok := s.counters[key]
+	if !ok || now.After(c.Expires) {
+		c = new(Counter)
+		s.counters[key] = c
+	}
+	fn(c)
+
+	cc := *c
+	return &cc, nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileSender struct {
+	dir  string
+	from string
+}
+
+// NewFileSender returns a Sender, for local testing, that writes each message
+// as .eml file into dir. If dir is empty only recipients and subjects are
+// logged, as bodies hold secrets such as password reset tokens.
+func NewFileSender(dir, from string) Sender {
+	return &fileSender{dir, from}
+}
+
+func (s *fileSender) Send(m *Message) error {
+	if s.dir == "" {
+		log.Printf("mail: not sent to %s: %s\n", strings.Join(m.To, ", "), m.Subject)
+		return nil
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	fname := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+
+	return ioutil.WriteFile(filepath.Join(s.dir, fname), m.bytes(s.from), 0600)
+}
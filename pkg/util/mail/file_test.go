@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	m := &Message{To: []string{"alice@example.com"}, Subject: "Reset your password", Body: "token=secret-token"}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Without directory, bodies holding secrets are not logged.
+	if err := NewFileSender("", "simdoc@localhost").Send(m); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); strings.Contains(out, "secret-token") || !strings.Contains(out, "alice@example.com") {
+		t.Errorf("logged %q", out)
+	}
+
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailDir := filepath.Join(dir, "mail")
+	if err := NewFileSender(mailDir, "simdoc@localhost").Send(m); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := filepath.Glob(filepath.Join(mailDir, "*.eml"))
+	if len(names) != 1 {
+		t.Fatalf("%d messages written, want 1", len(names))
+	}
+	fi, err := os.Stat(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("message mode %v, want 0600", fi.Mode().Perm())
+	}
+	if b, _ := ioutil.ReadFile(names[0]); !bytes.Contains(b, []byte("token=secret-token")) {
+		t.Errorf("message body not written")
+	}
+}
@@ -0,0 +1,37 @@
+// Package mail sends email notifications to users.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message represents a plain text email.
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Sender sends email messages.
+type Sender interface {
+	Send(m *Message) error
+}
+
+// bytes returns the message, sent from from, in RFC 5322 format.
+func (m *Message) bytes(from string) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+	buf.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+
+	return buf.Bytes()
+}
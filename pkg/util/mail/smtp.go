@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+)
+
+type smtpSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPSender returns a Sender that sends through SMTP server at addr, for
+// instance "smtp.example.com:587". PLAIN authentication is used if username
+// is not empty.
+func NewSMTPSender(addr, username, password, from string) Sender {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtpSender{addr, auth, from}
+}
+
+func (s *smtpSender) Send(m *Message) error {
+	return smtp.SendMail(s.addr, s.auth, s.from, m.To, m.bytes(s.from))
+}
@@ -0,0 +1,103 @@
+package password
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Default argon2id parameters, as recommended by RFC 9106 for memory
+// constrained environments.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // In KiB
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+type argon2id struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+}
+
+func newArgon2id() *argon2id {
+	return &argon2id{argon2Time, argon2Memory, argon2Threads, argon2KeyLen}
+}
+
+func (a *argon2id) Hash(password string) (string, error) {
+	s, err := salt(argon2SaltLen)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), s, a.time, a.memory, a.threads, a.keyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.memory, a.time, a.threads,
+		base64.RawStdEncoding.EncodeToString(s),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *argon2id) Verify(password, encoded string) (bool, error) {
+	p, s, key, err := a.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), s, p.time, p.memory, p.threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *argon2id) NeedsRehash(encoded string) bool {
+	p, _, key, err := a.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return p.time != a.time || p.memory != a.memory || p.threads != a.threads || uint32(len(key)) != a.keyLen
+}
+
+func (a *argon2id) Match(encoded string) bool {
+	f := splitHash(encoded)
+	return len(f) > 0 && f[0] == AlgorithmArgon2id
+}
+
+// decode returns parameters, salt and key of encoded hash.
+func (a *argon2id) decode(encoded string) (*argon2id, []byte, []byte, error) {
+	f := splitHash(encoded)
+	if len(f) != 5 || f[0] != AlgorithmArgon2id {
+		return nil, nil, nil, ErrorInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(f[1], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrorInvalidHash
+	}
+
+	p := new(argon2id)
+	if _, err := fmt.Sscanf(f[2], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, nil, nil, ErrorInvalidHash
+	}
+	// argon2.IDKey panics without threads.
+	if p.memory == 0 || p.time == 0 || p.threads == 0 {
+		return nil, nil, nil, ErrorInvalidHash
+	}
+
+	s, err := base64.RawStdEncoding.DecodeString(f[3])
+	if err != nil {
+		return nil, nil, nil, ErrorInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(f[4])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrorInvalidHash
+	}
+	p.keyLen = uint32(len(key))
+
+	return p, s, key, nil
+}
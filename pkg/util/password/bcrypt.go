@@ -0,0 +1,52 @@
+package password
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+const bcryptCost = 12
+
+type bcryptHasher struct {
+	cost int
+}
+
+func newBcrypt() *bcryptHasher {
+	return &bcryptHasher{bcryptCost}
+}
+
+func (b *bcryptHasher) Hash(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+func (b *bcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	default:
+		return false, ErrorInvalidHash
+	}
+}
+
+func (b *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
+
+func (b *bcryptHasher) Match(encoded string) bool {
+	f := splitHash(encoded)
+	if len(f) == 0 {
+		return false
+	}
+	switch f[0] {
+	case "2a", "2b", "2y":
+		return true
+	}
+	return false
+}
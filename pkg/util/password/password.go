@@ -0,0 +1,112 @@
+// Package password hashes and verifies user passwords. Hashes are encoded
+// with their algorithm, parameters and per-user random salt, so the algorithm
+// can be upgraded while existing hashes are still verifiable.
+package password
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrorUnknownAlgorithm = errors.New("Unknown password hashing algorithm")
+	ErrorInvalidHash      = errors.New("Invalid password hash")
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmPBKDF2   = "pbkdf2" // Legacy, verification only
+)
+
+// Hasher hashes and verifies passwords.
+type Hasher interface {
+	// Hash returns encoded hash of password with a random salt.
+	Hash(password string) (string, error)
+
+	// Verify checks, in constant time, whether password matches encoded hash.
+	Verify(password, encoded string) (bool, error)
+
+	// NeedsRehash checks whether encoded hash is not produced by this hasher
+	// with its current parameters, and should be replaced on next login.
+	NeedsRehash(encoded string) bool
+}
+
+// algorithm is implemented by each supported hashing algorithm.
+type algorithm interface {
+	Hasher
+
+	// Match checks whether encoded hash is produced by the algorithm.
+	Match(encoded string) bool
+}
+
+// New returns a Hasher that hashes with the given algorithm, either argon2id or
+// bcrypt, and verifies hashes of any supported algorithm, including legacy
+// PBKDF2 hashes made with the global legacySalt.
+func New(algo, legacySalt string) (Hasher, error) {
+	var preferred algorithm
+	switch algo {
+	case AlgorithmArgon2id:
+		preferred = newArgon2id()
+	case AlgorithmBcrypt:
+		preferred = newBcrypt()
+	default:
+		return nil, ErrorUnknownAlgorithm
+	}
+
+	return &multiHasher{
+		preferred: preferred,
+		algos: []algorithm{
+			preferred,
+			newArgon2id(),
+			newBcrypt(),
+			newLegacyPBKDF2(legacySalt),
+		},
+	}, nil
+}
+
+// multiHasher hashes with preferred algorithm and verifies with whichever
+// algorithm produced the hash.
+type multiHasher struct {
+	preferred algorithm
+	algos     []algorithm
+}
+
+func (h *multiHasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+func (h *multiHasher) Verify(password, encoded string) (bool, error) {
+	for _, a := range h.algos {
+		if a.Match(encoded) {
+			return a.Verify(password, encoded)
+		}
+	}
+	return false, ErrorInvalidHash
+}
+
+func (h *multiHasher) NeedsRehash(encoded string) bool {
+	if !h.preferred.Match(encoded) {
+		return true
+	}
+	return h.preferred.NeedsRehash(encoded)
+}
+
+// salt returns n random bytes.
+func salt(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// splitHash splits encoded hash in modular crypt format, for instance
+// "$argon2id$v=19$m=65536,t=1,p=4$salt$hash", into its fields.
+func splitHash(encoded string) []string {
+	if !strings.HasPrefix(encoded, "$") {
+		return nil
+	}
+	return strings.Split(encoded[1:], "$")
+}
@@ -0,0 +1,144 @@
+package password
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/util"
+)
+
+const legacySalt = "legacy-salt"
+
+// legacyHash returns legacy PBKDF2 hash of password, as stored before per-user
+// salts.
+func legacyHash(password string) string {
+	return hex.EncodeToString(util.PBKDF2([]byte(password), []byte(legacySalt), pbkdf2Iter, pbkdf2KeyLen, sha256.New))
+}
+
+func TestHasher(t *testing.T) {
+	argon, err := New(AlgorithmArgon2id, legacySalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcrypt, err := New(AlgorithmBcrypt, legacySalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New("md5", legacySalt); err != ErrorUnknownAlgorithm {
+		t.Errorf("New(md5): error %v, want %v", err, ErrorUnknownAlgorithm)
+	}
+
+	argonHash, err := argon.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := argon.Hash("secret"); other == argonHash {
+		t.Errorf("hashes of the same password share their salt")
+	}
+
+	tests := []struct {
+		name    string
+		hasher  Hasher
+		encoded string
+		rehash  bool
+	}{
+		{"argon2id", argon, argonHash, false},
+		{"bcrypt by argon2id", argon, bcryptHash, true},
+		{"legacy by argon2id", argon, legacyHash("secret"), true},
+		{"bcrypt", bcrypt, bcryptHash, false},
+		{"argon2id by bcrypt", bcrypt, argonHash, true},
+		{"legacy by bcrypt", bcrypt, legacyHash("secret"), true},
+	}
+
+	for _, tt := range tests {
+		if ok, err := tt.hasher.Verify("secret", tt.encoded); !ok || err != nil {
+			t.Errorf("%s: Verify = %v, %v, want true", tt.name, ok, err)
+		}
+		if ok, err := tt.hasher.Verify("other", tt.encoded); ok || err != nil {
+			t.Errorf("%s: Verify of other password = %v, %v, want false", tt.name, ok, err)
+		}
+		if rehash := tt.hasher.NeedsRehash(tt.encoded); rehash != tt.rehash {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, rehash, tt.rehash)
+		}
+	}
+}
+
+func TestArgon2idParams(t *testing.T) {
+	a := newArgon2id()
+	encoded, err := a.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := splitHash(encoded)
+
+	// Hashes of former parameters are still verified, then rehashed.
+	weak := &argon2id{time: 1, memory: 8 * 1024, threads: 1, keyLen: argon2KeyLen}
+	weakHash, err := weak.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.Verify("secret", weakHash); !ok || err != nil {
+		t.Errorf("Verify of former parameters = %v, %v, want true", ok, err)
+	}
+	if !a.NeedsRehash(weakHash) {
+		t.Errorf("NeedsRehash of former parameters = false")
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"no threads", "$argon2id$v=19$m=65536,t=3,p=0$" + f[3] + "$" + f[4]},
+		{"no memory", "$argon2id$v=19$m=0,t=3,p=4$" + f[3] + "$" + f[4]},
+		{"no time", "$argon2id$v=19$m=65536,t=0,p=4$" + f[3] + "$" + f[4]},
+		{"threads overflow", "$argon2id$v=19$m=65536,t=3,p=256$" + f[3] + "$" + f[4]},
+		{"other version", "$argon2id$v=16$" + f[2] + "$" + f[3] + "$" + f[4]},
+		{"missing params", "$argon2id$v=19$m=65536$" + f[3] + "$" + f[4]},
+		{"invalid salt", "$argon2id$v=19$" + f[2] + "$!$" + f[4]},
+		{"empty key", "$argon2id$v=19$" + f[2] + "$" + f[3] + "$"},
+		{"missing key", "$argon2id$v=19$" + f[2] + "$" + f[3]},
+		{"other algorithm", "$argon2i$v=19$" + f[2] + "$" + f[3] + "$" + f[4]},
+	}
+
+	h, _ := New(AlgorithmArgon2id, legacySalt)
+	for _, tt := range tests {
+		if ok, err := h.Verify("secret", tt.encoded); ok || err != ErrorInvalidHash {
+			t.Errorf("%s: Verify = %v, %v, want %v", tt.name, ok, err, ErrorInvalidHash)
+		}
+		if !h.NeedsRehash(tt.encoded) {
+			t.Errorf("%s: NeedsRehash = false", tt.name)
+		}
+	}
+}
+
+func TestLegacyPBKDF2(t *testing.T) {
+	l := newLegacyPBKDF2(legacySalt)
+	if _, err := l.Hash("secret"); err == nil {
+		t.Errorf("legacy hash produced")
+	}
+
+	tests := []struct {
+		encoded string
+		match   bool
+	}{
+		{legacyHash("secret"), true},
+		{legacyHash("secret")[2:], false},
+		{"$" + legacyHash("secret")[1:], false},
+		{"$2a$12$abcdefghijklmnopqrstuv", false},
+	}
+	for _, tt := range tests {
+		if match := l.Match(tt.encoded); match != tt.match {
+			t.Errorf("Match(%q) = %v, want %v", tt.encoded, match, tt.match)
+		}
+	}
+
+	// Hashes of another global salt don't match.
+	if ok, _ := newLegacyPBKDF2("other").Verify("secret", legacyHash("secret")); ok {
+		t.Errorf("Verify with other salt = true")
+	}
+}
@@ -0,0 +1,49 @@
+package password
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+
+	"github.com/gedex/simdoc/pkg/util"
+)
+
+// Parameters of legacy hashes, stored as hex without algorithm prefix.
+const (
+	pbkdf2Iter   = 10000
+	pbkdf2KeyLen = 50
+)
+
+// legacyPBKDF2 verifies hashes made with the global salt, before per-user salts
+// were introduced. It can not produce new hashes.
+type legacyPBKDF2 struct {
+	salt string
+}
+
+func newLegacyPBKDF2(salt string) *legacyPBKDF2 {
+	return &legacyPBKDF2{salt}
+}
+
+func (l *legacyPBKDF2) Hash(password string) (string, error) {
+	return "", errors.New("password: legacy PBKDF2 hashes are verification only")
+}
+
+func (l *legacyPBKDF2) Verify(password, encoded string) (bool, error) {
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(key) != pbkdf2KeyLen {
+		return false, ErrorInvalidHash
+	}
+
+	other := util.PBKDF2([]byte(password), []byte(l.salt), pbkdf2Iter, pbkdf2KeyLen, sha256.New)
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (l *legacyPBKDF2) NeedsRehash(encoded string) bool {
+	return true
+}
+
+func (l *legacyPBKDF2) Match(encoded string) bool {
+	return len(encoded) == hex.EncodedLen(pbkdf2KeyLen) && splitHash(encoded) == nil
+}
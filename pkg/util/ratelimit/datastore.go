@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gedex/simdoc/pkg/datastore"
+	"github.com/gedex/simdoc/pkg/model"
+)
+
+type datastoreStore struct {
+	ds datastore.RateLimitstore
+
+	mu    sync.Mutex
+	swept time.Time
+}
+
+// NewDatastoreStore returns Store that keeps buckets in the datastore, so that
+// they survive restarts and are shared between instances.
+func NewDatastoreStore(ds datastore.RateLimitstore) Store {
+	return &datastoreStore{ds: ds, swept: time.Now()}
+}
+
+func (s *datastoreStore) Update(key string, fn func(b *Bucket)) error {
+	if err := s.sweep(); err != nil {
+		return err
+	}
+
+	_, err := s.ds.UpdateRateLimitBucket(key, func(rb *model.RateLimitBucket) {
+		b := new(Bucket)
+		if time.Now().Unix() <= rb.Expires {
+			b.Tokens = rb.Tokens
+			b.Updated = time.Unix(0, rb.Updated*int64(time.Millisecond))
+			b.Expires = time.Unix(rb.Expires, 0)
+		}
+		fn(b)
+
+		if !b.Updated.IsZero() {
+			rb.Tokens = b.Tokens
+			rb.Updated = b.Updated.UnixNano() / int64(time.Millisecond)
+			rb.Expires = b.Expires.Unix() + 1
+		}
+	})
+	return err
+}
+
+// sweep deletes expired buckets, at most once per sweep interval.
+func (s *datastoreStore) sweep() error {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.swept) < sweepInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.swept = now
+	s.mu.Unlock()
+
+	return s.ds.DeleteExpiredRateLimitBuckets(now.Unix())
+}
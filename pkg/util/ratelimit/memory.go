@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is the interval between sweeps of expired buckets.
+const sweepInterval = time.Minute
+
+type memoryStore struct {
+	sync.Mutex
+	buckets map[string]*Bucket
+	swept   time.Time
+}
+
+// NewMemoryStore returns Store that keeps buckets in memory. Buckets are lost
+// on restart and not shared between instances.
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]*Bucket), swept: time.Now()}
+}
+
+func (s *memoryStore) Update(key string, fn func(b *Bucket)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.swept) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok || now.After(b.Expires) {
+		b = new(Bucket)
+	}
+	fn(b)
+
+	// Buckets left untouched, such as of denied requests, are not kept.
+	if !b.Updated.IsZero() {
+		s.buckets[key] = b
+	}
+	return nil
+}
+
+// sweep deletes buckets expired at now. The lock must be held.
+func (s *memoryStore) sweep(now time.Time) {
+	for k, b := range s.buckets {
+		if now.After(b.Expires) {
+			delete(s.buckets, k)
+		}
+	}
+	s.swept = now
+}
@@ -0,0 +1,141 @@
+// Package ratelimit limits the rate of requests per key, for instance per user
+// and per IP, with token buckets.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrorInvalidPolicy = errors.New("Invalid rate limit policy")
+
+// Bucket represents the tokens of a key. Each request takes tokens, which are
+// refilled at the rate of the policy up to its limit.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time // Tokens are refilled since then
+	Expires time.Time // Bucket is full again, so forgotten, after then
+}
+
+// Store stores buckets of keys.
+type Store interface {
+	// Update applies fn to bucket of key, a new one with zero Updated if there
+	// is none or it has expired, and stores it. Concurrent updates of key are
+	// applied one after the other, so that no token is taken twice. Expired
+	// buckets are swept from time to time.
+	Update(key string, fn func(b *Bucket)) error
+}
+
+// Policy represents the rate limit of a key: Limit tokens per Period, which
+// can all be taken at once.
+type Policy struct {
+	Limit  float64
+	Period time.Duration
+}
+
+// ParsePolicy parses policy s of the form "<limit>/<period>", such as
+// "600/1m". Limit may have a k, m or g suffix, multiplying it by 1024, 1024²
+// or 1024³, such as "1g/1h" for upload bandwidth. Empty s or zero limit
+// returns nil, meaning no limit.
+func ParsePolicy(s string) (*Policy, error) {
+	if s == "" || s == "0" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return nil, ErrorInvalidPolicy
+	}
+
+	var limit, mult = strings.ToLower(parts[0]), 1.0
+	for i, suffix := range []string{"k", "m", "g"} {
+		if strings.HasSuffix(limit, suffix) {
+			limit = strings.TrimSuffix(limit, suffix)
+			mult = math.Pow(1024, float64(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(limit, 64)
+	if err != nil || n < 0 {
+		return nil, ErrorInvalidPolicy
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return nil, ErrorInvalidPolicy
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+	return &Policy{n * mult, period}, nil
+}
+
+// rate returns tokens refilled per second.
+func (p *Policy) rate() float64 {
+	return p.Limit / p.Period.Seconds()
+}
+
+// Result represents the outcome of taking tokens.
+type Result struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the request would be allowed, if not allowed
+}
+
+// Limiter limits rate of keys according to a policy.
+type Limiter struct {
+	store  Store
+	policy *Policy
+	now    func() time.Time
+}
+
+// New returns Limiter that stores buckets in store.
+func New(store Store, policy *Policy) *Limiter {
+	return &Limiter{store, policy, time.Now}
+}
+
+// Take takes n tokens of key. Requests costing more than the limit, such as
+// large uploads, are allowed once the bucket is full, leaving it in debt until
+// refilled.
+func (l *Limiter) Take(key string, n float64) (*Result, error) {
+	var r *Result
+	err := l.store.Update(key, func(b *Bucket) {
+		var now = l.now()
+		var rate = l.policy.rate()
+
+		var tokens = l.policy.Limit
+		if !b.Updated.IsZero() {
+			tokens = math.Min(l.policy.Limit, b.Tokens+now.Sub(b.Updated).Seconds()*rate)
+		}
+
+		r = &Result{Limit: int64(l.policy.Limit)}
+		if need := math.Min(n, l.policy.Limit); tokens >= need {
+			r.Allowed = true
+			tokens -= n
+		} else {
+			r.RetryAfter = secondsDuration((need - tokens) / rate)
+		}
+
+		r.Remaining = int64(math.Max(0, math.Floor(tokens)))
+		r.Reset = secondsDuration((l.policy.Limit - tokens) / rate)
+
+		if r.Allowed {
+			b.Tokens, b.Updated, b.Expires = tokens, now, now.Add(r.Reset)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// secondsDuration returns duration of s seconds.
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
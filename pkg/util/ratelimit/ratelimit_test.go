@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want *Policy
+		err  error
+	}{
+		{"", nil, nil},
+		{"0", nil, nil},
+		{"0/1m", nil, nil},
+		{"600/1m", &Policy{600, time.Minute}, nil},
+		{"1.5/1s", &Policy{1.5, time.Second}, nil},
+		{"1k/1s", &Policy{1024, time.Second}, nil},
+		{"2M/1m", &Policy{2 * 1024 * 1024, time.Minute}, nil},
+		{"1g/1h", &Policy{1024 * 1024 * 1024, time.Hour}, nil},
+		{"600", nil, ErrorInvalidPolicy},
+		{"x/1m", nil, ErrorInvalidPolicy},
+		{"-1/1m", nil, ErrorInvalidPolicy},
+		{"600/x", nil, ErrorInvalidPolicy},
+		{"600/0s", nil, ErrorInvalidPolicy},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePolicy(tt.s)
+		switch {
+		case err != tt.err:
+			t.Errorf("%q: error %v, want %v", tt.s, err, tt.err)
+		case (p == nil) != (tt.want == nil), p != nil && *p != *tt.want:
+			t.Errorf("%q: policy %+v, want %+v", tt.s, p, tt.want)
+		}
+	}
+}
+
+func TestLimiterTake(t *testing.T) {
+	now := time.Now()
+	l := New(NewMemoryStore(), &Policy{Limit: 4, Period: 4 * time.Second})
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		wait       time.Duration // Wait before taking
+		n          float64
+		allowed    bool
+		remaining  int64
+		retryAfter time.Duration
+	}{
+		{0, 1, true, 3, 0},
+		{0, 3, true, 0, 0},
+		{0, 1, false, 0, time.Second},
+		{500 * time.Millisecond, 1, false, 0, 500 * time.Millisecond},
+		{500 * time.Millisecond, 1, true, 0, 0},
+		{4 * time.Second, 10, true, 0, 0}, // Costing more than the limit once full
+		{time.Second, 1, false, 0, 6 * time.Second},
+		{10 * time.Second, 1, true, 3, 0},
+	}
+
+	for i, tt := range tests {
+		now = now.Add(tt.wait)
+		r, err := l.Take("k", tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Allowed != tt.allowed || r.Remaining != tt.remaining || r.RetryAfter != tt.retryAfter {
+			t.Errorf("take %d: %+v, want allowed %v, remaining %d, retry after %s", i+1, r, tt.allowed, tt.remaining, tt.retryAfter)
+		}
+		if r.Limit != 4 {
+			t.Errorf("take %d: Limit = %d, want 4", i+1, r.Limit)
+		}
+	}
+
+	if r, _ := l.Take("other", 4); !r.Allowed {
+		t.Errorf("other key not allowed")
+	}
+}
+
+func TestLimiterTakeConcurrent(t *testing.T) {
+	l := New(NewMemoryStore(), &Policy{Limit: 100, Period: time.Hour})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var allowed int
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := l.Take("k", 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if r.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 100 {
+		t.Errorf("allowed %d requests, want 100", allowed)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore().(*memoryStore)
+	now := time.Now()
+
+	s.Update("expired", func(b *Bucket) { b.Updated, b.Expires = now, now.Add(-time.Second) })
+	s.Update("live", func(b *Bucket) { b.Updated, b.Expires = now, now.Add(time.Hour) })
+	s.Update("denied", func(b *Bucket) {})
+	if len(s.buckets) != 2 {
+		t.Fatalf("%d buckets, want 2", len(s.buckets))
+	}
+
+	s.Update("expired", func(b *Bucket) {
+		if !b.Updated.IsZero() {
+			t.Errorf("expired bucket not reset: %+v", b)
+		}
+	})
+
+	s.swept = now.Add(-sweepInterval)
+	s.Update("live", func(b *Bucket) {})
+	if _, ok := s.buckets["expired"]; ok {
+		t.Errorf("expired bucket not swept")
+	}
+	if _, ok := s.buckets["live"]; !ok {
+		t.Errorf("live bucket swept")
+	}
+}
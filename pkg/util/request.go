@@ -0,0 +1,83 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var ErrorInvalidTrustedProxy = errors.New("Invalid trusted proxy")
+
+// trustedProxies are the networks of reverse proxies whose X-Forwarded-For
+// header is trusted, see SetTrustedProxies.
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the reverse proxies whose X-Forwarded-For header is
+// trusted by RemoteIP, as IP addresses or CIDR networks such as "10.0.0.0/8".
+// It's meant to be called once on start.
+func SetTrustedProxies(proxies []string) error {
+	var nets = make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return ErrorInvalidTrustedProxy
+		}
+		nets = append(nets, n)
+	}
+
+	trustedProxies = nets
+	return nil
+}
+
+// RemoteIP returns IP address of the client of http.Request. When the request
+// comes from a trusted proxy, the client is the last address of
+// X-Forwarded-For not of a trusted proxy, as previous ones can be forged by the
+// client.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	var forwarded = strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return host
+}
+
+// isTrustedProxy checks whether ip is the address of a trusted proxy.
+func isTrustedProxy(ip string) bool {
+	var addr = net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,9 @@ package util
 import (
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"hash"
 )
 
@@ -60,3 +63,19 @@ func GetRandomString(n int, alphabets ...byte) string {
 	}
 	return string(bytes)
 }
+
+// GetRandomToken returns URL safe token made of n random bytes.
+func GetRandomToken(n int) (string, error) {
+	var bytes = make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// HashToken returns hex-encoded SHA-256 of token, so that tokens can be stored
+// and looked up without storing the token itself.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
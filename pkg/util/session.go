@@ -17,20 +17,94 @@ import (
 // The user details will be stored as either a simple API token or JWT bearer token.
 func GetUserFromRequest(c context.Context, r *http.Request) *model.User {
 	switch {
+	case IsAPITokenRequest(r):
+		_, user := GetAPITokenFromRequest(c, r)
+		return user
 	case r.Header.Get("Authorization") != "":
 		return getUserBearer(c, r)
+	case IsClientCertLogin(r):
+		return getUserClientCert(c, r)
 	default:
 		return nil
 	}
 }
 
-// GenerateToken generates a JWT token for the user session.
+// AccessTokenTTL is the lifetime of JWT access token. Sessions are extended
+// with refresh tokens.
+const AccessTokenTTL = 15 * time.Minute
+
+// clockSkew is the tolerated difference between clocks of token issuer and
+// this server when checking iat and nbf claims.
+const clockSkew = time.Minute
+
+// GenerateToken generates a JWT token for the user session, signed with the
+// current signing key. The token is bound to current token generation of the
+// user, so that it can be revoked.
 func GenerateToken(c context.Context, r *http.Request, user *model.User) (string, error) {
-	token := jwt.New(jwt.GetSigningMethod("HS256"))
+	var ks = getKeySetFromContext(c)
+	var key = ks.SigningKey()
+	var now = time.Now().UTC()
+
+	token := jwt.New(key.Method())
+	token.Header["kid"] = key.ID
+	token.Claims["iss"] = ks.Issuer
+	token.Claims["aud"] = ks.Audience
+	token.Claims["iat"] = now.Unix()
+	token.Claims["nbf"] = now.Unix()
+	token.Claims["exp"] = now.Add(AccessTokenTTL).Unix()
 	token.Claims["user_id"] = user.ID
-	token.Claims["exp"] = time.Now().UTC().Add(time.Hour * 72).Unix()
+	token.Claims["gen"] = user.TokenGeneration
+
+	return token.SignedString(key.Private)
+}
+
+// stateTokenType is the typ claim of state tokens, so that they are never
+// mistaken for other tokens.
+const stateTokenType = "state"
+
+// GenerateStateToken generates a JWT carrying values of a login flow through
+// the user agent, such as OpenID Connect state, valid for ttl. It is not an
+// access token.
+func GenerateStateToken(c context.Context, values map[string]string, ttl time.Duration) (string, error) {
+	var ks = getKeySetFromContext(c)
+	var key = ks.SigningKey()
+	var now = time.Now().UTC()
+
+	token := jwt.New(key.Method())
+	token.Header["kid"] = key.ID
+	token.Claims["iss"] = ks.Issuer
+	token.Claims["aud"] = ks.Audience
+	token.Claims["iat"] = now.Unix()
+	token.Claims["nbf"] = now.Unix()
+	token.Claims["exp"] = now.Add(ttl).Unix()
+	token.Claims["typ"] = stateTokenType
+	token.Claims["values"] = values
+
+	return token.SignedString(key.Private)
+}
+
+// ParseStateToken returns values of a token generated by GenerateStateToken, if
+// valid.
+func ParseStateToken(c context.Context, token string) (map[string]string, bool) {
+	var ks = getKeySetFromContext(c)
+
+	var t, err = jwt.Parse(token, ks.Keyfunc)
+	if err != nil || !t.Valid || !validClaims(ks, t.Claims) {
+		return nil, false
+	}
+	if typ, _ := t.Claims["typ"].(string); typ != stateTokenType {
+		return nil, false
+	}
 
-	return token.SignedString([]byte(getSecretFromContext(c)))
+	var claims, _ = t.Claims["values"].(map[string]interface{})
+	var values = make(map[string]string, len(claims))
+	for k, v := range claims {
+		if s, ok := v.(string); ok {
+			values[k] = s
+		}
+	}
+
+	return values, true
 }
 
 // getUserBearer gets the currently authenticated user for the given bearer token
@@ -45,25 +119,76 @@ func getUserBearer(c context.Context, r *http.Request) *model.User {
 // getUserJWT is a helper function that parses the user ID and retrieves the User
 // data from a JWT Token.
 func getUserJWT(c context.Context, token string) *model.User {
-	var t, err = jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		return []byte(getSecretFromContext(c)), nil
-	})
+	var ks = getKeySetFromContext(c)
+
+	var t, err = jwt.Parse(token, ks.Keyfunc)
 	if err != nil || !t.Valid {
 		return nil
 	}
+	if !validClaims(ks, t.Claims) {
+		return nil
+	}
 	var userId, ok = t.Claims["user_id"].(float64)
 	if !ok {
 		return nil
 	}
-	var user, _ = datastore.GetUserById(c, int64(userId))
+	var gen, _ = t.Claims["gen"].(float64)
+
+	var user, err1 = datastore.GetUserById(c, int64(userId))
+	if err1 != nil {
+		return nil
+	}
+
+	// Tokens of previous generation are revoked.
+	if user.TokenGeneration != int64(gen) || user.Deactivated {
+		return nil
+	}
 
 	return user
 }
 
-// getSecretFromContext is a helper function to retrieve JWT secret from the
+// validClaims checks registered claims that jwt.Parse doesn't: issuer and
+// audience must match the key set, and the token must not be issued or valid
+// in the future.
+func validClaims(ks *KeySet, claims map[string]interface{}) bool {
+	if iss, _ := claims["iss"].(string); iss != ks.Issuer {
+		return false
+	}
+	if !hasAudience(claims["aud"], ks.Audience) {
+		return false
+	}
+
+	var now = time.Now().UTC().Add(clockSkew).Unix()
+	for _, name := range []string{"iat", "nbf"} {
+		v, ok := claims[name].(float64)
+		if !ok || int64(v) > now {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasAudience checks whether aud claim, either a string or an array of strings,
+// contains audience.
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getKeySetFromContext is a helper function to retrieve JWT keys from the
 // context.
-func getSecretFromContext(c context.Context) string {
+func getKeySetFromContext(c context.Context) *KeySet {
 	var wc = webcontext.ToC(c)
 
-	return wc.Env["jwtSecret"].(string)
+	return wc.Env["jwtKeys"].(*KeySet)
 }
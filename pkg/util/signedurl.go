@@ -0,0 +1,90 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrorSignatureMissing = errors.New("Missing URL signature")
+	ErrorSignatureInvalid = errors.New("Invalid URL signature")
+	ErrorSignatureExpired = errors.New("URL signature has expired")
+)
+
+const (
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
+)
+
+// SignedURL represents claims of a signed URL.
+type SignedURL struct {
+	Path        string // URL path, without domain
+	UserID      int64  // User for whom the URL is issued
+	Expires     int64  // Unix timestamp after which URL is no longer valid
+	Disposition string // Optional Content-Disposition, either inline or attachment
+}
+
+// SignURL returns path su.Path with query params containing su claims and
+// HMAC-SHA256 signature of them using secret.
+func SignURL(secret []byte, su *SignedURL) string {
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(su.Expires, 10))
+	q.Set("uid", strconv.FormatInt(su.UserID, 10))
+	if su.Disposition != "" {
+		q.Set("disp", su.Disposition)
+	}
+	q.Set("sig", signURL(secret, su))
+
+	return su.Path + "?" + q.Encode()
+}
+
+// VerifySignedURL verifies the signature of URL u, as returned by SignURL, and
+// returns its claims.
+func VerifySignedURL(secret []byte, u *url.URL) (*SignedURL, error) {
+	q := u.Query()
+
+	sig := q.Get("sig")
+	if sig == "" {
+		return nil, ErrorSignatureMissing
+	}
+
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return nil, ErrorSignatureInvalid
+	}
+	uid, err := strconv.ParseInt(q.Get("uid"), 10, 64)
+	if err != nil {
+		return nil, ErrorSignatureInvalid
+	}
+
+	su := &SignedURL{
+		Path:        u.Path,
+		UserID:      uid,
+		Expires:     exp,
+		Disposition: q.Get("disp"),
+	}
+	if !hmac.Equal([]byte(sig), []byte(signURL(secret, su))) {
+		return nil, ErrorSignatureInvalid
+	}
+	if time.Now().UTC().Unix() > su.Expires {
+		return nil, ErrorSignatureExpired
+	}
+
+	return su, nil
+}
+
+// signURL returns hex-encoded HMAC-SHA256 of su claims.
+func signURL(secret []byte, su *SignedURL) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(su.Path))
+	mac.Write([]byte("\n" + strconv.FormatInt(su.Expires, 10)))
+	mac.Write([]byte("\n" + strconv.FormatInt(su.UserID, 10)))
+	mac.Write([]byte("\n" + su.Disposition))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
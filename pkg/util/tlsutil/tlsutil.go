@@ -0,0 +1,193 @@
+// Package tlsutil serves HTTPS with certificates reloaded without restart.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client authentication modes.
+const (
+	ClientAuthNone     = "none"     // Client certificates aren't requested
+	ClientAuthOptional = "optional" // Client certificates are verified if given
+	ClientAuthRequire  = "require"  // Client certificates are required
+)
+
+var (
+	ErrorInvalidClientAuth = errors.New("Invalid client authentication mode")
+	ErrorInvalidClientCA   = errors.New("No certificate found in client CA file")
+)
+
+// Certificate represents a certificate and its key loaded from PEM files. It's
+// reloaded from the files by Reload, so that renewed certificates are served
+// without restarting the server.
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// LoadCertificate loads certificate from certFile and its key from keyFile.
+func LoadCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reloads the certificate from its files. The current certificate is
+// kept if the files are invalid, for instance when they're being replaced.
+func (c *Certificate) Reload() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the certificate when its files change, checking them every
+// interval, until stop is closed. Failed reloads are logged.
+func (c *Certificate) Watch(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		modTime, err := c.filesModTime()
+		if err != nil {
+			log.Printf("tls: %+v\n", err)
+			continue
+		}
+
+		c.mu.RLock()
+		changed := !modTime.Equal(c.modTime)
+		c.mu.RUnlock()
+
+		if changed {
+			if err := c.Reload(); err != nil {
+				log.Printf("tls: reloading %s: %+v\n", c.certFile, err)
+			} else {
+				log.Printf("tls: reloaded %s\n", c.certFile)
+			}
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, see tls.Config.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// filesModTime returns the latest modification time of the files.
+func (c *Certificate) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewConfig returns TLS configuration serving cert, with TLS 1.2 or later and
+// forward secret AEAD cipher suites only. Client certificates are verified with
+// CA certificates of PEM file clientCAFile according to clientAuth mode.
+func NewConfig(cert *Certificate, clientAuth, clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		GetCertificate:           cert.GetCertificate,
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}
+
+	switch clientAuth {
+	case "", ClientAuthNone:
+		return cfg, nil
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, ErrorInvalidClientAuth
+	}
+
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, ErrorInvalidClientCA
+	}
+
+	return cfg, nil
+}
+
+// RedirectHandler returns a handler that permanently redirects requests to the
+// same URL over HTTPS, on the port of httpsAddr.
+func RedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+
+		// Other requests keep their method and body with 308.
+		code := http.StatusMovedPermanently
+		if r.Method != "GET" && r.Method != "HEAD" {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, u.String(), code)
+	})
+}
@@ -0,0 +1,117 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Secret of the test vectors of RFC 6238, "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// Last digits of the SHA1 test vectors of RFC 6238, appendix B.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("Code at %d = %q, want %q", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	codeAt := func(s int64) string {
+		code, err := Code(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOk   bool
+	}{
+		{"current", codeAt(step), 0, step, true},
+		{"previous", codeAt(step - 1), 0, step - 1, true},
+		{"next", codeAt(step + 1), 0, step + 1, true},
+		{"too old", codeAt(step - 2), 0, 0, false},
+		{"too new", codeAt(step + 2), 0, 0, false},
+		{"spaces", " " + codeAt(step) + " ", 0, step, true},
+		{"replayed", codeAt(step), step, 0, false},
+		{"after later code", codeAt(step - 1), step, 0, false},
+		{"short", "12345", 0, 0, false},
+		{"long", "1234567", 0, 0, false},
+		{"wrong", "000000", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		s, ok := Validate(rfcSecret, tt.code, now, tt.lastStep)
+		if ok != tt.wantOk || s != tt.wantStep {
+			t.Errorf("%s: Validate = %d, %v, want %d, %v", tt.name, s, ok, tt.wantStep, tt.wantOk)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Code(secret, 1); err != nil {
+		t.Errorf("Code of new secret: %v", err)
+	}
+
+	other, _ := NewSecret()
+	if secret == other {
+		t.Errorf("NewSecret returned twice %q", secret)
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	code, err := RecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		t.Fatalf("RecoveryCode = %q", code)
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"abcde-fghij", "abcde-fghij"},
+		{"ABCDE-FGHIJ", "abcde-fghij"},
+		{"abcdefghij", "abcde-fghij"},
+		{" abcde fghij ", "abcde-fghij"},
+		{"ab-cd-ef-gh-ij", "abcde-fghij"},
+		{"abc", "abc"},
+		{code, code},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeRecoveryCode(tt.in); got != tt.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
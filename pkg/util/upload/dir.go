@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -37,3 +38,31 @@ func (ud *UploadDir) preparePath(baseMime string) {
 	now := time.Now()
 	ud.path = fmt.Sprintf("/%s/%d/%d/%d", baseMime, now.Year(), now.Month(), now.Day())
 }
+
+// OrganizationRoot returns the root directory, under fsRoot, of files of the
+// organization orgId. Files of no organization are stored in fsRoot itself.
+func OrganizationRoot(fsRoot string, orgId int64) string {
+	if orgId == 0 {
+		return fsRoot
+	}
+	return filepath.Join(fsRoot, "org", strconv.FormatInt(orgId, 10))
+}
+
+// DirSize returns the total size of files under dir. A missing dir is empty.
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
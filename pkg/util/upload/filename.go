@@ -0,0 +1,59 @@
+package upload
+
+import (
+	"crypto/md5"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// generatedFilenameExp matches names generated by GenerateFilename.
+var generatedFilenameExp = regexp.MustCompile(`^[0-9a-f]{32}_[0-9a-z]+[-.]?`)
+
+// GenerateFilename generates filename for file f with given suffix. If ext is
+// empty, the extension of the uploaded filename is used.
+func GenerateFilename(f *File, suffix, ext string) string {
+	t := time.Now()
+	ts := int64(t.Hour()*3600 + t.Minute()*60 + t.Second())
+
+	if suffix != "" {
+		suffix = "-" + suffix
+	}
+	if ext == "" {
+		ext = filepath.Ext(f.Name)
+	}
+
+	return FilenamePrefix(f.Sid) + strconv.FormatInt(ts, 36) + suffix + ext
+}
+
+// FilenamePrefix returns the prefix of names generated by GenerateFilename for
+// files of the given sid.
+func FilenamePrefix(sid string) string {
+	return fmt.Sprintf("%x_", md5.Sum([]byte(sid)))
+}
+
+// URLFn returns callback function that's called after each processor.Process.
+// It sets the URL of processed file relative to the files prefix.
+func URLFn(fsRoot, prefix string) AfterProcessFn {
+	return func(out *File, err error) (*File, error) {
+		if err != nil || out == nil {
+			return out, err
+		}
+		if out.Filepath == "" {
+			return out, err
+		}
+		if rel, relErr := filepath.Rel(fsRoot, out.Filepath); relErr == nil {
+			out.URL = filepath.Join(prefix, rel)
+		}
+		return out, err
+	}
+}
+
+// IsGeneratedFilename checks whether name was generated by GenerateFilename.
+// Such files are written once and never changed, a new version getting a new
+// name.
+func IsGeneratedFilename(name string) bool {
+	return generatedFilenameExp.MatchString(name)
+}
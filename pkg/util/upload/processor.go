@@ -3,6 +3,7 @@ package upload
 import (
 	"errors"
 	"fmt"
+	"os"
 	"sync"
 
 	"github.com/gedex/simdoc/pkg/model"
@@ -13,13 +14,33 @@ type FileResult struct {
 	Versions map[string]*FileVersion `json:"versions"`
 }
 
+// Remove removes files of all versions of fr.
+func (fr *FileResult) Remove() {
+	for _, v := range fr.Versions {
+		if v.DocumentFileVersion != nil && v.Filepath != "" {
+			os.Remove(v.Filepath)
+		}
+	}
+}
+
+// StoredSize returns the total size of stored versions of fr.
+func (fr *FileResult) StoredSize() int64 {
+	var size int64
+	for _, v := range fr.Versions {
+		if v.DocumentFileVersion != nil && v.Error == nil && v.Meta != nil {
+			size += v.Meta.Size
+		}
+	}
+	return size
+}
+
 type FileVersion struct {
 	*model.DocumentFileVersion
 	Error error `json:"error"`
 }
 
 type ProcessManager interface {
-	Add(p Processor)
+	Add(p Processor) error
 	Run(ap AfterProcessFn) *FileResult
 }
 
@@ -30,12 +51,24 @@ type Processor interface {
 	CanProcess(baseMime string) bool
 }
 
+// MimeProcessor is implemented by processors that need the full mime type,
+// rather than the base one, to decide whether a file can be processed. For
+// instance "application/pdf" and "application/zip" share the same base mime.
+type MimeProcessor interface {
+	Processor
+	CanProcessMime(mime string) bool
+}
+
 var SourceOriginal = ":original:"
 
 type processManager struct {
 	mu  sync.RWMutex
 	src *File                    // Original source
 	pe  map[string]*processEntry // key is process name provided by processor implementor
+
+	// Names of processors in the order they're added, which is the pipeline
+	// order. Sources are always added before their downstreams.
+	names []string
 }
 
 type processEntry struct {
@@ -46,56 +79,63 @@ type processEntry struct {
 
 type AfterProcessFn func(out *File, err error) (*File, error)
 
+// ProcessFile runs processors procs against file f. If processors can't be
+// chained, none is run and the error is set on the file.
 func ProcessFile(f *File, ap AfterProcessFn, procs ...Processor) *FileResult {
 	pm := new(processManager)
 	pm.src = f
 	pm.pe = make(map[string]*processEntry, 0)
 
 	for _, p := range procs {
-		pm.Add(p)
+		if err := pm.Add(p); err != nil {
+			f.Error = err
+			return &FileResult{f, make(map[string]*FileVersion)}
+		}
 	}
 
 	return pm.Run(ap)
 }
 
-func (pm *processManager) Add(p Processor) {
+func (pm *processManager) Add(p Processor) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	if _, exists := pm.pe[p.GetName()]; exists {
-		panic("upload: processor name already exists")
-	}
 	pname := p.GetName()
 	psrc := p.GetSource()
+	if _, exists := pm.pe[pname]; exists {
+		return fmt.Errorf("upload: processor name %s already exists", pname)
+	}
 
 	pe := new(processEntry)
 	if psrc == SourceOriginal {
 		pe.src = pm.src
 	} else {
-		// If source processor doesn't exists panic, otherwise set source processor
-		// to upstream ups and set current processor as downstream downs of
-		// the source processor.
-		if u, ok := pm.pe[psrc]; !ok {
-			panic(fmt.Sprintf("upload: source processor %s does not exists", psrc))
-		} else {
-			u.downs = append(u.downs, pe)
+		// Sets current processor as downstream downs of the source
+		// processor, which must be added before.
+		u, ok := pm.pe[psrc]
+		if !ok {
+			return fmt.Errorf("upload: source processor %s does not exists", psrc)
 		}
+		u.downs = append(u.downs, pe)
 	}
 	pe.proc = p
 
 	// Adds process entry to manager.
 	pm.pe[pname] = pe
+	pm.names = append(pm.names, pname)
+	return nil
 }
 
 func (pm *processManager) Run(ap AfterProcessFn) *FileResult {
 	ver := make(map[string]*FileVersion, len(pm.pe))
 	fr := &FileResult{pm.src, ver}
-	for pname, pe := range pm.pe {
+	for _, pname := range pm.names {
+		pe := pm.pe[pname]
 		if pe.src == nil {
 			continue
 		}
 
-		if !pe.proc.CanProcess(pe.src.Type) {
+		if !canProcess(pe.proc, pe.src) {
 			continue
 		}
 
@@ -126,3 +166,43 @@ func (pm *processManager) Run(ap AfterProcessFn) *FileResult {
 
 	return fr
 }
+
+// canProcess checks whether processor p can process file f.
+func canProcess(p Processor, f *File) bool {
+	if mp, ok := p.(MimeProcessor); ok {
+		return mp.CanProcessMime(f.Mime)
+	}
+	return p.CanProcess(f.Type)
+}
+
+// DocumentFile returns the file result as a file of document docId. Output of
+// processor named original, for instance a mover, is used as the stored file.
+// Versions with processing error are left out.
+func (fr *FileResult) DocumentFile(docId int64, original string) *model.DocumentFile {
+	df := &model.DocumentFile{
+		DocumentID: docId,
+		Name:       fr.Name,
+		Filepath:   fr.Filepath,
+		URL:        fr.URL,
+		Meta: &model.DocumentFileMeta{
+			Type: fr.Type,
+			Mime: fr.Mime,
+			Size: fr.Size,
+		},
+		Versions: make(map[string]*model.DocumentFileVersion, len(fr.Versions)),
+	}
+
+	for name, v := range fr.Versions {
+		if v.Error != nil {
+			continue
+		}
+		df.Versions[name] = v.DocumentFileVersion
+	}
+
+	if v, ok := df.Versions[original]; ok {
+		df.Filepath = v.Filepath
+		df.URL = v.URL
+	}
+
+	return df
+}
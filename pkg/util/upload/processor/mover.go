@@ -17,17 +17,13 @@ func Mover(name, src, dst string) upload.Processor {
 }
 
 func (r *mover) Process(src *upload.File) (*upload.File, error) {
-	err := os.Rename(src.Filepath, r.dst)
+	// Size of the content, the stored file may be encrypted.
+	fi, err := os.Stat(src.Filepath)
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := os.Open(r.dst)
-	if err != nil {
-		return nil, err
-	}
-	fi, err := f.Stat()
-	if err != nil {
+	if err := upload.StoreFile(src.Filepath, r.dst); err != nil {
 		return nil, err
 	}
 
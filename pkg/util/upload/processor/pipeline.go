@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/gedex/simdoc/pkg/util/upload"
+)
+
+const (
+	// VersionOriginal is the name of the processor whose output is stored as
+	// the file of the document. Pipelines must run it against any file.
+	VersionOriginal = "default"
+
+	// VersionWatermarked is the name of the watermarked version of files of
+	// published documents, added after the pipeline. It's reserved.
+	VersionWatermarked = "watermarked"
+)
+
+// Pipeline represents a chain of processors run against each uploaded file.
+type Pipeline struct {
+	Processors []*ProcessorConfig `json:"processors"`
+}
+
+// ProcessorConfig represents a named processor in the pipeline.
+type ProcessorConfig struct {
+	Name     string   `json:"name"`     // Name of file version, for instance "thumbnail-120x90"
+	Type     string   `json:"type"`     // Registered type, for instance "resizer"
+	Source   string   `json:"source"`   // Name of source processor. Empty means the original file
+	Mimes    []string `json:"mimes"`    // Mime patterns to process, for instance "image/*". Empty means any
+	Format   string   `json:"format"`   // Extension of output file, for instance "jpg". Empty means source's extension
+	Params   Params   `json:"params"`   // Parameters passed to the processor factory
+	Disabled bool     `json:"disabled"` // Skips the processor and its downstreams
+}
+
+// DstFn returns filepath destination for the processor named name, with given
+// file extension ext.
+type DstFn func(name, ext string) string
+
+// DefaultPipeline is used when no pipeline configuration is provided.
+var DefaultPipeline = &Pipeline{
+	Processors: []*ProcessorConfig{
+		&ProcessorConfig{Name: VersionOriginal, Type: "mover"},
+		&ProcessorConfig{Name: "thumbnail-120x90", Type: "resizer", Source: VersionOriginal, Params: Params{"width": 120, "height": 90}},
+	},
+}
+
+// LoadPipeline reads pipeline configuration, in JSON format, from file fpath.
+func LoadPipeline(fpath string) (*Pipeline, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pl := new(Pipeline)
+	if err := json.NewDecoder(f).Decode(pl); err != nil {
+		return nil, err
+	}
+	if err := pl.Validate(); err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// Validate checks that processor names are unique, sources refer to a previous
+// enabled processor, every processor can be created from its params and the
+// original version is processed for any file.
+func (pl *Pipeline) Validate() error {
+	procs, err := pl.Build(func(name, ext string) string { return "" })
+	if err != nil {
+		return err
+	}
+
+	for _, p := range procs {
+		if p.GetName() != VersionOriginal {
+			continue
+		}
+		if _, filtered := p.(*mimeFilter); filtered {
+			return fmt.Errorf("processor: %s must process any mime type", VersionOriginal)
+		}
+		return nil
+	}
+	return fmt.Errorf("processor: missing enabled %s processor", VersionOriginal)
+}
+
+// Build creates enabled processors of the pipeline. Destination of each
+// processor is given by dst.
+func (pl *Pipeline) Build(dst DstFn) ([]upload.Processor, error) {
+	procs := make([]upload.Processor, 0, len(pl.Processors))
+	names := make(map[string]bool, len(pl.Processors))
+
+	for _, pc := range pl.Processors {
+		if pc.Name == "" {
+			return nil, fmt.Errorf("processor: missing name for processor of type %s", pc.Type)
+		}
+		if pc.Name == VersionWatermarked {
+			return nil, fmt.Errorf("processor: processor name %s is reserved", pc.Name)
+		}
+		if _, exists := names[pc.Name]; exists {
+			return nil, fmt.Errorf("processor: processor name %s already exists", pc.Name)
+		}
+
+		src := pc.Source
+		if src == "" {
+			src = upload.SourceOriginal
+		}
+
+		enabled := !pc.Disabled
+		if src != upload.SourceOriginal {
+			srcEnabled, ok := names[src]
+			if !ok {
+				return nil, fmt.Errorf("processor: source processor %s of %s does not exists", src, pc.Name)
+			}
+			// Downstreams of disabled processor are disabled too.
+			enabled = enabled && srcEnabled
+		}
+		names[pc.Name] = enabled
+
+		if !enabled {
+			continue
+		}
+
+		ext := ""
+		if pc.Format != "" {
+			ext = "." + pc.Format
+		}
+
+		p, err := New(pc.Type, pc.Name, src, dst(pc.Name, ext), pc.Params)
+		if err != nil {
+			return nil, fmt.Errorf("processor: %s: %s", pc.Name, err)
+		}
+		procs = append(procs, WithMimes(p, pc.Mimes))
+	}
+
+	return procs, nil
+}
@@ -0,0 +1,75 @@
+package processor
+
+import "testing"
+
+func TestPipelineValidate(t *testing.T) {
+	size := Params{"width": 120, "height": 90}
+
+	tests := []struct {
+		name  string
+		procs []*ProcessorConfig
+		valid bool
+	}{
+		{"default pipeline", DefaultPipeline.Processors, true},
+		{
+			name: "original with downstream",
+			procs: []*ProcessorConfig{
+				{Name: "default", Type: "mover"},
+				{Name: "thumb", Type: "resizer", Source: "default", Mimes: []string{"image/*"}, Params: size},
+			},
+			valid: true,
+		},
+		{"no processor", nil, false},
+		{"no original", []*ProcessorConfig{{Name: "copy", Type: "mover"}}, false},
+		{"original disabled", []*ProcessorConfig{{Name: "default", Type: "mover", Disabled: true}}, false},
+		{
+			name: "original of disabled source",
+			procs: []*ProcessorConfig{
+				{Name: "copy", Type: "mover", Disabled: true},
+				{Name: "default", Type: "mover", Source: "copy"},
+			},
+			valid: false,
+		},
+		{"original limited to mimes", []*ProcessorConfig{{Name: "default", Type: "mover", Mimes: []string{"image/*"}}}, false},
+		{
+			name: "duplicated name",
+			procs: []*ProcessorConfig{
+				{Name: "default", Type: "mover"},
+				{Name: "default", Type: "mover"},
+			},
+			valid: false,
+		},
+		{
+			name: "unknown source",
+			procs: []*ProcessorConfig{
+				{Name: "default", Type: "mover"},
+				{Name: "thumb", Type: "resizer", Source: "other", Params: size},
+			},
+			valid: false,
+		},
+		{
+			name: "invalid params",
+			procs: []*ProcessorConfig{
+				{Name: "default", Type: "mover"},
+				{Name: "thumb", Type: "resizer", Source: "default"},
+			},
+			valid: false,
+		},
+		{"unknown type", []*ProcessorConfig{{Name: "default", Type: "other"}}, false},
+		{
+			name: "reserved name",
+			procs: []*ProcessorConfig{
+				{Name: "default", Type: "mover"},
+				{Name: "watermarked", Type: "mover", Source: "default"},
+			},
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		err := (&Pipeline{Processors: tt.procs}).Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: error %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/gedex/simdoc/pkg/util/mimetype"
+	"github.com/gedex/simdoc/pkg/util/upload"
+)
+
+var ErrorInvalidParam = errors.New("Invalid processor param")
+
+// Params represents parameters of a processor, for instance width and height
+// of a resizer, as decoded from pipeline configuration.
+type Params map[string]interface{}
+
+// Int returns the integer param for the given key.
+func (p Params) Int(key string) (int, error) {
+	switch v := p[key].(type) {
+	case float64:
+		return int(v), nil
+	case int:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("%s: %s", ErrorInvalidParam, key)
+	}
+}
+
+// String returns the string param for the given key.
+func (p Params) String(key string) (string, error) {
+	v, ok := p[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s: %s", ErrorInvalidParam, key)
+	}
+	return v, nil
+}
+
+// Factory creates a processor, named name, that processes output of source
+// processor src and writes the result into filepath dst.
+type Factory func(name, src, dst string, params Params) (upload.Processor, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+func init() {
+	Register("mover", func(name, src, dst string, params Params) (upload.Processor, error) {
+		return Mover(name, src, dst), nil
+	})
+	Register("resizer", func(name, src, dst string, params Params) (upload.Processor, error) {
+		w, err := params.Int("width")
+		if err != nil {
+			return nil, err
+		}
+		h, err := params.Int("height")
+		if err != nil {
+			return nil, err
+		}
+		return Resizer(name, src, dst, w, h), nil
+	})
+}
+
+// Register makes a processor factory available by the provided type name. If
+// Register is called twice with the same type name it panics.
+func Register(typ string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := factories[typ]; exists {
+		panic("processor: Register called twice for type " + typ)
+	}
+	factories[typ] = f
+}
+
+// New creates a processor of the given registered type.
+func New(typ, name, src, dst string, params Params) (upload.Processor, error) {
+	mu.RLock()
+	f, ok := factories[typ]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("processor: unknown type %s", typ)
+	}
+	return f(name, src, dst, params)
+}
+
+// WithMimes wraps processor p so that it only processes files whose mime type
+// matches one of patterns, for instance "image/*" or "application/pdf".
+func WithMimes(p upload.Processor, patterns []string) upload.Processor {
+	if len(patterns) == 0 {
+		return p
+	}
+	return &mimeFilter{p, patterns}
+}
+
+type mimeFilter struct {
+	upload.Processor
+	patterns []string
+}
+
+func (m *mimeFilter) CanProcessMime(mime string) bool {
+	if mp, ok := m.Processor.(upload.MimeProcessor); ok && !mp.CanProcessMime(mime) {
+		return false
+	}
+	if _, ok := m.Processor.(upload.MimeProcessor); !ok && !m.Processor.CanProcess(mimetype.Base(mime)) {
+		return false
+	}
+
+	for _, p := range m.patterns {
+		if ok, _ := path.Match(p, mime); ok {
+			return true
+		}
+	}
+	return false
+}
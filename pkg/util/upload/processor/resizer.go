@@ -20,13 +20,26 @@ func Resizer(name, src, dst string, w, h int) upload.Processor {
 }
 
 func (r *resizer) Process(src *upload.File) (*upload.File, error) {
-	t, err := thumbnailer.VipsCreate(src.Filepath, r.dst, r.w, r.h)
+	path, cleanup, err := upload.PlainFile(src.Filepath)
 	if err != nil {
-		return nil, errors.New("thumbnailer.VipsCreate returns error: " + err.Error())
+		return nil, err
+	}
+	defer cleanup()
+
+	var t *thumbnailer.Thumbnail
+	err = upload.WriteFile(r.dst, func(dst string) error {
+		var err error
+		if t, err = thumbnailer.VipsCreate(path, dst, r.w, r.h); err != nil {
+			return errors.New("thumbnailer.VipsCreate returns error: " + err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	out := *src
-	out.Filepath = t.Filepath
+	out.Filepath = r.dst
 	out.Size = t.Size
 
 	return &out, nil
@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"os"
+
+	"github.com/gedex/simdoc/pkg/util/upload"
+	"github.com/gedex/simdoc/pkg/util/watermark"
+)
+
+type watermarker struct {
+	name string
+	src  string
+	dst  string // filepath destination
+	wm   *watermark.Watermark
+}
+
+// Watermarker returns a processor that stamps wm on images and on every page
+// of PDF files.
+func Watermarker(name, src, dst string, wm *watermark.Watermark) upload.Processor {
+	return &watermarker{name, src, dst, wm}
+}
+
+func (r *watermarker) Process(src *upload.File) (*upload.File, error) {
+	path, cleanup, err := upload.PlainFile(src.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var fi os.FileInfo
+	err = upload.WriteFile(r.dst, func(dst string) error {
+		if err := watermark.Apply(path, dst, src.Mime, r.wm); err != nil {
+			return err
+		}
+		var err error
+		fi, err = os.Stat(dst)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := *src
+	out.Filepath = r.dst
+	out.Size = fi.Size()
+
+	return &out, nil
+}
+
+func (r *watermarker) GetName() string {
+	return r.name
+}
+
+func (r *watermarker) GetSource() string {
+	return r.src
+}
+
+func (r *watermarker) CanProcess(baseMime string) bool {
+	return baseMime == "image" || baseMime == "application"
+}
+
+func (r *watermarker) CanProcessMime(mime string) bool {
+	return watermark.IsSupported(mime)
+}
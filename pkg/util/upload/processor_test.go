@@ -0,0 +1,125 @@
+package upload
+
+import (
+	"errors"
+	"testing"
+)
+
+// recorder is a processor recording the order processors are run in.
+type recorder struct {
+	name string
+	src  string
+	log  *[]string
+	err  error
+}
+
+func (r *recorder) Process(f *File) (*File, error) {
+	*r.log = append(*r.log, r.name+"<"+f.Filepath)
+	if r.err != nil {
+		return nil, r.err
+	}
+	out := *f
+	out.Filepath = r.name
+	return &out, nil
+}
+
+func (r *recorder) GetName() string             { return r.name }
+func (r *recorder) GetSource() string           { return r.src }
+func (r *recorder) CanProcess(base string) bool { return true }
+
+func passThrough(out *File, err error) (*File, error) {
+	return out, err
+}
+
+func TestProcessFileOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		procs [][3]string // name, source, error
+		want  []string
+		errs  []string
+	}{
+		{
+			name:  "downstreams after their source",
+			procs: [][3]string{{"default", SourceOriginal, ""}, {"thumb", "default", ""}, {"small", "thumb", ""}, {"wm", "default", ""}},
+			want:  []string{"default<tmp", "thumb<default", "small<thumb", "wm<default"},
+		},
+		{
+			name:  "failed source skips downstreams",
+			procs: [][3]string{{"default", SourceOriginal, "boom"}, {"thumb", "default", ""}, {"copy", SourceOriginal, ""}},
+			want:  []string{"default<tmp", "copy<tmp"},
+			errs:  []string{"default"},
+		},
+	}
+
+	for _, tt := range tests {
+		// Map iteration order is random, so a few runs catch ordering bugs.
+		for i := 0; i < 20; i++ {
+			var log []string
+			procs := make([]Processor, 0, len(tt.procs))
+			for _, p := range tt.procs {
+				r := &recorder{name: p[0], src: p[1], log: &log}
+				if p[2] != "" {
+					r.err = errors.New(p[2])
+				}
+				procs = append(procs, r)
+			}
+
+			fr := ProcessFile(&File{Filepath: "tmp", Type: "image"}, passThrough, procs...)
+
+			if len(log) != len(tt.want) {
+				t.Fatalf("%s: ran %v, want %v", tt.name, log, tt.want)
+			}
+			for j := range log {
+				if log[j] != tt.want[j] {
+					t.Fatalf("%s: ran %v, want %v", tt.name, log, tt.want)
+				}
+			}
+			for _, name := range tt.errs {
+				if v, ok := fr.Versions[name]; !ok || v.Error == nil {
+					t.Errorf("%s: version %s has no error", tt.name, name)
+				}
+			}
+		}
+	}
+}
+
+func TestStoredSize(t *testing.T) {
+	var log []string
+	fr := ProcessFile(&File{Filepath: "tmp", Type: "image", Size: 100}, passThrough,
+		&recorder{name: "default", src: SourceOriginal, log: &log},
+		&recorder{name: "copy", src: SourceOriginal, log: &log},
+		&recorder{name: "failed", src: SourceOriginal, log: &log, err: errors.New("boom")},
+	)
+
+	// Versions are copies of the original, failed ones aren't stored.
+	if size := fr.StoredSize(); size != 200 {
+		t.Errorf("StoredSize = %d, want 200", size)
+	}
+}
+
+func TestProcessFileInvalidChain(t *testing.T) {
+	tests := []struct {
+		name  string
+		procs [][2]string // name, source
+	}{
+		{"duplicated name", [][2]string{{"default", SourceOriginal}, {"wm", "default"}, {"wm", "default"}}},
+		{"unknown source", [][2]string{{"default", SourceOriginal}, {"thumb", "other"}}},
+		{"source added after", [][2]string{{"thumb", "default"}, {"default", SourceOriginal}}},
+	}
+
+	for _, tt := range tests {
+		var log []string
+		procs := make([]Processor, 0, len(tt.procs))
+		for _, p := range tt.procs {
+			procs = append(procs, &recorder{name: p[0], src: p[1], log: &log})
+		}
+
+		fr := ProcessFile(&File{Filepath: "tmp", Type: "image"}, passThrough, procs...)
+		if fr.Error == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+		if len(log) != 0 || len(fr.Versions) != 0 {
+			t.Errorf("%s: ran %v", tt.name, log)
+		}
+	}
+}
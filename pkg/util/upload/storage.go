@@ -0,0 +1,283 @@
+package upload
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gedex/simdoc/pkg/util/crypt"
+)
+
+var ErrorNoPlainDir = errors.New("Directory of decrypted files not set")
+
+var (
+	mu sync.RWMutex
+
+	// keyring encrypts stored files when set, see SetKeyring.
+	keyring *crypt.Keyring
+
+	// plainDir holds decrypted copies of stored files, see SetPlainDir.
+	plainDir string
+)
+
+// SetKeyring enables encryption at rest of stored files with master keys of k,
+// or disables it if k is nil. Files stored before are still read, whether
+// encrypted or not. It's called on start, and again when master keys are
+// reloaded.
+func SetKeyring(k *crypt.Keyring) {
+	mu.Lock()
+	keyring = k
+	mu.Unlock()
+}
+
+// SetPlainDir sets dir, created if needed, as the directory of decrypted copies
+// made by PlainFile. It's only readable by the owner, and meant to be under
+// fs_root, so that plain content doesn't leave the storage of files.
+func SetPlainDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	plainDir = dir
+	mu.Unlock()
+	return nil
+}
+
+// currentKeyring returns the keyring set by SetKeyring.
+func currentKeyring() *crypt.Keyring {
+	mu.RLock()
+	defer mu.RUnlock()
+	return keyring
+}
+
+// ReadSeekCloser is a stored file opened by OpenFile.
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// StoreFile moves file at src to dst, encrypting it if enabled. The encrypted
+// file is written aside then renamed, so that dst is never partially written.
+func StoreFile(src, dst string) error {
+	k := currentKeyring()
+	if k == nil {
+		return os.Rename(src, dst)
+	}
+
+	if err := replaceFile(dst, func(out *os.File) error {
+		return encrypt(k, src, out)
+	}); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// WriteFile stores at dst the file written by write, for programs writing files
+// by path such as image processors. If encryption is enabled, write is given a
+// temporary file of the directory set by SetPlainDir, keeping the extension of
+// dst, which is then encrypted into dst, so that plain content never lands at
+// dst.
+func WriteFile(dst string, write func(path string) error) error {
+	k := currentKeyring()
+	if k == nil {
+		return write(dst)
+	}
+
+	mu.RLock()
+	dir := plainDir
+	mu.RUnlock()
+	if dir == "" {
+		return ErrorNoPlainDir
+	}
+
+	tmp, err := ioutil.TempFile(dir, "plain-*"+filepath.Ext(dst))
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp.Name()); err != nil {
+		return err
+	}
+	return replaceFile(dst, func(out *os.File) error {
+		return encrypt(k, tmp.Name(), out)
+	})
+}
+
+// SealFile encrypts file at path in place if enabled, such as a stored file
+// encrypted by rotation.
+func SealFile(path string) error {
+	k := currentKeyring()
+	if k == nil {
+		return nil
+	}
+	return replaceFile(path, func(dst *os.File) error {
+		return encrypt(k, path, dst)
+	})
+}
+
+// OpenFile opens stored file at path for reading its content, decrypting it if
+// it's encrypted. The size of the content is returned along with the file.
+func OpenFile(path string) (ReadSeekCloser, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if !crypt.IsEncrypted(f) {
+		return f, fi.Size(), nil
+	}
+
+	k := currentKeyring()
+	if k == nil {
+		f.Close()
+		return nil, 0, crypt.ErrorUnknownKey
+	}
+	r, err := k.NewReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return &encryptedFile{r, f}, r.Size(), nil
+}
+
+type encryptedFile struct {
+	*crypt.Reader
+	f *os.File
+}
+
+func (f *encryptedFile) Close() error {
+	return f.f.Close()
+}
+
+// PlainFile returns path of the content of stored file at path, for programs
+// reading files by path. Encrypted files are decrypted into a temporary file
+// of the directory set by SetPlainDir, removed by the returned func, to be
+// called once done.
+func PlainFile(path string) (string, func(), error) {
+	r, _, err := OpenFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	if _, ok := r.(*encryptedFile); !ok {
+		return path, func() {}, nil
+	}
+
+	mu.RLock()
+	dir := plainDir
+	mu.RUnlock()
+	if dir == "" {
+		return "", nil, ErrorNoPlainDir
+	}
+
+	tmp, err := ioutil.TempFile(dir, "plain-*"+filepath.Ext(path))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// RekeyFile encrypts stored file at path with the current master key: plain
+// files are encrypted, and data keys of encrypted files are wrapped with the
+// current master key. It returns whether the file changed.
+func RekeyFile(path string) (bool, error) {
+	k := currentKeyring()
+	if k == nil {
+		return false, crypt.ErrorUnknownKey
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	if !crypt.IsEncrypted(f) {
+		f.Close()
+		return true, SealFile(path)
+	}
+	defer f.Close()
+
+	keyID, err := crypt.KeyID(f)
+	if err != nil {
+		return false, err
+	}
+	if keyID == k.Current() {
+		return false, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	return true, replaceFile(path, func(dst *os.File) error {
+		return k.Rewrap(f, fi.Size(), dst)
+	})
+}
+
+// encrypt writes content of file at src into dst, encrypted with k.
+func encrypt(k *crypt.Keyring, src string, dst io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	w, err := k.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// replaceFile replaces file at path with the one written by write, so that the
+// file is never left partially written.
+func replaceFile(path string, write func(*os.File) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
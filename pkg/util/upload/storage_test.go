@@ -0,0 +1,209 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gedex/simdoc/pkg/util/crypt"
+)
+
+// loadKeyring returns keyring of master keys ids written in dir, the first
+// being current. Keys are derived from their ID.
+func loadKeyring(t *testing.T, dir string, ids ...string) *crypt.Keyring {
+	var buf bytes.Buffer
+	for _, id := range ids {
+		key := bytes.Repeat([]byte(id), 32)[:32]
+		buf.WriteString(id + " " + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	path := filepath.Join(dir, "keys.txt")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := crypt.LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func readFile(t *testing.T, path string) []byte {
+	f, _, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetKeyring(nil)
+
+	root := filepath.Join(dir, "files")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("content of the file")
+
+	// Files stored before encryption was enabled.
+	plain := filepath.Join(root, "plain.txt")
+	if err := ioutil.WriteFile(plain, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	SetKeyring(loadKeyring(t, dir, "k1"))
+	if err := SetPlainDir(filepath.Join(root, ".plain")); err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "upload")
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stored := filepath.Join(root, "stored.txt")
+	if err := StoreFile(src, stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source of stored file not removed")
+	}
+	if b, _ := ioutil.ReadFile(stored); bytes.Contains(b, content) {
+		t.Errorf("stored file not encrypted")
+	}
+	if b := readFile(t, stored); !bytes.Equal(b, content) {
+		t.Errorf("stored content %q, want %q", b, content)
+	}
+	if b := readFile(t, plain); !bytes.Equal(b, content) {
+		t.Errorf("plain content %q, want %q", b, content)
+	}
+	if names, _ := filepath.Glob(filepath.Join(root, ".stored.txt.*")); len(names) != 0 {
+		t.Errorf("temporary files left: %v", names)
+	}
+
+	// Decrypted copies are made in the private directory.
+	path, cleanup, err := PlainFile(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != filepath.Join(root, ".plain") {
+		t.Errorf("decrypted copy %s outside of the plain directory", path)
+	}
+	if fi, err := os.Stat(filepath.Dir(path)); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("plain directory not private: %v, %v", fi.Mode(), err)
+	}
+	if b, _ := ioutil.ReadFile(path); !bytes.Equal(b, content) {
+		t.Errorf("decrypted copy %q, want %q", b, content)
+	}
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("decrypted copy not removed")
+	}
+	if path, _, _ := PlainFile(plain); path != plain {
+		t.Errorf("plain file copied to %s", path)
+	}
+
+	// Rotation encrypts plain files and rewraps data keys of former keys.
+	SetKeyring(loadKeyring(t, dir, "k2", "k1"))
+	tests := []struct {
+		path    string
+		changed bool
+	}{
+		{plain, true},
+		{stored, true},
+		{stored, false},
+	}
+	for _, tt := range tests {
+		changed, err := RekeyFile(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != tt.changed {
+			t.Errorf("RekeyFile(%s) = %v, want %v", filepath.Base(tt.path), changed, tt.changed)
+		}
+	}
+
+	SetKeyring(loadKeyring(t, dir, "k2"))
+	for _, path := range []string{plain, stored} {
+		if b := readFile(t, path); !bytes.Equal(b, content) {
+			t.Errorf("%s: content %q after rotation, want %q", filepath.Base(path), b, content)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetKeyring(nil)
+
+	root := filepath.Join(dir, "files")
+	plainDir := filepath.Join(root, ".plain")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("content of the thumbnail")
+	write := func(path string) error {
+		return ioutil.WriteFile(path, content, 0644)
+	}
+
+	// Without encryption, programs write the stored file.
+	dst := filepath.Join(root, "plain.jpg")
+	var written string
+	if err := WriteFile(dst, func(path string) error { written = path; return write(path) }); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(dst); written != dst || !bytes.Equal(b, content) {
+		t.Errorf("file written to %s, content %q", written, b)
+	}
+
+	SetKeyring(loadKeyring(t, dir, "k1"))
+	if err := SetPlainDir(plainDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// With encryption, programs write into the private directory.
+	dst = filepath.Join(root, "stored.jpg")
+	if err := WriteFile(dst, func(path string) error { written = path; return write(path) }); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(written) != plainDir || filepath.Ext(written) != ".jpg" {
+		t.Errorf("file written to %s, want a .jpg file of %s", written, plainDir)
+	}
+	if b, _ := ioutil.ReadFile(dst); bytes.Contains(b, content) {
+		t.Errorf("stored file not encrypted")
+	}
+	if b := readFile(t, dst); !bytes.Equal(b, content) {
+		t.Errorf("stored content %q, want %q", b, content)
+	}
+
+	// Failed programs leave no file.
+	failed := filepath.Join(root, "failed.jpg")
+	if err := WriteFile(failed, func(path string) error { write(path); return os.ErrInvalid }); err != os.ErrInvalid {
+		t.Errorf("error %v, want %v", err, os.ErrInvalid)
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Errorf("file of failed program stored")
+	}
+
+	if names, _ := filepath.Glob(filepath.Join(plainDir, "*")); len(names) != 0 {
+		t.Errorf("plain files left: %v", names)
+	}
+	if names, _ := filepath.Glob(filepath.Join(root, ".stored.jpg.*")); len(names) != 0 {
+		t.Errorf("temporary files left: %v", names)
+	}
+}
@@ -0,0 +1,147 @@
+// Using ImageMagick command line util `convert` to stamp watermarks.
+package watermark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+
+	"github.com/gedex/simdoc/pkg/util/mimetype"
+)
+
+var (
+	ErrorUnsupportedType = errors.New("Unsupported type for watermark")
+	ErrorEmptyWatermark  = errors.New("Watermark has neither text nor image")
+)
+
+const (
+	DefaultGravity  = "southeast"
+	DefaultOpacity  = 50
+	DefaultFontSize = 24
+	DefaultColor    = "rgba(128,128,128,0.5)"
+
+	// Density, in DPI, used to rasterize PDF pages.
+	pdfDensity = 150
+)
+
+// Watermark represents a mark stamped on images and PDF pages.
+type Watermark struct {
+	Text     string // Text to stamp. May contain template actions, see Vars
+	Image    string // Path to an image to overlay, for instance a logo
+	Gravity  string // Placement of the mark, for instance "southeast" or "center"
+	Opacity  int    // Opacity of image overlay, 0-100
+	FontSize int    // Font size of text in points
+	Color    string // Color of text, in any format accepted by ImageMagick
+}
+
+// Vars represents dynamic values that can be used in watermark text, for
+// instance "Copy of {{.DocumentName}} (#{{.DocumentID}}) {{.Date}}".
+type Vars struct {
+	DocumentID   int64
+	DocumentName string
+	Date         string
+}
+
+// New returns a Watermark with default placement and style, or nil if both
+// text and image are empty.
+func New(text, image string) *Watermark {
+	if text == "" && image == "" {
+		return nil
+	}
+
+	return &Watermark{
+		Text:     text,
+		Image:    image,
+		Gravity:  DefaultGravity,
+		Opacity:  DefaultOpacity,
+		FontSize: DefaultFontSize,
+		Color:    DefaultColor,
+	}
+}
+
+// Render returns a copy of the watermark with dynamic text evaluated for the
+// given vars.
+func (wm *Watermark) Render(v *Vars) (*Watermark, error) {
+	out := *wm
+	if wm.Text == "" {
+		return &out, nil
+	}
+
+	t, err := template.New("watermark").Parse(wm.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, v); err != nil {
+		return nil, err
+	}
+	out.Text = buf.String()
+
+	return &out, nil
+}
+
+// IsSupported checks whether a file with the given mime type can be watermarked.
+func IsSupported(mime string) bool {
+	return mimetype.Base(mime) == "image" || mime == "application/pdf"
+}
+
+// Apply stamps the watermark wm on src, which has the given mime type, and
+// writes the result to dst. Every page is stamped when src is a PDF.
+func Apply(src, dst, mime string, wm *Watermark) error {
+	if !IsSupported(mime) {
+		return ErrorUnsupportedType
+	}
+	if wm.Text == "" && wm.Image == "" {
+		return ErrorEmptyWatermark
+	}
+
+	var args []string
+	if mime == "application/pdf" {
+		args = append(args, "-density", fmt.Sprintf("%d", pdfDensity))
+	}
+	args = append(args, src)
+
+	if wm.Image != "" {
+		args = append(args,
+			"null:",
+			wm.Image,
+			"-gravity", wm.Gravity,
+			"-compose", "dissolve",
+			"-define", fmt.Sprintf("compose:args=%d", wm.Opacity),
+			"-layers", "composite",
+		)
+	}
+
+	if wm.Text != "" {
+		args = append(args,
+			"-gravity", wm.Gravity,
+			"-fill", wm.Color,
+			"-pointsize", fmt.Sprintf("%d", wm.FontSize),
+			"-annotate", "+10+10", escapeText(wm.Text),
+		)
+	}
+	args = append(args, dst)
+
+	out, err := exec.Command("convert", args...).CombinedOutput()
+	if err != nil {
+		return errors.New("convert returns error: " + strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// escapeText escapes text for -annotate, which otherwise reads the text from a
+// file if it starts with "@" and expands "%" escapes, such as "%[...]", and
+// backslash escapes.
+func escapeText(text string) string {
+	text = strings.Replace(text, `\`, `\\`, -1)
+	text = strings.Replace(text, "%", "%%", -1)
+	if strings.HasPrefix(text, "@") {
+		text = `\` + text
+	}
+	return text
+}
@@ -0,0 +1,37 @@
+package watermark
+
+import "testing"
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Copy of report", "Copy of report"},
+		{"@/etc/passwd", `\@/etc/passwd`},
+		{"user@example.com", "user@example.com"},
+		{"100% %[exif:*]", "100%% %%[exif:*]"},
+		{`C:\n`, `C:\\n`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeText(tt.text); got != tt.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	wm := New("{{.DocumentName}} #{{.DocumentID}} {{.Date}}", "")
+
+	out, err := wm.Render(&Vars{DocumentID: 42, DocumentName: "Report", Date: "2016-01-02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Report #42 2016-01-02"; out.Text != want {
+		t.Errorf("Text = %q, want %q", out.Text, want)
+	}
+	if wm.Text == out.Text {
+		t.Error("Render changed the watermark")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gedex/simdoc/pkg/util/upload"
+)
+
+// runRotateKeys runs the rotate-keys command, which encrypts stored files with
+// the current master key of -encryption_key_file: data keys wrapped with former
+// master keys are rewrapped, and plain files stored before encryption was
+// enabled are encrypted. Former master keys can be removed from the file once
+// it succeeds.
+//
+//	simdoc -encryption_key_file=keys.txt rotate-keys [-dry-run]
+//
+func runRotateKeys(args []string) {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Lists files to encrypt without changing them")
+	fs.Parse(args)
+
+	if *encryptionKeyFile == "" {
+		fmt.Fprintf(os.Stderr, "usage: simdoc -encryption_key_file=path rotate-keys [-dry-run]\n")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var changed, failed int
+	err := filepath.Walk(*fsRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skips decrypted copies, and temporary files of uploads and of
+		// interrupted rotations.
+		if strings.HasPrefix(info.Name(), ".") && path != *fsRoot {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if *dryRun {
+			fmt.Println(path)
+			return nil
+		}
+
+		ok, err := upload.RekeyFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rotate-keys: %s: %s\n", path, err)
+			failed++
+		} else if ok {
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		fatalf("rotate-keys: %s\n", err)
+	}
+
+	fmt.Printf("%d files encrypted with the current key, %d failed\n", changed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
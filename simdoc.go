@@ -4,15 +4,34 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/gedex/simdoc/pkg/auth"
+	"github.com/gedex/simdoc/pkg/auth/ldap"
+	"github.com/gedex/simdoc/pkg/auth/oidc"
 	"github.com/gedex/simdoc/pkg/datastore"
 	"github.com/gedex/simdoc/pkg/datastore/database"
 	"github.com/gedex/simdoc/pkg/handler"
 	"github.com/gedex/simdoc/pkg/middleware"
 	"github.com/gedex/simdoc/pkg/router"
+	"github.com/gedex/simdoc/pkg/util"
+	"github.com/gedex/simdoc/pkg/util/crypt"
+	"github.com/gedex/simdoc/pkg/util/lockout"
+	"github.com/gedex/simdoc/pkg/util/mail"
+	"github.com/gedex/simdoc/pkg/util/password"
+	"github.com/gedex/simdoc/pkg/util/ratelimit"
+	"github.com/gedex/simdoc/pkg/util/tlsutil"
+	"github.com/gedex/simdoc/pkg/util/upload"
+	"github.com/gedex/simdoc/pkg/util/upload/processor"
+	"github.com/gedex/simdoc/pkg/util/watermark"
 
 	"code.google.com/p/go.net/context"
 	webcontext "github.com/goji/context"
@@ -22,13 +41,74 @@ import (
 
 var (
 	httpServerPort = flag.String("port", ":8080", "HTTP server port")
+	// HTTPS. Certificates are reloaded on SIGHUP or when their files change.
+	tlsCert           = flag.String("tls_cert", "", "Path to TLS certificate in PEM, along with intermediate certificates. Serves HTTP if empty")
+	tlsKey            = flag.String("tls_key", "", "Path to TLS private key in PEM")
+	tlsReloadInterval = flag.Duration("tls_reload_interval", time.Minute, "Interval to check TLS certificate files for changes. Disabled if zero. Default to 1m")
+	tlsClientAuth     = flag.String("tls_client_auth", tlsutil.ClientAuthNone, "Client certificate authentication, either none, optional or require. Default to 'none'")
+	tlsClientCA       = flag.String("tls_client_ca", "", "Path to CA certificates in PEM verifying client certificates")
+	httpRedirectPort  = flag.String("http_redirect_port", "", "HTTP port redirecting to HTTPS, for instance ':80'. Disabled if empty")
+
 	dsn            = flag.String("dsn", "root:root@tcp(192.168.42.43:3306)/simdoc", "DSN")
 
-	// Salt for password.
-	passwdSalt = flag.String("pass_salt", "s1md0c!#", "Salt for password. Default to 's1md0c!#'")
+	// Salt for legacy password hashes. New hashes have per-user random salt.
+	passwdSalt = flag.String("pass_salt", "s1md0c!#", "Salt to verify legacy PBKDF2 password hashes. Default to 's1md0c!#'")
+
+	// Password hashing algorithm.
+	passwdHash = flag.String("pass_hash", password.AlgorithmArgon2id, "Password hashing algorithm, either argon2id or bcrypt. Default to 'argon2id'")
+
+	// JWT secret, used with HS256 when no JWT keys are set.
+	jwtSecret = flag.String("jwt_secret", "", "JWT Secret. Required unless jwt_keys is set")
+
+	// JWT keys. Tokens are signed with jwt_key_id and verified with any key in
+	// jwt_keys, so keys are rotated by adding a new key and changing jwt_key_id.
+	jwtKeysDir  = flag.String("jwt_keys", "", "Directory of JWT keys in PEM files named '<key ID>.pem'. Default to HS256 with jwt_secret")
+	jwtKeyID    = flag.String("jwt_key_id", "", "ID of the JWT key to sign tokens with")
+	jwtIssuer   = flag.String("jwt_issuer", "simdoc", "Issuer (iss) of JWT. Default to 'simdoc'")
+	jwtAudience = flag.String("jwt_audience", "simdoc", "Audience (aud) of JWT. Default to 'simdoc'")
+
+	// Requires two-factor authentication for admin role.
+	require2FAAdmin = flag.Bool("require_2fa_admin", false, "Requires TOTP two-factor authentication for admin role. Default to false")
+
+	// Store of failed login attempts.
+	loginAttemptsStore = flag.String("login_attempts_store", "memory", "Store of failed login attempts, either memory or datastore. Default to 'memory'")
+
+	// LDAP authentication provider.
+	ldapConfig = flag.String("ldap_config", "", "Path to LDAP authentication provider configuration in JSON. Disabled if empty")
+
+	// OpenID Connect single sign-on.
+	oidcConfig = flag.String("oidc_config", "", "Path to OpenID Connect single sign-on configuration in JSON. Disabled if empty")
+
+	// Rate limits, as "<limit>/<period>", per user or per IP for anonymous
+	// requests. Uploads are limited in bytes.
+	rateLimitStore  = flag.String("rate_limit_store", "memory", "Store of rate limits, either memory or datastore. Default to 'memory'")
+	apiRateLimit    = flag.String("api_rate_limit", "600/1m", "Rate limit of API calls. Disabled if empty. Default to '600/1m'")
+	loginRateLimit  = flag.String("login_rate_limit", "10/1m", "Rate limit of login and password reset attempts per IP. Disabled if empty. Default to '10/1m'")
+	uploadRateLimit = flag.String("upload_rate_limit", "1g/1h", "Rate limit of uploaded bytes, with k, m or g suffix. Disabled if empty. Default to '1g/1h'")
+
+	// Reverse proxies whose X-Forwarded-For header is trusted.
+	trustedProxies = flag.String("trusted_proxies", "", "Comma separated IP addresses or CIDR networks of trusted reverse proxies")
+
+	// Cross-origin resource sharing policy.
+	corsConfig = flag.String("cors_config", "", "Path to CORS policy configuration in JSON. Default to same origin requests only")
+
+	// Security headers of responses.
+	csp               = flag.String("csp", middleware.DefaultHeaderPolicy.ContentSecurityPolicy, "Content-Security-Policy of responses. Disabled if empty")
+	referrerPolicy    = flag.String("referrer_policy", middleware.DefaultHeaderPolicy.ReferrerPolicy, "Referrer-Policy of responses. Disabled if empty")
+	permissionsPolicy = flag.String("permissions_policy", middleware.DefaultHeaderPolicy.PermissionsPolicy, "Permissions-Policy of responses. Disabled if empty")
+	hstsMaxAge        = flag.Duration("hsts_max_age", middleware.DefaultHeaderPolicy.HSTSMaxAge, "Max age of Strict-Transport-Security sent over HTTPS. Disabled if zero. Default to 4320h")
+	hstsSubdomains    = flag.Bool("hsts_subdomains", false, "Includes subdomains in Strict-Transport-Security. Default to false")
 
-	// JWT secret.
-	jwtSecret = flag.String("jwt_secret", "s1md0c-jwt-secret", "JWT Secret")
+	// Base URL of the app, used in links sent by email.
+	baseURL = flag.String("base_url", "http://localhost:8080", "Base URL of the app, used in links sent by email")
+
+	// Mail. Emails are written to mail_dir unless SMTP server is set. Without
+	// either, only their recipients and subjects are logged.
+	smtpAddr = flag.String("smtp_addr", "", "SMTP server address, for instance 'smtp.example.com:587'")
+	smtpUser = flag.String("smtp_user", "", "SMTP username")
+	smtpPass = flag.String("smtp_pass", "", "SMTP password")
+	mailFrom = flag.String("mail_from", "simdoc@localhost", "Sender address of emails")
+	mailDir  = flag.String("mail_dir", "", "Directory to write emails into, when no SMTP server is set. Default to logging recipients and subjects only")
 
 	// Env (dev, staging or prod). Default to 'prod'.
 	env = flag.String("env", "prod", "Env name. Use 'dev' for additional handler during development. Default to 'prod'")
@@ -36,15 +116,74 @@ var (
 	// URL Prefix to access uploaded files
 	filesPrefix = flag.String("files_prefix", "/files/", "URL Prefix to access uploaded files. Default to '/files/'")
 
+	// Secret to sign URLs of uploaded files.
+	filesSecret = flag.String("files_secret", "", "Secret to sign URLs of uploaded files. Required")
+
+	// Key of the audit events chain, kept outside the datastore.
+	auditSecret = flag.String("audit_secret", "", "Secret keying the hash chain of audit events. Required")
+
+	// Lifetime of signed URLs of uploaded files.
+	filesURLTTL = flag.Duration("files_url_ttl", time.Hour, "Lifetime of signed URLs of uploaded files. Default to 1h")
+
+	// Allows unsigned access to uploaded files.
+	filesUnsigned = flag.Bool("files_unsigned", false, "Allows access to uploaded files without signed URL. Default to false")
+
 	// fsRoot is a root path to store files in file system.
 	fsRoot = flag.String("fs_root", "/tmp/simdoc/files", "Filestore root. Default to '/tmp/simdoc/files'")
 
+	// Master keys encrypting stored files at rest.
+	encryptionKeyFile = flag.String("encryption_key_file", "", "Path to master keys encrypting stored files, one '<key ID> <base64 key>' per line with the current key first. Disabled if empty")
+
+	// Directory imports through the API are confined to.
+	importRoot = flag.String("import_root", "", "Directory holding sources, manifests and journals of imports through the API. Disabled if empty")
+
+	// Processors pipeline configuration.
+	pipelineConfig = flag.String("pipeline", "", "Path to processors pipeline configuration in JSON. Default to mover and 120x90 thumbnail")
+
+	// Watermark stamped on files of published documents. Text may contain
+	// {{.DocumentID}}, {{.DocumentName}} and {{.Date}}.
+	watermarkText  = flag.String("watermark_text", "", "Text watermark for files of published documents. Disabled if empty")
+	watermarkImage = flag.String("watermark_image", "", "Path to image watermark for files of published documents. Disabled if empty")
+
 	// DB as Datastore
 	db *sql.DB
+
+	// Password hasher.
+	passwdHasher password.Hasher
+
+	// Keys to sign and verify JWT.
+	jwtKeys *util.KeySet
+
+	// Mail sender.
+	mailer mail.Sender
+
+	// External authentication provider, nil if disabled.
+	authProvider auth.Provider
+
+	// OpenID Connect provider, nil if disabled.
+	oidcProvider *oidc.Provider
+
+	// Throttle failed logins per account and per IP.
+	accountLimiter *lockout.Limiter
+	ipLimiter      *lockout.Limiter
+
+	// Rate limits of API calls, logins and uploads, nil if disabled.
+	apiRateLimiter    *ratelimit.Limiter
+	loginRateLimiter  *ratelimit.Limiter
+	uploadRateLimiter *ratelimit.Limiter
+
+	// Processors pipeline run against uploaded files.
+	pipeline = processor.DefaultPipeline
+
+	// Watermark for published documents, nil if disabled.
+	wm *watermark.Watermark
+
+	// CORS policy of the API.
+	cors = middleware.DefaultCORSConfig
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: simdoc [flags]\n")
+	fmt.Fprintf(os.Stderr, "usage: simdoc [flags] [import [import flags]]\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -55,8 +194,151 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
+	// Processors pipeline.
+	if *pipelineConfig != "" {
+		pl, err := processor.LoadPipeline(*pipelineConfig)
+		if err != nil {
+			panic(err)
+		}
+		pipeline = pl
+	}
+
+	// Password hasher.
+	h, err := password.New(*passwdHash, *passwdSalt)
+	if err != nil {
+		panic(err)
+	}
+	passwdHasher = h
+
+	// JWT keys.
+	if *jwtKeysDir != "" {
+		ks, err := util.LoadKeySet(*jwtKeysDir, *jwtKeyID, *jwtIssuer, *jwtAudience)
+		if err != nil {
+			panic(err)
+		}
+		jwtKeys = ks
+	} else {
+		jwtKeys = util.NewHMACKeySet(*jwtSecret, *jwtIssuer, *jwtAudience)
+	}
+
+	// Authentication provider.
+	if *ldapConfig != "" {
+		cfg, err := ldap.LoadConfig(*ldapConfig)
+		if err != nil {
+			panic(err)
+		}
+		authProvider = ldap.New(cfg)
+	}
+	if *oidcConfig != "" {
+		cfg, err := oidc.LoadConfig(*oidcConfig)
+		if err != nil {
+			panic(err)
+		}
+		oidcProvider = oidc.New(cfg)
+	}
+
+	// CORS policy.
+	if *corsConfig != "" {
+		cfg, err := middleware.LoadCORSConfig(*corsConfig)
+		if err != nil {
+			panic(err)
+		}
+		cors = cfg
+	}
+
+	// Encryption at rest.
+	if *encryptionKeyFile != "" {
+		k, err := crypt.LoadKeyring(*encryptionKeyFile)
+		if err != nil {
+			panic(err)
+		}
+		upload.SetKeyring(k)
+		if err := upload.SetPlainDir(filepath.Join(*fsRoot, plainDir)); err != nil {
+			panic(err)
+		}
+	}
+
+	// Mail sender.
+	if *smtpAddr != "" {
+		mailer = mail.NewSMTPSender(*smtpAddr, *smtpUser, *smtpPass, *mailFrom)
+	} else {
+		mailer = mail.NewFileSender(*mailDir, *mailFrom)
+	}
+
+	// Commands.
+	if flag.Arg(0) == "import" {
+		runImport(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "rotate-keys" {
+		runRotateKeys(flag.Args()[1:])
+		return
+	}
+	if *encryptionKeyFile != "" {
+		go reloadKeyring(*encryptionKeyFile)
+	}
+
+	// Signed URLs of files can't be forged without a secret of the install.
+	if *filesSecret == "" {
+		panic("files_secret is required to sign URLs of uploaded files")
+	}
+
+	// Nor the audit events chain rebuilt after altering events.
+	if *auditSecret == "" {
+		panic("audit_secret is required to chain audit events")
+	}
+
+	// Access tokens can't be forged without a secret of the install either.
+	if *jwtKeysDir == "" && *jwtSecret == "" {
+		panic("jwt_secret is required to sign access tokens, unless jwt_keys is set")
+	}
+
 	// DB.
 	db = database.MustConnect(*dsn)
+	countStorageUsage(database.NewDatastore(db))
+	go chainAuditEvents(database.NewDatastore(db), []byte(*auditSecret))
+
+	// Watermark.
+	wm = watermark.New(*watermarkText, *watermarkImage)
+
+	// Login attempts.
+	var attempts lockout.Store
+	switch *loginAttemptsStore {
+	case "memory":
+		attempts = lockout.NewMemoryStore()
+	case "datastore":
+		attempts = lockout.NewDatastoreStore(database.NewDatastore(db))
+	default:
+		panic("unknown login attempts store " + *loginAttemptsStore)
+	}
+	accountLimiter = lockout.New(attempts, lockout.AccountPolicy)
+	ipLimiter = lockout.New(attempts, lockout.IPPolicy)
+
+	// Rate limits.
+	var buckets ratelimit.Store
+	switch *rateLimitStore {
+	case "memory":
+		buckets = ratelimit.NewMemoryStore()
+	case "datastore":
+		buckets = ratelimit.NewDatastoreStore(database.NewDatastore(db))
+	default:
+		panic("unknown rate limit store " + *rateLimitStore)
+	}
+	apiRateLimiter = newRateLimiter(buckets, *apiRateLimit)
+	loginRateLimiter = newRateLimiter(buckets, *loginRateLimit)
+	uploadRateLimiter = newRateLimiter(buckets, *uploadRateLimit)
+	if err := util.SetTrustedProxies(strings.Split(*trustedProxies, ",")); err != nil {
+		panic(err)
+	}
+
+	// Security headers.
+	headers := &middleware.HeaderPolicy{
+		ContentSecurityPolicy: *csp,
+		ReferrerPolicy:        *referrerPolicy,
+		PermissionsPolicy:     *permissionsPolicy,
+		HSTSMaxAge:            *hstsMaxAge,
+		HSTSIncludeSubdomains: *hstsSubdomains,
+	}
 
 	// Static resources for SPA.
 	// @todo
@@ -67,27 +349,74 @@ func main() {
 	// Middleware for logging.
 	r.Use(gojiMiddleware.Logger)
 
-	// Middleware for OPTIONS request.
-	r.Use(middleware.OptionsRequestHeaderInjector)
+	// Middleware for cross-origin requests, answering preflight requests.
+	r.Use(middleware.CORS(cors))
 
 	// Middleware that inject services into context.
 	r.Use(ContextMiddleware)
 
-	// Middleware that inject header fields.
-	r.Use(middleware.HeaderInjector)
+	// Middleware that inject security headers, and prevent API responses from
+	// being cached.
+	r.Use(middleware.SecurityHeaders(headers))
+	r.Use(middleware.CacheControl(middleware.CacheNoStore))
 
 	// Middleware that may injects user information into context.
 	r.Use(middleware.UserToContextInjector)
 
+	// Middleware that limits rate of API calls.
+	r.Use(middleware.RateLimit)
+
 	// Handles all /api/* requests with API routers.
 	http.Handle("/api/", r)
 
+	// Publishes public JWT keys.
+	http.Handle("/.well-known/jwks.json", headers.Handler(handler.NewJWKSServer(jwtKeys)))
+
 	// Handle GET uploaded files requests with static file server.
-	http.Handle(*filesPrefix, handler.NewFileServer(*fsRoot, *filesPrefix))
+	http.Handle(*filesPrefix, headers.Handler(handler.NewFileServer(*fsRoot, *filesPrefix, []byte(*filesSecret), *filesUnsigned, database.NewDatastore(db))))
+
+	// Starts HTTP server, or HTTPS server if a certificate is set.
+	if *tlsCert == "" {
+		panic(http.ListenAndServe(*httpServerPort, nil))
+	}
+	panic(listenAndServeTLS())
+}
+
+// listenAndServeTLS serves HTTPS on port, reloading the certificate when its
+// files change or on SIGHUP, and redirects HTTP to HTTPS if enabled.
+func listenAndServeTLS() error {
+	cert, err := tlsutil.LoadCertificate(*tlsCert, *tlsKey)
+	if err != nil {
+		return err
+	}
+	cfg, err := tlsutil.NewConfig(cert, *tlsClientAuth, *tlsClientCA)
+	if err != nil {
+		return err
+	}
+
+	if *tlsReloadInterval > 0 {
+		go cert.Watch(*tlsReloadInterval, nil)
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+		for range sig {
+			if err := cert.Reload(); err != nil {
+				log.Printf("SIGHUP: TLS certificate %s not reloaded, keeping the current one: %+v\n", *tlsCert, err)
+			} else {
+				log.Printf("SIGHUP: TLS certificate %s reloaded\n", *tlsCert)
+			}
+		}
+	}()
+
+	if *httpRedirectPort != "" {
+		go func() {
+			panic(http.ListenAndServe(*httpRedirectPort, tlsutil.RedirectHandler(*httpServerPort)))
+		}()
+	}
 
-	// Starts HTTP server.
-	// @todo supports HTTPS.
-	panic(http.ListenAndServe(*httpServerPort, nil))
+	srv := &http.Server{Addr: *httpServerPort, TLSConfig: cfg}
+	return srv.ListenAndServeTLS("", "")
 }
 
 // ContextMiddleware creates a new go.net/context and injects into the current
@@ -98,14 +427,100 @@ func ContextMiddleware(c *web.C, h http.Handler) http.Handler {
 		ctx = datastore.NewContext(ctx, database.NewDatastore(db))
 
 		webcontext.Set(c, ctx)
-		c.Env["passwdSalt"] = *passwdSalt
-		c.Env["jwtSecret"] = *jwtSecret
+		c.Env["passwdHasher"] = passwdHasher
+		c.Env["jwtKeys"] = jwtKeys
+		c.Env["require2FAAdmin"] = *require2FAAdmin
+		c.Env["authProvider"] = authProvider
+		c.Env["oidcProvider"] = oidcProvider
+		c.Env["accountLimiter"] = accountLimiter
+		c.Env["ipLimiter"] = ipLimiter
+		c.Env["apiRateLimiter"] = apiRateLimiter
+		c.Env["loginRateLimiter"] = loginRateLimiter
+		c.Env["uploadRateLimiter"] = uploadRateLimiter
 		c.Env["env"] = *env
+		c.Env["baseURL"] = *baseURL
+		c.Env["mailer"] = mailer
 		c.Env["fsRoot"] = *fsRoot
 		c.Env["filesPrefix"] = *filesPrefix
+		c.Env["filesSecret"] = *filesSecret
+		c.Env["auditKey"] = []byte(*auditSecret)
+		c.Env["filesURLTTL"] = *filesURLTTL
+		c.Env["pipeline"] = pipeline
+		c.Env["watermark"] = wm
+		c.Env["importRoot"] = *importRoot
 
 		h.ServeHTTP(w, r)
 	}
 
 	return http.HandlerFunc(fn)
 }
+
+// newRateLimiter returns limiter of policy, nil if policy is disabled.
+func newRateLimiter(store ratelimit.Store, policy string) *ratelimit.Limiter {
+	p, err := ratelimit.ParsePolicy(policy)
+	if err != nil {
+		panic(err)
+	}
+	if p == nil {
+		return nil
+	}
+	return ratelimit.New(store, p)
+}
+
+// countStorageUsage counts the storage usage of organizations not counted yet,
+// from their files under fs_root. It's needed once after upgrading, later
+// uploads keep the usage up to date.
+func countStorageUsage(ds datastore.Datastore) {
+	orgs, err := ds.GetAllOrganizations()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, org := range orgs {
+		if _, err := ds.GetOrganizationUsage(org.ID); err != datastore.ErrorUsageUncounted {
+			continue
+		}
+		size, err := upload.DirSize(upload.OrganizationRoot(*fsRoot, org.ID))
+		if err != nil {
+			panic(err)
+		}
+		if err := ds.InitOrganizationUsage(org.ID, size); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Interval between chainings of new audit events.
+const auditChainInterval = 5 * time.Second
+
+// chainAuditEvents chains new audit events with key, in the background, so
+// that requests adding events don't wait for each other.
+func chainAuditEvents(ds datastore.Datastore, key []byte) {
+	for range time.Tick(auditChainInterval) {
+		if _, err := ds.ChainAuditEvents(key); err != nil {
+			log.Printf("audit: %+v\n", err)
+		}
+	}
+}
+
+// plainDir is the directory, under fs_root, of decrypted copies of stored files
+// read by path, such as by image processors. It's hidden, so neither served nor
+// rotated.
+const plainDir = ".plain"
+
+// reloadKeyring reloads master keys from the file at path on SIGHUP, so that a
+// new current key is used without restart. The current keys are kept if the
+// file is invalid.
+func reloadKeyring(path string) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		k, err := crypt.LoadKeyring(path)
+		if err != nil {
+			log.Printf("SIGHUP: encryption keys %s not reloaded, keeping the current ones: %+v\n", path, err)
+			continue
+		}
+		upload.SetKeyring(k)
+		log.Printf("SIGHUP: encryption keys %s reloaded, current key %s\n", path, k.Current())
+	}
+}